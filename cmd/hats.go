@@ -0,0 +1,62 @@
+/*
+Copyright © 2026 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/mightymoud/arlocode/internal/coding_agent"
+	"github.com/mightymoud/arlocode/internal/coding_agent/hats"
+	"github.com/spf13/cobra"
+)
+
+var hatsCmd = &cobra.Command{
+	Use:   "hats",
+	Short: "List the hats the agent can wear",
+	Long: `List the built-in hats together with the ones defined in YAML files under
+the user config dir (arlocode/hats) and the project (.arlocode/hats).`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hatList, err := coding_agent.LoadHats()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tMODEL\tSOURCE\tDESCRIPTION")
+		for _, hat := range hatList {
+			model := hat.Model
+			if model == "" {
+				model = "default"
+			}
+			source := hat.Source
+			if source == "" {
+				source = "built-in"
+			}
+			name := hat.Name
+			if name == hats.DefaultHat {
+				name += " *"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, model, source, hat.Description)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(hatsCmd)
+}
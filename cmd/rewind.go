@@ -0,0 +1,105 @@
+/*
+Copyright © 2026 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/session"
+	"github.com/mightymoud/arlocode/internal/coding_agent"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rewindSession string // Set by --session
+	rewindMemory  bool   // Set by --memory
+)
+
+var rewindCmd = &cobra.Command{
+	Use:   "rewind <turn>",
+	Short: "Restore the files the agent changed since the start of a turn, see /checkpoints",
+	Long: `Restore the files the agent changed since the start of a turn of the most recent session.
+Files are put back the way they were before the turn, files the agent created are deleted.
+With --memory the conversation is cut back to the same point, so resuming the session
+continues from before the turn.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		turn, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("turn must be a number, got %q", args[0])
+		}
+
+		store, err := coding_agent.SessionStore()
+		if err != nil {
+			return err
+		}
+		id := rewindSession
+		if id == "" {
+			latest, err := store.Latest()
+			if err != nil {
+				return err
+			}
+			id = latest.ID
+		}
+		checkpoints, err := coding_agent.CheckpointStore(store, id)
+		if err != nil {
+			return err
+		}
+		checkpoint, err := checkpoints.Get(turn)
+		if err != nil {
+			return err
+		}
+
+		// The conversation is checked before any file is touched, so a failure changes nothing
+		var sess *session.Session
+		var truncated []memory.MemoryEntry
+		if rewindMemory {
+			var entries []memory.MemoryEntry
+			if sess, entries, err = store.Open(id); err != nil {
+				return err
+			}
+			if truncated, err = checkpoint.MemoryBefore(entries, sess); err != nil {
+				return err
+			}
+		}
+
+		restored, err := checkpoints.Rewind(turn)
+		for _, path := range restored {
+			fmt.Println("restored", path)
+		}
+		if err != nil {
+			if rewindMemory {
+				return fmt.Errorf("these files weren't restored, the conversation was kept:\n%w", err)
+			}
+			return fmt.Errorf("these files weren't restored:\n%w", err)
+		}
+		if sess != nil {
+			sess.Reset(truncated)
+			if err := sess.Err(); err != nil {
+				return fmt.Errorf("files restored but the conversation wasn't rewound: %w", err)
+			}
+		}
+		fmt.Printf("Session %s rewound to before turn %d: %s\n", id, turn, checkpoint.Prompt)
+		return nil
+	},
+}
+
+func init() {
+	rewindCmd.Flags().StringVar(&rewindSession, "session", "", "Session to rewind, the most recent one when empty")
+	rewindCmd.Flags().BoolVar(&rewindMemory, "memory", false, "Also cut the conversation back to before the turn")
+	rootCmd.AddCommand(rewindCmd)
+}
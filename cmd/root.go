@@ -15,10 +15,22 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mightymoud/arlocode/internal/butler/actor"
+	"github.com/mightymoud/arlocode/internal/butler/checkpoint"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
+	"github.com/mightymoud/arlocode/internal/butler/llm/middleware"
+	"github.com/mightymoud/arlocode/internal/butler/llm/router"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/session"
+	"github.com/mightymoud/arlocode/internal/butler/telemetry"
+	"github.com/mightymoud/arlocode/internal/butler/todo"
+	"github.com/mightymoud/arlocode/internal/butler/transcript"
 	"github.com/mightymoud/arlocode/internal/coding_agent"
 	state "github.com/mightymoud/arlocode/internal/tui"
 	"github.com/mightymoud/arlocode/internal/tui/app"
@@ -38,36 +50,161 @@ var rootCmd = &cobra.Command{
 	Short: "ArloCode a Coding Agent focused on long running tasks and local models",
 	Long:  `AlroCode is an AI coding agent designed to assist developers with complex coding tasks.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		appState := state.Get()
-
-		// Create the app model using the new constructor
-		m := app.NewAppModel()
-
-		codingAgent := coding_agent.Agent.WithMaxIterations(10).
-			WithOnThinkingChunk(func(s string) {
-				appState.Program().Send(app.AgentThinkingChunkMsg(s))
-			}).
-			WithOnThinkingComplete(func() {
-				appState.Program().Send(app.AgentThinkingCompleteMsg(""))
-			}).
-			WithOnTextChunk(func(s string) {
-				appState.Program().Send(app.AgentTextChunkMsg(s))
-			}).
-			WithOnStreamComplete(func() {
-				appState.Program().Send(app.AgentTextCompleteMsg(""))
-			})
-
-		appState.SetAgent(codingAgent)
-
-		p := tea.NewProgram(m, tea.WithAltScreen(), tea.WithMouseCellMotion())
-		appState.SetProgram(p)
-		if _, err := p.Run(); err != nil {
-			fmt.Printf("Error: %v", err)
-			os.Exit(1)
+		resumeID := ""
+		if continueLast {
+			store, err := coding_agent.SessionStore()
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			latest, err := store.Latest()
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			resumeID = latest.ID
 		}
+		runApp(cmd, resumeID)
 	},
 }
 
+var (
+	continueLast bool   // Set by --continue
+	hatName      string // Set by --hat
+
+	traceEndpoint string // Set by --trace-endpoint
+	traceFile     string // Set by --trace-file
+
+	llmLog        string // Set by --llm-log
+	redactSecrets bool   // Set by --redact-secrets
+)
+
+// runApp starts the TUI, resuming the session with resumeID when it isn't empty
+func runApp(cmd *cobra.Command, resumeID string) {
+	appState := state.Get()
+
+	shutdownTracing, err := telemetry.Setup(cmd.Context(), telemetry.Config{
+		Endpoint: traceEndpoint,
+		File:     traceFile,
+		Version:  version,
+	})
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer func() {
+		// Give the exporter a moment to flush the last spans, without hanging the exit on a dead collector
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = shutdownTracing(ctx)
+	}()
+
+	var middlewares []llm.Middleware
+	if redactSecrets {
+		// Listed first so the log doesn't get the secrets either
+		middlewares = append(middlewares, middleware.RedactPatterns(middleware.Secrets...))
+	}
+	if llmLog != "" {
+		logModels, closeLog, err := middleware.LogFile(llmLog)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer closeLog()
+		middlewares = append(middlewares, logModels)
+	}
+
+	// Create the app model using the new constructor
+	m := app.NewAppModel()
+
+	// The chat renders what the agent and its sub-agents record here
+	conversation := transcript.New()
+
+	codingAgent, err := coding_agent.New(cmd.Context(), coding_agent.Options{
+		Hat:           hatName,
+		SubAgentHooks: conversation.SubAgentHooks(),
+		Middlewares:   middlewares,
+	})
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	store, err := coding_agent.SessionStore()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	var sess *session.Session
+	if resumeID != "" {
+		var entries []memory.MemoryEntry
+		sess, entries, err = store.Open(resumeID)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		codingAgent.WithMemory(entries)
+		conversation.Load(entries)
+
+		var todos []todo.Item
+		if _, err := sess.State(todo.StateKey, &todos); err != nil {
+			fmt.Printf("Error: reading the todo list: %v\n", err)
+			os.Exit(1)
+		}
+		if err := codingAgent.Todos().Set(todos); err != nil {
+			fmt.Printf("Error: reading the todo list: %v\n", err)
+			os.Exit(1)
+		}
+		m = m.WithTodos(todos)
+	} else {
+		sess = coding_agent.NewSession(store, codingAgent.ModelID())
+	}
+	appState.SetSession(sess)
+
+	checkpoints, err := coding_agent.CheckpointStore(store, sess.ID())
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	checkpoint.Watch(codingAgent.Agent, checkpoints, checkpoint.DefaultTools)
+	appState.SetCheckpoints(checkpoints)
+
+	// Every routing decision is kept next to the session, to check what the cheap model saves
+	routingLog, err := coding_agent.OpenRoutingLog(store, sess.ID())
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer routingLog.Close()
+	codingAgent.WithOnRoutingDecision(router.Log(routingLog))
+	appState.SetRouter(codingAgent)
+
+	codingAgent.WithRecorder(sess).
+		WithOnApprovalRequest(app.RequestToolApproval)
+	codingAgent.Subscribe(conversation.Handle)
+	codingAgent.Subscribe(app.ForwardAgentEvent)
+	conversation.WithOnChange(app.ForwardTranscript)
+	m = m.WithTranscript(conversation)
+	codingAgent.Todos().WithOnChange(func(items []todo.Item) {
+		sess.SetState(todo.StateKey, items)
+		app.ForwardTodos(items)
+	})
+
+	appState.SetAgent(codingAgent.Agent)
+	// Runs go through the actor, so prompts typed while the agent works wait or steer it instead of overlapping
+	appState.SetActor(actor.New(codingAgent.Agent).
+		WithOnChange(app.ForwardQueue).
+		WithOnDone(app.ForwardResult))
+	appState.SetHats(codingAgent)
+
+	p := tea.NewProgram(m, tea.WithAltScreen(), tea.WithMouseCellMotion())
+	appState.SetProgram(p)
+	if _, err := p.Run(); err != nil {
+		fmt.Printf("Error: %v", err)
+		os.Exit(1)
+	}
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -85,4 +222,11 @@ func init() {
 	// Set version template for --version flag
 	rootCmd.Version = version
 	rootCmd.SetVersionTemplate(fmt.Sprintf("arlocode %s (commit: %s, built: %s)\n", version, commit, date))
+
+	rootCmd.Flags().BoolVarP(&continueLast, "continue", "c", false, "Continue the most recent session of this project")
+	rootCmd.PersistentFlags().StringVar(&hatName, "hat", "", "Hat to start with, see 'arlocode hats'")
+	rootCmd.PersistentFlags().StringVar(&traceEndpoint, "trace-endpoint", "", "Send OpenTelemetry traces to this OTLP/HTTP collector, e.g. http://localhost:4318")
+	rootCmd.PersistentFlags().StringVar(&traceFile, "trace-file", "", "Append OpenTelemetry traces to this file as JSON")
+	rootCmd.PersistentFlags().StringVar(&llmLog, "llm-log", "", "Append every model request and response to this file as JSON lines")
+	rootCmd.PersistentFlags().BoolVar(&redactSecrets, "redact-secrets", false, "Hide API keys and tokens from the model, edits of files holding them may then fail")
 }
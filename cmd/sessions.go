@@ -0,0 +1,110 @@
+/*
+Copyright © 2026 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/mightymoud/arlocode/internal/butler/session"
+	"github.com/mightymoud/arlocode/internal/coding_agent"
+	"github.com/spf13/cobra"
+)
+
+// sessionsCmd groups the commands that work on saved sessions
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "Manage saved sessions of the current project",
+}
+
+var sessionsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List saved sessions of the current project, most recent first",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := coding_agent.SessionStore()
+		if err != nil {
+			return err
+		}
+		summaries, err := store.List()
+		if err != nil {
+			return err
+		}
+		if len(summaries) == 0 {
+			fmt.Println("No sessions yet for this project")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tUPDATED\tMESSAGES\tBRANCHES\tTITLE")
+		for _, s := range summaries {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", s.ID, s.Updated.Format("2006-01-02 15:04"), s.Entries, s.Branches, s.Title)
+		}
+		return w.Flush()
+	},
+}
+
+var sessionsForkCmd = &cobra.Command{
+	Use:   "fork <id> <turn>",
+	Short: "Start a new branch of a session before one of its turns",
+	Long: `Start a new branch of a session before one of its turns, to try another prompt there.
+Turns are the prompts of the branch the session is on, the first one is 1.
+The branch the session was on is kept, switch between them with /branch once resumed.
+Files are left alone, see 'arlocode rewind' to restore them.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		turn, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("turn must be a number, got %q", args[1])
+		}
+		store, err := coding_agent.SessionStore()
+		if err != nil {
+			return err
+		}
+		sess, entries, err := store.Open(args[0])
+		if err != nil {
+			return err
+		}
+		before, prompt, err := session.BeforeTurn(entries, turn)
+		if err != nil {
+			return err
+		}
+		sess.Reset(before)
+		if err := sess.Err(); err != nil {
+			return err
+		}
+		fmt.Printf("Session %s forked before turn %d: %s\n", sess.ID(), turn, prompt)
+		fmt.Printf("Resume it with 'arlocode resume %s' to send another prompt\n", sess.ID())
+		return nil
+	},
+}
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume <id>",
+	Short: "Resume a saved session, see 'arlocode sessions list'",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runApp(cmd, args[0])
+	},
+}
+
+func init() {
+	sessionsCmd.AddCommand(sessionsListCmd)
+	sessionsCmd.AddCommand(sessionsForkCmd)
+	rootCmd.AddCommand(sessionsCmd)
+	rootCmd.AddCommand(resumeCmd)
+}
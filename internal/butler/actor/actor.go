@@ -0,0 +1,278 @@
+// Package actor serializes the work on one conversation. An Actor owns an agent and runs one
+// job at a time, prompts or anything else that touches the memory like a compaction, so a
+// frontend can send messages at any moment without runs overlapping.
+package actor
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/mightymoud/arlocode/internal/butler/agent"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+)
+
+// State is what the actor is doing and what waits, for frontends to show
+type State struct {
+	Busy     bool
+	Current  string   // Prompt or name of the job in progress, empty between jobs
+	Queued   []string // Prompts and job names waiting, in order
+	Steering []string // Messages waiting for the next model call of the run in progress
+}
+
+// Result is how a job ended, reported through WithOnDone
+type Result struct {
+	Prompt string           // Set for prompts sent with Send
+	Name   string           // Set for jobs sent with Do
+	Reason agent.StopReason // Why the run stopped, empty for jobs sent with Do
+	Err    error
+}
+
+// JobFunc is work on the agent sent with Do, ctx is cancelled by Cancel
+type JobFunc func(ctx context.Context, a *agent.Agent) error
+
+type job struct {
+	prompt      string
+	attachments []memory.Part
+	name        string
+	do          JobFunc
+}
+
+func (j job) label() string {
+	if j.do != nil {
+		return j.name
+	}
+	return j.prompt
+}
+
+// steer is a message sent with Steer, queued is how many jobs were waiting when it was sent.
+// Only jobs sent later are appended after them until the run ends, so a message the run
+// didn't pick up is queued back at that position.
+type steer struct {
+	message string
+	queued  int
+}
+
+// Actor runs the jobs of one agent in the order they were sent.
+// Messages sent with Steer while a prompt runs reach the model at its next call, see agent.WithSteering.
+type Actor struct {
+	agent    *agent.Agent
+	onChange func(State)
+	onDone   func(Result)
+
+	mu       sync.Mutex
+	queue    []job
+	steering []steer
+	busy     bool
+	current  job
+	cancel   context.CancelFunc
+	idle     chan struct{} // Closed when the worker stops, nil before the first job
+
+	notifyMu sync.Mutex
+}
+
+// New takes over a: from now on runs must go through the actor, it is the agent's steering source
+func New(a *agent.Agent) *Actor {
+	x := &Actor{agent: a}
+	a.WithSteering(x.takeSteering)
+	return x
+}
+
+// WithOnChange calls f whenever the state changes, e.g. to show the queue.
+// It is called from the goroutine that made the change and must return quickly.
+func (x *Actor) WithOnChange(f func(State)) *Actor {
+	x.onChange = f
+	return x
+}
+
+// WithOnDone calls f after every job, on the actor's goroutine before the next job starts
+func (x *Actor) WithOnDone(f func(Result)) *Actor {
+	x.onDone = f
+	return x
+}
+
+// Agent is the agent the actor owns, it must only be used directly while the actor is idle
+func (x *Actor) Agent() *agent.Agent {
+	return x.agent
+}
+
+// Send runs the prompt once the jobs before it are done, with the attachments if any
+func (x *Actor) Send(prompt string, attachments ...memory.Part) {
+	x.enqueue(job{prompt: prompt, attachments: attachments})
+}
+
+// Do runs f once the jobs before it are done, name is how the state shows it
+func (x *Actor) Do(name string, f JobFunc) {
+	x.enqueue(job{name: name, do: f})
+}
+
+// Steer hands the message to the run in progress at its next model call.
+// When no prompt is running it is sent as a prompt of its own.
+// Messages the run didn't pick up before it ended become a prompt, after the
+// jobs sent before them and before the ones sent after them.
+func (x *Actor) Steer(message string) {
+	x.mu.Lock()
+	if !x.busy || x.current.do != nil {
+		x.mu.Unlock()
+		x.Send(message)
+		return
+	}
+	x.steering = append(x.steering, steer{message: message, queued: len(x.queue)})
+	x.mu.Unlock()
+	x.notify()
+}
+
+// Cancel stops the job in progress and drops the queued jobs and steering messages.
+// It returns how many were dropped.
+func (x *Actor) Cancel() (dropped int) {
+	x.mu.Lock()
+	dropped = len(x.queue) + len(x.steering)
+	x.queue = nil
+	x.steering = nil
+	if x.cancel != nil {
+		x.cancel()
+	}
+	x.mu.Unlock()
+	x.notify()
+	return dropped
+}
+
+// State returns what the actor is doing
+func (x *Actor) State() State {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	state := State{Busy: x.busy, Current: x.current.label()}
+	for _, s := range x.steering {
+		state.Steering = append(state.Steering, s.message)
+	}
+	for _, j := range x.queue {
+		state.Queued = append(state.Queued, j.label())
+	}
+	return state
+}
+
+// Busy reports whether a job is running or waiting
+func (x *Actor) Busy() bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.busy
+}
+
+// Wait blocks until all the jobs are done
+func (x *Actor) Wait() {
+	x.mu.Lock()
+	idle := x.idle
+	x.mu.Unlock()
+	if idle != nil {
+		<-idle
+	}
+}
+
+func (x *Actor) enqueue(j job) {
+	x.mu.Lock()
+	x.queue = append(x.queue, j)
+	start := !x.busy
+	if start {
+		x.busy = true
+		x.idle = make(chan struct{})
+	}
+	x.mu.Unlock()
+
+	if start {
+		go x.work()
+	}
+	x.notify()
+}
+
+// work runs the queued jobs one after the other until the queue is empty
+func (x *Actor) work() {
+	for {
+		x.mu.Lock()
+		if len(x.steering) > 0 {
+			x.queue = requeue(x.queue, x.steering)
+			x.steering = nil
+		}
+		if len(x.queue) == 0 {
+			x.busy = false
+			x.current = job{}
+			x.cancel = nil
+			idle := x.idle
+			x.mu.Unlock()
+			// Waiters are released once the idle state was reported
+			x.notify()
+			close(idle)
+			return
+		}
+		j := x.queue[0]
+		x.queue = x.queue[1:]
+		ctx, cancel := context.WithCancel(context.Background())
+		x.current = j
+		x.cancel = cancel
+		x.mu.Unlock()
+		x.notify()
+
+		result := x.run(ctx, j)
+		cancel()
+
+		x.mu.Lock()
+		x.current = job{}
+		x.cancel = nil
+		x.mu.Unlock()
+
+		if x.onDone != nil {
+			x.onDone(result)
+		}
+	}
+}
+
+func (x *Actor) run(ctx context.Context, j job) Result {
+	if j.do != nil {
+		return Result{Name: j.name, Err: j.do(ctx, x.agent)}
+	}
+	reason, err := x.agent.Run(ctx, j.prompt, j.attachments...)
+	return Result{Prompt: j.prompt, Reason: reason, Err: err}
+}
+
+// requeue turns the steering messages a run didn't pick up into prompts, in the order they
+// were sent among the queued jobs. Messages sent with no job in between make one prompt.
+func requeue(queue []job, steering []steer) []job {
+	requeued := make([]job, 0, len(queue)+len(steering))
+	next := 0
+	for i := 0; i < len(steering); {
+		for next < steering[i].queued && next < len(queue) {
+			requeued = append(requeued, queue[next])
+			next++
+		}
+		var messages []string
+		for queued := steering[i].queued; i < len(steering) && steering[i].queued == queued; i++ {
+			messages = append(messages, steering[i].message)
+		}
+		requeued = append(requeued, job{prompt: strings.Join(messages, "\n\n")})
+	}
+	return append(requeued, queue[next:]...)
+}
+
+// takeSteering is the agent's steering source
+func (x *Actor) takeSteering() []string {
+	x.mu.Lock()
+	var messages []string
+	for _, s := range x.steering {
+		messages = append(messages, s.message)
+	}
+	x.steering = nil
+	x.mu.Unlock()
+	if len(messages) > 0 {
+		x.notify()
+	}
+	return messages
+}
+
+// notify reports the latest state, the lock keeps the reports in order
+func (x *Actor) notify() {
+	if x.onChange == nil {
+		return
+	}
+	x.notifyMu.Lock()
+	defer x.notifyMu.Unlock()
+	x.onChange(x.State())
+}
@@ -0,0 +1,155 @@
+package actor
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler/agent"
+	"github.com/mightymoud/arlocode/internal/butler/llm/fake"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// results collects what the actor reports
+type results struct {
+	mu   sync.Mutex
+	list []Result
+}
+
+func (r *results) add(result Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.list = append(r.list, result)
+}
+
+func (r *results) get() []Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.list)
+}
+
+func TestActor_RunsOneJobAtATime(t *testing.T) {
+	model := fake.New(t).
+		Turn().Delay(20 * time.Millisecond).Text("first answer").
+		Turn().Expect(fake.MessageCount(3)).Text("second answer")
+	a := agent.NewAgent(model).WithNoTools()
+	var done results
+	x := New(a).WithOnDone(done.add)
+
+	var compactedAfter int
+	x.Send("first")
+	x.Do("count", func(ctx context.Context, a *agent.Agent) error {
+		compactedAfter = len(a.GetMemory())
+		return nil
+	})
+	x.Send("second")
+	if state := x.State(); !state.Busy || len(state.Queued) < 2 {
+		t.Errorf("Expected the jobs to wait, got %+v", state)
+	}
+	x.Wait()
+
+	got := done.get()
+	if len(got) != 3 || got[0].Prompt != "first" || got[1].Name != "count" || got[2].Prompt != "second" {
+		t.Fatalf("Expected the jobs in order, got %+v", got)
+	}
+	for _, r := range got {
+		if r.Err != nil {
+			t.Errorf("Job failed: %+v", r)
+		}
+	}
+	if compactedAfter != 2 {
+		t.Errorf("Expected the job to see the first run's memory, got %d entries", compactedAfter)
+	}
+	if x.Busy() {
+		t.Error("Expected the actor to be idle")
+	}
+}
+
+func TestActor_Steer(t *testing.T) {
+	var x *Actor
+	// The user types while the tool runs
+	lookup := tools.NewButlerTool("lookup", "Looks something up", func(args struct{}) (string, error) {
+		x.Steer("use the v2 API")
+		return "found it", nil
+	})
+	model := fake.New(t).
+		Turn().CallTool("lookup", map[string]any{}).
+		Turn().Expect(fake.LastMessage("user", "use the v2 API")).Text("switched to v2")
+	a := agent.NewAgent(model).WitTools([]tools.Tool{lookup})
+	var states []State
+	x = New(a).WithOnChange(func(s State) { states = append(states, s) })
+
+	x.Send("fix the client")
+	x.Wait()
+
+	if model.Remaining() != 0 {
+		t.Errorf("Expected both turns to run, %d left", model.Remaining())
+	}
+	if !slices.ContainsFunc(states, func(s State) bool { return len(s.Steering) == 1 }) {
+		t.Errorf("Expected the state to show the steering message, got %+v", states)
+	}
+
+	// Without a run in progress the message is a prompt of its own
+	model.Turn().Expect(fake.LastMessage("user", "thanks")).Text("you're welcome")
+	x.Steer("thanks")
+	x.Wait()
+	if model.Remaining() != 0 {
+		t.Error("Expected the steering message to be run as a prompt")
+	}
+}
+
+func TestActor_Cancel(t *testing.T) {
+	model := fake.New(t).Turn().Delay(time.Minute).Text("too late")
+	a := agent.NewAgent(model).WithNoTools()
+	var done results
+	x := New(a).WithOnDone(done.add)
+
+	x.Send("slow")
+	x.Send("queued")
+	for x.State().Current != "slow" {
+		time.Sleep(time.Millisecond)
+	}
+	if dropped := x.Cancel(); dropped != 1 {
+		t.Errorf("Expected the queued prompt to be dropped, got %d", dropped)
+	}
+	x.Wait()
+
+	got := done.get()
+	if len(got) != 1 || !errors.Is(got[0].Err, context.Canceled) || got[0].Reason != agent.StopCancelled {
+		t.Errorf("Expected only the cancelled run, got %+v", got)
+	}
+}
+
+func TestActor_LeftoverSteeringKeepsOrder(t *testing.T) {
+	var x *Actor
+	// The run hits its iteration limit before it picks up the steering message
+	lookup := tools.NewButlerTool("lookup", "Looks something up", func(args struct{}) (string, error) {
+		x.Send("sent before")
+		x.Steer("steered")
+		x.Send("sent after")
+		return "found it", nil
+	})
+	model := fake.New(t).
+		Turn().CallTool("lookup", map[string]any{}).
+		Turn().Text("out of iterations").
+		Turn().Expect(fake.LastMessage("user", "sent before")).Text("ok").
+		Turn().Expect(fake.LastMessage("user", "steered")).Text("ok").
+		Turn().Expect(fake.LastMessage("user", "sent after")).Text("ok")
+	a := agent.NewAgent(model).WitTools([]tools.Tool{lookup}).WithMaxIterations(1)
+	var done results
+	x = New(a).WithOnDone(done.add)
+
+	x.Send("fix the client")
+	x.Wait()
+
+	var prompts []string
+	for _, r := range done.get() {
+		prompts = append(prompts, r.Prompt)
+	}
+	if want := []string{"fix the client", "sent before", "steered", "sent after"}; !slices.Equal(prompts, want) {
+		t.Errorf("Expected the prompts in send order %v, got %v", want, prompts)
+	}
+}
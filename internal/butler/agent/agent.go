@@ -3,41 +3,111 @@ package agent
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/fatih/color"
 	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/approval"
+	"github.com/mightymoud/arlocode/internal/butler/compaction"
 	"github.com/mightymoud/arlocode/internal/butler/llm"
 	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
 	"github.com/mightymoud/arlocode/internal/butler/tools"
 )
 
 type Agent struct {
 	llm                llm.LLM
+	middlewares        []llm.Middleware
+	systemPrompt       string
+	systemPromptFunc   SystemPromptFunc
 	memory             []memory.MemoryEntry
 	tools              []tools.Tool
 	maxIterations      int
+	budget             Budget
+	approvalPolicy     approval.Policy
+	askApproval        approval.AskFunc
+	compactor          *compaction.Compactor
+	recorder           Recorder
+	steering           SteeringFunc
+	running            atomic.Bool
+	usageMu            sync.Mutex
+	runUsage           providers.Usage
+	sessionUsage       providers.Usage
+	eventsMu           sync.Mutex
+	subscribers        []subscriber
+	nextSubscriberID   int
+	runID              string
+	turn               int
+	OnCompaction       OnCompactionFunc
+	OnUsage            OnUsageFunc
 	OnTextChunk        butler.OnTextChunkFunc
 	OnStreamComplete   butler.OnStreamCompleteFunc
 	OnThinkingChunk    butler.OnThinkingChunkFunc
 	OnThinkingComplete butler.OnThinkingCompleteFunc
 	OnToolCall         butler.OnToolCallFunc
+	OnStreamReset      butler.OnStreamResetFunc
 }
 
-func NewAgent(l llm.LLM) *Agent {
+// NewAgent runs on l wrapped in the middlewares, see llm.Chain.
+// Models set later with WithLLM are wrapped in the same middlewares.
+func NewAgent(l llm.LLM, middlewares ...llm.Middleware) *Agent {
 	return &Agent{
-		llm:           l,
-		memory:        []memory.MemoryEntry{},
-		tools:         tools.StdToolset,
-		maxIterations: 10, // Default max iterations as recommended by OpenRouter docs
+		llm:            llm.Chain(l, middlewares...),
+		middlewares:    middlewares,
+		memory:         []memory.MemoryEntry{},
+		tools:          tools.StdToolset,
+		maxIterations:  10, // Default max iterations as recommended by OpenRouter docs
+		approvalPolicy: approval.AllowAll(),
 	}
 }
 
+// Recorder persists the memory as it changes, see session.Session
+type Recorder interface {
+	Append(entry memory.MemoryEntry)
+	Reset(entries []memory.MemoryEntry)
+}
+
+// SystemPromptFunc builds the system prompt, see sysprompt.Assembler
+type SystemPromptFunc func(ctx context.Context) (string, error)
+
+// SteeringFunc returns the messages the user sent since it was last called, see WithSteering
+type SteeringFunc func() []string
+
+// ErrRunning is returned by Run when the agent is already running, runs share the memory so they can't overlap
+var ErrRunning = errors.New("the agent is already running")
+
+// OnCompactionFunc is called after the conversation was compacted
+type OnCompactionFunc func(result compaction.Result)
+
 // Mods
-func (a *Agent) WithMemory(memory []memory.MemoryEntry) *Agent {
-	a.memory = memory
+// WithLLM swaps the model, the memory is kept so the conversation carries on with the new one
+func (a *Agent) WithLLM(l llm.LLM) *Agent {
+	a.llm = llm.Chain(l, a.middlewares...)
+	return a
+}
+
+// WithSystemPrompt sets the instructions sent ahead of the memory on every model call.
+// The prompt isn't part of the memory, so changing it never rewrites the conversation.
+func (a *Agent) WithSystemPrompt(prompt string) *Agent {
+	a.systemPrompt = prompt
+	return a
+}
+
+// WithSystemPromptFunc rebuilds the system prompt at the start of every Run,
+// so facts like the date or the project instructions stay current across a long session
+func (a *Agent) WithSystemPromptFunc(f SystemPromptFunc) *Agent {
+	a.systemPromptFunc = f
+	return a
+}
+
+func (a *Agent) WithMemory(entries []memory.MemoryEntry) *Agent {
+	a.memory = memory.Link(entries)
 	return a
 }
 
@@ -56,6 +126,43 @@ func (a *Agent) WithMaxIterations(max int) *Agent {
 	return a
 }
 
+// WithApprovalPolicy sets the policy every tool call is checked against before it runs
+func (a *Agent) WithApprovalPolicy(p approval.Policy) *Agent {
+	a.approvalPolicy = p
+	return a
+}
+
+// WithOnApprovalRequest sets the callback used when the policy asks for confirmation
+func (a *Agent) WithOnApprovalRequest(f approval.AskFunc) *Agent {
+	a.askApproval = f
+	return a
+}
+
+// WithCompaction compacts the memory before a model call whenever it crosses the compactor's threshold
+func (a *Agent) WithCompaction(c *compaction.Compactor) *Agent {
+	a.compactor = c
+	return a
+}
+
+// WithRecorder records every memory change, memory set through WithMemory is considered already recorded
+func (a *Agent) WithRecorder(r Recorder) *Agent {
+	a.recorder = r
+	return a
+}
+
+// WithSteering lets the user redirect a run in progress. Before every model call but the first,
+// and once more when the model is done, the messages f returns are added to the memory as one
+// user prompt. A run the model considered done carries on when there is such a prompt.
+func (a *Agent) WithSteering(f SteeringFunc) *Agent {
+	a.steering = f
+	return a
+}
+
+func (a *Agent) WithOnCompaction(f OnCompactionFunc) *Agent {
+	a.OnCompaction = f
+	return a
+}
+
 func (l *Agent) WithOnThinkingChunk(f butler.OnThinkingChunkFunc) *Agent {
 	l.OnThinkingChunk = f
 	return l
@@ -81,9 +188,67 @@ func (l *Agent) WithOnToolCall(f butler.OnToolCallFunc) *Agent {
 	return l
 }
 
+func (l *Agent) WithOnStreamReset(f butler.OnStreamResetFunc) *Agent {
+	l.OnStreamReset = f
+	return l
+}
+
 // Mock for Memory stuff later this is where Agent will use it
 func (a *Agent) AddMemoryEntry(entry memory.MemoryEntry) {
+	if entry.Tokens == 0 {
+		entry.Tokens = memory.EstimateTokens(entry)
+	}
+	parent := ""
+	if len(a.memory) > 0 {
+		parent = a.memory[len(a.memory)-1].ID
+	}
+	if entry.ID == "" || entry.Parent != parent {
+		entry.ID, entry.Parent = memory.NewID(), parent
+	}
 	a.memory = append(a.memory, entry)
+	if a.recorder != nil {
+		a.recorder.Append(entry)
+	}
+}
+
+// setMemory replaces the whole memory, e.g. after compaction
+func (a *Agent) setMemory(entries []memory.MemoryEntry) {
+	entries = memory.Link(entries)
+	a.memory = entries
+	if a.recorder != nil {
+		a.recorder.Reset(entries)
+	}
+}
+
+// TruncateMemory keeps the first n entries, e.g. to rewind the conversation to an earlier turn.
+// It must not be called while Run is in progress.
+func (a *Agent) TruncateMemory(n int) error {
+	if n < 0 || n > len(a.memory) {
+		return fmt.Errorf("can't truncate memory of %d entries to %d", len(a.memory), n)
+	}
+	a.setMemory(slices.Clone(a.memory[:n]))
+	return nil
+}
+
+// Fork goes back to the entry with the given ID, an empty id going back to the start, so the
+// next prompt starts a new branch of the conversation. With a session recording the memory
+// the later entries stay there as the branch that was left, see session.Session.Branches.
+// It must not be called while Run is in progress.
+func (a *Agent) Fork(id string) error {
+	if id == "" {
+		return a.TruncateMemory(0)
+	}
+	i := slices.IndexFunc(a.memory, func(entry memory.MemoryEntry) bool { return entry.ID == id })
+	if i < 0 {
+		return fmt.Errorf("no entry %s in the memory", id)
+	}
+	return a.TruncateMemory(i + 1)
+}
+
+// SwitchBranch replaces the memory with another branch of the conversation, e.g. one
+// returned by session.Session.Branch. It must not be called while Run is in progress.
+func (a *Agent) SwitchBranch(entries []memory.MemoryEntry) {
+	a.setMemory(slices.Clone(entries))
 }
 
 // Memory stuff later
@@ -91,110 +256,456 @@ func (a *Agent) GetMemory() []memory.MemoryEntry {
 	return a.memory
 }
 
-func (a *Agent) HandleToolCall(ctx context.Context, call tools.ToolCall) (string, error) {
-	var tool tools.Tool
+// messages is what the model gets on every call: the system prompt followed by the memory
+func (a *Agent) messages() []memory.MemoryEntry {
+	if a.systemPrompt == "" {
+		return a.memory
+	}
+	messages := make([]memory.MemoryEntry, 0, len(a.memory)+1)
+	messages = append(messages, memory.Text(memory.System, a.systemPrompt))
+	return append(messages, a.memory...)
+}
+
+// Tokens is the estimated size of the memory in tokens
+func (a *Agent) Tokens() int {
+	return memory.CountTokens(a.memory)
+}
+
+// overheadTokens is the estimated size of what every model call sends besides the memory:
+// the system prompt and the tool declarations
+func (a *Agent) overheadTokens() int {
+	tokens := tools.EstimateTokens(a.tools)
+	if a.systemPrompt != "" {
+		tokens += memory.EstimateTokens(memory.Text(memory.System, a.systemPrompt))
+	}
+	return tokens
+}
+
+// Compact summarizes the conversation now, whatever its size.
+// The memory is left untouched when it fails. It must not be called while Run is in progress.
+func (a *Agent) Compact(ctx context.Context) (compaction.Result, error) {
+	if a.compactor == nil {
+		return compaction.Result{}, errors.New("compaction is not configured for this agent")
+	}
+	compacted, result, err := a.compactor.Compact(ctx, a.memory)
+	a.AddUsage(result.Usage)
+	if err != nil {
+		return result, err
+	}
+	a.setMemory(compacted)
+	a.compacted(result)
+	return result, nil
+}
+
+// autoCompact compacts the memory when it grew past the threshold.
+// A failed summary isn't fatal, the run goes on with whatever pruning already freed up
+// and the provider reports ErrContextTooLong if that wasn't enough.
+func (a *Agent) autoCompact(ctx context.Context) error {
+	overhead := a.overheadTokens()
+	if a.compactor == nil || !a.compactor.ShouldCompact(a.memory, overhead) {
+		return nil
+	}
+	compacted, result, _ := a.compactor.MaybeCompact(ctx, a.memory, overhead)
+	a.AddUsage(result.Usage)
+	if result.TokensAfter < result.TokensBefore {
+		a.setMemory(compacted)
+		a.compacted(result)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (a *Agent) compacted(result compaction.Result) {
+	if a.OnCompaction != nil {
+		a.OnCompaction(result)
+	}
+	a.emit(Compacted{EventMeta: a.meta(""), Result: result})
+}
+
+// HandleToolCall runs a tool call and returns its output.
+// Unknown tools, undecodable arguments and panicking handlers come back as errors
+// instead of crashing, so the model can see what went wrong and correct itself.
+func (a *Agent) HandleToolCall(ctx context.Context, call tools.ToolCall) (output string, err error) {
+	ctx, span := startToolSpan(ctx, call)
+	defer func() {
+		span.SetAttributes(attrOutputSize.Int(len(output)))
+		endSpan(span, err)
+	}()
+	return a.callTool(ctx, call)
+}
+
+func (a *Agent) callTool(ctx context.Context, call tools.ToolCall) (output string, err error) {
+	tool, ok := a.findTool(call.FunctionName)
+	if !ok {
+		return "", fmt.Errorf("unknown tool %q, available tools are: %s", call.FunctionName, strings.Join(a.toolNames(), ", "))
+	}
+
+	argsPtr, err := decodeToolArgs(tool, call)
+	if err != nil {
+		return "", err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			output = ""
+			err = fmt.Errorf("tool %s panicked: %v", call.FunctionName, r)
+		}
+	}()
+
+	args := []reflect.Value{reflect.ValueOf(argsPtr).Elem()}
+	if tool.TakesContext {
+		args = append([]reflect.Value{reflect.ValueOf(ctx)}, args...)
+	}
+	results := tool.Handler.Call(args)
+
+	resultStr := results[0].String()
+	if len(results) > 1 && !results[1].IsNil() {
+		return resultStr, results[1].Interface().(error)
+	}
+
+	return resultStr, nil
+}
+
+func (a *Agent) findTool(name string) (tools.Tool, bool) {
 	for _, t := range a.tools {
-		if t.Name == call.FunctionName {
-			tool = t
-			break
+		if t.Name == name {
+			return t, true
 		}
 	}
+	return tools.Tool{}, false
+}
+
+func (a *Agent) toolNames() []string {
+	names := make([]string, 0, len(a.tools))
+	for _, t := range a.tools {
+		names = append(names, t.Name)
+	}
+	return names
+}
 
+// decodeToolArgs converts the call arguments into a pointer to the tool's args struct.
+// This works for ANY provider because we go through JSON bytes first.
+func decodeToolArgs(tool tools.Tool, call tools.ToolCall) (any, error) {
 	argsPtr := reflect.New(tool.ArgType).Interface()
 
-	// Convert the map[string]any into the concrete struct.
-	// This works for ANY provider because we go through JSON bytes first.
-	bytes, _ := json.Marshal(call.Arguments)
-	if err := json.Unmarshal(bytes, argsPtr); err != nil {
-		return "", fmt.Errorf("failed to unmarshal tool args: %w", err)
+	// Providers keep the raw JSON when the model sent arguments they couldn't parse
+	raw := []byte(call.RawArguments)
+	if call.Arguments != nil || len(raw) == 0 {
+		var err error
+		raw, err = json.Marshal(call.Arguments)
+		if err != nil {
+			return nil, fmt.Errorf("invalid arguments for tool %s: %w", call.FunctionName, err)
+		}
 	}
 
-	results := tool.Handler.Call([]reflect.Value{
-		reflect.ValueOf(argsPtr).Elem(),
-	})
+	if err := json.Unmarshal(raw, argsPtr); err != nil {
+		return nil, fmt.Errorf("invalid arguments for tool %s: %v. Expected a JSON object with: %s", call.FunctionName, err, describeArgs(tool.ArgType))
+	}
+	return argsPtr, nil
+}
 
-	if len(results) > 1 && !results[1].IsNil() {
-		return "", results[1].Interface().(error)
+// describeArgs lists the argument names and types of a tool, e.g. "path (string), count (int)"
+func describeArgs(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return t.String()
 	}
-	resultStr := results[0].String()
 
-	// Maybe useful to debug later
-	// if len(resultStr) > 100 {
-	// 	color.Blue("Tool %s returned: %s", call.FunctionName, resultStr[:100])
-	// } else {
-	// 	color.Blue("Tool %s returned: %s", call.FunctionName, resultStr)
-	// }
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, fmt.Sprintf("%s (%s)", name, field.Type))
+	}
+	return strings.Join(fields, ", ")
+}
 
-	return resultStr, nil
+// toolErrorMessage is the tool result the model sees when a call fails
+func toolErrorMessage(output string, err error) string {
+	message := "error: " + err.Error()
+	if output != "" {
+		message += "\n\n" + output
+	}
+	return message
 }
 
-func (a *Agent) Run(ctx context.Context, prompt string) error {
-	initMessage := memory.MemoryEntry{Message: prompt, Role: "user"}
-	a.AddMemoryEntry(initMessage)
+// approveToolCall checks the call against the approval policy and asks the attached frontend when needed.
+// When the call is not approved the returned message explains why, so it can go back to the model.
+func (a *Agent) approveToolCall(ctx context.Context, call tools.ToolCall) (bool, string) {
+	if a.approvalPolicy == nil {
+		return true, ""
+	}
+
+	decision, reason := a.approvalPolicy.Evaluate(call)
+	switch decision {
+	case approval.Allow:
+		return true, ""
+	case approval.Ask:
+		if a.askApproval == nil {
+			return false, fmt.Sprintf("Tool call %s requires approval but no approval handler is attached", call.FunctionName)
+		}
+		approved, err := a.askApproval(ctx, call)
+		if err != nil {
+			return false, fmt.Sprintf("Tool call %s was not approved: %v", call.FunctionName, err)
+		}
+		if !approved {
+			return false, fmt.Sprintf("Tool call %s was denied by the user", call.FunctionName)
+		}
+		return true, ""
+	default:
+		if reason == "" {
+			return false, fmt.Sprintf("Tool call %s was denied by the approval policy", call.FunctionName)
+		}
+		return false, fmt.Sprintf("Tool call %s was denied by the approval policy: %s", call.FunctionName, reason)
+	}
+}
+
+const interruptedToolMessage = "Tool call interrupted by the user"
 
-	hooks := butler.EventHooks{
-		OnTextChunk:        a.OnTextChunk,
-		OnStreamComplete:   a.OnStreamComplete,
-		OnThinkingChunk:    a.OnThinkingChunk,
-		OnThinkingComplete: a.OnThinkingComplete,
-		OnToolCall:         a.OnToolCall,
+// interruptToolCalls answers tool calls that never ran because the run was cancelled.
+// Providers reject histories where a tool call has no result, so each one gets a tool entry.
+func (a *Agent) interruptToolCalls(calls []tools.ToolCall) {
+	for _, call := range calls {
+		entry := memory.ToolResult(call, interruptedToolMessage, false)
+		entry.Interrupted = true
+		a.AddMemoryEntry(entry)
+		a.emit(ToolFinished{EventMeta: a.meta(call.ID), Call: call, Output: interruptedToolMessage, Interrupted: true})
 	}
+}
+
+// Run sends the prompt to the model and loops over tool calls until the model is done,
+// the iteration limit or the budget is reached. When a limit stops the run, the model is
+// asked once more, without tools, for a summary of where it stopped.
+// Cancelling ctx stops the provider stream and any running tool, the partial output
+// stays in memory marked as interrupted and Run returns the context error.
+// Provider failures are returned as *butler.ProviderError.
+// Attachments, e.g. screenshots made with memory.Attach, are sent after the prompt.
+func (a *Agent) Run(ctx context.Context, prompt string, attachments ...memory.Part) (reason StopReason, err error) {
+	return a.run(ctx, prompt, nil, attachments)
+}
+
+// run is Run holding the model calls of the loop to schema when it is set, see RunTyped.
+// The stop summary, compaction and sub-agents started by tools answer the way they normally do.
+func (a *Agent) run(ctx context.Context, prompt string, schema *llm.OutputSchema, attachments []memory.Part) (reason StopReason, err error) {
+	if !a.running.CompareAndSwap(false, true) {
+		return StopError, ErrRunning
+	}
+	defer a.running.Store(false)
+
+	started := time.Now()
+	a.startRun()
+	ctx, span := a.startRunSpan(ctx)
+	a.emit(RunStarted{EventMeta: a.meta(""), Prompt: prompt, Attachments: attachments})
+	defer func() {
+		meta := a.meta("")
+		endRunSpan(span, reason, meta.Turn, a.RunUsage(), err)
+		a.emit(RunFinished{EventMeta: meta, Reason: reason, Err: err, Duration: time.Since(started)})
+	}()
+
+	if a.systemPromptFunc != nil {
+		systemPrompt, err := a.systemPromptFunc(ctx)
+		if err != nil {
+			return StopError, fmt.Errorf("building system prompt: %w", err)
+		}
+		a.systemPrompt = systemPrompt
+	}
+	a.resetRunUsage()
+
+	initMessage := memory.Text(memory.User, prompt)
+	initMessage.Parts = append(initMessage.Parts, attachments...)
+	a.AddMemoryEntry(initMessage)
+
+	hooks := a.eventHooks()
 
 	iterationCount := 0
-	for iterationCount < a.maxIterations {
+	toolCallCount := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return StopCancelled, err
+		}
+		if iterationCount >= a.maxIterations {
+			return a.stopWithSummary(ctx, StopMaxIterations, fmt.Sprintf("limit of %d iterations", a.maxIterations), hooks)
+		}
+		if limit := a.budget.exceeded(a.RunUsage(), time.Since(started), toolCallCount); limit != "" {
+			return a.stopWithSummary(ctx, StopBudgetExhausted, limit, hooks)
+		}
+		if iterationCount > 0 {
+			a.steer()
+		}
 		iterationCount++
+		a.startTurn()
+
+		if err := a.autoCompact(ctx); err != nil {
+			return StopCancelled, err
+		}
 
-		result, err := a.llm.Stream(ctx, a.memory, a.tools, hooks)
+		callCtx := ctx
+		if schema != nil {
+			callCtx = llm.WithOutputSchema(ctx, *schema)
+		}
+		result, err := a.stream(callCtx, a.messages(), a.tools, hooks)
+		a.AddUsage(result.Usage)
 		if err != nil {
-			log.Fatal("Error calling LLM Stream: ", err)
-			return err
+			if ctx.Err() != nil {
+				// Keep the partial answer, its tool calls never ran so they are dropped
+				if result.Text != "" {
+					entry := memory.Text(memory.Model, result.Text)
+					entry.Interrupted = true
+					a.AddMemoryEntry(entry)
+				}
+				return StopCancelled, ctx.Err()
+			}
+			// Provider errors are classified into the butler taxonomy, callers check them with errors.Is
+			return StopError, err
+		}
+		response := memory.Response(result.Text, result.ToolCalls)
+		if result.Thinking != "" {
+			// Kept for the transcript of resumed sessions, providers don't get it back
+			response.Parts = slices.Insert(response.Parts, 0, memory.Part{Type: memory.ThinkingPart, Text: result.Thinking})
 		}
-		a.AddMemoryEntry(memory.MemoryEntry{Role: "model", Message: result.Text, ToolCalls: result.ToolCalls})
+		a.AddMemoryEntry(response)
 
 		if len(result.ToolCalls) == 0 {
-			break
-		}
-
-		for _, call := range result.ToolCalls {
-			// Ask user for confirmation before executing tool
-			// var confirm bool
-			// if err := huh.NewConfirm().
-			// 	Title("Execute tool?").
-			// 	Description(fmt.Sprintf("Do you want to execute %s?", call.FunctionName)).
-			// 	Affirmative("Yes").
-			// 	Negative("No").
-			// 	Value(&confirm).
-			// 	WithTheme(huh.ThemeCatppuccin()).
-			// 	Run(); err != nil {
-			// 	color.Red("Error getting confirmation: %v", err)
-			// 	continue
-			// }
-
-			// if !confirm {
-			// 	color.Red("Tool call %s was cancelled by the user.", call.FunctionName)
-			// 	a.AddMemoryEntry(memory.MemoryEntry{
-			// 		Role:       "tool",
-			// 		Message:    "Tool call cancelled by user",
-			// 		ToolName:   call.FunctionName,
-			// 		ToolCallID: call.ID,
-			// 	})
-			// 	continue
-			// }
-
-			output, _ := a.HandleToolCall(ctx, call)
-
-			a.AddMemoryEntry(memory.MemoryEntry{
-				Role:       "tool",
-				Message:    output,
-				ToolName:   call.FunctionName,
-				ToolCallID: call.ID,
-			})
-		}
-	}
-
-	if iterationCount >= a.maxIterations {
-		color.Yellow("\nWarning: Maximum iterations (%d) reached. The agent loop was terminated.\n", a.maxIterations)
+			if a.steer() {
+				continue
+			}
+			return StopCompleted, nil
+		}
+
+		for i, call := range result.ToolCalls {
+			if ctx.Err() != nil {
+				a.interruptToolCalls(result.ToolCalls[i:])
+				return StopCancelled, ctx.Err()
+			}
+			toolCallCount++
+
+			if approved, reason := a.approveToolCall(ctx, call); !approved {
+				a.AddMemoryEntry(memory.ToolResult(call, reason, false))
+				a.emit(ToolFinished{EventMeta: a.meta(call.ID), Call: call, Output: reason, Denied: true})
+				continue
+			}
+
+			a.emit(ToolStarted{EventMeta: a.meta(call.ID), Call: call})
+			toolStarted := time.Now()
+			output, err := a.HandleToolCall(ctx, call)
+			finished := ToolFinished{Call: call, Err: err, Duration: time.Since(toolStarted)}
+
+			if ctx.Err() != nil {
+				// Keep whatever the tool produced before it was cancelled
+				if output == "" {
+					output = interruptedToolMessage
+				}
+				entry := memory.ToolResult(call, output, false)
+				entry.Interrupted = true
+				a.AddMemoryEntry(entry)
+				finished.Output, finished.Interrupted = output, true
+				a.finishTool(finished)
+				a.interruptToolCalls(result.ToolCalls[i+1:])
+				return StopCancelled, ctx.Err()
+			}
+
+			if err != nil {
+				a.AddMemoryEntry(memory.ToolResult(call, toolErrorMessage(output, err), true))
+				finished.Output = toolErrorMessage(output, err)
+				a.finishTool(finished)
+				continue
+			}
+
+			a.AddMemoryEntry(memory.ToolResult(call, output, false))
+			finished.Output = output
+			a.finishTool(finished)
+		}
 	}
+}
 
-	return nil
+// steer adds the messages the user sent during the run as one prompt, it reports whether there were any
+func (a *Agent) steer() bool {
+	if a.steering == nil {
+		return false
+	}
+	messages := a.steering()
+	if len(messages) == 0 {
+		return false
+	}
+	a.AddMemoryEntry(memory.Text(memory.User, strings.Join(messages, "\n\n")))
+	a.emit(Steered{EventMeta: a.meta(""), Messages: messages})
+	return true
+}
+
+func (a *Agent) finishTool(event ToolFinished) {
+	event.EventMeta = a.meta(event.Call.ID)
+	a.emit(event)
+}
+
+// stopWithSummary ends a run that hit a limit with a last model call, without tools,
+// asking where the work stands. Tool calls the model makes anyway are dropped, they can't run.
+// A failed summary doesn't change why the run stopped, only a cancellation does.
+func (a *Agent) stopWithSummary(ctx context.Context, reason StopReason, limit string, hooks butler.EventHooks) (StopReason, error) {
+	a.startTurn()
+	messages := append(toolsAsText(a.messages()), memory.Text(memory.User, fmt.Sprintf(stopSummaryPrompt, limit)))
+	result, err := a.stream(ctx, messages, nil, hooks)
+	a.AddUsage(result.Usage)
+	if ctx.Err() != nil {
+		return StopCancelled, ctx.Err()
+	}
+	if err == nil && result.Text != "" {
+		a.AddMemoryEntry(memory.Text(memory.Model, result.Text))
+	}
+	return reason, nil
+}
+
+// toolsAsText rewrites the tool calls and results of entries as text, for a call made
+// without tools. Providers such as Anthropic and Gemini reject tool history when no tools are declared.
+func toolsAsText(entries []memory.MemoryEntry) []memory.MemoryEntry {
+	rewritten := make([]memory.MemoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		switch entry.Role {
+		case memory.Tool:
+			result, _ := entry.ToolResult()
+			rewritten = append(rewritten, memory.Text(memory.User, fmt.Sprintf("[%s result]\n%s", result.ToolName, result.Text)))
+		case memory.Model:
+			parts := make([]memory.Part, 0, len(entry.Parts))
+			for _, part := range entry.Parts {
+				if part.Type == memory.ToolCallPart {
+					part = memory.Part{Type: memory.TextPart, Text: fmt.Sprintf("(called %s with %s)", part.ToolCall.FunctionName, callArguments(*part.ToolCall))}
+				}
+				parts = append(parts, part)
+			}
+			entry.Parts = parts
+			rewritten = append(rewritten, entry)
+		default:
+			rewritten = append(rewritten, entry)
+		}
+	}
+	return rewritten
+}
+
+// callArguments is the JSON the model sent for a call
+func callArguments(call tools.ToolCall) string {
+	if call.Arguments != nil {
+		if raw, err := json.Marshal(call.Arguments); err == nil {
+			return string(raw)
+		}
+	}
+	if call.RawArguments != "" {
+		return call.RawArguments
+	}
+	return "{}"
 }
@@ -2,12 +2,25 @@ package agent
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/approval"
+	"github.com/mightymoud/arlocode/internal/butler/compaction"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
 	"github.com/mightymoud/arlocode/internal/butler/memory"
 	"github.com/mightymoud/arlocode/internal/butler/providers"
 	"github.com/mightymoud/arlocode/internal/butler/tools"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 // MockLLM is a mock implementation of the LLM interface
@@ -48,13 +61,39 @@ func TestNewAgent(t *testing.T) {
 	}
 }
 
+func TestNewAgent_Middlewares(t *testing.T) {
+	var calls []string
+	tag := func(name string) llm.Middleware {
+		return func(inner llm.LLM) llm.LLM {
+			return &MockLLM{StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+				calls = append(calls, name)
+				return inner.Stream(ctx, mem, t, hooks)
+			}}
+		}
+	}
+	agent := NewAgent(&MockLLM{}, tag("outer"), tag("inner")).WithNoTools()
+
+	if _, err := agent.Run(context.Background(), "hello"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	// Models set later get the same middlewares
+	agent.WithLLM(&MockLLM{})
+	if _, err := agent.Run(context.Background(), "again"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if want := []string{"outer", "inner", "outer", "inner"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("Expected calls through %v, got %v", want, calls)
+	}
+}
+
 func TestAgent_WithMemory(t *testing.T) {
 	mockLLM := &MockLLM{}
 	agent := NewAgent(mockLLM)
-	mem := []memory.MemoryEntry{{Message: "test", Role: "user"}}
+	mem := []memory.MemoryEntry{memory.Text(memory.User, "test")}
 
 	agent.WithMemory(mem)
-	if len(agent.memory) != 1 || agent.memory[0].Message != "test" {
+	if len(agent.memory) != 1 || agent.memory[0].Text() != "test" {
 		t.Errorf("WithMemory failed")
 	}
 }
@@ -83,7 +122,7 @@ func TestAgent_WithNoTools(t *testing.T) {
 func TestAgent_AddMemoryEntry(t *testing.T) {
 	mockLLM := &MockLLM{}
 	agent := NewAgent(mockLLM)
-	entry := memory.MemoryEntry{Message: "test", Role: "user"}
+	entry := memory.Text(memory.User, "test")
 
 	agent.AddMemoryEntry(entry)
 	if len(agent.memory) != 1 {
@@ -94,7 +133,7 @@ func TestAgent_AddMemoryEntry(t *testing.T) {
 func TestAgent_GetMemory(t *testing.T) {
 	mockLLM := &MockLLM{}
 	agent := NewAgent(mockLLM)
-	entry := memory.MemoryEntry{Message: "test", Role: "user"}
+	entry := memory.Text(memory.User, "test")
 	agent.AddMemoryEntry(entry)
 
 	mem := agent.GetMemory()
@@ -147,7 +186,7 @@ func TestAgent_Run(t *testing.T) {
 	ctx := context.Background()
 	prompt := "hello"
 
-	err := agent.Run(ctx, prompt)
+	_, err := agent.Run(ctx, prompt)
 	if err != nil {
 		t.Fatalf("Run failed: %v", err)
 	}
@@ -156,11 +195,59 @@ func TestAgent_Run(t *testing.T) {
 	if len(mem) != 2 {
 		t.Fatalf("Expected 2 memory entries, got %d", len(mem))
 	}
-	if mem[0].Message != "hello" {
-		t.Errorf("Expected first message 'hello', got '%s'", mem[0].Message)
+	if mem[0].Text() != "hello" {
+		t.Errorf("Expected first message 'hello', got '%s'", mem[0].Text())
+	}
+	if mem[1].Text() != "world" {
+		t.Errorf("Expected second message 'world', got '%s'", mem[1].Text())
+	}
+}
+
+func TestAgent_Run_WithAttachments(t *testing.T) {
+	var sent memory.MemoryEntry
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			sent = mem[len(mem)-1]
+			return providers.ProviderResponse{Text: "a typo on line 3"}, nil
+		},
+	}
+	agent := NewAgent(mockLLM).WithNoTools()
+	var started RunStarted
+	agent.Subscribe(func(e Event) {
+		if e, ok := e.(RunStarted); ok {
+			started = e
+		}
+	})
+
+	screenshot := memory.Image("image/png", []byte("png"))
+	if _, err := agent.Run(context.Background(), "what's wrong?", screenshot); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	want := []memory.Part{{Type: memory.TextPart, Text: "what's wrong?"}, screenshot}
+	if sent.Role != memory.User || !reflect.DeepEqual(sent.Parts, want) {
+		t.Errorf("Expected the prompt followed by the screenshot, got %+v", sent)
+	}
+	if len(started.Attachments) != 1 {
+		t.Errorf("Expected RunStarted to carry the attachment, got %+v", started)
+	}
+}
+
+func TestAgent_Run_KeepsThinking(t *testing.T) {
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			return providers.ProviderResponse{Thinking: "the user greets", Text: "world"}, nil
+		},
+	}
+	agent := NewAgent(mockLLM).WithNoTools()
+
+	if _, err := agent.Run(context.Background(), "hello"); err != nil {
+		t.Fatalf("Run failed: %v", err)
 	}
-	if mem[1].Message != "world" {
-		t.Errorf("Expected second message 'world', got '%s'", mem[1].Message)
+
+	want := []memory.Part{{Type: memory.ThinkingPart, Text: "the user greets"}, {Type: memory.TextPart, Text: "world"}}
+	if got := agent.GetMemory()[1]; !reflect.DeepEqual(got.Parts, want) {
+		t.Errorf("Expected the thinking before the answer, got %+v", got.Parts)
 	}
 }
 
@@ -195,7 +282,7 @@ func TestAgent_Run_WithToolCall(t *testing.T) {
 	ctx := context.Background()
 	prompt := "do something"
 
-	err := agent.Run(ctx, prompt)
+	_, err := agent.Run(ctx, prompt)
 	if err != nil {
 		t.Fatalf("Run failed: %v", err)
 	}
@@ -211,7 +298,1006 @@ func TestAgent_Run_WithToolCall(t *testing.T) {
 	if mem[2].Role != "tool" {
 		t.Errorf("Expected 3rd message role 'tool', got '%s'", mem[2].Role)
 	}
-	if mem[2].Message != "processed: test" {
-		t.Errorf("Expected tool output 'processed: test', got '%s'", mem[2].Message)
+	if mem[2].Text() != "processed: test" {
+		t.Errorf("Expected tool output 'processed: test', got '%s'", mem[2].Text())
+	}
+}
+
+func newToolCallingLLM() *MockLLM {
+	callCount := 0
+	return &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			callCount++
+			if callCount == 1 {
+				return providers.ProviderResponse{
+					ToolCalls: []tools.ToolCall{
+						{
+							ID:           "call_1",
+							FunctionName: "mock_tool",
+							Arguments:    map[string]any{"input": "test"},
+						},
+					},
+				}, nil
+			}
+			return providers.ProviderResponse{Text: "Final answer"}, nil
+		},
+	}
+}
+
+func TestAgent_Run_ToolCallDeniedByPolicy(t *testing.T) {
+	mockTool := tools.NewButlerTool("mock_tool", "mock description", MockToolHandler)
+
+	agent := NewAgent(newToolCallingLLM()).
+		WitTools([]tools.Tool{mockTool}).
+		WithApprovalPolicy(approval.NewRulePolicy(approval.Allow,
+			approval.Rule{Tool: "mock_tool", Decision: approval.Deny, Reason: "not allowed in tests"},
+		))
+
+	if _, err := agent.Run(context.Background(), "do something"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	mem := agent.GetMemory()
+	if len(mem) != 4 {
+		t.Fatalf("Expected 4 memory entries, got %d", len(mem))
+	}
+	if result, _ := mem[2].ToolResult(); result.ToolCallID != "call_1" {
+		t.Errorf("Expected denied call to be answered with a tool message, got %+v", mem[2])
+	}
+	if !strings.Contains(mem[2].Text(), "not allowed in tests") {
+		t.Errorf("Expected denial reason in tool message, got '%s'", mem[2].Text())
+	}
+}
+
+func TestAgent_Run_ToolCallAsk(t *testing.T) {
+	mockTool := tools.NewButlerTool("mock_tool", "mock description", MockToolHandler)
+
+	for _, approved := range []bool{true, false} {
+		asked := 0
+		agent := NewAgent(newToolCallingLLM()).
+			WitTools([]tools.Tool{mockTool}).
+			WithApprovalPolicy(approval.NewRulePolicy(approval.Ask)).
+			WithOnApprovalRequest(func(ctx context.Context, call tools.ToolCall) (bool, error) {
+				asked++
+				return approved, nil
+			})
+
+		if _, err := agent.Run(context.Background(), "do something"); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if asked != 1 {
+			t.Errorf("Expected to be asked once, got %d", asked)
+		}
+
+		toolOutput := agent.GetMemory()[2].Text()
+		if approved && toolOutput != "processed: test" {
+			t.Errorf("Expected approved call to run, got '%s'", toolOutput)
+		}
+		if !approved && !strings.Contains(toolOutput, "denied by the user") {
+			t.Errorf("Expected rejected call to be reported, got '%s'", toolOutput)
+		}
+	}
+}
+
+func TestAgent_Run_ToolCallAskWithoutHandler(t *testing.T) {
+	mockTool := tools.NewButlerTool("mock_tool", "mock description", MockToolHandler)
+
+	agent := NewAgent(newToolCallingLLM()).
+		WitTools([]tools.Tool{mockTool}).
+		WithApprovalPolicy(approval.NewRulePolicy(approval.Ask))
+
+	if _, err := agent.Run(context.Background(), "do something"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(agent.GetMemory()[2].Text(), "no approval handler") {
+		t.Errorf("Expected missing handler to deny the call, got '%s'", agent.GetMemory()[2].Text())
+	}
+}
+
+func TestAgent_Run_CancelledDuringStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			cancel()
+			return providers.ProviderResponse{Text: "partial"}, ctx.Err()
+		},
+	}
+
+	agent := NewAgent(mockLLM).WithNoTools()
+	reason, err := agent.Run(ctx, "hello")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if reason != StopCancelled {
+		t.Errorf("Expected stop reason %q, got %q", StopCancelled, reason)
+	}
+
+	mem := agent.GetMemory()
+	if len(mem) != 2 {
+		t.Fatalf("Expected 2 memory entries, got %d", len(mem))
+	}
+	if mem[1].Text() != "partial" || !mem[1].Interrupted {
+		t.Errorf("Expected partial interrupted model entry, got %+v", mem[1])
+	}
+}
+
+func TestAgent_Run_CancelledDuringToolCall(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	blockingTool := tools.NewButlerTool("blocking_tool", "blocks until cancelled", func(ctx context.Context, args MockToolArgs) (string, error) {
+		cancel()
+		<-ctx.Done()
+		return "half done", ctx.Err()
+	})
+
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			return providers.ProviderResponse{
+				ToolCalls: []tools.ToolCall{
+					{ID: "call_1", FunctionName: "blocking_tool", Arguments: map[string]any{"input": "a"}},
+					{ID: "call_2", FunctionName: "blocking_tool", Arguments: map[string]any{"input": "b"}},
+				},
+			}, nil
+		},
+	}
+
+	agent := NewAgent(mockLLM).WitTools([]tools.Tool{blockingTool})
+	_, err := agent.Run(ctx, "hello")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	mem := agent.GetMemory()
+	// 1. User prompt
+	// 2. Model response (tool calls)
+	// 3. Interrupted tool output
+	// 4. Tool call that never ran
+	if len(mem) != 4 {
+		t.Fatalf("Expected 4 memory entries, got %d", len(mem))
+	}
+	if mem[2].Text() != "half done" || !mem[2].Interrupted {
+		t.Errorf("Expected partial tool output marked interrupted, got %+v", mem[2])
+	}
+	if result, _ := mem[3].ToolResult(); result.ToolCallID != "call_2" || !mem[3].Interrupted {
+		t.Errorf("Expected skipped tool call to be answered, got %+v", mem[3])
+	}
+}
+
+func TestAgent_Run_ReturnsProviderError(t *testing.T) {
+	providerErr := &butler.ProviderError{Kind: butler.ErrRateLimited, Provider: "mock"}
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			return providers.ProviderResponse{}, providerErr
+		},
+	}
+
+	agent := NewAgent(mockLLM).WithNoTools()
+	_, err := agent.Run(context.Background(), "hello")
+	if !errors.Is(err, butler.ErrRateLimited) {
+		t.Fatalf("Expected rate limit error, got %v", err)
+	}
+}
+
+func TestAgent_HandleToolCall_UnknownTool(t *testing.T) {
+	agent := NewAgent(&MockLLM{})
+	agent.WitTools([]tools.Tool{tools.NewButlerTool("mock_tool", "mock description", MockToolHandler)})
+
+	_, err := agent.HandleToolCall(context.Background(), tools.ToolCall{ID: "call_1", FunctionName: "missing_tool"})
+	if err == nil {
+		t.Fatal("Expected error for unknown tool")
+	}
+	if !strings.Contains(err.Error(), "missing_tool") || !strings.Contains(err.Error(), "mock_tool") {
+		t.Errorf("Expected error to name the tool and list valid tools, got '%s'", err)
+	}
+}
+
+func TestAgent_HandleToolCall_InvalidArguments(t *testing.T) {
+	agent := NewAgent(&MockLLM{})
+	agent.WitTools([]tools.Tool{tools.NewButlerTool("mock_tool", "mock description", MockToolHandler)})
+
+	cases := []tools.ToolCall{
+		{ID: "call_1", FunctionName: "mock_tool", Arguments: map[string]any{"input": 42}},
+		{ID: "call_2", FunctionName: "mock_tool", RawArguments: `{"input": "unterminated`},
+	}
+	for _, call := range cases {
+		_, err := agent.HandleToolCall(context.Background(), call)
+		if err == nil {
+			t.Fatalf("Expected error for %s", call.ID)
+		}
+		if !strings.Contains(err.Error(), "input (string)") {
+			t.Errorf("Expected error to describe the arguments, got '%s'", err)
+		}
+	}
+}
+
+func TestAgent_HandleToolCall_RecoversPanics(t *testing.T) {
+	panicking := tools.NewButlerTool("panicking_tool", "panics", func(args MockToolArgs) (string, error) {
+		panic("boom")
+	})
+	agent := NewAgent(&MockLLM{}).WitTools([]tools.Tool{panicking})
+
+	_, err := agent.HandleToolCall(context.Background(), tools.ToolCall{ID: "call_1", FunctionName: "panicking_tool"})
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Expected recovered panic as error, got %v", err)
+	}
+}
+
+func TestAgent_Run_ToolErrorsGoBackToModel(t *testing.T) {
+	failing := tools.NewButlerTool("mock_tool", "fails", func(args MockToolArgs) (string, error) {
+		return "", errors.New("file not found")
+	})
+
+	var seen []memory.MemoryEntry
+	callCount := 0
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			callCount++
+			if callCount == 1 {
+				return providers.ProviderResponse{
+					ToolCalls: []tools.ToolCall{
+						{ID: "call_1", FunctionName: "mock_tool", Arguments: map[string]any{"input": "a"}},
+						{ID: "call_2", FunctionName: "no_such_tool"},
+					},
+				}, nil
+			}
+			seen = mem
+			return providers.ProviderResponse{Text: "recovered"}, nil
+		},
+	}
+
+	agent := NewAgent(mockLLM).WitTools([]tools.Tool{failing})
+	if _, err := agent.Run(context.Background(), "do something"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if len(seen) != 4 {
+		t.Fatalf("Expected the model to see 4 entries, got %d", len(seen))
+	}
+	for _, entry := range seen[2:] {
+		if result, ok := entry.ToolResult(); !ok || !result.IsError || !strings.HasPrefix(result.Text, "error: ") {
+			t.Errorf("Expected error tool message, got %+v", entry)
+		}
+	}
+}
+
+func TestAgent_Run_CompactsWhenOverThreshold(t *testing.T) {
+	summarizer := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			return providers.ProviderResponse{Text: "earlier work"}, nil
+		},
+	}
+
+	var seen []memory.MemoryEntry
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			seen = mem
+			return providers.ProviderResponse{Text: "done"}, nil
+		},
+	}
+
+	history := []memory.MemoryEntry{
+		memory.Text(memory.User, strings.Repeat("old request ", 500)),
+		memory.Text(memory.Model, strings.Repeat("old answer ", 500)),
+	}
+
+	var compacted compaction.Result
+	agent := NewAgent(mockLLM).
+		WithMemory(history).
+		WithCompaction(compaction.New(compaction.Config{ContextLimit: 1000, Threshold: 0.5, KeepRecent: 1, Summarizer: summarizer})).
+		WithOnCompaction(func(r compaction.Result) { compacted = r })
+
+	if _, err := agent.Run(context.Background(), "new request"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if len(seen) != 2 {
+		t.Fatalf("Expected summary and prompt to be sent, got %d entries", len(seen))
+	}
+	if !strings.HasPrefix(seen[0].Text(), compaction.SummaryPrefix) || seen[1].Text() != "new request" {
+		t.Errorf("Unexpected memory sent to the model: %+v", seen)
+	}
+	if compacted.SummarizedEntries != 2 {
+		t.Errorf("Expected OnCompaction to report 2 summarized entries, got %d", compacted.SummarizedEntries)
+	}
+}
+
+func TestAgent_Run_CompactsCountingSystemPromptAndTools(t *testing.T) {
+	summarizer := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			return providers.ProviderResponse{Text: "earlier work"}, nil
+		},
+	}
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			return providers.ProviderResponse{Text: "done"}, nil
+		},
+	}
+
+	// The memory alone stays under the 500 token threshold, the system prompt pushes it over
+	history := []memory.MemoryEntry{
+		memory.Text(memory.User, strings.Repeat("old request ", 50)),
+		memory.Text(memory.Model, strings.Repeat("old answer ", 50)),
+	}
+	compactions := 0
+	agent := NewAgent(mockLLM).
+		WithMemory(history).
+		WithSystemPrompt(strings.Repeat("be careful ", 200)).
+		WitTools([]tools.Tool{tools.NewButlerTool("mock_tool", "mock description", MockToolHandler)}).
+		WithCompaction(compaction.New(compaction.Config{ContextLimit: 1000, Threshold: 0.5, KeepRecent: 1, Summarizer: summarizer})).
+		WithOnCompaction(func(compaction.Result) { compactions++ })
+
+	if _, err := agent.Run(context.Background(), "new request"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if compactions != 1 {
+		t.Errorf("Expected the system prompt to count towards the threshold, got %d compactions", compactions)
+	}
+}
+
+func TestAgent_Compact_NotConfigured(t *testing.T) {
+	agent := NewAgent(&MockLLM{})
+	if _, err := agent.Compact(context.Background()); err == nil {
+		t.Error("Expected error when compaction is not configured")
+	}
+}
+
+type mockRecorder struct {
+	appended []memory.MemoryEntry
+	resets   int
+}
+
+func (r *mockRecorder) Append(entry memory.MemoryEntry) { r.appended = append(r.appended, entry) }
+func (r *mockRecorder) Reset(entries []memory.MemoryEntry) {
+	r.resets++
+	r.appended = append([]memory.MemoryEntry{}, entries...)
+}
+
+func TestAgent_Run_RecordsMemory(t *testing.T) {
+	callCount := 0
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			callCount++
+			if callCount == 1 {
+				return providers.ProviderResponse{ToolCalls: []tools.ToolCall{
+					{ID: "call_1", FunctionName: "mock_tool", Arguments: map[string]any{"input": "a"}},
+				}}, nil
+			}
+			return providers.ProviderResponse{Text: "done"}, nil
+		},
+	}
+
+	recorder := &mockRecorder{}
+	agent := NewAgent(mockLLM).
+		WithMemory([]memory.MemoryEntry{memory.Text(memory.User, "resumed")}).
+		WitTools([]tools.Tool{tools.NewButlerTool("mock_tool", "mock description", MockToolHandler)}).
+		WithRecorder(recorder)
+
+	if _, err := agent.Run(context.Background(), "go"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if len(recorder.appended) != 4 {
+		t.Fatalf("Expected the 4 new entries to be recorded, got %d", len(recorder.appended))
+	}
+	if !reflect.DeepEqual(recorder.appended, agent.GetMemory()[1:]) {
+		t.Error("Expected recorded entries to match the new memory")
+	}
+	if recorder.resets != 0 {
+		t.Errorf("Expected no resets, got %d", recorder.resets)
+	}
+}
+
+func TestAgent_Fork(t *testing.T) {
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			return providers.ProviderResponse{Text: "done"}, nil
+		},
+	}
+	recorder := &mockRecorder{}
+	agent := NewAgent(mockLLM).WithNoTools().WithRecorder(recorder)
+	for _, prompt := range []string{"first", "second"} {
+		if _, err := agent.Run(context.Background(), prompt); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+	}
+	mem := agent.GetMemory()
+	for i, entry := range mem {
+		if entry.ID == "" || i > 0 && entry.Parent != mem[i-1].ID {
+			t.Fatalf("Expected the entries to be chained, got %+v", mem)
+		}
+	}
+
+	if err := agent.Fork(mem[1].ID); err != nil {
+		t.Fatalf("Fork failed: %v", err)
+	}
+	if recorder.resets != 1 || len(agent.GetMemory()) != 2 {
+		t.Fatalf("Expected the memory to be cut after the first answer, got %+v", agent.GetMemory())
+	}
+	if _, err := agent.Run(context.Background(), "something else"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if forked := agent.GetMemory(); forked[2].Parent != mem[1].ID || forked[2].ID == mem[2].ID {
+		t.Errorf("Expected the new prompt to branch off the first answer, got %+v", forked[2])
+	}
+
+	if err := agent.Fork("missing"); err == nil {
+		t.Error("Expected forking at an unknown entry to fail")
+	}
+}
+
+func TestAgent_Run_SendsSystemPrompt(t *testing.T) {
+	var seen []memory.MemoryEntry
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			seen = mem
+			return providers.ProviderResponse{Text: "ok"}, nil
+		},
+	}
+
+	agent := NewAgent(mockLLM).WithSystemPrompt("You are a test agent")
+	if _, err := agent.Run(context.Background(), "hello"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if len(seen) != 2 || seen[0].Role != "system" || seen[0].Text() != "You are a test agent" {
+		t.Fatalf("Expected the system prompt first, got %+v", seen)
+	}
+	if memory := agent.GetMemory(); len(memory) != 2 || memory[0].Role != "user" {
+		t.Errorf("Expected the system prompt to stay out of the memory, got %+v", memory)
+	}
+}
+
+func TestAgent_Run_RebuildsSystemPrompt(t *testing.T) {
+	var seen []string
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			seen = append(seen, mem[0].Text())
+			return providers.ProviderResponse{Text: "ok"}, nil
+		},
+	}
+
+	builds := 0
+	agent := NewAgent(mockLLM).WithSystemPromptFunc(func(ctx context.Context) (string, error) {
+		builds++
+		return fmt.Sprintf("prompt %d", builds), nil
+	})
+	for _, prompt := range []string{"first", "second"} {
+		if _, err := agent.Run(context.Background(), prompt); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+	}
+	if len(seen) != 2 || seen[0] != "prompt 1" || seen[1] != "prompt 2" {
+		t.Errorf("Expected the prompt to be rebuilt on every run, got %v", seen)
+	}
+
+	failing := NewAgent(mockLLM).WithSystemPromptFunc(func(ctx context.Context) (string, error) {
+		return "", errors.New("unreadable AGENTS.md")
+	})
+	_, err := failing.Run(context.Background(), "hello")
+	if err == nil || !strings.Contains(err.Error(), "building system prompt: unreadable AGENTS.md") {
+		t.Errorf("Expected the build error, got %v", err)
+	}
+	if len(failing.GetMemory()) != 0 {
+		t.Errorf("Expected no memory after a failed build, got %+v", failing.GetMemory())
+	}
+}
+
+func TestAgent_Run_AggregatesUsage(t *testing.T) {
+	calls := 0
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			calls++
+			usage := providers.Usage{PromptTokens: 100, CompletionTokens: 10, Cost: 0.01}
+			if calls%2 == 1 {
+				return providers.ProviderResponse{ToolCalls: []tools.ToolCall{{ID: "1", FunctionName: "missing"}}, Usage: usage}, nil
+			}
+			return providers.ProviderResponse{Text: "done", Usage: usage}, nil
+		},
+	}
+
+	var reports []UsageReport
+	agent := NewAgent(mockLLM).WithOnUsage(func(r UsageReport) { reports = append(reports, r) })
+	for _, prompt := range []string{"first", "second"} {
+		if _, err := agent.Run(context.Background(), prompt); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+	}
+
+	if len(reports) != 4 {
+		t.Fatalf("Expected a report per model call, got %d", len(reports))
+	}
+	if got := agent.RunUsage(); got.PromptTokens != 200 || got.CompletionTokens != 20 {
+		t.Errorf("Expected the run to count its two calls, got %+v", got)
+	}
+	if got := agent.Usage(); got.PromptTokens != 400 || got.Cost < 0.0399 || got.Cost > 0.0401 {
+		t.Errorf("Expected the session to count all four calls, got %+v", got)
+	}
+
+	agent.AddUsage(providers.Usage{CompletionTokens: 5})
+	if last := reports[len(reports)-1]; last.Call.CompletionTokens != 5 || last.Session.CompletionTokens != 45 {
+		t.Errorf("Expected external usage to be reported and counted, got %+v", last)
+	}
+}
+
+// loopingLLM calls a tool on every turn and answers the stop summary request, which comes without tools.
+// Like Anthropic and Gemini, it rejects tool history in a call that declares no tools.
+func loopingLLM(usage providers.Usage) *MockLLM {
+	return &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			if t == nil {
+				for _, entry := range mem {
+					if entry.Role == memory.Tool || len(entry.ToolCalls()) > 0 {
+						return providers.ProviderResponse{}, errors.New("tool_use blocks require tools to be declared")
+					}
+				}
+				return providers.ProviderResponse{Text: "summary: " + mem[len(mem)-1].Text(), Usage: usage}, nil
+			}
+			return providers.ProviderResponse{
+				ToolCalls: []tools.ToolCall{{ID: "1", FunctionName: "mock_tool", Arguments: map[string]any{"Input": "x"}}},
+				Usage:     usage,
+			}, nil
+		},
+	}
+}
+
+func TestAgent_Run_StopReasons(t *testing.T) {
+	mockTool := tools.NewButlerTool("mock_tool", "A mock tool", MockToolHandler)
+
+	tests := []struct {
+		name       string
+		agent      *Agent
+		wantReason StopReason
+		wantLimit  string
+		wantCalls  int
+	}{
+		{
+			name:       "max iterations",
+			agent:      NewAgent(loopingLLM(providers.Usage{})).WitTools([]tools.Tool{mockTool}).WithMaxIterations(3),
+			wantReason: StopMaxIterations,
+			wantLimit:  "limit of 3 iterations",
+			wantCalls:  3,
+		},
+		{
+			name:       "token budget",
+			agent:      NewAgent(loopingLLM(providers.Usage{PromptTokens: 400, CompletionTokens: 100})).WitTools([]tools.Tool{mockTool}).WithBudget(Budget{MaxTokens: 1000}),
+			wantReason: StopBudgetExhausted,
+			wantLimit:  "token budget of 1000 tokens",
+			wantCalls:  2,
+		},
+		{
+			name:       "cost budget",
+			agent:      NewAgent(loopingLLM(providers.Usage{Cost: 0.3})).WitTools([]tools.Tool{mockTool}).WithBudget(Budget{MaxCost: 1}),
+			wantReason: StopBudgetExhausted,
+			wantLimit:  "cost budget of $1.00",
+			wantCalls:  4,
+		},
+		{
+			name:       "tool call budget",
+			agent:      NewAgent(loopingLLM(providers.Usage{})).WitTools([]tools.Tool{mockTool}).WithBudget(Budget{MaxToolCalls: 2}),
+			wantReason: StopBudgetExhausted,
+			wantLimit:  "tool call budget of 2 calls",
+			wantCalls:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, err := tt.agent.Run(context.Background(), "loop")
+			if err != nil {
+				t.Fatalf("Expected a graceful stop, got %v", err)
+			}
+			if reason != tt.wantReason {
+				t.Errorf("Expected stop reason %q, got %q", tt.wantReason, reason)
+			}
+
+			mem := tt.agent.GetMemory()
+			calls := 0
+			for _, entry := range mem {
+				calls += len(entry.ToolCalls())
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("Expected %d tool calls, got %d", tt.wantCalls, calls)
+			}
+			last := mem[len(mem)-1]
+			if last.Role != "model" || !strings.Contains(last.Text(), tt.wantLimit) {
+				t.Errorf("Expected a summary turn naming the %s, got %+v", tt.wantLimit, last)
+			}
+			for _, entry := range mem {
+				if strings.Contains(entry.Text(), "must stop now") && entry.Role == "user" {
+					t.Error("Expected the summary request to stay out of the memory")
+				}
+			}
+		})
+	}
+}
+
+func TestAgent_Run_Completed(t *testing.T) {
+	agent := NewAgent(&MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			return providers.ProviderResponse{Text: "done"}, nil
+		},
+	}).WithMaxIterations(1)
+
+	reason, err := agent.Run(context.Background(), "hello")
+	if err != nil || reason != StopCompleted {
+		t.Errorf("Expected a completed run, got %q, %v", reason, err)
+	}
+	if len(agent.GetMemory()) != 2 {
+		t.Errorf("Expected no summary turn after a completed run, got %+v", agent.GetMemory())
+	}
+}
+
+func TestAgent_Subscribe(t *testing.T) {
+	calls := 0
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			calls++
+			if calls == 1 {
+				call := tools.ToolCall{ID: "call_1", FunctionName: "mock_tool", Arguments: map[string]any{"input": "x"}}
+				hooks.OnToolCall(call)
+				return providers.ProviderResponse{ToolCalls: []tools.ToolCall{call}, Usage: providers.Usage{PromptTokens: 10}}, nil
+			}
+			hooks.OnTextChunk("done")
+			hooks.OnStreamComplete()
+			return providers.ProviderResponse{Text: "done"}, nil
+		},
+	}
+	agent := NewAgent(mockLLM).WitTools([]tools.Tool{tools.NewButlerTool("mock_tool", "A mock tool", MockToolHandler)})
+
+	var first, second []Event
+	agent.Subscribe(func(e Event) { first = append(first, e) })
+	unsubscribe := agent.Subscribe(func(e Event) { second = append(second, e) })
+
+	if _, err := agent.Run(context.Background(), "hello"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	var kinds []string
+	for _, e := range first {
+		kinds = append(kinds, reflect.TypeOf(e).Name())
+	}
+	// The provider reports tool calls while streaming, before the agent sees the usage of the response
+	want := []string{"RunStarted", "TurnStarted", "ToolCallRequested", "UsageUpdated", "ToolStarted", "ToolFinished", "TurnStarted", "TextChunk", "TextDone", "RunFinished"}
+	if strings.Join(kinds, ",") != strings.Join(want, ",") {
+		t.Fatalf("Unexpected events:\n got %v\nwant %v", kinds, want)
+	}
+	if len(second) != len(first) {
+		t.Errorf("Expected every subscriber to get every event, got %d and %d", len(first), len(second))
+	}
+
+	runID := first[0].Meta().RunID
+	for _, e := range first {
+		if e.Meta().RunID != runID || runID == "" {
+			t.Errorf("Expected all events to carry run ID %q, got %+v", runID, e.Meta())
+		}
+	}
+	finished := first[5].(ToolFinished)
+	if finished.ToolCallID != "call_1" || finished.Turn != 1 || finished.Output != "processed: x" || finished.Err != nil {
+		t.Errorf("Unexpected tool event: %+v", finished)
+	}
+	if done := first[len(first)-1].(RunFinished); done.Reason != StopCompleted || done.Turn != 2 {
+		t.Errorf("Unexpected run finished event: %+v", done)
+	}
+
+	unsubscribe()
+	firstBefore, secondBefore := len(first), len(second)
+	if _, err := agent.Run(context.Background(), "again"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(second) != secondBefore {
+		t.Errorf("Expected no events after unsubscribing, got %d more", len(second)-secondBefore)
+	}
+	if len(first) == firstBefore || first[firstBefore].Meta().RunID == runID {
+		t.Error("Expected the other subscriber to keep listening to a new run")
+	}
+}
+
+func TestAgent_Run_Steering(t *testing.T) {
+	var pending []string
+	var requests [][]memory.MemoryEntry
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			requests = append(requests, slices.Clone(mem))
+			switch len(requests) {
+			case 1:
+				// The user types while the tool runs
+				pending = append(pending, "use the v2 API", "and keep the tests")
+				return providers.ProviderResponse{ToolCalls: []tools.ToolCall{{ID: "call_1", FunctionName: "mock_tool", Arguments: map[string]any{"input": "x"}}}}, nil
+			case 2:
+				pending = append(pending, "also update the README")
+				return providers.ProviderResponse{Text: "done"}, nil
+			}
+			return providers.ProviderResponse{Text: "README updated"}, nil
+		},
+	}
+	agent := NewAgent(mockLLM).
+		WitTools([]tools.Tool{tools.NewButlerTool("mock_tool", "A mock tool", MockToolHandler)}).
+		WithSteering(func() []string {
+			messages := pending
+			pending = nil
+			return messages
+		})
+	var steered []Steered
+	agent.Subscribe(func(e Event) {
+		if e, ok := e.(Steered); ok {
+			steered = append(steered, e)
+		}
+	})
+
+	reason, err := agent.Run(context.Background(), "fix the client")
+	if err != nil || reason != StopCompleted {
+		t.Fatalf("Run failed: %v, %v", reason, err)
+	}
+
+	if len(requests) != 3 {
+		t.Fatalf("Expected the run to carry on after the last steering message, got %d model calls", len(requests))
+	}
+	if last := requests[1][len(requests[1])-1]; last.Role != "user" || last.Text() != "use the v2 API\n\nand keep the tests" {
+		t.Errorf("Expected the steering messages after the tool result, got %+v", last)
+	}
+	if last := requests[2][len(requests[2])-1]; last.Role != "user" || last.Text() != "also update the README" {
+		t.Errorf("Expected the last steering message before the final call, got %+v", last)
+	}
+	if len(steered) != 2 || len(steered[0].Messages) != 2 {
+		t.Errorf("Expected a Steered event per injection, got %+v", steered)
+	}
+}
+
+func TestAgent_Run_RejectsOverlappingRuns(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			close(started)
+			<-release
+			return providers.ProviderResponse{Text: "done"}, nil
+		},
+	}
+	agent := NewAgent(mockLLM).WithNoTools()
+
+	done := make(chan error)
+	go func() {
+		_, err := agent.Run(context.Background(), "first")
+		done <- err
+	}()
+	<-started
+
+	if _, err := agent.Run(context.Background(), "second"); !errors.Is(err, ErrRunning) {
+		t.Errorf("Expected ErrRunning for an overlapping run, got %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("First run failed: %v", err)
+	}
+	if mem := agent.GetMemory(); len(mem) != 2 || mem[0].Text() != "first" {
+		t.Errorf("Expected only the first run in memory, got %+v", mem)
+	}
+}
+
+func TestAgent_Run_Traces(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	calls := 0
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			calls++
+			if calls == 1 {
+				call := tools.ToolCall{ID: "call_1", FunctionName: "mock_tool", Arguments: map[string]any{"input": "x"}}
+				hooks.OnToolCall(call)
+				return providers.ProviderResponse{ToolCalls: []tools.ToolCall{call}, Usage: providers.Usage{PromptTokens: 10, CompletionTokens: 5}}, nil
+			}
+			return providers.ProviderResponse{Text: "done"}, nil
+		},
+	}
+	agent := NewAgent(mockLLM).WitTools([]tools.Tool{tools.NewButlerTool("mock_tool", "A mock tool", MockToolHandler)})
+
+	if _, err := agent.Run(context.Background(), "hello"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	spans := recorder.Ended()
+	var names []string
+	for _, s := range spans {
+		names = append(names, s.Name())
+	}
+	// Spans end innermost first, the run span is last
+	want := []string{"chat", "execute_tool mock_tool", "chat", "invoke_agent"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("Unexpected spans:\n got %v\nwant %v", names, want)
+	}
+
+	run := spans[3]
+	for _, s := range spans[:3] {
+		if s.Parent().SpanID() != run.SpanContext().SpanID() {
+			t.Errorf("Expected span %q to be a child of the run span", s.Name())
+		}
+	}
+
+	attrs := func(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		m := map[attribute.Key]attribute.Value{}
+		for _, kv := range s.Attributes() {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+	chat := attrs(spans[0])
+	if chat[attrInputTokens].AsInt64() != 10 || chat[attrOutputTokens].AsInt64() != 5 || chat[attrToolCalls].AsInt64() != 1 {
+		t.Errorf("Unexpected chat span attributes: %v", chat)
+	}
+	if _, ok := chat[attrTimeToFirst]; !ok {
+		t.Error("Expected the chat span to record the time to the first chunk")
+	}
+	tool := attrs(spans[1])
+	if tool[attrToolName].AsString() != "mock_tool" || tool[attrArgumentSize].AsInt64() != int64(len(`{"input":"x"}`)) || tool[attrOutputSize].AsInt64() != int64(len("processed: x")) {
+		t.Errorf("Unexpected tool span attributes: %v", tool)
+	}
+	runAttrs := attrs(run)
+	if runAttrs[attrStopReason].AsString() != string(StopCompleted) || runAttrs[attrTurns].AsInt64() != 2 || runAttrs[attrInputTokens].AsInt64() != 10 {
+		t.Errorf("Unexpected run span attributes: %v", runAttrs)
+	}
+}
+
+func TestAgent_HandleToolCall_TracesErrors(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	agent := NewAgent(&MockLLM{})
+	if _, err := agent.HandleToolCall(context.Background(), tools.ToolCall{ID: "call_1", FunctionName: "missing_tool"}); err == nil {
+		t.Fatal("Expected an error for an unknown tool")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Status().Code != codes.Error || len(spans[0].Events()) == 0 {
+		t.Fatalf("Expected one failed tool span with the error recorded, got %+v", spans)
+	}
+}
+
+type changedFile struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason" description:"Why the file changed"`
+	Kind   string `json:"kind,omitempty" enum:"added,modified,deleted"`
+}
+
+type changeReport struct {
+	Files []changedFile `json:"files"`
+}
+
+func TestRunTyped(t *testing.T) {
+	answers := []string{
+		"I changed main.go",
+		"```json\n{\"files\": [{\"path\": \"main.go\", \"kind\": \"moved\"}]}\n```",
+		"```json\n{\"files\": [{\"path\": \"main.go\", \"reason\": \"new flag\", \"kind\": \"modified\"}]}\n```",
+	}
+	var prompts []string
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			prompts = append(prompts, mem[len(mem)-1].Text())
+			return providers.ProviderResponse{Text: answers[len(prompts)-1]}, nil
+		},
+	}
+	agent := NewAgent(mockLLM).WithNoTools()
+
+	report, reason, err := RunTyped[changeReport](context.Background(), agent, "list the files you changed")
+	if err != nil || reason != StopCompleted {
+		t.Fatalf("RunTyped failed: %v, %v", reason, err)
+	}
+	if len(report.Files) != 1 || report.Files[0] != (changedFile{Path: "main.go", Reason: "new flag", Kind: "modified"}) {
+		t.Errorf("Expected the decoded report, got %+v", report)
+	}
+
+	if len(prompts) != 3 {
+		t.Fatalf("Expected the model to be asked twice more, got %d calls", len(prompts))
+	}
+	if !strings.Contains(prompts[0], "list the files you changed") || !strings.Contains(prompts[0], `"Why the file changed"`) {
+		t.Errorf("Expected the schema in the prompt of a model without structured outputs, got %q", prompts[0])
+	}
+	if !strings.Contains(prompts[1], "isn't valid JSON") {
+		t.Errorf("Expected the JSON error in the second prompt, got %q", prompts[1])
+	}
+	if !strings.Contains(prompts[2], `files[0] is missing "reason"`) {
+		t.Errorf("Expected the schema violation in the third prompt, got %q", prompts[2])
+	}
+}
+
+// structuredLLM is a MockLLM whose provider has a native structured output mode
+type structuredLLM struct{ MockLLM }
+
+func (structuredLLM) Describe() llm.Info { return llm.Info{StructuredOutput: true} }
+
+func TestRunTyped_NativeSchema(t *testing.T) {
+	var schema llm.OutputSchema
+	var prompt string
+	mockLLM := &structuredLLM{MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			schema, _ = llm.OutputSchemaFrom(ctx)
+			prompt = mem[len(mem)-1].Text()
+			return providers.ProviderResponse{Text: `{"value": ["main.go", "go.mod"]}`}, nil
+		},
+	}}
+	agent := NewAgent(mockLLM).WithNoTools()
+
+	files, _, err := RunTyped[[]string](context.Background(), agent, "list the files")
+	if err != nil {
+		t.Fatalf("RunTyped failed: %v", err)
+	}
+	if !slices.Equal(files, []string{"main.go", "go.mod"}) {
+		t.Errorf("Expected the unwrapped list, got %v", files)
+	}
+	if prompt != "list the files" {
+		t.Errorf("Expected the provider to enforce the schema instead of the prompt, got %q", prompt)
+	}
+	if schema.Name != "output" || schema.Type == nil || schema.Type.Kind() != reflect.Struct {
+		t.Errorf("Expected a wrapping object schema on the context, got %+v", schema)
+	}
+}
+
+// toolsFirstLLM is a MockLLM whose provider can only hold calls without tools to a schema, like Gemini
+type toolsFirstLLM struct{ MockLLM }
+
+func (toolsFirstLLM) Describe() llm.Info {
+	return llm.Info{StructuredOutput: true, SchemaNeedsNoTools: true}
+}
+
+func TestRunTyped_SchemaWithTools(t *testing.T) {
+	var prompt string
+	mockLLM := &toolsFirstLLM{MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			prompt = mem[len(mem)-1].Text()
+			return providers.ProviderResponse{Text: `{"value": ["main.go"]}`}, nil
+		},
+	}}
+
+	if _, _, err := RunTyped[[]string](context.Background(), NewAgent(mockLLM), "list the files"); err != nil {
+		t.Fatalf("RunTyped failed: %v", err)
+	}
+	if !strings.Contains(prompt, "JSON Schema") {
+		t.Errorf("Expected the schema in the prompt when the provider drops it next to tools, got %q", prompt)
+	}
+}
+
+func TestRunTyped_SchemaOnlyOnLoopCalls(t *testing.T) {
+	var withSchema []bool
+	mockLLM := &structuredLLM{MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			_, ok := llm.OutputSchemaFrom(ctx)
+			withSchema = append(withSchema, ok)
+			if len(t) == 0 {
+				return providers.ProviderResponse{Text: "Stopped while looking at the files"}, nil
+			}
+			return providers.ProviderResponse{ToolCalls: []tools.ToolCall{{ID: "c1", FunctionName: "missing_tool"}}}, nil
+		},
+	}}
+	agent := NewAgent(mockLLM).WithMaxIterations(1)
+
+	if _, _, err := RunTyped[[]string](context.Background(), agent, "list the files"); !errors.Is(err, ErrNoOutput) {
+		t.Fatalf("Expected ErrNoOutput, got %v", err)
+	}
+	// The loop call gets the schema, the summary of where the run stopped doesn't
+	if !slices.Equal(withSchema, []bool{true, false}) {
+		t.Errorf("Expected the schema on the loop call only, got %v", withSchema)
+	}
+	if _, err := agent.Run(context.Background(), "thanks"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if withSchema[len(withSchema)-2] {
+		t.Error("Expected a plain Run after RunTyped to go without the schema")
+	}
+}
+
+func TestRunTyped_GivesUp(t *testing.T) {
+	mockLLM := &MockLLM{
+		StreamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+			return providers.ProviderResponse{Text: "no"}, nil
+		},
+	}
+	_, _, err := RunTyped[changeReport](context.Background(), NewAgent(mockLLM).WithNoTools(), "list the files")
+	if !errors.Is(err, ErrInvalidOutput) {
+		t.Errorf("Expected ErrInvalidOutput, got %v", err)
 	}
 }
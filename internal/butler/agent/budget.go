@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+)
+
+// StopReason tells why Run returned
+type StopReason string
+
+const (
+	StopCompleted       StopReason = "completed"        // The model answered without calling more tools
+	StopMaxIterations   StopReason = "max-iterations"   // The iteration limit was reached
+	StopBudgetExhausted StopReason = "budget-exhausted" // One of the Budget limits was reached
+	StopCancelled       StopReason = "cancelled"        // The context was cancelled, Run returns its error
+	StopError           StopReason = "error"            // The model call failed, Run returns the error
+)
+
+// Budget limits what a single Run may spend. Zero fields are unlimited.
+// Budgets are checked before every model call, so the call that crosses a limit finishes
+// and its tool calls run before the agent stops.
+type Budget struct {
+	MaxTokens    int           `yaml:"max_tokens,omitempty"`     // Prompt and completion tokens
+	MaxCost      float64       `yaml:"max_cost,omitempty"`       // In USD, only enforced when the provider reports or the price table knows the cost
+	MaxDuration  time.Duration `yaml:"max_duration,omitempty"`   // Wall-clock time
+	MaxToolCalls int           `yaml:"max_tool_calls,omitempty"` // Tool calls, denied and failed ones included
+}
+
+// exceeded describes the first limit the run went over, "" while it is within budget
+func (b Budget) exceeded(usage providers.Usage, elapsed time.Duration, toolCalls int) string {
+	switch {
+	case b.MaxTokens > 0 && usage.TotalTokens() >= b.MaxTokens:
+		return fmt.Sprintf("token budget of %d tokens", b.MaxTokens)
+	case b.MaxCost > 0 && usage.Cost >= b.MaxCost:
+		return fmt.Sprintf("cost budget of $%.2f", b.MaxCost)
+	case b.MaxDuration > 0 && elapsed >= b.MaxDuration:
+		return fmt.Sprintf("time budget of %s", b.MaxDuration)
+	case b.MaxToolCalls > 0 && toolCalls >= b.MaxToolCalls:
+		return fmt.Sprintf("tool call budget of %d calls", b.MaxToolCalls)
+	}
+	return ""
+}
+
+// WithBudget limits every Run, on top of the iteration limit
+func (a *Agent) WithBudget(b Budget) *Agent {
+	a.budget = b
+	return a
+}
+
+// stopSummaryPrompt asks for a last answer when a limit stops the run.
+// It is sent once and never stored, the memory only keeps the summary.
+const stopSummaryPrompt = "You have reached the %s and must stop now, you can't use tools anymore. " +
+	"Briefly summarize what you did, what is left to do and anything the user should check before continuing."
@@ -0,0 +1,231 @@
+package agent
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/compaction"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// Event is something that happened in the agent. The set of events is closed,
+// subscribers switch on the concrete types below.
+type Event interface {
+	Meta() EventMeta
+	isEvent()
+}
+
+// EventMeta tells where and when an event happened
+type EventMeta struct {
+	RunID      string // Unique per Run, empty for events outside a run like a manual Compact
+	Turn       int    // Model call within the run, starting at 1
+	ToolCallID string // Set on tool events
+	Time       time.Time
+}
+
+func (m EventMeta) Meta() EventMeta { return m }
+
+// RunStarted is the first event of every Run
+type RunStarted struct {
+	EventMeta
+	Prompt      string
+	Attachments []memory.Part // Images and files sent with the prompt
+}
+
+// RunFinished is the last event of every Run, including failed and cancelled ones
+type RunFinished struct {
+	EventMeta
+	Reason   StopReason
+	Err      error
+	Duration time.Duration
+}
+
+// Steered is sent when messages the user sent during the run were added to the memory, see WithSteering
+type Steered struct {
+	EventMeta
+	Messages []string
+}
+
+// TurnStarted is sent before every model call of a run
+type TurnStarted struct {
+	EventMeta
+}
+
+type TextChunk struct {
+	EventMeta
+	Text string
+}
+
+// TextDone is sent when the model finished streaming its answer
+type TextDone struct {
+	EventMeta
+}
+
+type ThinkingChunk struct {
+	EventMeta
+	Text string
+}
+
+type ThinkingDone struct {
+	EventMeta
+}
+
+// StreamReset is sent when a failed stream is retried, the chunks of the turn so far must be discarded
+type StreamReset struct {
+	EventMeta
+}
+
+// ToolCallRequested is sent as the model streams a tool call. Some providers send it
+// several times per call while the arguments arrive, ToolStarted has the final call.
+type ToolCallRequested struct {
+	EventMeta
+	Call tools.ToolCall
+}
+
+// ToolStarted is sent when an approved tool call starts running
+type ToolStarted struct {
+	EventMeta
+	Call tools.ToolCall
+}
+
+// ToolFinished is sent for every tool call of the model, whether it ran, failed or was denied
+type ToolFinished struct {
+	EventMeta
+	Call        tools.ToolCall
+	Output      string // What the model gets back
+	Err         error  // The tool failed, Output holds the error message sent to the model
+	Denied      bool   // The approval policy or the user refused the call, it never ran
+	Interrupted bool   // The run was cancelled while the tool was running
+	Duration    time.Duration
+}
+
+// UsageUpdated is sent after every model call, see OnUsage
+type UsageUpdated struct {
+	EventMeta
+	Usage UsageReport
+}
+
+// Compacted is sent after the memory was compacted, see OnCompaction
+type Compacted struct {
+	EventMeta
+	Result compaction.Result
+}
+
+// The marker is on the events themselves rather than on EventMeta, so embedding
+// EventMeta elsewhere doesn't make a type an Event
+func (RunStarted) isEvent()        {}
+func (RunFinished) isEvent()       {}
+func (Steered) isEvent()           {}
+func (TurnStarted) isEvent()       {}
+func (TextChunk) isEvent()         {}
+func (TextDone) isEvent()          {}
+func (ThinkingChunk) isEvent()     {}
+func (ThinkingDone) isEvent()      {}
+func (StreamReset) isEvent()       {}
+func (ToolCallRequested) isEvent() {}
+func (ToolStarted) isEvent()       {}
+func (ToolFinished) isEvent()      {}
+func (UsageUpdated) isEvent()      {}
+func (Compacted) isEvent()         {}
+
+// Subscribe calls f with every event of the agent until the returned function is called.
+// Any number of subscribers can listen at once. Events are delivered in order on the
+// goroutine that produced them, usually the one running Run, except for usage reported
+// by sub-agents through AddUsage. f must return quickly and hand slow work to a goroutine.
+func (a *Agent) Subscribe(f func(Event)) (unsubscribe func()) {
+	a.eventsMu.Lock()
+	defer a.eventsMu.Unlock()
+	a.nextSubscriberID++
+	id := a.nextSubscriberID
+	a.subscribers = append(a.subscribers, subscriber{id: id, f: f})
+	return func() {
+		a.eventsMu.Lock()
+		defer a.eventsMu.Unlock()
+		a.subscribers = slices.DeleteFunc(a.subscribers, func(s subscriber) bool { return s.id == id })
+	}
+}
+
+type subscriber struct {
+	id int
+	f  func(Event)
+}
+
+// meta stamps an event with the current run and turn
+func (a *Agent) meta(toolCallID string) EventMeta {
+	a.eventsMu.Lock()
+	defer a.eventsMu.Unlock()
+	return EventMeta{RunID: a.runID, Turn: a.turn, ToolCallID: toolCallID, Time: time.Now()}
+}
+
+func (a *Agent) emit(event Event) {
+	a.eventsMu.Lock()
+	subscribers := slices.Clone(a.subscribers)
+	a.eventsMu.Unlock()
+
+	for _, s := range subscribers {
+		s.f(event)
+	}
+}
+
+// startRun gives the run its ID, events from now on carry it
+func (a *Agent) startRun() {
+	id := make([]byte, 6)
+	_, _ = rand.Read(id)
+	a.eventsMu.Lock()
+	defer a.eventsMu.Unlock()
+	a.runID = "run-" + hex.EncodeToString(id)
+	a.turn = 0
+}
+
+func (a *Agent) startTurn() {
+	a.eventsMu.Lock()
+	a.turn++
+	a.eventsMu.Unlock()
+	a.emit(TurnStarted{EventMeta: a.meta("")})
+}
+
+// eventHooks forwards the provider callbacks to the hook fields and the subscribers
+func (a *Agent) eventHooks() butler.EventHooks {
+	return butler.EventHooks{
+		OnTextChunk: func(chunk string) {
+			if a.OnTextChunk != nil {
+				a.OnTextChunk(chunk)
+			}
+			a.emit(TextChunk{EventMeta: a.meta(""), Text: chunk})
+		},
+		OnStreamComplete: func() {
+			if a.OnStreamComplete != nil {
+				a.OnStreamComplete()
+			}
+			a.emit(TextDone{EventMeta: a.meta("")})
+		},
+		OnThinkingChunk: func(chunk string) {
+			if a.OnThinkingChunk != nil {
+				a.OnThinkingChunk(chunk)
+			}
+			a.emit(ThinkingChunk{EventMeta: a.meta(""), Text: chunk})
+		},
+		OnThinkingComplete: func() {
+			if a.OnThinkingComplete != nil {
+				a.OnThinkingComplete()
+			}
+			a.emit(ThinkingDone{EventMeta: a.meta("")})
+		},
+		OnToolCall: func(call tools.ToolCall) {
+			if a.OnToolCall != nil {
+				a.OnToolCall(call)
+			}
+			a.emit(ToolCallRequested{EventMeta: a.meta(call.ID), Call: call})
+		},
+		OnStreamReset: func() {
+			if a.OnStreamReset != nil {
+				a.OnStreamReset()
+			}
+			a.emit(StreamReset{EventMeta: a.meta("")})
+		},
+	}
+}
@@ -0,0 +1,214 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mightymoud/arlocode/internal/butler/llm"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// ErrInvalidOutput is returned by RunTyped when the answer still didn't match the schema after asking the model to fix it
+var ErrInvalidOutput = errors.New("the answer doesn't match the output schema")
+
+// ErrNoOutput is returned by RunTyped when a limit stopped the run before the model answered
+var ErrNoOutput = errors.New("the run stopped before the model answered")
+
+// maxOutputAttempts caps how often RunTyped asks for an answer matching the schema
+const maxOutputAttempts = 3
+
+// RunTyped runs the prompt like Run and decodes the model's final answer into a T.
+// The JSON schema of T is derived like the schema of tool arguments, see tools.JSONSchema.
+// Calls that can be held to it natively are, see llm.Info.HoldsToSchema, the others get the
+// schema in the prompt. Only the calls of the agent's own loop answer in the format, the stop
+// summary, compaction and sub-agents don't. The answer is validated either way and the
+// model is asked to fix an invalid one before RunTyped gives up with ErrInvalidOutput.
+// T is usually a struct, other types are sent wrapped in an object since providers want one.
+func RunTyped[T any](ctx context.Context, a *Agent, prompt string) (T, StopReason, error) {
+	var value T
+	schemaType, wrapped := outputType[T]()
+	schema := tools.JSONSchema(schemaType)
+	output := &llm.OutputSchema{Name: schemaName(reflect.TypeFor[T]()), Type: schemaType}
+
+	if !llm.Describe(a.llm).HoldsToSchema(len(a.tools) > 0) {
+		prompt += "\n\n" + outputInstructions(schema)
+	}
+	for attempt := 1; ; attempt++ {
+		reason, err := a.run(ctx, prompt, output, nil)
+		if err != nil {
+			return value, reason, err
+		}
+		if reason != StopCompleted {
+			return value, reason, fmt.Errorf("%w: %s", ErrNoOutput, reason)
+		}
+		err = decodeOutput(a.lastAnswer(), schema, wrapped, &value)
+		if err == nil {
+			return value, reason, nil
+		}
+		if attempt == maxOutputAttempts {
+			return value, reason, fmt.Errorf("%w: %v", ErrInvalidOutput, err)
+		}
+		prompt = fmt.Sprintf("Your answer can't be used: %v.\n\n%s", err, outputInstructions(schema))
+	}
+}
+
+// outputWrapper holds answers that aren't objects
+type outputWrapper[T any] struct {
+	Value T `json:"value"`
+}
+
+// outputType is the type the schema is derived from, wrapped tells whether T was wrapped in an object
+func outputType[T any]() (t reflect.Type, wrapped bool) {
+	t = reflect.TypeFor[T]()
+	if t.Kind() == reflect.Struct || t.Kind() == reflect.Pointer && t.Elem().Kind() == reflect.Struct {
+		return t, false
+	}
+	return reflect.TypeFor[outputWrapper[T]](), true
+}
+
+var schemaNameInvalid = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// schemaName names the schema after T the way providers accept it
+func schemaName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	name := strings.Trim(schemaNameInvalid.ReplaceAllString(t.Name(), "_"), "_")
+	if name == "" {
+		return "output"
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+func outputInstructions(schema map[string]any) string {
+	encoded, _ := json.MarshalIndent(schema, "", "  ")
+	return "Answer with only a JSON value matching this JSON Schema, no prose and no code fences:\n" + string(encoded)
+}
+
+// lastAnswer is the text of the model's last message
+func (a *Agent) lastAnswer() string {
+	mem := a.GetMemory()
+	for i := len(mem) - 1; i >= 0; i-- {
+		if mem[i].Role == memory.Model {
+			return mem[i].Text()
+		}
+	}
+	return ""
+}
+
+// decodeOutput validates the answer against the schema and decodes it into value
+func decodeOutput[T any](answer string, schema map[string]any, wrapped bool, value *T) error {
+	text := stripCodeFence(answer)
+	if text == "" {
+		return errors.New("the answer is empty")
+	}
+	var raw any
+	if err := json.Unmarshal([]byte(text), &raw); err != nil {
+		return fmt.Errorf("the answer isn't valid JSON: %v", err)
+	}
+	if err := validateOutput(schema, raw, ""); err != nil {
+		return err
+	}
+	if !wrapped {
+		return json.Unmarshal([]byte(text), value)
+	}
+	var w outputWrapper[T]
+	if err := json.Unmarshal([]byte(text), &w); err != nil {
+		return err
+	}
+	*value = w.Value
+	return nil
+}
+
+// stripCodeFence drops the ```json fence models like to put around JSON when they aren't held to a schema
+func stripCodeFence(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") {
+		return text
+	}
+	text = strings.TrimPrefix(text, "```")
+	if newline := strings.IndexByte(text, '\n'); newline >= 0 {
+		text = text[newline+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+}
+
+// validateOutput checks value against the subset of JSON Schema tools.JSONSchema produces
+func validateOutput(schema map[string]any, value any, path string) error {
+	if value == nil {
+		// Null decodes to the zero value, missing required fields are caught by their object
+		return nil
+	}
+	where := path
+	if where == "" {
+		where = "the answer"
+	}
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s should be an object", where)
+		}
+		required, _ := schema["required"].([]string)
+		for _, name := range required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s is missing %q", where, name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for _, name := range slices.Sorted(maps.Keys(object)) {
+			property, ok := properties[name].(map[string]any)
+			if !ok {
+				continue
+			}
+			if err := validateOutput(property, object[name], strings.TrimPrefix(path+"."+name, ".")); err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s should be an array", where)
+		}
+		items, _ := schema["items"].(map[string]any)
+		for i, item := range array {
+			if err := validateOutput(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s should be a string", where)
+		}
+		if enum, ok := schema["enum"].([]string); ok && !slices.Contains(enum, s) {
+			return fmt.Errorf("%s should be one of %s, got %q", where, strings.Join(enum, ", "), s)
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != math.Trunc(n) {
+			return fmt.Errorf("%s should be an integer", where)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s should be a number", where)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s should be true or false", where)
+		}
+	}
+	return nil
+}
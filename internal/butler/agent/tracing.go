@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Spans follow the OpenTelemetry GenAI semantic conventions, attributes the conventions
+// don't cover are prefixed with arlocode. Nothing is recorded until the application
+// installs a tracer provider, see the telemetry package.
+const tracerName = "github.com/mightymoud/arlocode/internal/butler/agent"
+
+const (
+	attrOperation       = attribute.Key("gen_ai.operation.name")
+	attrProvider        = attribute.Key("gen_ai.provider.name")
+	attrRequestModel    = attribute.Key("gen_ai.request.model")
+	attrConversationID  = attribute.Key("gen_ai.conversation.id")
+	attrInputTokens     = attribute.Key("gen_ai.usage.input_tokens")
+	attrOutputTokens    = attribute.Key("gen_ai.usage.output_tokens")
+	attrToolName        = attribute.Key("gen_ai.tool.name")
+	attrToolCallID      = attribute.Key("gen_ai.tool.call.id")
+	attrReasoningTokens = attribute.Key("arlocode.usage.reasoning_tokens")
+	attrCachedTokens    = attribute.Key("arlocode.usage.cached_tokens")
+	attrCost            = attribute.Key("arlocode.usage.cost")
+	attrTimeToFirst     = attribute.Key("arlocode.time_to_first_token")
+	attrToolCalls       = attribute.Key("arlocode.response.tool_calls")
+	attrStopReason      = attribute.Key("arlocode.stop_reason")
+	attrTurns           = attribute.Key("arlocode.turns")
+	attrArgumentSize    = attribute.Key("arlocode.tool.argument_size")
+	attrOutputSize      = attribute.Key("arlocode.tool.output_size")
+)
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// startRunSpan opens the span every model call and tool call of the run nests under
+func (a *Agent) startRunSpan(ctx context.Context) (context.Context, trace.Span) {
+	info := llm.Describe(a.llm)
+	return tracer().Start(ctx, "invoke_agent", trace.WithAttributes(
+		attrOperation.String("invoke_agent"),
+		attrProvider.String(info.Provider),
+		attrRequestModel.String(info.Model),
+		attrConversationID.String(a.meta("").RunID),
+	))
+}
+
+func endRunSpan(span trace.Span, reason StopReason, turns int, usage providers.Usage, err error) {
+	span.SetAttributes(attrStopReason.String(string(reason)), attrTurns.Int(turns))
+	setUsageAttributes(span, usage)
+	endSpan(span, err)
+}
+
+// stream calls the model inside a chat span that records the token counts and the time to the first chunk
+func (a *Agent) stream(ctx context.Context, messages []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+	info := llm.Describe(a.llm)
+	name := "chat"
+	if info.Model != "" {
+		name += " " + info.Model
+	}
+	ctx, span := tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attrOperation.String("chat"),
+		attrProvider.String(info.Provider),
+		attrRequestModel.String(info.Model),
+	))
+
+	started := time.Now()
+	var firstToken time.Duration
+	seen := false
+	first := func() {
+		if !seen {
+			seen = true
+			firstToken = time.Since(started)
+		}
+	}
+	hooks = withFirstChunk(hooks, first)
+
+	result, err := a.llm.Stream(ctx, messages, agentTools, hooks)
+	if seen {
+		span.SetAttributes(attrTimeToFirst.Float64(firstToken.Seconds()))
+	}
+	span.SetAttributes(attrToolCalls.Int(len(result.ToolCalls)))
+	setUsageAttributes(span, result.Usage)
+	endSpan(span, err)
+	return result, err
+}
+
+// withFirstChunk calls first before every streamed chunk, on top of the existing hooks
+func withFirstChunk(hooks butler.EventHooks, first func()) butler.EventHooks {
+	if f := hooks.OnTextChunk; f != nil {
+		hooks.OnTextChunk = func(chunk string) { first(); f(chunk) }
+	}
+	if f := hooks.OnThinkingChunk; f != nil {
+		hooks.OnThinkingChunk = func(chunk string) { first(); f(chunk) }
+	}
+	if f := hooks.OnToolCall; f != nil {
+		hooks.OnToolCall = func(call tools.ToolCall) { first(); f(call) }
+	}
+	return hooks
+}
+
+// startToolSpan opens the span of one tool execution
+func startToolSpan(ctx context.Context, call tools.ToolCall) (context.Context, trace.Span) {
+	argumentSize := len(call.RawArguments)
+	if argumentSize == 0 && call.Arguments != nil {
+		if data, err := json.Marshal(call.Arguments); err == nil {
+			argumentSize = len(data)
+		}
+	}
+	return tracer().Start(ctx, "execute_tool "+call.FunctionName, trace.WithAttributes(
+		attrOperation.String("execute_tool"),
+		attrToolName.String(call.FunctionName),
+		attrToolCallID.String(call.ID),
+		attrArgumentSize.Int(argumentSize),
+	))
+}
+
+func setUsageAttributes(span trace.Span, usage providers.Usage) {
+	if usage.IsZero() {
+		return
+	}
+	span.SetAttributes(
+		attrInputTokens.Int(usage.PromptTokens),
+		attrOutputTokens.Int(usage.CompletionTokens),
+		attrReasoningTokens.Int(usage.ReasoningTokens),
+		attrCachedTokens.Int(usage.CachedTokens),
+		attrCost.Float64(usage.Cost),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
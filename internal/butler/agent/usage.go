@@ -0,0 +1,56 @@
+package agent
+
+import "github.com/mightymoud/arlocode/internal/butler/providers"
+
+// UsageReport is sent to OnUsage after every model call
+type UsageReport struct {
+	Call    providers.Usage // The call that just finished
+	Run     providers.Usage // All calls of the current Run
+	Session providers.Usage // All calls since the agent was created
+}
+
+// OnUsageFunc is called with the usage of every model call, including summaries and sub-agents
+type OnUsageFunc func(report UsageReport)
+
+func (a *Agent) WithOnUsage(f OnUsageFunc) *Agent {
+	a.OnUsage = f
+	return a
+}
+
+// Usage is what the agent consumed since it was created
+func (a *Agent) Usage() providers.Usage {
+	a.usageMu.Lock()
+	defer a.usageMu.Unlock()
+	return a.sessionUsage
+}
+
+// RunUsage is what the current or last Run consumed
+func (a *Agent) RunUsage() providers.Usage {
+	a.usageMu.Lock()
+	defer a.usageMu.Unlock()
+	return a.runUsage
+}
+
+// AddUsage counts a model call the agent didn't make itself, e.g. one of a sub-agent,
+// towards the run and session totals. It is safe to call from several goroutines.
+func (a *Agent) AddUsage(usage providers.Usage) {
+	if usage.IsZero() {
+		return
+	}
+	a.usageMu.Lock()
+	a.runUsage = a.runUsage.Add(usage)
+	a.sessionUsage = a.sessionUsage.Add(usage)
+	report := UsageReport{Call: usage, Run: a.runUsage, Session: a.sessionUsage}
+	a.usageMu.Unlock()
+
+	if a.OnUsage != nil {
+		a.OnUsage(report)
+	}
+	a.emit(UsageUpdated{EventMeta: a.meta(""), Usage: report})
+}
+
+func (a *Agent) resetRunUsage() {
+	a.usageMu.Lock()
+	defer a.usageMu.Unlock()
+	a.runUsage = providers.Usage{}
+}
@@ -0,0 +1,254 @@
+package approval
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// Decision is the outcome of checking a tool call against a Policy.
+type Decision int
+
+const (
+	Allow Decision = iota
+	Deny
+	Ask
+)
+
+func (d Decision) String() string {
+	switch d {
+	case Allow:
+		return "allow"
+	case Deny:
+		return "deny"
+	case Ask:
+		return "ask"
+	default:
+		return "unknown"
+	}
+}
+
+// Policy decides whether a tool call may run before the agent hands it to HandleToolCall.
+// The returned reason is sent back to the model when a call is denied.
+type Policy interface {
+	Evaluate(call tools.ToolCall) (Decision, string)
+}
+
+// AskFunc is called for calls the policy marks as Ask.
+// Frontends implement it to prompt the user - the TUI uses its modal, headless runs can use StdinAsker.
+type AskFunc func(ctx context.Context, call tools.ToolCall) (bool, error)
+
+// Rule matches a tool call by tool name and optionally by one of its arguments.
+type Rule struct {
+	Tool     string   // Tool name, "*" matches every tool
+	Argument string   // Argument to inspect, e.g. "command" or "path" - empty matches on the tool name alone
+	Pattern  string   // Glob matched against the argument value, "*" matches any run of characters
+	Path     bool     // Resolve the argument and pattern to absolute paths before matching
+	Command  bool     // The argument is a shell command, match its words and only when it is one simple command, see SplitCommand
+	Flags    []string // With Command, the only flags the command may use, e.g. "-run". Any other flag keeps the rule from matching, nil allows all
+	Decision Decision
+	Reason   string
+}
+
+// Matches reports whether the rule applies to the given call.
+func (r Rule) Matches(call tools.ToolCall) bool {
+	if r.Tool != "*" && r.Tool != call.FunctionName {
+		return false
+	}
+	if r.Argument == "" {
+		return true
+	}
+
+	raw, ok := call.Arguments[r.Argument]
+	if !ok {
+		return false
+	}
+	value, ok := raw.(string)
+	if !ok {
+		return false
+	}
+
+	pattern := r.Pattern
+	if r.Path {
+		pattern = absPath(pattern)
+		value = absPath(value)
+	}
+	if r.Command {
+		words, ok := SplitCommand(value)
+		if !ok {
+			return false
+		}
+		if r.Flags != nil && !onlyFlags(words, r.Flags) {
+			return false
+		}
+		value = strings.Join(words, " ")
+	}
+	return wildcardMatch(pattern, value)
+}
+
+// onlyFlags reports whether every flag among the words is one of allowed, "-name", "--name"
+// and "-name=value" are the same flag
+func onlyFlags(words, allowed []string) bool {
+	for _, word := range words {
+		if !strings.HasPrefix(word, "-") {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimPrefix(word, "-"), "=")
+		name = strings.TrimPrefix(name, "-")
+		if name == "" || !slices.Contains(allowed, "-"+name) {
+			return false
+		}
+	}
+	return true
+}
+
+// RulePolicy evaluates rules in order, the first matching rule wins.
+// Calls that match no rule get the default decision.
+type RulePolicy struct {
+	Rules   []Rule
+	Default Decision
+}
+
+func NewRulePolicy(defaultDecision Decision, rules ...Rule) *RulePolicy {
+	return &RulePolicy{
+		Rules:   rules,
+		Default: defaultDecision,
+	}
+}
+
+func (p *RulePolicy) Evaluate(call tools.ToolCall) (Decision, string) {
+	for _, rule := range p.Rules {
+		if rule.Matches(call) {
+			return rule.Decision, rule.Reason
+		}
+	}
+	return p.Default, ""
+}
+
+// AllowAll is the policy agents use when none is configured.
+func AllowAll() Policy {
+	return NewRulePolicy(Allow)
+}
+
+// StdinAsker prompts on out and reads a y/n answer from in.
+// It is meant for headless runs where no TUI is attached.
+// Prompts are asked one at a time, so parallel sub-agents don't read each other's answers.
+func StdinAsker(in io.Reader, out io.Writer) AskFunc {
+	reader := bufio.NewReader(in)
+	turn := make(chan struct{}, 1)
+	// A read can't be interrupted, so a cancelled prompt leaves its read behind for the next
+	// prompt to pick up rather than starting a second one that would race it for the input
+	var pending chan readResult
+
+	return func(ctx context.Context, call tools.ToolCall) (bool, error) {
+		select {
+		case turn <- struct{}{}:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+		defer func() { <-turn }()
+
+		if pending != nil {
+			select {
+			case <-pending:
+				// Typed after the last prompt was cancelled, it doesn't answer this one
+				pending = nil
+			default:
+			}
+		}
+		if pending == nil {
+			pending = make(chan readResult, 1)
+			go func(result chan<- readResult) {
+				line, err := reader.ReadString('\n')
+				result <- readResult{line: line, err: err}
+			}(pending)
+		}
+
+		fmt.Fprintf(out, "\nExecute tool %s?\n%s\n[y/N]: ", call.FunctionName, Describe(call))
+
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case result := <-pending:
+			pending = nil
+			if result.err != nil && result.line == "" {
+				return false, result.err
+			}
+			return IsAffirmative(result.line), nil
+		}
+	}
+}
+
+type readResult struct {
+	line string
+	err  error
+}
+
+// IsAffirmative reports whether a typed answer approves the call.
+func IsAffirmative(answer string) bool {
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// Describe renders the call arguments for display in approval prompts.
+func Describe(call tools.ToolCall) string {
+	if len(call.Arguments) == 0 {
+		return "(no arguments)"
+	}
+	bytes, err := json.MarshalIndent(call.Arguments, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%v", call.Arguments)
+	}
+	return string(bytes)
+}
+
+func absPath(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return filepath.Clean(p)
+	}
+	return filepath.ToSlash(abs)
+}
+
+// wildcardMatch matches value against a glob where "*" matches any run of characters (including "/")
+// and "?" matches exactly one character.
+func wildcardMatch(pattern, value string) bool {
+	p := []rune(pattern)
+	v := []rune(value)
+	pi, vi := 0, 0
+	star, match := -1, 0
+
+	for vi < len(v) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == v[vi]):
+			pi++
+			vi++
+		case pi < len(p) && p[pi] == '*':
+			star = pi
+			match = vi
+			pi++
+		case star != -1:
+			pi = star + 1
+			match++
+			vi = match
+		default:
+			return false
+		}
+	}
+
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
@@ -0,0 +1,199 @@
+package approval
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+func TestWildcardMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"go test *", "go test ./...", true},
+		{"go test *", "go test", false},
+		{"go test*", "go test", true},
+		{"go test *", "go build ./...", false},
+		{"*", "", true},
+		{"internal/*", "internal/butler/agent/agent.go", true},
+		{"?.go", "a.go", true},
+		{"?.go", "ab.go", false},
+	}
+
+	for _, c := range cases {
+		if got := wildcardMatch(c.pattern, c.value); got != c.want {
+			t.Errorf("wildcardMatch(%q, %q) = %v, want %v", c.pattern, c.value, got, c.want)
+		}
+	}
+}
+
+func TestRulePolicy_Evaluate(t *testing.T) {
+	policy := NewRulePolicy(Ask,
+		Rule{Tool: "read_file", Decision: Allow},
+		Rule{Tool: "run_command", Argument: "command", Pattern: "go test *", Decision: Allow},
+		Rule{Tool: "run_command", Argument: "command", Pattern: "rm *", Decision: Deny, Reason: "destructive"},
+		Rule{Tool: "apply_edit", Argument: "path", Pattern: "./internal/*", Path: true, Decision: Allow},
+	)
+
+	cases := []struct {
+		name   string
+		call   tools.ToolCall
+		want   Decision
+		reason string
+	}{
+		{"allowed tool", tools.ToolCall{FunctionName: "read_file", Arguments: map[string]any{"path": "main.go"}}, Allow, ""},
+		{"allowed command", tools.ToolCall{FunctionName: "run_command", Arguments: map[string]any{"command": "go test ./..."}}, Allow, ""},
+		{"denied command", tools.ToolCall{FunctionName: "run_command", Arguments: map[string]any{"command": "rm -rf /"}}, Deny, "destructive"},
+		{"unmatched command", tools.ToolCall{FunctionName: "run_command", Arguments: map[string]any{"command": "make"}}, Ask, ""},
+		{"edit inside path", tools.ToolCall{FunctionName: "apply_edit", Arguments: map[string]any{"path": "internal/tui/state.go"}}, Allow, ""},
+		{"edit escaping path", tools.ToolCall{FunctionName: "apply_edit", Arguments: map[string]any{"path": "internal/../cmd/root.go"}}, Ask, ""},
+		{"missing argument", tools.ToolCall{FunctionName: "apply_edit"}, Ask, ""},
+		{"non string argument", tools.ToolCall{FunctionName: "run_command", Arguments: map[string]any{"command": 42}}, Ask, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, reason := policy.Evaluate(c.call)
+			if got != c.want {
+				t.Errorf("Expected decision %s, got %s", c.want, got)
+			}
+			if reason != c.reason {
+				t.Errorf("Expected reason %q, got %q", c.reason, reason)
+			}
+		})
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	cases := []struct {
+		command string
+		want    []string
+	}{
+		{"go test ./...", []string{"go", "test", "./..."}},
+		{"  go   vet\t./internal/...  ", []string{"go", "vet", "./internal/..."}},
+		{"go test -run 'TestA|TestB' ./...", []string{"go", "test", "-run", "TestA|TestB", "./..."}},
+		{`go test -run "Test A" ./...`, []string{"go", "test", "-run", "Test A", "./..."}},
+		{"go test -ex'ec' ./...", []string{"go", "test", "-exec", "./..."}},
+		{"go test ./...\nrm -rf ~", nil},
+		{"go test ./...\rrm -rf ~", nil},
+		{"go test ./... < /etc/shadow", nil},
+		{"go test ./... && rm -rf .", nil},
+		{"go test $(pwd)/...", nil},
+		{`go test "$HOME"`, nil},
+		{"go test ./*", nil},
+		{`go test \;`, nil},
+		{"go test 'unterminated", nil},
+		{"GOFLAGS=-exec=/tmp/evil go test ./...", nil},
+		{"   ", nil},
+	}
+
+	for _, c := range cases {
+		got, ok := SplitCommand(c.command)
+		if ok != (c.want != nil) || !slices.Equal(got, c.want) {
+			t.Errorf("SplitCommand(%q) = %q, %v, want %q", c.command, got, ok, c.want)
+		}
+	}
+}
+
+func TestRule_Command(t *testing.T) {
+	rule := Rule{Tool: "run_command", Argument: "command", Pattern: "go test *", Command: true, Decision: Allow}
+	command := func(c string) tools.ToolCall {
+		return tools.ToolCall{FunctionName: "run_command", Arguments: map[string]any{"command": c}}
+	}
+
+	if !rule.Matches(command("go 'test' ./...")) {
+		t.Error("Expected the quoted words to match")
+	}
+	if rule.Matches(command("go test ./...\nrm -rf ~")) {
+		t.Error("Expected a second line to keep the rule from matching")
+	}
+}
+
+func TestRule_Flags(t *testing.T) {
+	rule := Rule{Tool: "run_command", Argument: "command", Pattern: "go test *", Command: true, Flags: []string{"-run", "-count"}, Decision: Allow}
+	command := func(c string) tools.ToolCall {
+		return tools.ToolCall{FunctionName: "run_command", Arguments: map[string]any{"command": c}}
+	}
+
+	for _, c := range []string{"go test ./...", "go test -run TestA -count=1 ./...", "go test --count 1 ./..."} {
+		if !rule.Matches(command(c)) {
+			t.Errorf("Expected %q to match", c)
+		}
+	}
+	for _, c := range []string{"go test -o out ./...", "go test -coverprofile=c.out ./...", "go test - ./...", "go test ---run x ./..."} {
+		if rule.Matches(command(c)) {
+			t.Errorf("Expected %q not to match", c)
+		}
+	}
+}
+
+func TestRule_WildcardTool(t *testing.T) {
+	rule := Rule{Tool: "*", Decision: Deny}
+	if !rule.Matches(tools.ToolCall{FunctionName: "anything"}) {
+		t.Error("Expected wildcard tool rule to match every tool")
+	}
+}
+
+func TestAllowAll(t *testing.T) {
+	decision, _ := AllowAll().Evaluate(tools.ToolCall{FunctionName: "run_command"})
+	if decision != Allow {
+		t.Errorf("Expected allow, got %s", decision)
+	}
+}
+
+func TestStdinAsker(t *testing.T) {
+	call := tools.ToolCall{FunctionName: "run_command", Arguments: map[string]any{"command": "ls"}}
+
+	var out bytes.Buffer
+	approved, err := StdinAsker(strings.NewReader("y\n"), &out)(context.Background(), call)
+	if err != nil {
+		t.Fatalf("StdinAsker failed: %v", err)
+	}
+	if !approved {
+		t.Error("Expected call to be approved")
+	}
+	if !strings.Contains(out.String(), "run_command") {
+		t.Errorf("Expected prompt to mention the tool, got %q", out.String())
+	}
+
+	approved, err = StdinAsker(strings.NewReader("\n"), &out)(context.Background(), call)
+	if err != nil {
+		t.Fatalf("StdinAsker failed: %v", err)
+	}
+	if approved {
+		t.Error("Expected empty answer to deny the call")
+	}
+}
+
+func TestStdinAsker_Cancelled(t *testing.T) {
+	call := tools.ToolCall{FunctionName: "run_command", Arguments: map[string]any{"command": "ls"}}
+	in, typed := io.Pipe()
+	ask := StdinAsker(in, io.Discard)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ask(ctx, call); err != context.Canceled {
+		t.Fatalf("Expected the cancelled prompt to return context.Canceled, got %v", err)
+	}
+
+	// The next prompt is answered by the next line, not by a read left over from the cancelled one
+	answered := make(chan bool)
+	go func() {
+		approved, err := ask(context.Background(), call)
+		if err != nil {
+			t.Errorf("StdinAsker failed: %v", err)
+		}
+		answered <- approved
+	}()
+	io.WriteString(typed, "y\n")
+	if !<-answered {
+		t.Error("Expected the call to be approved")
+	}
+}
@@ -0,0 +1,77 @@
+package approval
+
+import "strings"
+
+// unquotedSpecial are the characters that make the shell do more than run one command with
+// literal arguments: chaining, pipes, redirects, substitutions, globs, comments and escapes
+const unquotedSpecial = ";&|<>()$`\\\"'*?[]{}~#!\n\r"
+
+// SplitCommand splits a shell command into its words when it is one simple command with
+// literal arguments, e.g. `go test -run 'TestA|TestB' ./...`. Anything the shell would
+// expand or treat as more than one command is rejected, so the words are exactly the argv
+// the command runs with.
+func SplitCommand(command string) ([]string, bool) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case r == '\'':
+			// Single quotes keep everything up to the next one literal
+			end := indexRune(runes, i+1, '\'')
+			if end < 0 {
+				return nil, false
+			}
+			word.WriteString(string(runes[i+1 : end]))
+			inWord = true
+			i = end
+		case r == '"':
+			// Double quotes still expand $, ` and \, those are refused
+			end := indexRune(runes, i+1, '"')
+			if end < 0 {
+				return nil, false
+			}
+			quoted := string(runes[i+1 : end])
+			if strings.ContainsAny(quoted, "$`\\") {
+				return nil, false
+			}
+			word.WriteString(quoted)
+			inWord = true
+			i = end
+		case strings.ContainsRune(unquotedSpecial, r):
+			return nil, false
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	if len(words) == 0 {
+		return nil, false
+	}
+	// A leading NAME=value changes the environment of the command
+	if strings.Contains(words[0], "=") {
+		return nil, false
+	}
+	return words, true
+}
+
+func indexRune(runes []rune, from int, r rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
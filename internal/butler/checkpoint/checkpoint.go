@@ -0,0 +1,311 @@
+// Package checkpoint keeps the content files had before the agent changed them, one checkpoint
+// per Run, so a turn can be undone. It works with plain files and doesn't need git.
+package checkpoint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/session"
+)
+
+// ErrNotFound is returned when no checkpoint exists for the requested turn
+var ErrNotFound = errors.New("checkpoint not found")
+
+const (
+	indexFile = "checkpoints.json"
+	blobsDir  = "blobs"
+)
+
+// Checkpoint is the state before one turn, a turn being one Run of the agent
+type Checkpoint struct {
+	Turn   int       `json:"turn"` // Starting at 1 in every session
+	Time   time.Time `json:"time"`
+	Prompt string    `json:"prompt"`
+	Parent string    `json:"parent,omitempty"` // ID of the last memory entry before the prompt, empty on the first turn
+	Memory int       `json:"memory"`           // Entries before the prompt in checkpoints older than Parent, -1 outside a run
+	Files  []File    `json:"files,omitempty"`
+}
+
+// File is the content a file had before the turn first changed it
+type File struct {
+	Path    string      `json:"path"` // Absolute
+	Existed bool        `json:"existed"`
+	Blob    string      `json:"blob,omitempty"` // SHA-256 of the content, the name of the blob file
+	Mode    fs.FileMode `json:"mode,omitempty"`
+}
+
+// Truncate returns the memory as it was before the turn, found by the ID of the entry before
+// its prompt rather than by position. It fails when that entry isn't followed by the prompt
+// anymore, e.g. when the conversation was compacted or forked since, see MemoryBefore.
+func (c Checkpoint) Truncate(entries []memory.MemoryEntry) ([]memory.MemoryEntry, error) {
+	i := c.Memory
+	if c.Parent != "" {
+		i = slices.IndexFunc(entries, func(entry memory.MemoryEntry) bool { return entry.ID == c.Parent })
+		if i >= 0 {
+			i++
+		}
+	}
+	if i < 0 || i >= len(entries) || entries[i].Role != memory.User || entries[i].Text() != c.Prompt {
+		return nil, fmt.Errorf("the conversation changed since turn %d, e.g. by compaction, it can't be rewound", c.Turn)
+	}
+	return slices.Clone(entries[:i]), nil
+}
+
+// MemoryBefore is Truncate for conversations recorded in a session, sess may be nil for ones
+// that aren't. When the memory was compacted since the turn, the entries before it are taken
+// from the session, which keeps them.
+func (c Checkpoint) MemoryBefore(entries []memory.MemoryEntry, sess *session.Session) ([]memory.MemoryEntry, error) {
+	truncated, err := c.Truncate(entries)
+	if err == nil || sess == nil || (c.Parent == "" && c.Memory != 0) {
+		return truncated, err
+	}
+	// Still in the memory but followed by another prompt, the turn is on another branch
+	if slices.ContainsFunc(entries, func(entry memory.MemoryEntry) bool { return entry.ID == c.Parent }) {
+		return nil, err
+	}
+	before, branchErr := sess.Branch(c.Parent)
+	if branchErr != nil {
+		return nil, err
+	}
+	return before, nil
+}
+
+// Store keeps the checkpoints of one session in a directory: an index file
+// and the old file contents as blobs named by their hash.
+// Like session recording, snapshots never fail the agent: the first error is kept,
+// later snapshots are skipped and the frontend can report it through Err.
+type Store struct {
+	dir string
+
+	mu          sync.Mutex
+	checkpoints []Checkpoint
+	err         error
+}
+
+// Open loads the checkpoints in dir, a missing directory is an empty store
+func Open(dir string) (*Store, error) {
+	s := &Store{dir: dir}
+	data, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.checkpoints); err != nil {
+		return nil, fmt.Errorf("reading checkpoints: %w", err)
+	}
+	return s, nil
+}
+
+// Dir is where the checkpoints are stored
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Err returns the first error, if any
+func (s *Store) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// List returns the checkpoints, oldest first
+func (s *Store) List() []Checkpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.checkpoints)
+}
+
+// Last returns the checkpoint of the latest turn
+func (s *Store) Last() (Checkpoint, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.checkpoints) == 0 {
+		return Checkpoint{}, false
+	}
+	return s.checkpoints[len(s.checkpoints)-1], true
+}
+
+// Get returns the checkpoint of a turn
+func (s *Store) Get(turn int) (Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.index(turn)
+	if i < 0 {
+		return Checkpoint{}, fmt.Errorf("%w: turn %d", ErrNotFound, turn)
+	}
+	return s.checkpoints[i], nil
+}
+
+// Begin starts the checkpoint of a new turn, files snapshotted from now on belong to it.
+// parent is the ID of the last memory entry before the prompt.
+func (s *Store) Begin(prompt, parent string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	turn := 1
+	if n := len(s.checkpoints); n > 0 {
+		turn = s.checkpoints[n-1].Turn + 1
+	}
+	s.checkpoints = append(s.checkpoints, Checkpoint{Turn: turn, Time: time.Now(), Prompt: prompt, Parent: parent})
+	s.fail(s.save())
+}
+
+// Snapshot saves the content of path before the current turn changes it.
+// Only the first snapshot of a path per turn is kept, that is the content to go back to.
+func (s *Store) Snapshot(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	if len(s.checkpoints) == 0 {
+		// Files changed outside a Run, e.g. by a tool called directly, still get a checkpoint
+		s.checkpoints = append(s.checkpoints, Checkpoint{Turn: 1, Time: time.Now(), Memory: -1})
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	current := &s.checkpoints[len(s.checkpoints)-1]
+	if slices.ContainsFunc(current.Files, func(f File) bool { return f.Path == abs }) {
+		return
+	}
+
+	file, err := s.snapshot(abs)
+	if err != nil {
+		s.fail(fmt.Errorf("snapshotting %s: %w", path, err))
+		return
+	}
+	current.Files = append(current.Files, file)
+	s.fail(s.save())
+}
+
+func (s *Store) snapshot(path string) (File, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return File{Path: path}, nil
+	}
+	if err != nil {
+		return File{}, err
+	}
+	if info.IsDir() {
+		return File{}, fmt.Errorf("%s is a directory", path)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return File{}, err
+	}
+	sum := sha256.Sum256(content)
+	blob := hex.EncodeToString(sum[:])
+	blobPath := filepath.Join(s.dir, blobsDir, blob)
+	if _, err := os.Stat(blobPath); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(blobPath), 0o755); err != nil {
+			return File{}, err
+		}
+		if err := os.WriteFile(blobPath, content, 0o600); err != nil {
+			return File{}, err
+		}
+	}
+	return File{Path: path, Existed: true, Blob: blob, Mode: info.Mode().Perm()}, nil
+}
+
+// Rewind puts every file changed since the start of turn back the way it was
+// and forgets the checkpoints of that turn and the later ones.
+// It returns the restored paths, files the agent created are deleted. When some files can't be
+// restored the error names each of them, the others are still restored and the checkpoints are kept.
+func (s *Store) Rewind(turn int) (restored []string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.index(turn)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: turn %d", ErrNotFound, turn)
+	}
+
+	// The oldest snapshot of a path is its content before all the turns being undone
+	oldest := map[string]File{}
+	var paths []string
+	for _, c := range s.checkpoints[i:] {
+		for _, f := range c.Files {
+			if _, ok := oldest[f.Path]; !ok {
+				oldest[f.Path] = f
+				paths = append(paths, f.Path)
+			}
+		}
+	}
+	slices.Sort(paths)
+
+	var errs []error
+	for _, path := range paths {
+		if err := s.restore(oldest[path]); err != nil {
+			errs = append(errs, fmt.Errorf("restoring %s: %w", path, err))
+			continue
+		}
+		restored = append(restored, path)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return restored, err
+	}
+
+	s.checkpoints = s.checkpoints[:i]
+	return restored, s.save()
+}
+
+func (s *Store) restore(f File) error {
+	if !f.Existed {
+		if err := os.Remove(f.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	content, err := os.ReadFile(filepath.Join(s.dir, blobsDir, f.Blob))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0o755); err != nil {
+		return err
+	}
+	mode := f.Mode
+	if mode == 0 {
+		mode = 0o644
+	}
+	return os.WriteFile(f.Path, content, mode)
+}
+
+func (s *Store) index(turn int) int {
+	return slices.IndexFunc(s.checkpoints, func(c Checkpoint) bool { return c.Turn == turn })
+}
+
+// save rewrites the index, the file is replaced atomically so a crash keeps the previous one
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.checkpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, indexFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, indexFile))
+}
+
+func (s *Store) fail(err error) {
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+}
@@ -0,0 +1,214 @@
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/mightymoud/arlocode/internal/butler/agent"
+	"github.com/mightymoud/arlocode/internal/butler/llm/fake"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/session"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestStore_Rewind(t *testing.T) {
+	work := t.TempDir()
+	main := filepath.Join(work, "main.go")
+	created := filepath.Join(work, "new", "util.go")
+	writeFile(t, main, "v1")
+
+	store, err := Open(filepath.Join(t.TempDir(), "checkpoints"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	store.Begin("first", "")
+	store.Snapshot(main)
+	writeFile(t, main, "v2")
+	store.Snapshot(main) // Only the content before the turn matters
+	writeFile(t, main, "v3")
+
+	store.Begin("second", "answer-1")
+	store.Snapshot(main)
+	writeFile(t, main, "v4")
+	store.Snapshot(created)
+	os.MkdirAll(filepath.Dir(created), 0o755)
+	writeFile(t, created, "package new")
+
+	if err := store.Err(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	checkpoints := store.List()
+	if len(checkpoints) != 2 || checkpoints[0].Turn != 1 || checkpoints[1].Turn != 2 || len(checkpoints[0].Files) != 1 {
+		t.Fatalf("Unexpected checkpoints: %+v", checkpoints)
+	}
+
+	restored, err := store.Rewind(2)
+	if err != nil {
+		t.Fatalf("Rewind failed: %v", err)
+	}
+	if !slices.Equal(restored, []string{main, created}) {
+		t.Errorf("Unexpected restored files: %v", restored)
+	}
+	if got := readFile(t, main); got != "v3" {
+		t.Errorf("Expected main.go as it was before turn 2, got %q", got)
+	}
+	if _, err := os.Stat(created); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the file created in turn 2 to be deleted, got %v", err)
+	}
+
+	// A new process sees what is left
+	reopened, err := Open(store.Dir())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if last, ok := reopened.Last(); !ok || last.Turn != 1 {
+		t.Fatalf("Expected only turn 1 to be left, got %+v", reopened.List())
+	}
+	if _, err := reopened.Rewind(1); err != nil {
+		t.Fatalf("Rewind failed: %v", err)
+	}
+	if got := readFile(t, main); got != "v1" {
+		t.Errorf("Expected the original main.go, got %q", got)
+	}
+	if _, err := reopened.Rewind(1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a rewound turn, got %v", err)
+	}
+}
+
+func TestCheckpoint_Truncate(t *testing.T) {
+	entries := memory.Link([]memory.MemoryEntry{
+		memory.Text(memory.User, "first"),
+		memory.Text(memory.Model, "done"),
+		memory.Text(memory.User, "second"),
+		memory.Text(memory.Model, "done too"),
+	})
+
+	got, err := Checkpoint{Turn: 2, Prompt: "second", Parent: entries[1].ID}.Truncate(entries)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("Expected the memory before turn 2, got %v, %v", got, err)
+	}
+	got, err = Checkpoint{Turn: 1, Prompt: "first"}.Truncate(entries)
+	if err != nil || len(got) != 0 {
+		t.Fatalf("Expected the memory before turn 1, got %v, %v", got, err)
+	}
+	// Checkpoints written before Parent existed hold the position of the prompt
+	got, err = Checkpoint{Turn: 2, Prompt: "second", Memory: 2}.Truncate(entries)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("Expected the memory before turn 2, got %v, %v", got, err)
+	}
+
+	if _, err := (Checkpoint{Turn: 2, Prompt: "second", Parent: entries[0].ID}).Truncate(entries); err == nil {
+		t.Error("Expected an error when the prompt doesn't follow the entry")
+	}
+	if _, err := (Checkpoint{Turn: 2, Prompt: "second", Parent: "compacted"}).Truncate(entries); err == nil {
+		t.Error("Expected an error when the entry isn't in the memory anymore")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	work := t.TempDir()
+	path := filepath.Join(work, "notes.txt")
+	writeFile(t, path, "hello")
+
+	var fileTools []tools.Tool
+	for _, tool := range tools.StdToolset {
+		if _, ok := DefaultTools[tool.Name]; ok {
+			fileTools = append(fileTools, tool)
+		}
+	}
+	model := fake.New(t).
+		Turn().CallTool("apply_edit", map[string]any{"path": path, "old_text": "hello", "new_text": "bye"}).
+		Turn().Text("edited").
+		Turn().CallTool("make_file", map[string]any{"path": filepath.Join(work, "todo.txt"), "content": "x"}).
+		Turn().Text("created")
+	a := agent.NewAgent(model).WitTools(fileTools)
+
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	stop := Watch(a, store, DefaultTools)
+	defer stop()
+
+	for _, prompt := range []string{"edit", "create"} {
+		if _, err := a.Run(context.Background(), prompt); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+	}
+
+	checkpoints := store.List()
+	if len(checkpoints) != 2 || checkpoints[1].Prompt != "create" || checkpoints[1].Parent != a.GetMemory()[3].ID {
+		t.Fatalf("Expected a checkpoint per run, got %+v", checkpoints)
+	}
+
+	restored, err := store.Rewind(1)
+	if err != nil {
+		t.Fatalf("Rewind failed: %v", err)
+	}
+	if len(restored) != 2 || readFile(t, path) != "hello" {
+		t.Errorf("Expected both runs undone, restored %v and notes.txt is %q", restored, readFile(t, path))
+	}
+
+	mem, err := checkpoints[0].Truncate(a.GetMemory())
+	if err != nil || len(mem) != 0 {
+		t.Errorf("Expected the memory before the first run, got %v, %v", mem, err)
+	}
+}
+
+func TestCheckpoint_MemoryBefore(t *testing.T) {
+	model := fake.New(t).Turn().Text("one").Turn().Text("two")
+	sess := session.NewStore(t.TempDir()).Create(session.Meta{})
+	a := agent.NewAgent(model).WithRecorder(sess)
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer Watch(a, store, DefaultTools)()
+
+	for _, prompt := range []string{"first", "second"} {
+		if _, err := a.Run(context.Background(), prompt); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+	}
+	checkpoints := store.List()
+
+	// Compaction replaces the memory with a summary and the latest entries
+	mem := a.GetMemory()
+	a.SwitchBranch(append([]memory.MemoryEntry{memory.Text(memory.User, "summary")}, mem[2:]...))
+	if _, err := checkpoints[1].Truncate(a.GetMemory()); err == nil {
+		t.Fatal("Expected the compacted memory to have lost the turn")
+	}
+
+	got, err := checkpoints[1].MemoryBefore(a.GetMemory(), sess)
+	if err != nil || len(got) != 2 || got[1].Text() != "one" {
+		t.Errorf("Expected the recorded entries before turn 2, got %v, %v", got, err)
+	}
+	got, err = checkpoints[0].MemoryBefore(a.GetMemory(), sess)
+	if err != nil || len(got) != 0 {
+		t.Errorf("Expected the memory before turn 1, got %v, %v", got, err)
+	}
+	if _, err := checkpoints[1].MemoryBefore(a.GetMemory(), nil); err == nil {
+		t.Error("Expected an error without a session")
+	}
+}
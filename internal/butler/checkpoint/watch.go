@@ -0,0 +1,35 @@
+package checkpoint
+
+import "github.com/mightymoud/arlocode/internal/butler/agent"
+
+// DefaultTools are the tools of tools.StdToolset that write files, mapped to the argument holding the path.
+// run_command can change files too but there is no telling which, its changes can't be undone.
+var DefaultTools = map[string]string{
+	"apply_edit": "path",
+	"make_file":  "path",
+}
+
+// Watch starts a checkpoint on every Run of the agent and snapshots the files the given tools
+// are about to write, until stop is called. Tool events are delivered before the tool runs,
+// so the snapshot always holds the content from before the change.
+func Watch(a *agent.Agent, s *Store, writers map[string]string) (stop func()) {
+	return a.Subscribe(func(e agent.Event) {
+		switch e := e.(type) {
+		case agent.RunStarted:
+			// The prompt is added to the memory right after this event
+			parent := ""
+			if mem := a.GetMemory(); len(mem) > 0 {
+				parent = mem[len(mem)-1].ID
+			}
+			s.Begin(e.Prompt, parent)
+		case agent.ToolStarted:
+			arg, ok := writers[e.Call.FunctionName]
+			if !ok {
+				return
+			}
+			if path, ok := e.Call.Arguments[arg].(string); ok && path != "" {
+				s.Snapshot(path)
+			}
+		}
+	})
+}
@@ -0,0 +1,230 @@
+package compaction
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+)
+
+// Config controls when the conversation is compacted and what survives it.
+type Config struct {
+	ContextLimit int     // Tokens the model accepts, see ContextWindow
+	Threshold    float64 // Fraction of ContextLimit that triggers compaction
+	KeepRecent   int     // Most recent entries that are always kept verbatim
+
+	// Summarizer writes the summary of the older turns, a small cheap model is enough.
+	// Without one compaction only drops stale tool outputs.
+	Summarizer llm.LLM
+
+	// Pinned is appended verbatim to every summary, for state kept outside the memory
+	// that the model must not lose, like its todo list. Empty text adds nothing.
+	Pinned func() string
+}
+
+// DefaultConfig compacts at 80% of the context window and keeps the last few exchanges intact
+func DefaultConfig(contextLimit int, summarizer llm.LLM) Config {
+	return Config{
+		ContextLimit: contextLimit,
+		Threshold:    0.8,
+		KeepRecent:   8,
+		Summarizer:   summarizer,
+	}
+}
+
+// Result describes what a compaction did
+type Result struct {
+	TokensBefore      int
+	TokensAfter       int
+	PrunedToolOutputs int             // Old tool outputs replaced with a placeholder
+	SummarizedEntries int             // Entries folded into the summary, 0 when no summary was written
+	Usage             providers.Usage // What the summarizer call consumed
+}
+
+// SummaryPrefix starts the memory entry that replaces summarized turns
+const SummaryPrefix = "Summary of the earlier conversation:\n\n"
+
+const prunedToolOutput = "[output of %s removed to save context, call the tool again if it is still needed]"
+
+const summaryInstructions = `Summarize the conversation below so it can replace it in the context of a coding agent.
+Keep the user's requests and constraints, decisions that were made, files that were read or changed,
+commands that were run with their outcome, and any open questions or next steps.
+Leave out tool output that is no longer relevant. Answer with the summary only.
+
+Conversation:
+
+`
+
+type Compactor struct {
+	config Config
+}
+
+func New(config Config) *Compactor {
+	return &Compactor{config: config}
+}
+
+// Limit is the token count above which the conversation gets compacted
+func (c *Compactor) Limit() int {
+	return int(float64(c.config.ContextLimit) * c.config.Threshold)
+}
+
+// ShouldCompact reports whether the entries crossed the configured threshold. overhead is
+// what every call sends besides the entries, like the system prompt and the tool declarations.
+func (c *Compactor) ShouldCompact(entries []memory.MemoryEntry, overhead int) bool {
+	if c.config.ContextLimit <= 0 {
+		return false
+	}
+	return memory.CountTokens(entries)+overhead > c.Limit()
+}
+
+// MaybeCompact compacts only when the threshold is crossed, see ShouldCompact for overhead.
+// Stale tool outputs are dropped first, the older turns are only summarized when that wasn't enough.
+// When summarizing fails the pruned entries are still returned together with the error.
+func (c *Compactor) MaybeCompact(ctx context.Context, entries []memory.MemoryEntry, overhead int) ([]memory.MemoryEntry, Result, error) {
+	result := Result{TokensBefore: memory.CountTokens(entries)}
+	if !c.ShouldCompact(entries, overhead) {
+		result.TokensAfter = result.TokensBefore
+		return entries, result, nil
+	}
+
+	pruned, count := c.prune(entries)
+	result.PrunedToolOutputs = count
+	result.TokensAfter = memory.CountTokens(pruned)
+	if result.TokensAfter+overhead <= c.Limit() || c.config.Summarizer == nil {
+		return pruned, result, nil
+	}
+
+	return c.summarize(ctx, pruned, result)
+}
+
+// Compact drops stale tool outputs and summarizes everything but the system prompt
+// and the recent entries, regardless of the threshold.
+func (c *Compactor) Compact(ctx context.Context, entries []memory.MemoryEntry) ([]memory.MemoryEntry, Result, error) {
+	result := Result{TokensBefore: memory.CountTokens(entries)}
+	pruned, count := c.prune(entries)
+	result.PrunedToolOutputs = count
+	result.TokensAfter = memory.CountTokens(pruned)
+	if c.config.Summarizer == nil {
+		return pruned, result, nil
+	}
+	return c.summarize(ctx, pruned, result)
+}
+
+// prune replaces the output of tool calls outside the recent window with a short placeholder
+func (c *Compactor) prune(entries []memory.MemoryEntry) ([]memory.MemoryEntry, int) {
+	_, end := c.olderRange(entries)
+	pruned := make([]memory.MemoryEntry, len(entries))
+	copy(pruned, entries)
+
+	count := 0
+	for i := 0; i < end; i++ {
+		entry := pruned[i]
+		result, ok := entry.ToolResult()
+		if entry.Role != memory.Tool || !ok {
+			continue
+		}
+		placeholder := fmt.Sprintf(prunedToolOutput, result.ToolName)
+		if len(result.Text) <= len(placeholder) {
+			continue
+		}
+		result.Text = placeholder
+		entry.Parts = []memory.Part{result}
+		entry.Tokens = memory.EstimateTokens(entry)
+		pruned[i] = entry
+		count++
+	}
+	return pruned, count
+}
+
+// summarize asks the summarizer to condense the older entries into a single user entry
+func (c *Compactor) summarize(ctx context.Context, entries []memory.MemoryEntry, result Result) ([]memory.MemoryEntry, Result, error) {
+	start, end := c.olderRange(entries)
+	if end <= start {
+		return entries, result, nil
+	}
+
+	prompt := memory.Text(memory.User, summaryInstructions+Transcript(entries[start:end]))
+	resp, err := c.config.Summarizer.Stream(ctx, []memory.MemoryEntry{prompt}, nil, butler.EventHooks{})
+	if err != nil {
+		return entries, result, fmt.Errorf("summarizing conversation: %w", err)
+	}
+	result.Usage = resp.Usage
+	if strings.TrimSpace(resp.Text) == "" {
+		return entries, result, errors.New("summarizing conversation: the model returned an empty summary")
+	}
+
+	message := SummaryPrefix + strings.TrimSpace(resp.Text)
+	if c.config.Pinned != nil {
+		if pinned := strings.TrimSpace(c.config.Pinned()); pinned != "" {
+			message += "\n\n" + pinned
+		}
+	}
+	summary := memory.Text(memory.User, message)
+	summary.Tokens = memory.EstimateTokens(summary)
+
+	compacted := make([]memory.MemoryEntry, 0, start+1+len(entries)-end)
+	compacted = append(compacted, entries[:start]...)
+	compacted = append(compacted, summary)
+	compacted = append(compacted, entries[end:]...)
+
+	result.SummarizedEntries = end - start
+	result.TokensAfter = memory.CountTokens(compacted)
+	return compacted, result, nil
+}
+
+// olderRange returns the entries that may be compacted: everything after the leading
+// system entries and before the recent window. The window never starts on a tool entry,
+// so a tool call and its results always stay together.
+func (c *Compactor) olderRange(entries []memory.MemoryEntry) (start, end int) {
+	for start < len(entries) && entries[start].Role == memory.System {
+		start++
+	}
+
+	end = len(entries) - c.config.KeepRecent
+	for end > start && end < len(entries) && entries[end].Role == memory.Tool {
+		end--
+	}
+	if end < start {
+		end = start
+	}
+	return start, end
+}
+
+// Transcript renders entries as plain text for the summarizer
+func Transcript(entries []memory.MemoryEntry) string {
+	var b strings.Builder
+	for _, entry := range entries {
+		switch entry.Role {
+		case memory.Tool:
+			result, _ := entry.ToolResult()
+			fmt.Fprintf(&b, "[tool %s result]\n%s\n\n", result.ToolName, result.Text)
+		default:
+			fmt.Fprintf(&b, "[%s]\n%s\n", entry.Role, entry.Text())
+			for _, part := range entry.Parts {
+				switch part.Type {
+				case memory.ImagePart:
+					b.WriteString("(attached an image)\n")
+				case memory.FilePart:
+					fmt.Fprintf(&b, "(attached %s)\n", part.Path)
+				}
+			}
+			for _, call := range entry.ToolCalls() {
+				args := call.RawArguments
+				if call.Arguments != nil {
+					if raw, err := json.Marshal(call.Arguments); err == nil {
+						args = string(raw)
+					}
+				}
+				fmt.Fprintf(&b, "(called %s with %s)\n", call.FunctionName, args)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,172 @@
+package compaction
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+type mockSummarizer struct {
+	text   string
+	err    error
+	prompt string
+}
+
+func (m *mockSummarizer) Stream(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+	m.prompt = mem[len(mem)-1].Text()
+	return providers.ProviderResponse{Text: m.text}, m.err
+}
+
+func (m *mockSummarizer) Generate(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) error {
+	return nil
+}
+
+// conversation builds a system prompt followed by n exchanges of user, model tool call and tool result
+func conversation(n int, toolOutput string) []memory.MemoryEntry {
+	entries := []memory.MemoryEntry{memory.Text(memory.System, "You are a coding agent")}
+	call := tools.ToolCall{ID: "call", FunctionName: "read_file"}
+	for i := 0; i < n; i++ {
+		entries = append(entries,
+			memory.Text(memory.User, "read the file"),
+			memory.Response("", []tools.ToolCall{call}),
+			memory.ToolResult(call, toolOutput, false),
+		)
+	}
+	return entries
+}
+
+func TestShouldCompact(t *testing.T) {
+	c := New(Config{ContextLimit: 1000, Threshold: 0.5})
+
+	if c.ShouldCompact(conversation(1, "short"), 0) {
+		t.Error("Expected small conversation to stay as is")
+	}
+	if !c.ShouldCompact(conversation(1, "short"), 500) {
+		t.Error("Expected the system prompt and tools to count towards the threshold")
+	}
+	if !c.ShouldCompact(conversation(1, strings.Repeat("x", 4000)), 0) {
+		t.Error("Expected conversation over the threshold to be compacted")
+	}
+	if New(Config{}).ShouldCompact(conversation(1, strings.Repeat("x", 4000)), 0) {
+		t.Error("Expected no compaction without a context limit")
+	}
+}
+
+func TestMaybeCompact_PrunesStaleToolOutputs(t *testing.T) {
+	summarizer := &mockSummarizer{text: "summary"}
+	c := New(Config{ContextLimit: 6000, Threshold: 0.5, KeepRecent: 3, Summarizer: summarizer})
+
+	entries := conversation(4, strings.Repeat("x", 4000))
+	compacted, result, err := c.MaybeCompact(context.Background(), entries, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(compacted) != len(entries) {
+		t.Fatalf("Expected pruning to keep all entries, got %d of %d", len(compacted), len(entries))
+	}
+	if result.PrunedToolOutputs != 3 {
+		t.Errorf("Expected 3 pruned tool outputs, got %d", result.PrunedToolOutputs)
+	}
+	if result.SummarizedEntries != 0 || summarizer.prompt != "" {
+		t.Error("Expected no summary when pruning was enough")
+	}
+	if compacted[len(compacted)-1].Text() != entries[len(entries)-1].Text() {
+		t.Error("Expected the recent tool output to be kept verbatim")
+	}
+	if result.TokensAfter >= result.TokensBefore {
+		t.Errorf("Expected fewer tokens after compaction, got %d -> %d", result.TokensBefore, result.TokensAfter)
+	}
+	if entries[3].Text() != strings.Repeat("x", 4000) {
+		t.Error("Expected the original entries to be left untouched")
+	}
+}
+
+func TestMaybeCompact_SummarizesOlderTurns(t *testing.T) {
+	summarizer := &mockSummarizer{text: "the user asked to read files"}
+	c := New(Config{ContextLimit: 1000, Threshold: 0.5, KeepRecent: 2, Summarizer: summarizer})
+
+	entries := conversation(4, strings.Repeat("x", 4000))
+	compacted, result, err := c.MaybeCompact(context.Background(), entries, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// system + summary + the last model/tool pair, the window moved back so the pair stays together
+	if len(compacted) != 4 {
+		t.Fatalf("Expected 4 entries, got %d", len(compacted))
+	}
+	if compacted[0].Role != "system" {
+		t.Error("Expected the system prompt to be kept")
+	}
+	if compacted[1].Role != "user" || compacted[1].Text() != SummaryPrefix+"the user asked to read files" {
+		t.Errorf("Expected summary entry, got %+v", compacted[1])
+	}
+	if compacted[2].Role != "model" || compacted[3].Role != "tool" {
+		t.Errorf("Expected the last tool call and its result to be kept, got %s and %s", compacted[2].Role, compacted[3].Role)
+	}
+	if result.SummarizedEntries != 10 {
+		t.Errorf("Expected 10 summarized entries, got %d", result.SummarizedEntries)
+	}
+	if !strings.Contains(summarizer.prompt, "(called read_file with") {
+		t.Error("Expected the transcript to include tool calls")
+	}
+}
+
+func TestCompact_AppendsPinnedState(t *testing.T) {
+	config := Config{ContextLimit: 1000, Threshold: 0.5, KeepRecent: 2, Summarizer: &mockSummarizer{text: "summary"}}
+	config.Pinned = func() string { return "[ ] 1. write tests" }
+	compacted, _, err := New(config).Compact(context.Background(), conversation(4, "output"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if compacted[1].Text() != SummaryPrefix+"summary\n\n[ ] 1. write tests" {
+		t.Errorf("Expected the pinned state after the summary, got %q", compacted[1].Text())
+	}
+}
+
+func TestMaybeCompact_SummaryFailureKeepsPruning(t *testing.T) {
+	failure := errors.New("boom")
+	c := New(Config{ContextLimit: 100, Threshold: 0.5, KeepRecent: 2, Summarizer: &mockSummarizer{err: failure}})
+
+	entries := conversation(4, strings.Repeat("x", 4000))
+	compacted, result, err := c.MaybeCompact(context.Background(), entries, 0)
+	if !errors.Is(err, failure) {
+		t.Fatalf("Expected summarizer error, got %v", err)
+	}
+	if len(compacted) != len(entries) || result.PrunedToolOutputs == 0 {
+		t.Error("Expected the pruned entries to be returned with the error")
+	}
+}
+
+func TestCompact_IgnoresThreshold(t *testing.T) {
+	c := New(Config{ContextLimit: 1_000_000, Threshold: 0.8, KeepRecent: 2, Summarizer: &mockSummarizer{text: "summary"}})
+
+	compacted, result, err := c.Compact(context.Background(), conversation(3, "output"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.SummarizedEntries == 0 || len(compacted) != 4 {
+		t.Errorf("Expected a manual compaction to summarize, got %d entries", len(compacted))
+	}
+}
+
+func TestContextWindow(t *testing.T) {
+	cases := map[string]int{
+		"anthropic/claude-sonnet-4.5": 200_000,
+		"gpt-4o":                      128_000,
+		"google/gemini-2.5-pro":       1_048_576,
+		"some/unknown-model":          DefaultContextWindow,
+	}
+	for model, want := range cases {
+		if got := ContextWindow(model); got != want {
+			t.Errorf("ContextWindow(%q) = %d, want %d", model, got, want)
+		}
+	}
+}
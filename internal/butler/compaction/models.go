@@ -0,0 +1,39 @@
+package compaction
+
+import "strings"
+
+// DefaultContextWindow is used for models that aren't listed in ContextWindows
+const DefaultContextWindow = 128_000
+
+// ContextWindows maps model IDs to the number of tokens they accept.
+// Provider prefixes such as "anthropic/" are ignored when looking models up.
+var ContextWindows = map[string]int{
+	"claude-sonnet-4.5":     200_000,
+	"claude-sonnet-4":       200_000,
+	"claude-opus-4.1":       200_000,
+	"claude-haiku-4.5":      200_000,
+	"claude-3.5-haiku":      200_000,
+	"gpt-4o":                128_000,
+	"gpt-4o-mini":           128_000,
+	"gpt-4.1":               1_047_576,
+	"gpt-4.1-mini":          1_047_576,
+	"gpt-5":                 400_000,
+	"gpt-5-mini":            400_000,
+	"gemini-2.5-pro":        1_048_576,
+	"gemini-2.5-flash":      1_048_576,
+	"gemini-2.5-flash-lite": 1_048_576,
+	"gemini-2.0-flash":      1_048_576,
+}
+
+// ContextWindow returns the context limit of a model in tokens
+func ContextWindow(modelID string) int {
+	if limit, ok := ContextWindows[modelID]; ok {
+		return limit
+	}
+	if i := strings.LastIndex(modelID, "/"); i >= 0 {
+		if limit, ok := ContextWindows[modelID[i+1:]]; ok {
+			return limit
+		}
+	}
+	return DefaultContextWindow
+}
@@ -0,0 +1,274 @@
+package delegate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mightymoud/arlocode/internal/butler/agent"
+	"github.com/mightymoud/arlocode/internal/butler/approval"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// ToolName is the name the model calls the delegation tool by
+const ToolName = "delegate_task"
+
+const toolDescription = "Hands bounded subtasks to sub-agents that work with their own context and return only their final answer. " +
+	"Use it for research that would otherwise fill your context, e.g. 'find every caller of X and summarize how it is used'. " +
+	"Independent tasks passed in one call run in parallel. Each task must be self-contained, sub-agents can't see this conversation."
+
+const subAgentPrompt = `You are a sub-agent working on a single task for another agent.
+Work only on this task and don't ask questions, nobody will answer them.
+Finish with a concise, self-contained answer: it is the only part of your work the other agent will see.
+
+Task: %s`
+
+// Hooks report what sub-agents are doing so frontends can show it under the parent.
+// Sub-agents run concurrently, so the hooks are called from several goroutines.
+type Hooks struct {
+	OnStart     func(id, task string)
+	OnTextChunk func(id, chunk string)
+	OnToolCall  func(id string, call tools.ToolCall)
+	OnDone      func(id, answer string, err error)
+	OnUsage     func(id string, usage providers.Usage) // After every model call of a sub-agent
+}
+
+type Config struct {
+	LLM           llm.LLM      // Model the sub-agents run on, a cheaper one than the parent's works for most tasks
+	Tools         []tools.Tool // Tools sub-agents may use, defaults to ReadOnlyTools
+	MaxIterations int          // Iteration limit of every sub-agent
+	Budget        agent.Budget // Budget of every sub-agent, unlimited by default
+	MaxParallel   int          // Sub-agents running at the same time
+
+	// Tool calls of sub-agents are checked against this policy, calls that need
+	// approval are denied when AskApproval is nil
+	ApprovalPolicy approval.Policy
+	AskApproval    approval.AskFunc
+
+	Hooks Hooks
+}
+
+// DefaultConfig gives sub-agents the read-only tools and a policy that only allows those,
+// 15 iterations and runs up to 4 at once
+func DefaultConfig(l llm.LLM) Config {
+	return Config{
+		LLM:            l,
+		Tools:          ReadOnlyTools(),
+		MaxIterations:  15,
+		MaxParallel:    4,
+		ApprovalPolicy: ReadOnlyPolicy(),
+	}
+}
+
+// readOnlyToolNames are the standard tools that can't change anything on the machine
+var readOnlyToolNames = []string{"read_file", "read_folder", "list_folder_contents", "search_code", "fetch_url_as_markdown"}
+
+// ReadOnlyTools returns the standard tools that only read
+func ReadOnlyTools() []tools.Tool {
+	var readOnly []tools.Tool
+	for _, t := range tools.StdToolset {
+		if slices.Contains(readOnlyToolNames, t.Name) {
+			readOnly = append(readOnly, t)
+		}
+	}
+	return readOnly
+}
+
+// ReadOnlyPolicy allows the read-only tools and denies every other call, so sub-agents
+// given more tools still can't change anything without a policy of the caller's own
+func ReadOnlyPolicy() approval.Policy {
+	rules := make([]approval.Rule, 0, len(readOnlyToolNames)+1)
+	for _, name := range readOnlyToolNames {
+		rules = append(rules, approval.Rule{Tool: name, Decision: approval.Allow})
+	}
+	rules = append(rules, approval.Rule{Tool: "*", Decision: approval.Deny, Reason: "sub-agents may only use read-only tools"})
+	return approval.NewRulePolicy(approval.Deny, rules...)
+}
+
+// Task is one subtask handed to a sub-agent
+type Task struct {
+	Task  string   `json:"task" description:"What the sub-agent should do and what its answer should contain, with all the context it needs"`
+	Tools []string `json:"tools,omitempty" description:"Names of the tools the sub-agent may use, all available tools when empty"`
+}
+
+type delegateArgs struct {
+	Tasks []Task `json:"tasks" description:"The subtasks, independent tasks run in parallel"`
+}
+
+type delegator struct {
+	config Config
+	nextID atomic.Int64
+}
+
+// NewTool builds the delegate_task tool
+func NewTool(config Config) tools.Tool {
+	if config.Tools == nil {
+		config.Tools = ReadOnlyTools()
+	}
+	// Sub-agents never delegate further, that would make the cost unbounded
+	config.Tools = slices.DeleteFunc(slices.Clone(config.Tools), func(t tools.Tool) bool {
+		return t.Name == ToolName
+	})
+	if config.MaxParallel <= 0 {
+		config.MaxParallel = 1
+	}
+	d := &delegator{config: config}
+	return tools.NewButlerTool(ToolName, toolDescription, d.run)
+}
+
+type taskResult struct {
+	answer string
+	err    error
+}
+
+func (d *delegator) run(ctx context.Context, args delegateArgs) (string, error) {
+	if len(args.Tasks) == 0 {
+		return "", errors.New("no tasks given")
+	}
+
+	results := make([]taskResult, len(args.Tasks))
+	sem := make(chan struct{}, d.config.MaxParallel)
+	var wg sync.WaitGroup
+	for i, task := range args.Tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				results[i] = taskResult{err: ctx.Err()}
+				return
+			}
+			answer, err := d.runTask(ctx, task)
+			results[i] = taskResult{answer: answer, err: err}
+		}()
+	}
+	wg.Wait()
+
+	var b strings.Builder
+	for i, task := range args.Tasks {
+		fmt.Fprintf(&b, "## Task %d: %s\n\n", i+1, task.Task)
+		if results[i].err != nil {
+			fmt.Fprintf(&b, "error: %v\n\n", results[i].err)
+			if results[i].answer != "" {
+				b.WriteString(results[i].answer + "\n\n")
+			}
+			continue
+		}
+		b.WriteString(results[i].answer + "\n\n")
+	}
+	return strings.TrimSpace(b.String()), ctx.Err()
+}
+
+// runTask runs one sub-agent to completion and returns its final answer
+func (d *delegator) runTask(ctx context.Context, task Task) (answer string, err error) {
+	id := fmt.Sprintf("task-%d", d.nextID.Add(1))
+	hooks := d.config.Hooks
+	if hooks.OnStart != nil {
+		hooks.OnStart(id, task.Task)
+	}
+	defer func() {
+		if hooks.OnDone != nil {
+			hooks.OnDone(id, answer, err)
+		}
+	}()
+
+	taskTools, err := d.selectTools(task.Tools)
+	if err != nil {
+		return "", err
+	}
+
+	child := agent.NewAgent(d.config.LLM).
+		WitTools(taskTools).
+		WithMaxIterations(d.config.MaxIterations).
+		WithBudget(d.config.Budget)
+	if d.config.ApprovalPolicy != nil {
+		child.WithApprovalPolicy(d.config.ApprovalPolicy)
+	}
+	if d.config.AskApproval != nil {
+		child.WithOnApprovalRequest(d.config.AskApproval)
+	}
+	if hooks.OnTextChunk != nil {
+		child.WithOnTextChunk(func(chunk string) { hooks.OnTextChunk(id, chunk) })
+	}
+	if hooks.OnToolCall != nil {
+		// Retried or re-streamed model calls report the same call again, it is forwarded once
+		seen := map[string]bool{}
+		child.WithOnToolCall(func(call tools.ToolCall) {
+			if call.ID != "" && seen[call.ID] {
+				return
+			}
+			seen[call.ID] = true
+			hooks.OnToolCall(id, call)
+		})
+	}
+	if hooks.OnUsage != nil {
+		child.WithOnUsage(func(report agent.UsageReport) { hooks.OnUsage(id, report.Call) })
+	}
+
+	reason, runErr := child.Run(ctx, fmt.Sprintf(subAgentPrompt, task.Task))
+	answer = finalAnswer(child.GetMemory())
+	if runErr != nil {
+		return answer, runErr
+	}
+	switch {
+	case answer == "" && reason == agent.StopMaxIterations:
+		return "", fmt.Errorf("the sub-agent used its %d iterations without giving an answer", d.config.MaxIterations)
+	case answer == "" && reason == agent.StopBudgetExhausted:
+		return "", errors.New("the sub-agent ran out of budget without giving an answer")
+	case reason != agent.StopCompleted:
+		// The summary of a stopped sub-agent is all there is, but it isn't a finished answer
+		return answer, fmt.Errorf("the sub-agent stopped early (%s), its answer may be incomplete", reason)
+	}
+	return answer, nil
+}
+
+// selectTools restricts the configured tools to the ones the task asked for
+func (d *delegator) selectTools(names []string) ([]tools.Tool, error) {
+	if len(names) == 0 {
+		return d.config.Tools, nil
+	}
+	var selected []tools.Tool
+	for _, name := range names {
+		i := slices.IndexFunc(d.config.Tools, func(t tools.Tool) bool { return t.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("tool %q is not available to sub-agents, available tools are: %s", name, strings.Join(toolNames(d.config.Tools), ", "))
+		}
+		selected = append(selected, d.config.Tools[i])
+	}
+	return selected, nil
+}
+
+func toolNames(ts []tools.Tool) []string {
+	names := make([]string, 0, len(ts))
+	for _, t := range ts {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// finalAnswer is the text of the last model entry that says something, provided the model
+// stopped calling tools there. Entries after it that aren't the model's are skipped.
+func finalAnswer(mem []memory.MemoryEntry) string {
+	for i := len(mem) - 1; i >= 0; i-- {
+		entry := mem[i]
+		if entry.Role != memory.Model {
+			continue
+		}
+		if len(entry.ToolCalls()) > 0 {
+			return ""
+		}
+		if answer := strings.TrimSpace(entry.Text()); answer != "" {
+			return answer
+		}
+	}
+	return ""
+}
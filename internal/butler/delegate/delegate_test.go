@@ -0,0 +1,231 @@
+package delegate
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/agent"
+	"github.com/mightymoud/arlocode/internal/butler/approval"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+type mockLLM struct {
+	streamFunc func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error)
+}
+
+func (m *mockLLM) Stream(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+	return m.streamFunc(ctx, mem, t, hooks)
+}
+
+func (m *mockLLM) Generate(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) error {
+	return nil
+}
+
+// echoLLM answers every task with the task text, streamed as one chunk
+func echoLLM() *mockLLM {
+	return &mockLLM{streamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+		prompt := mem[0].Text()
+		answer := "answer to " + prompt[strings.LastIndex(prompt, "Task: ")+len("Task: "):]
+		if hooks.OnTextChunk != nil {
+			hooks.OnTextChunk(answer)
+		}
+		return providers.ProviderResponse{Text: answer}, nil
+	}}
+}
+
+func runTool(t *testing.T, tool tools.Tool, args map[string]any) (string, error) {
+	t.Helper()
+	return agent.NewAgent(nil).WitTools([]tools.Tool{tool}).HandleToolCall(context.Background(), tools.ToolCall{
+		ID:           "call_1",
+		FunctionName: ToolName,
+		Arguments:    args,
+	})
+}
+
+func TestDelegate_ReturnsOnlyFinalAnswers(t *testing.T) {
+	var mu sync.Mutex
+	var started, done []string
+	chunks := map[string]string{}
+
+	config := DefaultConfig(echoLLM())
+	config.Hooks = Hooks{
+		OnStart: func(id, task string) {
+			mu.Lock()
+			defer mu.Unlock()
+			started = append(started, id)
+		},
+		OnTextChunk: func(id, chunk string) {
+			mu.Lock()
+			defer mu.Unlock()
+			chunks[id] += chunk
+		},
+		OnDone: func(id, answer string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			done = append(done, id)
+		},
+	}
+
+	output, err := runTool(t, NewTool(config), map[string]any{
+		"tasks": []any{
+			map[string]any{"task": "find callers of Run"},
+			map[string]any{"task": "list the packages"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := "## Task 1: find callers of Run\n\nanswer to find callers of Run\n\n## Task 2: list the packages\n\nanswer to list the packages"
+	if output != want {
+		t.Errorf("Unexpected output:\n%s", output)
+	}
+	if len(started) != 2 || len(done) != 2 || len(chunks) != 2 {
+		t.Errorf("Expected hooks for both sub-agents, got %d started, %d done, %d streamed", len(started), len(done), len(chunks))
+	}
+}
+
+func TestDelegate_RunsInParallel(t *testing.T) {
+	var active, maxActive atomic.Int32
+	l := &mockLLM{streamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			current := maxActive.Load()
+			if n <= current || maxActive.CompareAndSwap(current, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return providers.ProviderResponse{Text: "ok"}, nil
+	}}
+
+	config := DefaultConfig(l)
+	config.MaxParallel = 2
+	tasks := []any{}
+	for i := 0; i < 4; i++ {
+		tasks = append(tasks, map[string]any{"task": "task"})
+	}
+	if _, err := runTool(t, NewTool(config), map[string]any{"tasks": tasks}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := maxActive.Load(); got != 2 {
+		t.Errorf("Expected 2 sub-agents at a time, got %d", got)
+	}
+}
+
+func TestDelegate_RestrictsTools(t *testing.T) {
+	var seen []string
+	l := &mockLLM{streamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+		seen = toolNames(t)
+		return providers.ProviderResponse{Text: "ok"}, nil
+	}}
+
+	config := DefaultConfig(l)
+	config.Tools = append(ReadOnlyTools(), NewTool(config))
+	tool := NewTool(config)
+
+	if _, err := runTool(t, tool, map[string]any{"tasks": []any{map[string]any{"task": "look"}}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, name := range seen {
+		if name == ToolName {
+			t.Error("Expected sub-agents to never get the delegate tool")
+		}
+	}
+
+	if _, err := runTool(t, tool, map[string]any{"tasks": []any{map[string]any{"task": "look", "tools": []any{"read_file"}}}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(seen) != 1 || seen[0] != "read_file" {
+		t.Errorf("Expected only read_file, got %v", seen)
+	}
+
+	output, _ := runTool(t, tool, map[string]any{"tasks": []any{map[string]any{"task": "edit", "tools": []any{"apply_edit"}}}})
+	if !strings.Contains(output, `error: tool "apply_edit" is not available`) {
+		t.Errorf("Expected unavailable tool to be reported, got:\n%s", output)
+	}
+}
+
+func TestReadOnlyPolicy(t *testing.T) {
+	policy := ReadOnlyPolicy()
+	if decision, _ := policy.Evaluate(tools.ToolCall{FunctionName: "read_file"}); decision != approval.Allow {
+		t.Errorf("Expected read_file to be allowed, got %s", decision)
+	}
+	for _, name := range []string{"run_command", "apply_edit", "make_file"} {
+		if decision, reason := policy.Evaluate(tools.ToolCall{FunctionName: name}); decision != approval.Deny || reason == "" {
+			t.Errorf("Expected %s to be denied with a reason, got %s %q", name, decision, reason)
+		}
+	}
+}
+
+func TestDelegate_NoAnswerWithinBudget(t *testing.T) {
+	l := &mockLLM{streamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+		return providers.ProviderResponse{ToolCalls: []tools.ToolCall{
+			{ID: "call", FunctionName: "list_folder_contents", Arguments: map[string]any{"folder_path": "/nonexistent"}},
+		}}, nil
+	}}
+
+	config := DefaultConfig(l)
+	config.MaxIterations = 2
+	output, err := runTool(t, NewTool(config), map[string]any{"tasks": []any{map[string]any{"task": "loop"}}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(output, "used its 2 iterations without giving an answer") {
+		t.Errorf("Expected the exhausted budget to be reported, got:\n%s", output)
+	}
+}
+
+func TestDelegate_ForwardsEachToolCallOnce(t *testing.T) {
+	calls := 0
+	l := &mockLLM{streamFunc: func(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+		calls++
+		if calls > 1 {
+			return providers.ProviderResponse{Text: "done"}, nil
+		}
+		call := tools.ToolCall{ID: "call", FunctionName: "list_folder_contents", Arguments: map[string]any{"folder_path": "."}}
+		// Streamed twice, as a retried model call would
+		hooks.OnToolCall(call)
+		hooks.OnToolCall(call)
+		return providers.ProviderResponse{ToolCalls: []tools.ToolCall{call}}, nil
+	}}
+
+	var forwarded []string
+	config := DefaultConfig(l)
+	config.Hooks.OnToolCall = func(id string, call tools.ToolCall) { forwarded = append(forwarded, call.ID) }
+	if _, err := runTool(t, NewTool(config), map[string]any{"tasks": []any{map[string]any{"task": "look"}}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(forwarded) != 1 {
+		t.Errorf("Expected the tool call to be forwarded once, got %v", forwarded)
+	}
+}
+
+func TestFinalAnswer(t *testing.T) {
+	readFile := tools.ToolCall{ID: "c1", FunctionName: "read_file"}
+	call := memory.Response("", []tools.ToolCall{readFile})
+	cases := []struct {
+		name string
+		mem  []memory.MemoryEntry
+		want string
+	}{
+		{"answer", []memory.MemoryEntry{memory.Text(memory.User, "task"), memory.Text(memory.Model, " found it ")}, "found it"},
+		{"still calling tools", []memory.MemoryEntry{memory.Text(memory.User, "task"), call, memory.ToolResult(readFile, "...", false)}, ""},
+		{"prompt after the answer", []memory.MemoryEntry{memory.Text(memory.Model, "found it"), memory.Text(memory.User, "thanks")}, "found it"},
+		{"empty last answer", []memory.MemoryEntry{memory.Text(memory.Model, "found it"), memory.Text(memory.Model, "")}, "found it"},
+		{"empty memory", nil, ""},
+	}
+	for _, c := range cases {
+		if got := finalAnswer(c.mem); got != c.want {
+			t.Errorf("%s: expected %q, got %q", c.name, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package butler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error kinds every provider maps its SDK errors into.
+// Use errors.Is to check for them, they are never returned bare.
+var (
+	ErrAuth            = errors.New("authentication failed")
+	ErrRateLimited     = errors.New("rate limited")
+	ErrContextTooLong  = errors.New("context too long")
+	ErrContentFiltered = errors.New("content filtered")
+	ErrTransient       = errors.New("transient provider error")
+)
+
+// ProviderError is a classified provider failure.
+// Kind is one of the Err* values above, or nil when the error couldn't be classified.
+type ProviderError struct {
+	Kind       error
+	Provider   string
+	StatusCode int
+	RetryAfter time.Duration // Set for rate limits when the provider says how long to wait
+	Err        error         // The original SDK error
+}
+
+func (e *ProviderError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Provider)
+	if e.Kind != nil {
+		b.WriteString(": ")
+		b.WriteString(e.Kind.Error())
+	}
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&b, " (status %d)", e.StatusCode)
+	}
+	if e.Err != nil {
+		b.WriteString(": ")
+		b.WriteString(e.Err.Error())
+	}
+	return b.String()
+}
+
+// Unwrap exposes both the kind and the SDK error to errors.Is and errors.As
+func (e *ProviderError) Unwrap() []error {
+	var errs []error
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+// NewProviderError classifies an SDK error from the provider's error code or type, e.g.
+// "context_length_exceeded" or "RESOURCE_EXHAUSTED", then its HTTP status, then its message.
+// code may be empty when the provider sends none.
+func NewProviderError(provider string, statusCode int, code, message string, err error) *ProviderError {
+	kind, ok := errorCodes[code]
+	if !ok {
+		kind = classify(statusCode, message)
+	}
+	return &ProviderError{
+		Kind:       kind,
+		Provider:   provider,
+		StatusCode: statusCode,
+		Err:        err,
+	}
+}
+
+// WrapError classifies errors that carry no HTTP status, such as dropped connections.
+// Context errors and errors that are already classified are returned unchanged.
+func WrapError(provider string, err error) error {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return err
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+		return &ProviderError{Kind: ErrTransient, Provider: provider, Err: err}
+	}
+	return &ProviderError{Kind: classify(0, err.Error()), Provider: provider, Err: err}
+}
+
+// WithRetryAfter sets the wait time from a Retry-After header value, in seconds or as an HTTP date
+func (e *ProviderError) WithRetryAfter(header string) *ProviderError {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return e
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+		return e
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			e.RetryAfter = wait
+		}
+	}
+	return e
+}
+
+// RetryAfter returns how long the provider asked to wait before retrying, if it said so
+func RetryAfter(err error) (time.Duration, bool) {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) && providerErr.RetryAfter > 0 {
+		return providerErr.RetryAfter, true
+	}
+	return 0, false
+}
+
+// IsRetryable reports whether retrying the same request may succeed
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrTransient)
+}
+
+// errorCodes are the error codes and types providers send, they are checked before anything else
+var errorCodes = map[string]error{
+	// OpenAI and compatible servers
+	"context_length_exceeded":  ErrContextTooLong,
+	"content_filter":           ErrContentFiltered,
+	"content_policy_violation": ErrContentFiltered,
+	"rate_limit_exceeded":      ErrRateLimited,
+	"invalid_api_key":          ErrAuth,
+	// Anthropic, as OpenRouter passes it on
+	"authentication_error": ErrAuth,
+	"permission_error":     ErrAuth,
+	"rate_limit_error":     ErrRateLimited,
+	"request_too_large":    ErrContextTooLong,
+	"overloaded_error":     ErrTransient,
+	"api_error":            ErrTransient,
+	// Gemini
+	"UNAUTHENTICATED":    ErrAuth,
+	"PERMISSION_DENIED":  ErrAuth,
+	"RESOURCE_EXHAUSTED": ErrRateLimited,
+	"UNAVAILABLE":        ErrTransient,
+	"DEADLINE_EXCEEDED":  ErrTransient,
+	"INTERNAL":           ErrTransient,
+}
+
+// contextTooLongHints are the phrases providers use when the prompt doesn't fit the model.
+// They are whole phrases, a bare "exceeds the maximum" also matches a max_tokens that is too high.
+var contextTooLongHints = []string{
+	"maximum context length",                       // OpenAI, OpenRouter, Mistral
+	"context_length_exceeded",                      // Compatible servers that only put the code in the message
+	"prompt is too long",                           // Anthropic
+	"input is too long for requested model",        // Bedrock
+	"exceeds the maximum number of tokens allowed", // Gemini
+	"exceeds the context window",                   // Groq, xAI
+	"reduce the length of the messages",            // OpenAI
+}
+
+var contentFilteredHints = []string{
+	"content_filter",
+	"content management policy", // Azure OpenAI
+	"content_policy_violation",
+	"content filter",
+}
+
+func classify(statusCode int, message string) error {
+	lower := strings.ToLower(message)
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden || statusCode == http.StatusPaymentRequired:
+		return ErrAuth
+	case statusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case statusCode == http.StatusRequestEntityTooLarge || containsAny(lower, contextTooLongHints):
+		return ErrContextTooLong
+	case containsAny(lower, contentFilteredHints):
+		return ErrContentFiltered
+	case statusCode == http.StatusRequestTimeout || statusCode == http.StatusConflict || statusCode >= 500:
+		return ErrTransient
+	default:
+		return nil
+	}
+}
+
+func containsAny(s string, hints []string) bool {
+	for _, hint := range hints {
+		if strings.Contains(s, hint) {
+			return true
+		}
+	}
+	return false
+}
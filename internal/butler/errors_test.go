@@ -0,0 +1,108 @@
+package butler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewProviderError_Classification(t *testing.T) {
+	cases := []struct {
+		status  int
+		message string
+		want    error
+	}{
+		{http.StatusUnauthorized, "invalid api key", ErrAuth},
+		{http.StatusTooManyRequests, "slow down", ErrRateLimited},
+		{http.StatusBadRequest, "This model's maximum context length is 128000 tokens", ErrContextTooLong},
+		{http.StatusBadRequest, "Request blocked by content filter", ErrContentFiltered},
+		{http.StatusServiceUnavailable, "overloaded", ErrTransient},
+		{http.StatusBadGateway, "", ErrTransient},
+	}
+
+	for _, c := range cases {
+		err := NewProviderError("test", c.status, "", c.message, errors.New(c.message))
+		if !errors.Is(err, c.want) {
+			t.Errorf("status %d %q: expected %v, got %v", c.status, c.message, c.want, err.Kind)
+		}
+	}
+
+	unknown := NewProviderError("test", http.StatusBadRequest, "", "bad request", errors.New("bad request"))
+	if unknown.Kind != nil {
+		t.Errorf("Expected unclassified error, got %v", unknown.Kind)
+	}
+}
+
+func TestNewProviderError_Codes(t *testing.T) {
+	cases := []struct {
+		status  int
+		code    string
+		message string
+		want    error
+	}{
+		{http.StatusBadRequest, "context_length_exceeded", "Please reduce your prompt", ErrContextTooLong},
+		{http.StatusBadRequest, "content_filter", "The response was filtered", ErrContentFiltered},
+		{http.StatusTooManyRequests, "RESOURCE_EXHAUSTED", "Quota exceeded", ErrRateLimited},
+		{529, "overloaded_error", "Overloaded", ErrTransient},
+		// The code wins over the message
+		{http.StatusBadRequest, "rate_limit_exceeded", "prompt is too long", ErrRateLimited},
+	}
+	for _, c := range cases {
+		if err := NewProviderError("test", c.status, c.code, c.message, nil); !errors.Is(err, c.want) {
+			t.Errorf("code %q: expected %v, got %v", c.code, c.want, err.Kind)
+		}
+	}
+
+	// Unrelated bad requests that share words with the hints stay unclassified
+	for _, message := range []string{
+		"max_tokens exceeds the maximum allowed for this model",
+		"Invalid value for safety_settings",
+		"temperature exceeds the maximum of 2",
+	} {
+		if err := NewProviderError("test", http.StatusBadRequest, "invalid_request_error", message, nil); err.Kind != nil {
+			t.Errorf("%q: expected an unclassified error, got %v", message, err.Kind)
+		}
+	}
+}
+
+func TestProviderError_UnwrapsOriginal(t *testing.T) {
+	original := errors.New("sdk error")
+	err := NewProviderError("test", http.StatusTooManyRequests, "", "", original)
+
+	if !errors.Is(err, original) {
+		t.Error("Expected provider error to wrap the SDK error")
+	}
+	if !IsRetryable(err) {
+		t.Error("Expected rate limit to be retryable")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	err := NewProviderError("test", http.StatusTooManyRequests, "", "", nil).WithRetryAfter("7")
+
+	wait, ok := RetryAfter(err)
+	if !ok || wait != 7*time.Second {
+		t.Errorf("Expected 7s retry-after, got %s (%v)", wait, ok)
+	}
+
+	if _, ok := RetryAfter(errors.New("plain")); ok {
+		t.Error("Expected no retry-after for plain errors")
+	}
+}
+
+func TestWrapError(t *testing.T) {
+	if err := WrapError("test", context.Canceled); err != context.Canceled {
+		t.Errorf("Expected context errors to pass through, got %v", err)
+	}
+	if err := WrapError("test", io.ErrUnexpectedEOF); !errors.Is(err, ErrTransient) {
+		t.Errorf("Expected unexpected EOF to be transient, got %v", err)
+	}
+
+	classified := NewProviderError("test", http.StatusUnauthorized, "", "", nil)
+	if err := WrapError("other", classified); err != classified {
+		t.Errorf("Expected classified errors to pass through, got %v", err)
+	}
+}
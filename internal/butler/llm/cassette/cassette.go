@@ -0,0 +1,170 @@
+// Package cassette records the traffic of an LLM to a file and replays it, so whole agent runs
+// can be tested offline and deterministically.
+package cassette
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// Cassette is the recorded traffic of one LLM, calls are replayed in the order they were made
+type Cassette struct {
+	Provider     string        `json:"provider,omitempty"`
+	Model        string        `json:"model,omitempty"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one model call
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is what the agent sent. Tools are stored by name, their handlers can't be saved.
+type Request struct {
+	Messages []memory.MemoryEntry `json:"messages"`
+	Tools    []string             `json:"tools,omitempty"`
+}
+
+// Response is what the model streamed back, Chunks keep the order the hooks were called in
+type Response struct {
+	Chunks    []Chunk          `json:"chunks,omitempty"`
+	Text      string           `json:"text,omitempty"`
+	Thinking  string           `json:"thinking,omitempty"`
+	ToolCalls []tools.ToolCall `json:"tool_calls,omitempty"`
+	Usage     providers.Usage  `json:"usage,omitzero"`
+	Error     *Error           `json:"error,omitempty"`
+}
+
+// Chunk kinds, one per stream hook
+const (
+	ChunkText         = "text"
+	ChunkTextDone     = "text_done"
+	ChunkThinking     = "thinking"
+	ChunkThinkingDone = "thinking_done"
+	ChunkToolCall     = "tool_call"
+	ChunkReset        = "reset"
+)
+
+// Chunk is one call of a stream hook
+type Chunk struct {
+	Kind     string          `json:"kind"`
+	Text     string          `json:"text,omitempty"`
+	ToolCall *tools.ToolCall `json:"tool_call,omitempty"`
+}
+
+// Error is a failed call. Classified provider errors and cancellations keep their kind,
+// so errors.Is behaves the same on replay.
+type Error struct {
+	Message    string        `json:"message"`
+	Kind       string        `json:"kind,omitempty"`
+	Provider   string        `json:"provider,omitempty"`
+	StatusCode int           `json:"status_code,omitempty"`
+	RetryAfter time.Duration `json:"retry_after,omitempty"`
+}
+
+// errorKinds names the errors whose identity survives a round trip through a cassette
+var errorKinds = map[string]error{
+	"auth":              butler.ErrAuth,
+	"rate_limited":      butler.ErrRateLimited,
+	"context_too_long":  butler.ErrContextTooLong,
+	"content_filtered":  butler.ErrContentFiltered,
+	"transient":         butler.ErrTransient,
+	"canceled":          context.Canceled,
+	"deadline_exceeded": context.DeadlineExceeded,
+}
+
+func newError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	recorded := &Error{Message: err.Error()}
+	var providerErr *butler.ProviderError
+	if errors.As(err, &providerErr) {
+		recorded.Provider = providerErr.Provider
+		recorded.StatusCode = providerErr.StatusCode
+		recorded.RetryAfter = providerErr.RetryAfter
+		if providerErr.Err != nil {
+			recorded.Message = providerErr.Err.Error()
+		}
+	}
+	for name, kind := range errorKinds {
+		if errors.Is(err, kind) {
+			recorded.Kind = name
+			break
+		}
+	}
+	return recorded
+}
+
+// Err rebuilds the recorded error
+func (e *Error) Err() error {
+	if e == nil {
+		return nil
+	}
+	kind := errorKinds[e.Kind]
+	if e.Provider != "" {
+		return &butler.ProviderError{
+			Kind:       kind,
+			Provider:   e.Provider,
+			StatusCode: e.StatusCode,
+			RetryAfter: e.RetryAfter,
+			Err:        errors.New(e.Message),
+		}
+	}
+	if kind != nil && e.Message == kind.Error() {
+		return kind
+	}
+	if kind != nil {
+		return fmt.Errorf("%s: %w", e.Message, kind)
+	}
+	return errors.New(e.Message)
+}
+
+// Load reads a cassette file
+func Load(path string) (Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Cassette{}, err
+	}
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return Cassette{}, fmt.Errorf("reading cassette %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Save writes the cassette as indented JSON, so recordings diff well in reviews.
+// The file is replaced atomically, a crash mid-write keeps the previous recording.
+func (c Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func toolNames(agentTools []tools.Tool) []string {
+	var names []string
+	for _, t := range agentTools {
+		names = append(names, t.Name)
+	}
+	return names
+}
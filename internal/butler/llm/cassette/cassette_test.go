@@ -0,0 +1,154 @@
+package cassette
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/agent"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// scriptedLLM calls the echo tool once, then answers with what it got back
+type scriptedLLM struct {
+	calls int
+	err   error
+}
+
+func (s *scriptedLLM) Stream(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+	s.calls++
+	if s.err != nil {
+		return providers.ProviderResponse{}, s.err
+	}
+	if s.calls == 1 {
+		hooks.OnThinkingChunk("I should echo")
+		hooks.OnThinkingComplete()
+		call := tools.ToolCall{ID: "call_1", FunctionName: "echo", Arguments: map[string]any{"input": "hi"}}
+		hooks.OnToolCall(call)
+		return providers.ProviderResponse{ToolCalls: []tools.ToolCall{call}, Usage: providers.Usage{PromptTokens: 12, CompletionTokens: 3}}, nil
+	}
+	last := mem[len(mem)-1].Text()
+	hooks.OnTextChunk("It said ")
+	hooks.OnTextChunk(last)
+	hooks.OnStreamComplete()
+	return providers.ProviderResponse{Text: "It said " + last, Usage: providers.Usage{PromptTokens: 20, CompletionTokens: 4}}, nil
+}
+
+func (s *scriptedLLM) Generate(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) error {
+	_, err := s.Stream(ctx, mem, t, hooks)
+	return err
+}
+
+type echoArgs struct {
+	Input string `json:"input"`
+}
+
+func echo(args echoArgs) (string, error) {
+	return "echo: " + args.Input, nil
+}
+
+// runAgent runs a prompt and returns the final memory and the streamed hook calls
+func runAgent(t *testing.T, model llm.LLM) ([]memory.MemoryEntry, []string) {
+	t.Helper()
+	var streamed []string
+	a := agent.NewAgent(model).
+		WitTools([]tools.Tool{tools.NewButlerTool("echo", "Echoes its input", echo)}).
+		WithOnThinkingChunk(func(s string) { streamed = append(streamed, "thinking:"+s) }).
+		WithOnToolCall(func(c tools.ToolCall) { streamed = append(streamed, "tool:"+c.FunctionName) }).
+		WithOnTextChunk(func(s string) { streamed = append(streamed, "text:"+s) })
+	if _, err := a.Run(context.Background(), "say hi"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	return a.GetMemory(), streamed
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassettes", "echo.json")
+
+	recorder := NewRecorder(&scriptedLLM{}, path)
+	recordedMemory, recordedStream := runAgent(t, recorder)
+
+	replayer, err := LoadReplayer(path)
+	if err != nil {
+		t.Fatalf("LoadReplayer failed: %v", err)
+	}
+	replayedMemory, replayedStream := runAgent(t, replayer)
+
+	if !reflect.DeepEqual(replayedStream, recordedStream) {
+		t.Errorf("Expected the same hook calls on replay:\n got %v\nwant %v", replayedStream, recordedStream)
+	}
+	if len(replayedMemory) != len(recordedMemory) {
+		t.Fatalf("Expected %d memory entries on replay, got %d", len(recordedMemory), len(replayedMemory))
+	}
+	for i := range recordedMemory {
+		if replayedMemory[i].Role != recordedMemory[i].Role || replayedMemory[i].Text() != recordedMemory[i].Text() {
+			t.Errorf("Entry %d differs on replay: got %+v, want %+v", i, replayedMemory[i], recordedMemory[i])
+		}
+	}
+	if replayer.Remaining() != 0 {
+		t.Errorf("Expected the cassette to be used up, %d interactions left", replayer.Remaining())
+	}
+
+	recorded := recorder.Cassette().Interactions
+	requests := replayer.Requests()
+	if len(requests) != 2 || !reflect.DeepEqual(requests[1].Tools, []string{"echo"}) || len(requests[1].Messages) != len(recorded[1].Request.Messages) {
+		t.Errorf("Expected the replayed requests to match the recording, got %+v", requests)
+	}
+	if recorded[0].Response.Usage.PromptTokens != 12 {
+		t.Errorf("Expected usage to be recorded, got %+v", recorded[0].Response.Usage)
+	}
+}
+
+func TestReplay_Exhausted(t *testing.T) {
+	replayer := NewReplayer(Cassette{})
+	if _, err := replayer.Stream(context.Background(), nil, nil, butler.EventHooks{}); err == nil {
+		t.Fatal("Expected an error once the cassette is exhausted")
+	}
+}
+
+func TestRecordAndReplay_Errors(t *testing.T) {
+	tests := map[string]error{
+		"rate limited": &butler.ProviderError{Kind: butler.ErrRateLimited, Provider: "openrouter", StatusCode: 429, RetryAfter: 3 * time.Second, Err: errors.New("slow down")},
+		"cancelled":    context.Canceled,
+		"unclassified": errors.New("boom"),
+	}
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "error.json")
+			recorder := NewRecorder(&scriptedLLM{err: want}, path)
+			if _, err := recorder.Stream(context.Background(), nil, nil, butler.EventHooks{}); err != want {
+				t.Fatalf("Expected the recorder to return the error unchanged, got %v", err)
+			}
+
+			replayer, err := LoadReplayer(path)
+			if err != nil {
+				t.Fatalf("LoadReplayer failed: %v", err)
+			}
+			_, got := replayer.Stream(context.Background(), nil, nil, butler.EventHooks{})
+			if got == nil || got.Error() != want.Error() {
+				t.Errorf("Expected %q on replay, got %v", want, got)
+			}
+			var kind error
+			for _, k := range errorKinds {
+				if errors.Is(want, k) {
+					kind = k
+				}
+			}
+			if kind != nil && !errors.Is(got, kind) {
+				t.Errorf("Expected the replayed error to match %v", kind)
+			}
+			if wait, ok := butler.RetryAfter(want); ok {
+				if got, _ := butler.RetryAfter(got); got != wait {
+					t.Errorf("Expected Retry-After %s on replay, got %s", wait, got)
+				}
+			}
+		})
+	}
+}
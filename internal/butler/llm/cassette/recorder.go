@@ -0,0 +1,130 @@
+package cassette
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// Recorder passes calls through to the wrapped LLM and saves each of them to the cassette file.
+// The file is rewritten after every call, so a crashed or cancelled run keeps what it recorded.
+type Recorder struct {
+	inner llm.LLM
+	path  string
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+func NewRecorder(inner llm.LLM, path string) *Recorder {
+	info := llm.Describe(inner)
+	return &Recorder{
+		inner:    inner,
+		path:     path,
+		cassette: Cassette{Provider: info.Provider, Model: info.Model, Interactions: []Interaction{}},
+	}
+}
+
+func (r *Recorder) Describe() llm.Info {
+	return llm.Describe(r.inner)
+}
+
+// Cassette returns what was recorded so far
+func (r *Recorder) Cassette() Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.cassette
+	c.Interactions = slices.Clone(c.Interactions)
+	return c
+}
+
+// Stream records the call, a failure to save the cassette is returned when the call itself succeeded
+func (r *Recorder) Stream(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+	var chunks []Chunk
+	resp, err := r.inner.Stream(ctx, mem, agentTools, recordChunks(hooks, &chunks))
+	saveErr := r.record(mem, agentTools, Response{
+		Chunks:    chunks,
+		Text:      resp.Text,
+		Thinking:  resp.Thinking,
+		ToolCalls: resp.ToolCalls,
+		Usage:     resp.Usage,
+		Error:     newError(err),
+	})
+	if err != nil {
+		return resp, err
+	}
+	return resp, saveErr
+}
+
+func (r *Recorder) Generate(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) error {
+	var chunks []Chunk
+	err := r.inner.Generate(ctx, mem, agentTools, recordChunks(hooks, &chunks))
+	saveErr := r.record(mem, agentTools, Response{Chunks: chunks, Error: newError(err)})
+	return errors.Join(err, saveErr)
+}
+
+func (r *Recorder) record(mem []memory.MemoryEntry, agentTools []tools.Tool, resp Response) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request:  Request{Messages: slices.Clone(mem), Tools: toolNames(agentTools)},
+		Response: resp,
+	})
+	return r.cassette.Save(r.path)
+}
+
+// recordChunks appends every hook call to chunks before passing it on
+func recordChunks(hooks butler.EventHooks, chunks *[]Chunk) butler.EventHooks {
+	add := func(chunk Chunk) { *chunks = append(*chunks, chunk) }
+	return butler.EventHooks{
+		OnTextChunk: func(text string) {
+			add(Chunk{Kind: ChunkText, Text: text})
+			if hooks.OnTextChunk != nil {
+				hooks.OnTextChunk(text)
+			}
+		},
+		OnStreamComplete: func() {
+			add(Chunk{Kind: ChunkTextDone})
+			if hooks.OnStreamComplete != nil {
+				hooks.OnStreamComplete()
+			}
+		},
+		OnThinkingChunk: func(text string) {
+			add(Chunk{Kind: ChunkThinking, Text: text})
+			if hooks.OnThinkingChunk != nil {
+				hooks.OnThinkingChunk(text)
+			}
+		},
+		OnThinkingComplete: func() {
+			add(Chunk{Kind: ChunkThinkingDone})
+			if hooks.OnThinkingComplete != nil {
+				hooks.OnThinkingComplete()
+			}
+		},
+		OnToolCall: func(call tools.ToolCall) {
+			add(Chunk{Kind: ChunkToolCall, ToolCall: &call})
+			if hooks.OnToolCall != nil {
+				hooks.OnToolCall(call)
+			}
+		},
+		// Left unset when the caller can't handle resets, providers check it to decide whether to retry
+		OnStreamReset: recordReset(hooks.OnStreamReset, add),
+	}
+}
+
+func recordReset(onReset func(), add func(Chunk)) func() {
+	if onReset == nil {
+		return nil
+	}
+	return func() {
+		add(Chunk{Kind: ChunkReset})
+		onReset()
+	}
+}
@@ -0,0 +1,127 @@
+package cassette
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// Replayer serves the interactions of a cassette in order, whatever the requests are.
+// Requests keeps what the agent actually sent so tests can compare it to the recording.
+type Replayer struct {
+	mu       sync.Mutex
+	cassette Cassette
+	next     int
+	requests []Request
+}
+
+func NewReplayer(c Cassette) *Replayer {
+	return &Replayer{cassette: c}
+}
+
+// LoadReplayer reads a cassette file and replays it
+func LoadReplayer(path string) (*Replayer, error) {
+	c, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayer(c), nil
+}
+
+func (r *Replayer) Describe() llm.Info {
+	return llm.Info{Provider: r.cassette.Provider, Model: r.cassette.Model}
+}
+
+// Remaining is the number of interactions that weren't replayed yet
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.cassette.Interactions) - r.next
+}
+
+// Requests returns what was sent to the replayer so far
+func (r *Replayer) Requests() []Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.requests)
+}
+
+// Stream replays the chunks of the next interaction through the hooks and returns its response.
+// It fails once the cassette is exhausted or when ctx is cancelled.
+func (r *Replayer) Stream(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+	interaction, err := r.take(mem, agentTools)
+	if err != nil {
+		return providers.ProviderResponse{}, err
+	}
+	resp := interaction.Response
+	if err := replayChunks(ctx, resp.Chunks, hooks); err != nil {
+		return providers.ProviderResponse{}, err
+	}
+	return providers.ProviderResponse{
+		Text:      resp.Text,
+		Thinking:  resp.Thinking,
+		ToolCalls: resp.ToolCalls,
+		Usage:     resp.Usage,
+	}, resp.Error.Err()
+}
+
+func (r *Replayer) Generate(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) error {
+	_, err := r.Stream(ctx, mem, agentTools, hooks)
+	return err
+}
+
+func (r *Replayer) take(mem []memory.MemoryEntry, agentTools []tools.Tool) (Interaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, Request{Messages: slices.Clone(mem), Tools: toolNames(agentTools)})
+	if r.next >= len(r.cassette.Interactions) {
+		return Interaction{}, fmt.Errorf("cassette exhausted: call %d but only %d interactions were recorded", r.next+1, len(r.cassette.Interactions))
+	}
+	interaction := r.cassette.Interactions[r.next]
+	r.next++
+	return interaction, nil
+}
+
+func replayChunks(ctx context.Context, chunks []Chunk, hooks butler.EventHooks) error {
+	for _, chunk := range chunks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch chunk.Kind {
+		case ChunkText:
+			if hooks.OnTextChunk != nil {
+				hooks.OnTextChunk(chunk.Text)
+			}
+		case ChunkTextDone:
+			if hooks.OnStreamComplete != nil {
+				hooks.OnStreamComplete()
+			}
+		case ChunkThinking:
+			if hooks.OnThinkingChunk != nil {
+				hooks.OnThinkingChunk(chunk.Text)
+			}
+		case ChunkThinkingDone:
+			if hooks.OnThinkingComplete != nil {
+				hooks.OnThinkingComplete()
+			}
+		case ChunkToolCall:
+			if hooks.OnToolCall != nil && chunk.ToolCall != nil {
+				hooks.OnToolCall(*chunk.ToolCall)
+			}
+		case ChunkReset:
+			if hooks.OnStreamReset != nil {
+				hooks.OnStreamReset()
+			}
+		default:
+			return fmt.Errorf("cassette: unknown chunk kind %q", chunk.Kind)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package fake
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Check inspects the request of a turn and returns why it is wrong, nil when it is fine
+type Check func(req Request) error
+
+// LastMessage checks the role of the newest memory entry and that its message contains substr
+func LastMessage(role, substr string) Check {
+	return func(req Request) error {
+		if len(req.Messages) == 0 {
+			return fmt.Errorf("expected a last %s message containing %q, memory is empty", role, substr)
+		}
+		last := req.Messages[len(req.Messages)-1]
+		if string(last.Role) != role || !strings.Contains(last.Text(), substr) {
+			return fmt.Errorf("expected a last %s message containing %q, got %s %q", role, substr, last.Role, last.Text())
+		}
+		return nil
+	}
+}
+
+// MessageCount checks how many memory entries were sent
+func MessageCount(n int) Check {
+	return func(req Request) error {
+		if len(req.Messages) != n {
+			return fmt.Errorf("expected %d messages, got %d", n, len(req.Messages))
+		}
+		return nil
+	}
+}
+
+// HasTools checks that the model was offered at least the named tools
+func HasTools(names ...string) Check {
+	return func(req Request) error {
+		offered := toolNames(req)
+		for _, name := range names {
+			if !slices.Contains(offered, name) {
+				return fmt.Errorf("expected tool %s to be offered, got %v", name, offered)
+			}
+		}
+		return nil
+	}
+}
+
+// NoTools checks that the model was offered no tools, as in summaries and stop messages
+func NoTools() Check {
+	return func(req Request) error {
+		if len(req.Tools) > 0 {
+			return fmt.Errorf("expected no tools, got %v", toolNames(req))
+		}
+		return nil
+	}
+}
+
+// ToolResult checks that a tool result for callID was sent and that it contains substr
+func ToolResult(callID, substr string) Check {
+	return func(req Request) error {
+		for _, entry := range req.Messages {
+			if result, ok := entry.ToolResult(); ok && result.ToolCallID == callID {
+				if !strings.Contains(result.Text, substr) {
+					return fmt.Errorf("expected the result of %s to contain %q, got %q", callID, substr, result.Text)
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("expected a result for tool call %s", callID)
+	}
+}
+
+func toolNames(req Request) []string {
+	var names []string
+	for _, t := range req.Tools {
+		names = append(names, t.Name)
+	}
+	return names
+}
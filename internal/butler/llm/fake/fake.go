@@ -0,0 +1,245 @@
+// Package fake is a scripted LLM for tests. Each turn of the script answers one model call,
+// in order, and can check the memory and tools the agent sent.
+//
+//	model := fake.New(t).
+//		Turn().Thinking("let me look").CallTool("read_file", map[string]any{"path": "go.mod"}).
+//		Turn().Expect(fake.LastMessage("tool", "module")).Text("It is a Go module")
+//	agent.NewAgent(model).WitTools(tools.StdToolset).Run(ctx, "what is this project?")
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// Request is what the agent sent for one model call
+type Request struct {
+	Turn     int // Starting at 1
+	Messages []memory.MemoryEntry
+	Tools    []tools.Tool
+}
+
+// LLM plays its script back, one turn per call. Builder methods apply to the last turn
+// added with Turn, they must all be called before the LLM is used.
+type LLM struct {
+	t testing.TB
+
+	mu       sync.Mutex
+	turns    []*turn
+	next     int
+	requests []Request
+}
+
+type turn struct {
+	steps  []step
+	checks []Check
+	usage  providers.Usage
+	err    error
+}
+
+// step is one thing the model does while streaming a turn
+type step struct {
+	delay    time.Duration
+	text     string
+	thinking string
+	call     *tools.ToolCall
+	complete func(butler.EventHooks) // Set on the steps that close a text or thinking block
+}
+
+// New returns an empty script, expectation failures are reported to t
+func New(t testing.TB) *LLM {
+	return &LLM{t: t}
+}
+
+func (l *LLM) Describe() llm.Info {
+	return llm.Info{Provider: "fake", Model: "fake"}
+}
+
+// Turn starts the answer to the next model call
+func (l *LLM) Turn() *LLM {
+	l.turns = append(l.turns, &turn{})
+	return l
+}
+
+func (l *LLM) current() *turn {
+	if len(l.turns) == 0 {
+		l.Turn()
+	}
+	return l.turns[len(l.turns)-1]
+}
+
+func (l *LLM) add(s step) *LLM {
+	t := l.current()
+	t.steps = append(t.steps, s)
+	return l
+}
+
+// Text streams text as a single chunk and ends the answer
+func (l *LLM) Text(text string) *LLM {
+	return l.Chunks(text)
+}
+
+// Chunks streams the chunks one after the other and ends the answer
+func (l *LLM) Chunks(chunks ...string) *LLM {
+	for _, chunk := range chunks {
+		l.add(step{text: chunk})
+	}
+	return l.add(step{complete: func(h butler.EventHooks) {
+		if h.OnStreamComplete != nil {
+			h.OnStreamComplete()
+		}
+	}})
+}
+
+// Thinking streams a reasoning block
+func (l *LLM) Thinking(text string) *LLM {
+	l.add(step{thinking: text})
+	return l.add(step{complete: func(h butler.EventHooks) {
+		if h.OnThinkingComplete != nil {
+			h.OnThinkingComplete()
+		}
+	}})
+}
+
+// CallTool asks the agent to run a tool. Call IDs are "call_<turn>_<n>".
+func (l *LLM) CallTool(name string, args map[string]any) *LLM {
+	t := l.current()
+	calls := 0
+	for _, s := range t.steps {
+		if s.call != nil {
+			calls++
+		}
+	}
+	return l.add(step{call: &tools.ToolCall{
+		ID:           fmt.Sprintf("call_%d_%d", len(l.turns), calls+1),
+		FunctionName: name,
+		Arguments:    args,
+	}})
+}
+
+// Delay waits before the next step of the turn, a cancelled context ends the wait with its error
+func (l *LLM) Delay(d time.Duration) *LLM {
+	return l.add(step{delay: d})
+}
+
+// Usage reports the tokens and cost of the turn
+func (l *LLM) Usage(usage providers.Usage) *LLM {
+	l.current().usage = usage
+	return l
+}
+
+// Fail makes the turn return err after streaming its steps, see ProviderError for classified failures
+func (l *LLM) Fail(err error) *LLM {
+	l.current().err = err
+	return l
+}
+
+// ProviderError is a classified provider failure like the real providers return,
+// kind is one of butler.ErrAuth, butler.ErrRateLimited and the other error kinds.
+func ProviderError(kind error) *butler.ProviderError {
+	return &butler.ProviderError{Kind: kind, Provider: "fake"}
+}
+
+// Expect checks the request the turn answers
+func (l *LLM) Expect(checks ...Check) *LLM {
+	t := l.current()
+	t.checks = append(t.checks, checks...)
+	return l
+}
+
+// Requests returns what the agent sent so far
+func (l *LLM) Requests() []Request {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Request(nil), l.requests...)
+}
+
+// Remaining is the number of turns that weren't played yet
+func (l *LLM) Remaining() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.turns) - l.next
+}
+
+// Stream plays the next turn. Failed expectations are reported to t and returned as the error of the call.
+func (l *LLM) Stream(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+	t, req, err := l.take(mem, agentTools)
+	if err != nil {
+		l.t.Error(err)
+		return providers.ProviderResponse{}, err
+	}
+	for _, check := range t.checks {
+		if err := check(req); err != nil {
+			err = fmt.Errorf("fake: turn %d: %w", req.Turn, err)
+			l.t.Error(err)
+			return providers.ProviderResponse{}, err
+		}
+	}
+
+	var resp providers.ProviderResponse
+	for _, s := range t.steps {
+		if err := ctx.Err(); err != nil {
+			return resp, err
+		}
+		switch {
+		case s.delay > 0:
+			timer := time.NewTimer(s.delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return resp, ctx.Err()
+			case <-timer.C:
+			}
+		case s.text != "":
+			resp.Text += s.text
+			if hooks.OnTextChunk != nil {
+				hooks.OnTextChunk(s.text)
+			}
+		case s.thinking != "":
+			resp.Thinking += s.thinking
+			if hooks.OnThinkingChunk != nil {
+				hooks.OnThinkingChunk(s.thinking)
+			}
+		case s.call != nil:
+			resp.ToolCalls = append(resp.ToolCalls, *s.call)
+			if hooks.OnToolCall != nil {
+				hooks.OnToolCall(*s.call)
+			}
+		case s.complete != nil:
+			s.complete(hooks)
+		}
+	}
+	resp.Usage = t.usage
+	return resp, t.err
+}
+
+func (l *LLM) Generate(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) error {
+	_, err := l.Stream(ctx, mem, agentTools, hooks)
+	return err
+}
+
+func (l *LLM) take(mem []memory.MemoryEntry, agentTools []tools.Tool) (*turn, Request, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	req := Request{
+		Turn:     len(l.requests) + 1,
+		Messages: append([]memory.MemoryEntry(nil), mem...),
+		Tools:    append([]tools.Tool(nil), agentTools...),
+	}
+	l.requests = append(l.requests, req)
+	if l.next >= len(l.turns) {
+		return nil, req, fmt.Errorf("fake: model called %d times but only %d turns are scripted", req.Turn, len(l.turns))
+	}
+	t := l.turns[l.next]
+	l.next++
+	return t, req, nil
+}
@@ -0,0 +1,142 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/agent"
+	"github.com/mightymoud/arlocode/internal/butler/approval"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// recordingT collects the errors a script reports instead of failing the test
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingT) Error(args ...any) {
+	r.errors = append(r.errors, fmt.Sprint(args...))
+}
+
+type echoArgs struct {
+	Input string `json:"input"`
+}
+
+func echoTool() tools.Tool {
+	return tools.NewButlerTool("echo", "Echoes its input", func(args echoArgs) (string, error) {
+		return "echo: " + args.Input, nil
+	})
+}
+
+func TestLLM_DrivesAgentLoop(t *testing.T) {
+	model := New(t).
+		Turn().Expect(LastMessage("user", "say hi"), HasTools("echo")).
+		Thinking("I should echo").CallTool("echo", map[string]any{"input": "hi"}).Usage(providers.Usage{PromptTokens: 10}).
+		Turn().Expect(ToolResult("call_1_1", "echo: hi"), MessageCount(3)).
+		Chunks("It said ", "hi")
+
+	var streamed []string
+	a := agent.NewAgent(model).
+		WitTools([]tools.Tool{echoTool()}).
+		WithOnThinkingChunk(func(s string) { streamed = append(streamed, "thinking:"+s) }).
+		WithOnToolCall(func(c tools.ToolCall) { streamed = append(streamed, "tool:"+c.ID) }).
+		WithOnTextChunk(func(s string) { streamed = append(streamed, "text:"+s) })
+
+	reason, err := a.Run(context.Background(), "say hi")
+	if err != nil || reason != agent.StopCompleted {
+		t.Fatalf("Expected the run to complete, got %s, %v", reason, err)
+	}
+
+	want := "thinking:I should echo,tool:call_1_1,text:It said ,text:hi"
+	if got := strings.Join(streamed, ","); got != want {
+		t.Errorf("Unexpected stream:\n got %s\nwant %s", got, want)
+	}
+	mem := a.GetMemory()
+	if last := mem[len(mem)-1]; last.Text() != "It said hi" {
+		t.Errorf("Expected the chunks to make up the answer, got %q", last.Text())
+	}
+	if a.Usage().PromptTokens != 10 {
+		t.Errorf("Expected the scripted usage to be counted, got %+v", a.Usage())
+	}
+	if model.Remaining() != 0 || len(model.Requests()) != 2 {
+		t.Errorf("Expected both turns to be played, %d left after %d requests", model.Remaining(), len(model.Requests()))
+	}
+}
+
+func TestLLM_ApprovalPolicy(t *testing.T) {
+	model := New(t).
+		Turn().CallTool("echo", map[string]any{"input": "hi"}).
+		Turn().Expect(ToolResult("call_1_1", "not in tests")).Text("ok")
+
+	a := agent.NewAgent(model).
+		WitTools([]tools.Tool{echoTool()}).
+		WithApprovalPolicy(approval.NewRulePolicy(approval.Allow,
+			approval.Rule{Tool: "echo", Decision: approval.Deny, Reason: "not in tests"},
+		))
+	if _, err := a.Run(context.Background(), "say hi"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+}
+
+func TestLLM_FailedExpectation(t *testing.T) {
+	rt := &recordingT{TB: t}
+	model := New(rt).Turn().Expect(NoTools()).Text("never sent")
+
+	_, err := agent.NewAgent(model).WitTools([]tools.Tool{echoTool()}).Run(context.Background(), "hi")
+	if err == nil || !strings.Contains(err.Error(), "expected no tools") {
+		t.Errorf("Expected the failed check as the run error, got %v", err)
+	}
+	if len(rt.errors) != 1 {
+		t.Errorf("Expected the failed check to be reported once, got %v", rt.errors)
+	}
+}
+
+func TestLLM_Exhausted(t *testing.T) {
+	rt := &recordingT{TB: t}
+	model := New(rt).Turn().Text("only one")
+
+	model.Stream(context.Background(), nil, nil, butler.EventHooks{})
+	if _, err := model.Stream(context.Background(), nil, nil, butler.EventHooks{}); err == nil {
+		t.Error("Expected an error once the script is played")
+	}
+	if len(rt.errors) != 1 {
+		t.Errorf("Expected the extra call to be reported, got %v", rt.errors)
+	}
+}
+
+func TestLLM_Fail(t *testing.T) {
+	model := New(t).Turn().Text("partial").Fail(ProviderError(butler.ErrRateLimited))
+
+	resp, err := model.Stream(context.Background(), nil, nil, butler.EventHooks{})
+	if !errors.Is(err, butler.ErrRateLimited) || !butler.IsRetryable(err) {
+		t.Errorf("Expected a retryable rate limit error, got %v", err)
+	}
+	if resp.Text != "partial" {
+		t.Errorf("Expected the output streamed before the failure, got %q", resp.Text)
+	}
+}
+
+func TestLLM_DelayHonoursCancellation(t *testing.T) {
+	model := New(t).Turn().Chunks("a").Delay(time.Hour).Chunks("b")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var chunks []string
+	hooks := butler.EventHooks{OnTextChunk: func(s string) {
+		chunks = append(chunks, s)
+		cancel()
+	}}
+	resp, err := model.Stream(ctx, nil, nil, hooks)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the delay to end with the cancellation, got %v", err)
+	}
+	if resp.Text != "a" || len(chunks) != 1 {
+		t.Errorf("Expected only the chunk before the delay, got %q and %v", resp.Text, chunks)
+	}
+}
@@ -0,0 +1,42 @@
+package gemini_llm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"google.golang.org/genai"
+)
+
+const providerName = "gemini"
+
+// mapError converts genai errors into the butler error taxonomy
+func mapError(err error) error {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		return butler.NewProviderError(providerName, apiErr.Code, apiErr.Status, apiErr.Message, err)
+	}
+	var apiErrPtr *genai.APIError
+	if errors.As(err, &apiErrPtr) {
+		return butler.NewProviderError(providerName, apiErrPtr.Code, apiErrPtr.Status, apiErrPtr.Message, err)
+	}
+	return butler.WrapError(providerName, err)
+}
+
+// blockedFinishReasons are the finish reasons Gemini uses when it refuses to answer
+var blockedFinishReasons = map[genai.FinishReason]bool{
+	genai.FinishReasonSafety:            true,
+	genai.FinishReasonRecitation:        true,
+	genai.FinishReasonBlocklist:         true,
+	genai.FinishReasonProhibitedContent: true,
+	genai.FinishReasonSPII:              true,
+}
+
+// contentFilteredError describes why Gemini blocked the prompt or the response
+func contentFilteredError(reason string) error {
+	return &butler.ProviderError{
+		Kind:     butler.ErrContentFiltered,
+		Provider: providerName,
+		Err:      fmt.Errorf("blocked: %s", reason),
+	}
+}
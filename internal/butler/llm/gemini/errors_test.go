@@ -0,0 +1,37 @@
+package gemini_llm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"google.golang.org/genai"
+)
+
+func TestMapError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"quota", genai.APIError{Code: 429, Status: "RESOURCE_EXHAUSTED", Message: "Quota exceeded"}, butler.ErrRateLimited},
+		{"bad key", genai.APIError{Code: 403, Status: "PERMISSION_DENIED", Message: "API key not valid"}, butler.ErrAuth},
+		{"too long", genai.APIError{Code: 400, Status: "INVALID_ARGUMENT", Message: "The input token count exceeds the maximum number of tokens allowed"}, butler.ErrContextTooLong},
+		{"overloaded", genai.APIError{Code: 503, Status: "UNAVAILABLE", Message: "The model is overloaded"}, butler.ErrTransient},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := mapError(c.err); !errors.Is(err, c.want) {
+				t.Errorf("Expected %v, got %v", c.want, err)
+			}
+		})
+	}
+}
+
+func TestContentFilteredError(t *testing.T) {
+	err := contentFilteredError(string(genai.FinishReasonSafety))
+	if !errors.Is(err, butler.ErrContentFiltered) {
+		t.Errorf("Expected content filtered error, got %v", err)
+	}
+}
@@ -3,10 +3,11 @@ package gemini_llm
 import (
 	"context"
 	"fmt"
-	"log"
+	"slices"
 	"strings"
 
 	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
 	"github.com/mightymoud/arlocode/internal/butler/memory"
 	"github.com/mightymoud/arlocode/internal/butler/providers"
 	"github.com/mightymoud/arlocode/internal/butler/tools"
@@ -16,30 +17,58 @@ import (
 type GeminiLLM struct {
 	ModelID string
 	Client  *genai.Client
+	Config  providers.GenerationConfig
+}
+
+func (l GeminiLLM) Describe() llm.Info {
+	return llm.Info{Provider: "gcp.gemini", Model: l.ModelID, StructuredOutput: true, SchemaNeedsNoTools: true}
 }
 
 func (l GeminiLLM) Stream(ctx context.Context, memory []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
 	geminiTools := makeGeminiTools(agentTools)
 
-	config := &genai.GenerateContentConfig{
-		Tools: geminiTools,
-		ThinkingConfig: &genai.ThinkingConfig{
-			IncludeThoughts: true,
-		},
-	}
+	config := l.generateConfig()
+	config.Tools = geminiTools
+	applyOutputSchema(ctx, config)
 
-	history := convertMemoryToGeminiHistory(memory)
+	systemInstruction, conversation := splitSystemInstruction(memory)
+	config.SystemInstruction = systemInstruction
+	history := convertMemoryToGeminiHistory(conversation)
 	resp := l.Client.Models.GenerateContentStream(ctx, l.ModelID, history, config)
 
 	var currentResponseText []string
+	var thinking strings.Builder
 	var functionCalls []tools.ToolCall
+	var usage providers.Usage
 
 	for chunk, err := range resp {
+		// Failed or blocked streams hand back the partial text so it can be kept in memory
 		if err != nil {
-			log.Fatal(err)
+			if ctx.Err() != nil {
+				return providers.ProviderResponse{Text: strings.Join(currentResponseText, "")}, ctx.Err()
+			}
+			return providers.ProviderResponse{Text: strings.Join(currentResponseText, "")}, mapError(err)
+		}
+		// Every chunk reports the usage so far, the last one has the totals
+		if chunk.UsageMetadata != nil {
+			usage = convertUsage(l.ModelID, chunk.UsageMetadata)
+		}
+		if chunk.PromptFeedback != nil && chunk.PromptFeedback.BlockReason != "" {
+			return providers.ProviderResponse{Text: strings.Join(currentResponseText, "")}, contentFilteredError(string(chunk.PromptFeedback.BlockReason))
+		}
+		if len(chunk.Candidates) == 0 {
+			continue
+		}
+
+		candidate := chunk.Candidates[0]
+		if blockedFinishReasons[candidate.FinishReason] {
+			return providers.ProviderResponse{Text: strings.Join(currentResponseText, "")}, contentFilteredError(string(candidate.FinishReason))
+		}
+		if candidate.Content == nil {
+			continue
 		}
 
-		for _, part := range chunk.Candidates[0].Content.Parts {
+		for _, part := range candidate.Content.Parts {
 
 			if part.FunctionCall != nil {
 				functionCalls = append(functionCalls, tools.ToolCall{
@@ -57,6 +86,7 @@ func (l GeminiLLM) Stream(ctx context.Context, memory []memory.MemoryEntry, agen
 					})
 				}
 			} else if part.Thought {
+				thinking.WriteString(part.Text)
 				if hooks.OnThinkingChunk != nil {
 					hooks.OnThinkingChunk(part.Text)
 				}
@@ -75,28 +105,58 @@ func (l GeminiLLM) Stream(ctx context.Context, memory []memory.MemoryEntry, agen
 	}
 	return providers.ProviderResponse{
 		Text:      textResponse.String(),
+		Thinking:  thinking.String(),
 		ToolCalls: functionCalls,
+		Usage:     usage,
 	}, nil
 }
 
 func (l GeminiLLM) Generate(ctx context.Context, memory []memory.MemoryEntry, tools []tools.Tool, hooks butler.EventHooks) error {
-	history := []*genai.Content{}
-	for _, entry := range memory {
-		genAIEntry := genai.Content{
-			Role: entry.Role,
-			Parts: []*genai.Part{
-				{Text: entry.Message},
-			},
-		}
-		history = append(history, &genAIEntry)
+	config := l.generateConfig()
+	applyOutputSchema(ctx, config)
+	systemInstruction, conversation := splitSystemInstruction(memory)
+	config.SystemInstruction = systemInstruction
+	history := convertMemoryToGeminiHistory(conversation)
+	resp, err := l.Client.Models.GenerateContent(ctx, l.ModelID, history, config)
+	if err != nil {
+		return mapError(err)
 	}
+
+	fmt.Print(resp.Text())
+	return nil
+}
+
+// generateConfig applies the generation settings of the model to a request config
+func (l GeminiLLM) generateConfig() *genai.GenerateContentConfig {
 	config := &genai.GenerateContentConfig{
 		ThinkingConfig: &genai.ThinkingConfig{
 			IncludeThoughts: true,
 		},
+		MaxOutputTokens: int32(l.Config.MaxTokens),
 	}
-	resp, err := l.Client.Models.GenerateContent(ctx, l.ModelID, history, config)
+	if l.Config.Temperature != nil {
+		config.Temperature = genai.Ptr(float32(*l.Config.Temperature))
+	}
+	if l.Config.TopP != nil {
+		config.TopP = genai.Ptr(float32(*l.Config.TopP))
+	}
+	if l.Config.ReasoningTokens > 0 {
+		config.ThinkingConfig.ThinkingBudget = genai.Ptr(int32(l.Config.ReasoningTokens))
+	}
+	return config
+}
 
-	fmt.Print(resp.Text())
-	return err
+// applyOutputSchema asks for JSON matching the schema set with llm.WithOutputSchema.
+// Gemini rejects a response schema next to function declarations, calls offering tools
+// go without it, which Describe reports through llm.Info.SchemaNeedsNoTools.
+func applyOutputSchema(ctx context.Context, config *genai.GenerateContentConfig) {
+	schema, ok := llm.OutputSchemaFrom(ctx)
+	if !ok {
+		return
+	}
+	if slices.ContainsFunc(config.Tools, func(tool *genai.Tool) bool { return len(tool.FunctionDeclarations) > 0 }) {
+		return
+	}
+	config.ResponseMIMEType = "application/json"
+	config.ResponseSchema = generateGenAISchema(schema.Type)
 }
@@ -1,10 +1,13 @@
 package gemini_llm
 
 import (
+	"fmt"
 	"reflect"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
 	"github.com/mightymoud/arlocode/internal/butler/tools"
 	"google.golang.org/genai"
 )
@@ -133,42 +136,106 @@ func generateGenAISchema(t reflect.Type) *genai.Schema {
 	}
 }
 
-func convertMemoryToGeminiHistory(memory []memory.MemoryEntry) []*genai.Content {
+// splitSystemInstruction takes the system entries out of the memory.
+// Gemini has no system role in the history, they go in the request's SystemInstruction instead.
+func splitSystemInstruction(mem []memory.MemoryEntry) (*genai.Content, []memory.MemoryEntry) {
+	var parts []*genai.Part
+	var rest []memory.MemoryEntry
+	for _, entry := range mem {
+		if entry.Role == memory.System {
+			parts = append(parts, &genai.Part{Text: entry.Text()})
+			continue
+		}
+		rest = append(rest, entry)
+	}
+	if len(parts) == 0 {
+		return nil, rest
+	}
+	return &genai.Content{Parts: parts}, rest
+}
+
+// inlineMIMETypes are the attachments Gemini reads as inline data
+var inlineMIMETypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/webp":      true,
+	"image/heic":      true,
+	"image/heif":      true,
+	"application/pdf": true,
+}
+
+// unsupportedAttachment is the text an attachment Gemini can't read inline is sent as
+func unsupportedAttachment(part memory.Part) string {
+	if part.Type == memory.FilePart && utf8.Valid(part.Data) {
+		return part.FileText()
+	}
+	name := part.Path
+	if name == "" {
+		name = "an attachment"
+	}
+	return fmt.Sprintf("(%s of type %s can't be sent to this model)", name, part.MIMEType)
+}
+
+func convertMemoryToGeminiHistory(mem []memory.MemoryEntry) []*genai.Content {
 	history := []*genai.Content{}
-	for _, entry := range memory {
-		var genAIEntry genai.Content
-		if entry.Role == "tool" {
-			genAIEntry = genai.Content{
-				Role: "tool",
-				Parts: []*genai.Part{{
-					FunctionResponse: &genai.FunctionResponse{
-						Name:     entry.ToolName,
-						Response: map[string]any{"content": entry.Message},
-						ID:       entry.ToolCallID,
-					},
-				}},
-			}
-		} else {
-			parts := []*genai.Part{}
-			if entry.Message != "" {
-				parts = append(parts, &genai.Part{Text: entry.Message})
-			}
-			for _, tc := range entry.ToolCalls {
+	for _, entry := range mem {
+		parts := []*genai.Part{}
+		for _, part := range entry.Parts {
+			part = part.Load()
+			switch {
+			case part.Type == memory.TextPart && part.Text != "":
+				parts = append(parts, &genai.Part{Text: part.Text})
+			case part.Type == memory.ToolCallPart && part.ToolCall != nil:
 				parts = append(parts, &genai.Part{
-					ThoughtSignature: tc.ThoughtSignature,
+					ThoughtSignature: part.ToolCall.ThoughtSignature,
 					FunctionCall: &genai.FunctionCall{
-						Name: tc.FunctionName,
-						Args: tc.Arguments,
-						ID:   tc.ID,
+						Name: part.ToolCall.FunctionName,
+						Args: part.ToolCall.Arguments,
+						ID:   part.ToolCall.ID,
 					},
 				})
-			}
-			genAIEntry = genai.Content{
-				Role:  entry.Role,
-				Parts: parts,
+			case part.Type == memory.ToolResultPart:
+				parts = append(parts, &genai.Part{
+					FunctionResponse: &genai.FunctionResponse{
+						Name:     part.ToolName,
+						Response: map[string]any{"content": part.Text},
+						ID:       part.ToolCallID,
+					},
+				})
+			case part.IsTextFile():
+				parts = append(parts, &genai.Part{Text: part.FileText()})
+			case (part.Type == memory.ImagePart || part.Type == memory.FilePart) && inlineMIMETypes[part.MIMEType]:
+				// Gemini reads images and PDFs inline, no upload needed below its 20MB request limit
+				parts = append(parts, &genai.Part{InlineData: &genai.Blob{MIMEType: part.MIMEType, Data: part.Data}})
+			case part.Type == memory.ImagePart || part.Type == memory.FilePart:
+				// Other types fail the whole request, source files with an odd MIME type are still text
+				parts = append(parts, &genai.Part{Text: unsupportedAttachment(part)})
 			}
 		}
-		history = append(history, &genAIEntry)
+		if len(parts) == 0 {
+			// Entries holding only thinking, Gemini rejects contents without parts
+			continue
+		}
+		history = append(history, &genai.Content{
+			Role:  string(entry.Role),
+			Parts: parts,
+		})
 	}
 	return history
 }
+
+// convertUsage maps Gemini's usage metadata and prices it from the providers table.
+// Gemini counts thoughts apart from the candidates, both are billed as output.
+func convertUsage(modelID string, u *genai.GenerateContentResponseUsageMetadata) providers.Usage {
+	if u == nil {
+		return providers.Usage{}
+	}
+	usage := providers.Usage{
+		PromptTokens:     int(u.PromptTokenCount + u.ToolUsePromptTokenCount),
+		CompletionTokens: int(u.CandidatesTokenCount + u.ThoughtsTokenCount),
+		ReasoningTokens:  int(u.ThoughtsTokenCount),
+		CachedTokens:     int(u.CachedContentTokenCount),
+	}
+	usage.Cost = providers.EstimateCost(modelID, usage)
+	return usage
+}
@@ -1,9 +1,12 @@
 package gemini_llm
 
 import (
+	"context"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/mightymoud/arlocode/internal/butler/llm"
 	"github.com/mightymoud/arlocode/internal/butler/memory"
 	"github.com/mightymoud/arlocode/internal/butler/tools"
 	"google.golang.org/genai"
@@ -88,11 +91,11 @@ func TestMakeGeminiTools(t *testing.T) {
 
 func TestConvertMemoryToGeminiHistory(t *testing.T) {
 	mem := []memory.MemoryEntry{
-		{Role: "user", Message: "hello"},
-		{Role: "model", Message: "hi", ToolCalls: []tools.ToolCall{
+		memory.Text(memory.User, "hello"),
+		memory.Response("hi", []tools.ToolCall{
 			{ID: "1", FunctionName: "func", Arguments: map[string]any{"a": 1}},
-		}},
-		{Role: "tool", Message: "result", ToolName: "func", ToolCallID: "1"},
+		}),
+		memory.ToolResult(tools.ToolCall{ID: "1", FunctionName: "func"}, "result", false),
 	}
 
 	history := convertMemoryToGeminiHistory(mem)
@@ -131,6 +134,61 @@ func TestConvertMemoryToGeminiHistory(t *testing.T) {
 	}
 }
 
+func TestConvertMemoryToGeminiHistory_Attachments(t *testing.T) {
+	prompt := memory.Text(memory.User, "what's wrong here?")
+	prompt.Parts = append(prompt.Parts, memory.Image("image/png", []byte("png")), memory.File("main.go", "text/plain", []byte("package main")))
+
+	history := convertMemoryToGeminiHistory([]memory.MemoryEntry{prompt})
+	parts := history[0].Parts
+	if len(parts) != 3 || parts[0].Text != "what's wrong here?" {
+		t.Fatalf("expected the prompt and its attachments, got %+v", parts)
+	}
+	if parts[1].InlineData == nil || parts[1].InlineData.MIMEType != "image/png" || string(parts[1].InlineData.Data) != "png" {
+		t.Errorf("expected the image inline, got %+v", parts[1])
+	}
+	if !strings.Contains(parts[2].Text, "package main") {
+		t.Errorf("expected the source file as text, got %+v", parts[2])
+	}
+}
+
+func TestConvertMemoryToGeminiHistory_UnsupportedAttachments(t *testing.T) {
+	prompt := memory.Text(memory.User, "look at these")
+	prompt.Parts = append(prompt.Parts,
+		memory.File("app.ts", "video/mp2t", []byte("export const a = 1")),
+		memory.File("data.bin", "application/octet-stream", []byte{0xff, 0xfe, 0x00}),
+		memory.Image("image/gif", []byte("GIF89a")),
+	)
+
+	parts := convertMemoryToGeminiHistory([]memory.MemoryEntry{prompt})[0].Parts
+	if len(parts) != 4 {
+		t.Fatalf("expected the prompt and its attachments, got %+v", parts)
+	}
+	for _, part := range parts {
+		if part.InlineData != nil {
+			t.Errorf("expected no inline data for types Gemini doesn't read, got %s", part.InlineData.MIMEType)
+		}
+	}
+	if !strings.Contains(parts[1].Text, "export const a = 1") {
+		t.Errorf("expected the TypeScript file as text, got %+v", parts[1])
+	}
+	if !strings.Contains(parts[2].Text, "data.bin") || !strings.Contains(parts[3].Text, "image/gif") {
+		t.Errorf("expected notes for the binary file and the GIF, got %+v %+v", parts[2], parts[3])
+	}
+}
+
+func TestConvertMemoryToGeminiHistory_SkipsEmptyEntries(t *testing.T) {
+	thinking := memory.MemoryEntry{Role: memory.Model, Parts: []memory.Part{{Type: memory.ThinkingPart, Text: "hmm"}}}
+	history := convertMemoryToGeminiHistory([]memory.MemoryEntry{memory.Text(memory.User, "hi"), thinking, memory.Text(memory.User, "well?")})
+	if len(history) != 2 {
+		t.Fatalf("expected the thinking-only entry to be left out, got %d contents", len(history))
+	}
+	for _, content := range history {
+		if len(content.Parts) == 0 {
+			t.Error("expected no content without parts")
+		}
+	}
+}
+
 func TestGenerateGenAISchema_Map(t *testing.T) {
 	schema := generateGenAISchema(reflect.TypeOf(map[string]int{}))
 	if schema.Type != genai.TypeObject {
@@ -192,3 +250,66 @@ func TestGenerateGenAISchema_MoreTypes(t *testing.T) {
 		})
 	}
 }
+
+func TestSplitSystemInstruction(t *testing.T) {
+	mem := []memory.MemoryEntry{
+		memory.Text(memory.System, "You are a coding agent"),
+		memory.Text(memory.User, "Hello"),
+		memory.Text(memory.Model, "Hi"),
+	}
+
+	system, rest := splitSystemInstruction(mem)
+	if system == nil || len(system.Parts) != 1 || system.Parts[0].Text != "You are a coding agent" {
+		t.Fatalf("Expected the system prompt as system instruction, got %+v", system)
+	}
+	if len(rest) != 2 || rest[0].Role != "user" {
+		t.Errorf("Expected the system entry to be removed from the history, got %+v", rest)
+	}
+
+	if system, _ := splitSystemInstruction(mem[1:]); system != nil {
+		t.Error("Expected no system instruction without system entries")
+	}
+}
+
+func TestConvertUsage(t *testing.T) {
+	usage := convertUsage("gemini-2.5-flash", &genai.GenerateContentResponseUsageMetadata{
+		PromptTokenCount:     1_000_000,
+		CandidatesTokenCount: 100_000,
+		ThoughtsTokenCount:   100_000,
+	})
+	if usage.PromptTokens != 1_000_000 || usage.CompletionTokens != 200_000 || usage.ReasoningTokens != 100_000 {
+		t.Errorf("Expected thoughts to count as output, got %+v", usage)
+	}
+	// 1M input at 0.30 and 200k output at 2.50 per million
+	if usage.Cost < 0.7999 || usage.Cost > 0.8001 {
+		t.Errorf("Expected $0.80, got $%f", usage.Cost)
+	}
+	if !convertUsage("gemini-2.5-flash", nil).IsZero() {
+		t.Error("Expected no usage without metadata")
+	}
+}
+
+func TestApplyOutputSchema(t *testing.T) {
+	type answer struct {
+		Files []string `json:"files"`
+	}
+	ctx := llm.WithOutputSchema(context.Background(), llm.OutputSchema{Name: "answer", Type: reflect.TypeOf(answer{})})
+
+	config := &genai.GenerateContentConfig{}
+	applyOutputSchema(ctx, config)
+	if config.ResponseMIMEType != "application/json" || config.ResponseSchema == nil {
+		t.Errorf("expected a JSON response schema, got %q, %v", config.ResponseMIMEType, config.ResponseSchema)
+	}
+
+	handler := func(args struct{ Name string }) (string, error) { return "", nil }
+	config = &genai.GenerateContentConfig{Tools: makeGeminiTools([]tools.Tool{tools.NewButlerTool("test_tool", "description", handler)})}
+	applyOutputSchema(ctx, config)
+	if config.ResponseSchema != nil || config.ResponseMIMEType != "" {
+		t.Errorf("expected no response schema next to tools, got %q, %v", config.ResponseMIMEType, config.ResponseSchema)
+	}
+	// The schema is dropped, so callers must put it in the prompt
+	info := GeminiLLM{ModelID: "gemini-2.5-flash"}.Describe()
+	if info.HoldsToSchema(true) || !info.HoldsToSchema(false) {
+		t.Errorf("expected the schema to hold only without tools, got %+v", info)
+	}
+}
@@ -13,3 +13,32 @@ type LLM interface {
 	Stream(ctx context.Context, memory []memory.MemoryEntry, tools []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error)
 	Generate(ctx context.Context, memory []memory.MemoryEntry, tools []tools.Tool, hooks butler.EventHooks) error
 }
+
+// Info names the model behind an LLM for telemetry and logs
+type Info struct {
+	Provider string // e.g. "openai", "gcp.gemini" or "openrouter", as in the OpenTelemetry GenAI conventions
+	Model    string
+
+	StructuredOutput   bool // The provider can hold the model to an OutputSchema
+	SchemaNeedsNoTools bool // Only calls offering no tools are held to it, e.g. on Gemini
+}
+
+// HoldsToSchema reports whether a call offering tools, or none, holds the model to the
+// OutputSchema of its context. When it doesn't, the caller asks for the format in the prompt.
+func (i Info) HoldsToSchema(withTools bool) bool {
+	return i.StructuredOutput && !(withTools && i.SchemaNeedsNoTools)
+}
+
+// Describer is implemented by LLMs that know which model they call.
+// Wrappers like retry.RetryLLM describe the LLM they wrap.
+type Describer interface {
+	Describe() Info
+}
+
+// Describe returns the Info of l, empty when l doesn't implement Describer
+func Describe(l LLM) Info {
+	if d, ok := l.(Describer); ok {
+		return d.Describe()
+	}
+	return Info{}
+}
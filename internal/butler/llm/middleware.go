@@ -0,0 +1,48 @@
+package llm
+
+import (
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// Middleware wraps an LLM to add behaviour to every call, like logging or redaction,
+// without touching the providers. See the middleware package for the built-in ones.
+// Wrappers should implement Describer by describing the LLM they wrap.
+type Middleware func(LLM) LLM
+
+// Chain wraps l in the middlewares, the first one is the outermost:
+// it sees the call first and the response last. A nil l stays nil.
+func Chain(l LLM, middlewares ...Middleware) LLM {
+	if l == nil {
+		return nil
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		l = middlewares[i](l)
+	}
+	return l
+}
+
+// TrackEmission wraps the streaming hooks to set *emitted once the frontend saw any output.
+// Wrappers that retry or fall back use it to tell whether a failed call can be redone unnoticed.
+func TrackEmission(hooks butler.EventHooks, emitted *bool) butler.EventHooks {
+	wrapped := hooks
+	if hooks.OnTextChunk != nil {
+		wrapped.OnTextChunk = func(s string) {
+			*emitted = true
+			hooks.OnTextChunk(s)
+		}
+	}
+	if hooks.OnThinkingChunk != nil {
+		wrapped.OnThinkingChunk = func(s string) {
+			*emitted = true
+			hooks.OnThinkingChunk(s)
+		}
+	}
+	if hooks.OnToolCall != nil {
+		wrapped.OnToolCall = func(call tools.ToolCall) {
+			*emitted = true
+			hooks.OnToolCall(call)
+		}
+	}
+	return wrapped
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// Entry is one line of the log, one model call with what was sent and what came back
+type Entry struct {
+	Time       time.Time            `json:"time"`
+	Provider   string               `json:"provider,omitempty"`
+	Model      string               `json:"model,omitempty"`
+	Call       string               `json:"call"` // "stream" or "generate"
+	Messages   []memory.MemoryEntry `json:"messages"`
+	Tools      []string             `json:"tools,omitempty"`
+	Text       string               `json:"text,omitempty"`
+	ToolCalls  []tools.ToolCall     `json:"tool_calls,omitempty"`
+	Usage      providers.Usage      `json:"usage,omitzero"`
+	Error      string               `json:"error,omitempty"`
+	DurationMS int64                `json:"duration_ms"`
+}
+
+// Log writes every call to w as a JSON line once it returns.
+// Calls of all the models it wraps, sub-agents included, share w safely.
+// A failed write never fails the call, the log is only an aid.
+func Log(w io.Writer) llm.Middleware {
+	l := &logWriter{encoder: json.NewEncoder(w)}
+	return func(inner llm.LLM) llm.LLM {
+		return &logLLM{wrapper: wrapper{inner}, log: l}
+	}
+}
+
+// LogFile appends the log to the file at path, creating it and its directory when needed.
+// close must be called when the models are no longer used.
+func LogFile(path string) (mw llm.Middleware, close func() error, err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return Log(f), f.Close, nil
+}
+
+type logWriter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func (l *logWriter) write(entry Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_ = l.encoder.Encode(entry)
+}
+
+type logLLM struct {
+	wrapper
+	log *logWriter
+}
+
+func (l *logLLM) Stream(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+	entry := l.entry("stream", mem, agentTools)
+	start := time.Now()
+	resp, err := l.inner.Stream(ctx, mem, agentTools, hooks)
+	entry.Text = resp.Text
+	entry.ToolCalls = resp.ToolCalls
+	entry.Usage = resp.Usage
+	l.finish(entry, start, err)
+	return resp, err
+}
+
+// Generate only hands its output to the hooks, so the log collects the text chunks
+func (l *logLLM) Generate(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) error {
+	entry := l.entry("generate", mem, agentTools)
+	var text strings.Builder
+	onTextChunk := hooks.OnTextChunk
+	hooks.OnTextChunk = func(chunk string) {
+		text.WriteString(chunk)
+		if onTextChunk != nil {
+			onTextChunk(chunk)
+		}
+	}
+	start := time.Now()
+	err := l.inner.Generate(ctx, mem, agentTools, hooks)
+	entry.Text = text.String()
+	l.finish(entry, start, err)
+	return err
+}
+
+func (l *logLLM) entry(call string, mem []memory.MemoryEntry, agentTools []tools.Tool) Entry {
+	info := l.Describe()
+	entry := Entry{Time: time.Now(), Provider: info.Provider, Model: info.Model, Call: call, Messages: mem}
+	for _, t := range agentTools {
+		entry.Tools = append(entry.Tools, t.Name)
+	}
+	return entry
+}
+
+func (l *logLLM) finish(entry Entry, start time.Time, err error) {
+	entry.DurationMS = time.Since(start).Milliseconds()
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	l.log.write(entry)
+}
@@ -0,0 +1,19 @@
+// Package middleware has the built-in llm.Middleware: request logging, latency timing and redaction.
+// They work with every provider and combine with llm.Chain:
+//
+//	model := llm.Chain(openrouterModel,
+//		middleware.RedactPatterns(middleware.Secrets...), // outermost, so the log never sees the secrets
+//		middleware.Log(file),
+//	)
+package middleware
+
+import "github.com/mightymoud/arlocode/internal/butler/llm"
+
+// wrapper is embedded by the middlewares so they describe the model they wrap
+type wrapper struct {
+	inner llm.LLM
+}
+
+func (w wrapper) Describe() llm.Info {
+	return llm.Describe(w.inner)
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
+	"github.com/mightymoud/arlocode/internal/butler/llm/fake"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	model := llm.Chain(fake.New(t).
+		Turn().CallTool("read_file", map[string]any{"path": "go.mod"}).Usage(providers.Usage{PromptTokens: 10, CompletionTokens: 2}).
+		Turn().Fail(fake.ProviderError(butler.ErrTransient)),
+		Log(&buf))
+
+	mem := []memory.MemoryEntry{memory.Text(memory.User, "what is this?")}
+	if _, err := model.Stream(context.Background(), mem, tools.StdToolset[:1], butler.EventHooks{}); err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if _, err := model.Stream(context.Background(), mem, nil, butler.EventHooks{}); err == nil {
+		t.Fatal("Expected the scripted failure")
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected a line per call, got %q", buf.String())
+	}
+	var first, second Entry
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatal(err)
+	}
+	if first.Provider != "fake" || first.Call != "stream" || len(first.Messages) != 1 || len(first.Tools) != 1 ||
+		len(first.ToolCalls) != 1 || first.Usage.PromptTokens != 10 || first.Error != "" {
+		t.Errorf("Unexpected first entry: %+v", first)
+	}
+	if second.Error == "" {
+		t.Errorf("Expected the error in the second entry, got %+v", second)
+	}
+	if llm.Describe(model).Provider != "fake" {
+		t.Errorf("Expected the middleware to describe the wrapped model, got %+v", llm.Describe(model))
+	}
+}
+
+func TestTiming(t *testing.T) {
+	var latencies []Latency
+	model := llm.Chain(fake.New(t).
+		Turn().Delay(10*time.Millisecond).Chunks("a", "b"),
+		Timing(func(l Latency) { latencies = append(latencies, l) }))
+
+	var text string
+	hooks := butler.EventHooks{OnTextChunk: func(s string) { text += s }}
+	if _, err := model.Stream(context.Background(), nil, nil, hooks); err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	if text != "ab" {
+		t.Errorf("Expected the chunks to reach the caller, got %q", text)
+	}
+	if len(latencies) != 1 {
+		t.Fatalf("Expected one latency, got %+v", latencies)
+	}
+	l := latencies[0]
+	if l.Info.Provider != "fake" || l.FirstChunk < 10*time.Millisecond || l.Total < l.FirstChunk || l.Err != nil {
+		t.Errorf("Unexpected latency: %+v", l)
+	}
+}
+
+func TestRedactPatterns(t *testing.T) {
+	key := "sk-or-v1-" + strings.Repeat("a1", 16)
+	model := llm.Chain(fake.New(t).
+		Turn().Expect(func(req fake.Request) error {
+		if msg := req.Messages[0].Text(); msg != "OPENROUTER_API_KEY="+Redacted {
+			return errors.New("expected the key to be redacted, got " + msg)
+		}
+		return nil
+	}).Text("ok"),
+		RedactPatterns(Secrets...))
+
+	mem := []memory.MemoryEntry{memory.ToolResult(tools.ToolCall{}, "OPENROUTER_API_KEY="+key, false)}
+	if _, err := model.Stream(context.Background(), mem, nil, butler.EventHooks{}); err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if mem[0].Text() != "OPENROUTER_API_KEY="+key {
+		t.Errorf("Expected the memory to keep the key, got %q", mem[0].Text())
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+	"regexp"
+	"slices"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// Redacted replaces what RedactPatterns removes
+const Redacted = "[REDACTED]"
+
+// Secrets match common API keys, tokens and private keys, e.g. read from a .env file by a tool
+var Secrets = []*regexp.Regexp{
+	regexp.MustCompile(`sk-[A-Za-z0-9_-]{20,}`),        // OpenAI, Anthropic and OpenRouter keys
+	regexp.MustCompile(`AIza[0-9A-Za-z_-]{35}`),        // Google API keys
+	regexp.MustCompile(`AKIA[0-9A-Z]{16}`),             // AWS access key IDs
+	regexp.MustCompile(`gh[pousr]_[A-Za-z0-9]{36,}`),   // GitHub tokens
+	regexp.MustCompile(`xox[abprs]-[A-Za-z0-9-]{10,}`), // Slack tokens
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`),
+}
+
+// Redact rewrites the text of every memory entry with replace before the model sees it.
+// Only the request is changed, the agent's memory keeps the original.
+func Redact(replace func(string) string) llm.Middleware {
+	return func(inner llm.LLM) llm.LLM {
+		return &redactLLM{wrapper: wrapper{inner}, replace: replace}
+	}
+}
+
+// RedactPatterns replaces the matches of the patterns with Redacted, see Secrets
+func RedactPatterns(patterns ...*regexp.Regexp) llm.Middleware {
+	return Redact(func(s string) string {
+		for _, p := range patterns {
+			s = p.ReplaceAllString(s, Redacted)
+		}
+		return s
+	})
+}
+
+type redactLLM struct {
+	wrapper
+	replace func(string) string
+}
+
+func (r *redactLLM) Stream(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+	return r.inner.Stream(ctx, r.redact(mem), agentTools, hooks)
+}
+
+func (r *redactLLM) Generate(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) error {
+	return r.inner.Generate(ctx, r.redact(mem), agentTools, hooks)
+}
+
+func (r *redactLLM) redact(mem []memory.MemoryEntry) []memory.MemoryEntry {
+	redacted := make([]memory.MemoryEntry, len(mem))
+	for i, entry := range mem {
+		entry.Parts = slices.Clone(entry.Parts)
+		for j, part := range entry.Parts {
+			entry.Parts[j].Text = r.replace(part.Text)
+		}
+		redacted[i] = entry
+	}
+	return redacted
+}
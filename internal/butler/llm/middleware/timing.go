@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// Latency is how long one model call took
+type Latency struct {
+	Info       llm.Info
+	Call       string        // "stream" or "generate"
+	FirstChunk time.Duration // Until the first text, thinking or tool call chunk, 0 when nothing was streamed
+	Total      time.Duration
+	Err        error
+}
+
+// Timing reports the latency of every call to report once it returns, e.g. for metrics
+func Timing(report func(Latency)) llm.Middleware {
+	return func(inner llm.LLM) llm.LLM {
+		return &timingLLM{wrapper: wrapper{inner}, report: report}
+	}
+}
+
+type timingLLM struct {
+	wrapper
+	report func(Latency)
+}
+
+func (t *timingLLM) Stream(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+	latency := Latency{Info: t.Describe(), Call: "stream"}
+	start := time.Now()
+	resp, err := t.inner.Stream(ctx, mem, agentTools, timeFirstChunk(hooks, start, &latency.FirstChunk))
+	t.finish(latency, start, err)
+	return resp, err
+}
+
+func (t *timingLLM) Generate(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) error {
+	latency := Latency{Info: t.Describe(), Call: "generate"}
+	start := time.Now()
+	err := t.inner.Generate(ctx, mem, agentTools, timeFirstChunk(hooks, start, &latency.FirstChunk))
+	t.finish(latency, start, err)
+	return err
+}
+
+func (t *timingLLM) finish(latency Latency, start time.Time, err error) {
+	latency.Total = time.Since(start)
+	latency.Err = err
+	t.report(latency)
+}
+
+// timeFirstChunk wraps the streaming hooks to measure when the first chunk arrives
+func timeFirstChunk(hooks butler.EventHooks, start time.Time, firstChunk *time.Duration) butler.EventHooks {
+	seen := false
+	mark := func() {
+		if !seen {
+			seen = true
+			*firstChunk = time.Since(start)
+		}
+	}
+	wrapped := hooks
+	wrapped.OnTextChunk = func(s string) {
+		mark()
+		if hooks.OnTextChunk != nil {
+			hooks.OnTextChunk(s)
+		}
+	}
+	wrapped.OnThinkingChunk = func(s string) {
+		mark()
+		if hooks.OnThinkingChunk != nil {
+			hooks.OnThinkingChunk(s)
+		}
+	}
+	wrapped.OnToolCall = func(call tools.ToolCall) {
+		mark()
+		if hooks.OnToolCall != nil {
+			hooks.OnToolCall(call)
+		}
+	}
+	return wrapped
+}
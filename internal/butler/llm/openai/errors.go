@@ -0,0 +1,40 @@
+package openai_llm
+
+import (
+	"errors"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/openai/openai-go/v3"
+)
+
+const providerName = "openai"
+
+// mapError converts OpenAI SDK errors into the butler error taxonomy
+func mapError(err error) error {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		providerErr := butler.NewProviderError(providerName, apiErr.StatusCode, errorCode(apiErr), apiErr.Message, err)
+		if apiErr.Response != nil {
+			providerErr.WithRetryAfter(apiErr.Response.Header.Get("Retry-After"))
+		}
+		return providerErr
+	}
+	return butler.WrapError(providerName, err)
+}
+
+// errorCode is the code of an error, or its type when it has none
+func errorCode(apiErr *openai.Error) string {
+	if apiErr.Code != "" {
+		return apiErr.Code
+	}
+	return apiErr.Type
+}
+
+// contentFilteredError is returned when the model stops because of the provider's content filter
+func contentFilteredError() error {
+	return &butler.ProviderError{
+		Kind:     butler.ErrContentFiltered,
+		Provider: providerName,
+		Err:      errors.New("response stopped by the content filter"),
+	}
+}
@@ -4,19 +4,27 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"maps"
+	"slices"
 	"strings"
 
 	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
 	"github.com/mightymoud/arlocode/internal/butler/memory"
 	"github.com/mightymoud/arlocode/internal/butler/providers"
 	"github.com/mightymoud/arlocode/internal/butler/tools"
 	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/shared"
 )
 
 type OpenAILLM struct {
 	ModelID string
 	Client  *openai.Client
+	Config  providers.GenerationConfig
+}
+
+func (l OpenAILLM) Describe() llm.Info {
+	return llm.Info{Provider: "openai", Model: l.ModelID, StructuredOutput: true}
 }
 
 func (l OpenAILLM) Stream(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
@@ -33,10 +41,15 @@ func (l OpenAILLM) Stream(ctx context.Context, mem []memory.MemoryEntry, agentTo
 	if len(openaiTools) > 0 {
 		params.Tools = openaiTools
 	}
+	l.applyConfig(&params)
+	applyOutputSchema(ctx, &params)
 
 	stream := l.Client.Chat.Completions.NewStreaming(ctx, params)
 
 	var fullText strings.Builder
+	var thinking strings.Builder
+	var usage providers.Usage
+	isThinking := false
 
 	type partialToolCall struct {
 		ID   string
@@ -47,9 +60,29 @@ func (l OpenAILLM) Stream(ctx context.Context, mem []memory.MemoryEntry, agentTo
 
 	for stream.Next() {
 		chunk := stream.Current()
+		// Only the last chunk, which has no choices, carries the usage
+		if chunk.Usage.PromptTokens > 0 {
+			usage = convertUsage(l.ModelID, chunk.Usage)
+		}
 		if len(chunk.Choices) > 0 {
+			if chunk.Choices[0].FinishReason == "content_filter" {
+				return providers.ProviderResponse{Text: fullText.String()}, contentFilteredError()
+			}
 			delta := chunk.Choices[0].Delta
+			if reasoning := reasoningDelta(delta); reasoning != "" {
+				isThinking = true
+				thinking.WriteString(reasoning)
+				if hooks.OnThinkingChunk != nil {
+					hooks.OnThinkingChunk(reasoning)
+				}
+			}
 			if delta.Content != "" {
+				if isThinking {
+					isThinking = false
+					if hooks.OnThinkingComplete != nil {
+						hooks.OnThinkingComplete()
+					}
+				}
 				if hooks.OnTextChunk != nil {
 					hooks.OnTextChunk(delta.Content)
 				}
@@ -84,16 +117,22 @@ func (l OpenAILLM) Stream(ctx context.Context, mem []memory.MemoryEntry, agentTo
 	}
 
 	if err := stream.Err(); err != nil {
-		fmt.Print(err)
-		return providers.ProviderResponse{}, err
+		// Hand back the partial text so an interrupted run can keep it
+		return providers.ProviderResponse{Text: fullText.String()}, mapError(err)
 	}
 
+	// Keep the order the model sent the calls in
+	indices := slices.Sorted(maps.Keys(pendingToolCalls))
+
 	var toolCalls []tools.ToolCall
-	for _, ptc := range pendingToolCalls {
+	for _, index := range indices {
+		ptc := pendingToolCalls[index]
 		var args map[string]any
+		var rawArgs string
 		if ptc.Args.Len() > 0 {
+			// Malformed arguments are handed to the agent raw so it can report the error to the model
 			if err := json.Unmarshal([]byte(ptc.Args.String()), &args); err != nil {
-				log.Printf("Error unmarshaling tool arguments: %v", err)
+				rawArgs = ptc.Args.String()
 			}
 		}
 
@@ -101,12 +140,15 @@ func (l OpenAILLM) Stream(ctx context.Context, mem []memory.MemoryEntry, agentTo
 			ID:           ptc.ID,
 			FunctionName: ptc.Name,
 			Arguments:    args,
+			RawArguments: rawArgs,
 		})
 	}
 
 	return providers.ProviderResponse{
 		Text:      fullText.String(),
+		Thinking:  thinking.String(),
 		ToolCalls: toolCalls,
+		Usage:     usage,
 	}, nil
 }
 
@@ -121,10 +163,12 @@ func (l OpenAILLM) Generate(ctx context.Context, mem []memory.MemoryEntry, agent
 	if len(openaiTools) > 0 {
 		params.Tools = openaiTools
 	}
+	l.applyConfig(&params)
+	applyOutputSchema(ctx, &params)
 
 	resp, err := l.Client.Chat.Completions.New(ctx, params)
 	if err != nil {
-		return err
+		return mapError(err)
 	}
 
 	if len(resp.Choices) > 0 {
@@ -132,3 +176,33 @@ func (l OpenAILLM) Generate(ctx context.Context, mem []memory.MemoryEntry, agent
 	}
 	return nil
 }
+
+// applyConfig sets the generation settings of the model on the request.
+// The reasoning budget has no equivalent in the chat completions API and is ignored.
+func (l OpenAILLM) applyConfig(params *openai.ChatCompletionNewParams) {
+	if l.Config.Temperature != nil {
+		params.Temperature = openai.Float(*l.Config.Temperature)
+	}
+	if l.Config.TopP != nil {
+		params.TopP = openai.Float(*l.Config.TopP)
+	}
+	if l.Config.MaxTokens > 0 {
+		params.MaxCompletionTokens = openai.Int(int64(l.Config.MaxTokens))
+	}
+}
+
+// applyOutputSchema asks for JSON matching the schema set with llm.WithOutputSchema
+func applyOutputSchema(ctx context.Context, params *openai.ChatCompletionNewParams) {
+	schema, ok := llm.OutputSchemaFrom(ctx)
+	if !ok {
+		return
+	}
+	params.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
+		OfJSONSchema: &shared.ResponseFormatJSONSchemaParam{
+			JSONSchema: shared.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:   schema.Name,
+				Schema: generateOpenAISchema(schema.Type),
+			},
+		},
+	}
+}
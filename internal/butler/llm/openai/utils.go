@@ -1,10 +1,13 @@
 package openai_llm
 
 import (
+	"encoding/json"
+	"path/filepath"
 	"reflect"
 	"strings"
 
 	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
 	"github.com/mightymoud/arlocode/internal/butler/tools"
 	"github.com/openai/openai-go/v3"
 	"github.com/openai/openai-go/v3/shared"
@@ -103,16 +106,102 @@ func convertMemoryToOpenAIMessages(mem []memory.MemoryEntry) []openai.ChatComple
 	var messages []openai.ChatCompletionMessageParamUnion
 	for _, entry := range mem {
 		switch entry.Role {
-		case "user":
-			messages = append(messages, openai.UserMessage(entry.Message))
-		case "assistant", "model":
-			messages = append(messages, openai.AssistantMessage(entry.Message))
-		case "system":
-			messages = append(messages, openai.SystemMessage(entry.Message))
+		case memory.Tool:
+			result, _ := entry.ToolResult()
+			messages = append(messages, openai.ToolMessage(result.Text, result.ToolCallID))
+		case memory.Model:
+			messages = append(messages, convertResponse(entry))
+		case memory.System:
+			messages = append(messages, openai.SystemMessage(entry.Text()))
 		default:
-			// Default to user or handle error?
-			messages = append(messages, openai.UserMessage(entry.Message))
+			if entry.HasMedia() {
+				messages = append(messages, openai.UserMessage(convertParts(entry.Parts)))
+			} else {
+				messages = append(messages, openai.UserMessage(entry.Text()))
+			}
 		}
 	}
 	return messages
 }
+
+// convertResponse sends the tool calls back with the answer, the tool messages answering them refer to their IDs
+func convertResponse(entry memory.MemoryEntry) openai.ChatCompletionMessageParamUnion {
+	calls := entry.ToolCalls()
+	if len(calls) == 0 {
+		return openai.AssistantMessage(entry.Text())
+	}
+	message := openai.ChatCompletionAssistantMessageParam{}
+	if text := entry.Text(); text != "" {
+		message.Content.OfString = openai.String(text)
+	}
+	for _, call := range calls {
+		arguments := call.RawArguments
+		if call.Arguments != nil {
+			encoded, _ := json.Marshal(call.Arguments)
+			arguments = string(encoded)
+		}
+		message.ToolCalls = append(message.ToolCalls, openai.ChatCompletionMessageToolCallUnionParam{
+			OfFunction: &openai.ChatCompletionMessageFunctionToolCallParam{
+				ID: call.ID,
+				Function: openai.ChatCompletionMessageFunctionToolCallFunctionParam{
+					Name:      call.FunctionName,
+					Arguments: arguments,
+				},
+			},
+		})
+	}
+	return openai.ChatCompletionMessageParamUnion{OfAssistant: &message}
+}
+
+// convertParts keeps the order of text and attachments, images and files go inline as data URLs
+func convertParts(parts []memory.Part) []openai.ChatCompletionContentPartUnionParam {
+	var content []openai.ChatCompletionContentPartUnionParam
+	for _, part := range parts {
+		part = part.Load()
+		switch {
+		case part.Type == memory.TextPart:
+			content = append(content, openai.TextContentPart(part.Text))
+		case part.Type == memory.ImagePart:
+			content = append(content, openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{
+				URL: part.DataURL(),
+			}))
+		case part.IsTextFile():
+			content = append(content, openai.TextContentPart(part.FileText()))
+		case part.Type == memory.FilePart:
+			content = append(content, openai.FileContentPart(openai.ChatCompletionContentPartFileFileParam{
+				FileData: openai.String(part.DataURL()),
+				Filename: openai.String(filepath.Base(part.Path)),
+			}))
+		}
+	}
+	return content
+}
+
+// convertUsage maps the usage of the last stream chunk and prices it from the providers table
+func convertUsage(modelID string, u openai.CompletionUsage) providers.Usage {
+	usage := providers.Usage{
+		PromptTokens:     int(u.PromptTokens),
+		CompletionTokens: int(u.CompletionTokens),
+		ReasoningTokens:  int(u.CompletionTokensDetails.ReasoningTokens),
+		CachedTokens:     int(u.PromptTokensDetails.CachedTokens),
+	}
+	usage.Cost = providers.EstimateCost(modelID, usage)
+	return usage
+}
+
+// reasoningDelta is the reasoning streamed in a chunk. OpenAI doesn't stream it on chat completions,
+// compatible servers like DeepSeek and vLLM send it next to the content as reasoning_content.
+func reasoningDelta(delta openai.ChatCompletionChunkChoiceDelta) string {
+	for _, name := range []string{"reasoning_content", "reasoning"} {
+		// Fields the SDK doesn't know are never Valid, only their raw JSON is kept
+		field, ok := delta.JSON.ExtraFields[name]
+		if !ok || field.Raw() == "" {
+			continue
+		}
+		var reasoning string
+		if err := json.Unmarshal([]byte(field.Raw()), &reasoning); err == nil && reasoning != "" {
+			return reasoning
+		}
+	}
+	return ""
+}
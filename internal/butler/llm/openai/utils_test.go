@@ -1,11 +1,13 @@
 package openai_llm
 
 import (
+	"encoding/json"
 	"reflect"
 	"testing"
 
 	"github.com/mightymoud/arlocode/internal/butler/memory"
 	"github.com/mightymoud/arlocode/internal/butler/tools"
+	"github.com/openai/openai-go/v3"
 )
 
 func TestMakeOpenAITools(t *testing.T) {
@@ -67,10 +69,10 @@ func TestGenerateOpenAISchema_Struct(t *testing.T) {
 
 func TestConvertMemoryToOpenAIMessages(t *testing.T) {
 	mem := []memory.MemoryEntry{
-		{Role: "user", Message: "hello"},
-		{Role: "assistant", Message: "hi"},
-		{Role: "system", Message: "system message"},
-		{Role: "unknown", Message: "default to user"},
+		memory.Text(memory.User, "hello"),
+		memory.Text(memory.Model, "hi"),
+		memory.Text(memory.System, "system message"),
+		memory.Text("unknown", "default to user"),
 	}
 
 	messages := convertMemoryToOpenAIMessages(mem)
@@ -83,6 +85,65 @@ func TestConvertMemoryToOpenAIMessages(t *testing.T) {
 	// the correct number of messages without panicking.
 }
 
+func TestConvertMemoryToOpenAIMessages_ToolCalls(t *testing.T) {
+	call := tools.ToolCall{ID: "1", FunctionName: "func", Arguments: map[string]any{"a": 1}}
+	mem := []memory.MemoryEntry{
+		memory.Text(memory.User, "hello"),
+		memory.Response("", []tools.ToolCall{call}),
+		memory.ToolResult(call, "result", false),
+	}
+
+	messages := convertMemoryToOpenAIMessages(mem)
+	if len(messages) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(messages))
+	}
+	assistant := messages[1].OfAssistant
+	if assistant == nil || len(assistant.ToolCalls) != 1 || assistant.ToolCalls[0].OfFunction.Function.Arguments != `{"a":1}` {
+		t.Errorf("expected the tool call on the assistant message, got %+v", messages[1])
+	}
+	if tool := messages[2].OfTool; tool == nil || tool.ToolCallID != "1" {
+		t.Errorf("expected a tool message answering the call, got %+v", messages[2])
+	}
+}
+
+func TestConvertMemoryToOpenAIMessages_Attachments(t *testing.T) {
+	prompt := memory.Text(memory.User, "what's wrong here?")
+	prompt.Parts = append(prompt.Parts, memory.Image("image/png", []byte("png")), memory.File("docs/spec.pdf", "application/pdf", []byte("pdf")))
+
+	messages := convertMemoryToOpenAIMessages([]memory.MemoryEntry{prompt})
+	parts := messages[0].OfUser.Content.OfArrayOfContentParts
+	if len(parts) != 3 || parts[0].OfText == nil {
+		t.Fatalf("expected the prompt and its attachments, got %+v", parts)
+	}
+	if image := parts[1].OfImageURL; image == nil || image.ImageURL.URL != "data:image/png;base64,cG5n" {
+		t.Errorf("expected the image as a data URL, got %+v", parts[1])
+	}
+	if file := parts[2].OfFile; file == nil || file.File.Filename.Value != "spec.pdf" {
+		t.Errorf("expected the PDF as a file, got %+v", parts[2])
+	}
+}
+
+func TestReasoningDelta(t *testing.T) {
+	tests := []struct {
+		chunk string
+		want  string
+	}{
+		{`{"content":"hi"}`, ""},
+		{`{"reasoning_content":"thinking it over"}`, "thinking it over"}, // DeepSeek, vLLM
+		{`{"reasoning":"thinking it over"}`, "thinking it over"},         // OpenRouter and compatible servers
+		{`{"reasoning":null}`, ""},
+	}
+	for _, tt := range tests {
+		var delta openai.ChatCompletionChunkChoiceDelta
+		if err := json.Unmarshal([]byte(tt.chunk), &delta); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if got := reasoningDelta(delta); got != tt.want {
+			t.Errorf("reasoningDelta(%s) = %q, want %q", tt.chunk, got, tt.want)
+		}
+	}
+}
+
 func TestGenerateOpenAISchema_Map(t *testing.T) {
 	schema := generateOpenAISchema(reflect.TypeOf(map[string]int{}))
 	if schema == nil {
@@ -0,0 +1,97 @@
+package openrouter_llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/iamwavecut/gopenrouter"
+	"github.com/mightymoud/arlocode/internal/butler"
+)
+
+const providerName = "openrouter"
+
+// streamErrorPrefix is how gopenrouter reports error events received mid-stream
+const streamErrorPrefix = "stream error: "
+
+// mapError converts gopenrouter errors into the butler error taxonomy
+func mapError(err error) error {
+	if streamErr := parseStreamError(err); streamErr != nil {
+		err = streamErr
+	}
+
+	var apiErr *gopenrouter.APIError
+	if errors.As(err, &apiErr) {
+		code, message := upstreamError(apiErr)
+		providerErr := butler.NewProviderError(providerName, apiErrorStatus(apiErr), code, strings.TrimSpace(apiErr.Message+" "+message), err)
+		if retryAfter, ok := apiErr.Metadata["retry_after"]; ok {
+			providerErr.WithRetryAfter(fmt.Sprint(retryAfter))
+		}
+		return providerErr
+	}
+
+	var reqErr *gopenrouter.RequestError
+	if errors.As(err, &reqErr) && reqErr.HTTPStatusCode != 0 {
+		return butler.NewProviderError(providerName, reqErr.HTTPStatusCode, "", string(reqErr.Body), err)
+	}
+	return butler.WrapError(providerName, err)
+}
+
+// parseStreamError recovers the API error from an error event sent mid-stream
+func parseStreamError(err error) *gopenrouter.APIError {
+	payload, ok := strings.CutPrefix(err.Error(), streamErrorPrefix)
+	if !ok {
+		return nil
+	}
+	var errResp gopenrouter.ErrorResponse
+	if json.Unmarshal([]byte(payload), &errResp) != nil {
+		return nil
+	}
+	return errResp.Error
+}
+
+// upstreamError reads the code and message of the error the model's provider returned,
+// OpenRouter passes it on as raw JSON in the metadata with a generic message of its own
+func upstreamError(apiErr *gopenrouter.APIError) (code, message string) {
+	raw, ok := apiErr.Metadata["raw"].(string)
+	if !ok {
+		return "", ""
+	}
+	var upstream struct {
+		Error struct {
+			Code    any    `json:"code"`
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal([]byte(raw), &upstream) != nil {
+		return "", raw
+	}
+	code, _ = upstream.Error.Code.(string)
+	if code == "" {
+		code = upstream.Error.Type
+	}
+	return code, upstream.Error.Message
+}
+
+// apiErrorStatus reads the HTTP status OpenRouter puts in the error code field
+func apiErrorStatus(apiErr *gopenrouter.APIError) int {
+	switch code := apiErr.Code.(type) {
+	case float64:
+		return int(code)
+	case int:
+		return code
+	default:
+		return 0
+	}
+}
+
+// contentFilteredError is returned when the model stops because of the provider's content filter
+func contentFilteredError() error {
+	return &butler.ProviderError{
+		Kind:     butler.ErrContentFiltered,
+		Provider: providerName,
+		Err:      errors.New("response stopped by the content filter"),
+	}
+}
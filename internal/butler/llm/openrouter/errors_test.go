@@ -0,0 +1,62 @@
+package openrouter_llm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/iamwavecut/gopenrouter"
+	"github.com/mightymoud/arlocode/internal/butler"
+)
+
+func TestMapError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"rate limited", &gopenrouter.APIError{Message: "Rate limit exceeded", Code: float64(429)}, butler.ErrRateLimited},
+		{"bad key", &gopenrouter.APIError{Message: "No auth credentials found", Code: float64(401)}, butler.ErrAuth},
+		{"context length", &gopenrouter.APIError{Message: "This endpoint's maximum context length is 200000 tokens", Code: float64(400)}, butler.ErrContextTooLong},
+		{"upstream down", &gopenrouter.RequestError{HTTPStatusCode: 502, Err: errors.New("bad gateway")}, butler.ErrTransient},
+		{"upstream too long", &gopenrouter.APIError{Message: "Provider returned error", Code: float64(400), Metadata: map[string]any{
+			"raw": `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 210000 tokens > 200000 maximum"}}`,
+		}}, butler.ErrContextTooLong},
+		{"upstream overloaded", &gopenrouter.APIError{Message: "Provider returned error", Code: float64(400), Metadata: map[string]any{
+			"raw": `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+		}}, butler.ErrTransient},
+		{"wrapped", fmt.Errorf("request: %w", &gopenrouter.APIError{Code: float64(503)}), butler.ErrTransient},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := mapError(c.err)
+			if !errors.Is(err, c.want) {
+				t.Errorf("Expected %v, got %v", c.want, err)
+			}
+			if !errors.Is(err, c.err) {
+				t.Errorf("Expected mapped error to wrap the SDK error")
+			}
+		})
+	}
+}
+
+func TestMapError_RetryAfter(t *testing.T) {
+	err := mapError(&gopenrouter.APIError{
+		Message:  "Rate limit exceeded",
+		Code:     float64(429),
+		Metadata: map[string]any{"retry_after": 12},
+	})
+
+	wait, ok := butler.RetryAfter(err)
+	if !ok || wait.Seconds() != 12 {
+		t.Errorf("Expected 12s retry-after, got %s (%v)", wait, ok)
+	}
+}
+
+func TestMapError_StreamError(t *testing.T) {
+	err := mapError(errors.New(`stream error: {"error":{"message":"Provider returned error","code":502}}`))
+	if !errors.Is(err, butler.ErrTransient) {
+		t.Errorf("Expected transient error, got %v", err)
+	}
+}
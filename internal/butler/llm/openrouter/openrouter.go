@@ -6,11 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"maps"
+	"slices"
 	"strings"
 
 	"github.com/iamwavecut/gopenrouter"
 	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
 	"github.com/mightymoud/arlocode/internal/butler/memory"
 	"github.com/mightymoud/arlocode/internal/butler/providers"
 	"github.com/mightymoud/arlocode/internal/butler/tools"
@@ -20,11 +22,23 @@ type OpenRouterLLM struct {
 	ModelID           string
 	Client            *gopenrouter.Client
 	ParallelToolCalls *bool // unused atm
+	Config            providers.GenerationConfig
+}
+
+// defaultReasoningTokens is the thinking budget when the config doesn't set one
+const defaultReasoningTokens = 1000
+
+func (l OpenRouterLLM) Describe() llm.Info {
+	// Whether response_format is honoured depends on the model behind the ID, so no promise is made
+	return llm.Info{Provider: "openrouter", Model: l.ModelID}
 }
 
 func (l OpenRouterLLM) Stream(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
 	openRouterTools := makeOpenRouterTools(agentTools)
-	messages := convertMemoryToOpenRouterMessages(mem)
+	messages, err := convertMemoryToOpenRouterMessages(mem)
+	if err != nil {
+		return providers.ProviderResponse{}, err
+	}
 
 	req := gopenrouter.ChatCompletionRequest{
 		Model:    l.ModelID,
@@ -33,9 +47,13 @@ func (l OpenRouterLLM) Stream(ctx context.Context, mem []memory.MemoryEntry, age
 		// per OpenRouter documentation
 		Tools: openRouterTools,
 		Reasoning: &gopenrouter.ReasoningParams{
-			MaxTokens: 1000,
+			MaxTokens: defaultReasoningTokens,
 		},
+		// Usage accounting adds the token counts and the cost to the last chunk
+		Usage: &gopenrouter.UsageParams{Include: true},
 	}
+	l.applyConfig(&req)
+	applyOutputSchema(ctx, &req)
 
 	// Apply parallel tool calls configuration if set
 	if l.ParallelToolCalls != nil {
@@ -44,11 +62,13 @@ func (l OpenRouterLLM) Stream(ctx context.Context, mem []memory.MemoryEntry, age
 
 	stream, err := l.Client.CreateChatCompletionStream(ctx, req)
 	if err != nil {
-		return providers.ProviderResponse{}, err
+		return providers.ProviderResponse{}, mapError(err)
 	}
 	defer stream.Close()
 
 	var currentResponseText strings.Builder
+	var thinking strings.Builder
+	var usage providers.Usage
 	isThinking := false
 
 	type streamToolCall struct {
@@ -65,19 +85,25 @@ func (l OpenRouterLLM) Stream(ctx context.Context, mem []memory.MemoryEntry, age
 			break
 		}
 		if err != nil {
-			log.Printf("Stream error: %v", err)
-			break
+			// Failed and cancelled streams hand back the partial text so it can be kept in memory
+			if ctx.Err() != nil {
+				return providers.ProviderResponse{Text: currentResponseText.String()}, ctx.Err()
+			}
+			return providers.ProviderResponse{Text: currentResponseText.String()}, mapError(err)
 		}
 
 		if len(response.Choices) > 0 {
+			if response.Choices[0].FinishReason == "content_filter" {
+				return providers.ProviderResponse{Text: currentResponseText.String()}, contentFilteredError()
+			}
 			delta := response.Choices[0].Delta
 
 			if delta.Reasoning != "" {
 				isThinking = true
+				thinking.WriteString(delta.Reasoning)
 				if hooks.OnThinkingChunk != nil {
 					hooks.OnThinkingChunk(delta.Reasoning)
 				}
-				// currentResponseText.WriteString(delta.Reasoning)
 			}
 
 			// Handle Content
@@ -115,34 +141,35 @@ func (l OpenRouterLLM) Stream(ctx context.Context, mem []memory.MemoryEntry, age
 			}
 		}
 
-		// Accumulate usage stats -> for later
-		// if response.Usage != nil {
-		// 	fmt.Printf("\n[Usage]: Prompt: %d, Completion: %d, Total: %d, Cost: %f\n",
-		// 		response.Usage.PromptTokens, response.Usage.CompletionTokens, response.Usage.TotalTokens, response.Usage.Cost)
-		// }
+		if response.Usage != nil {
+			usage = convertUsage(*response.Usage)
+		}
 	}
 
+	// Keep the order the model sent the calls in
+	indices := slices.Sorted(maps.Keys(pendingToolCalls))
+
 	var toolCalls []tools.ToolCall
-	for _, ptc := range pendingToolCalls {
+	for _, index := range indices {
+		ptc := pendingToolCalls[index]
 		var args map[string]any
+		var rawArgs string
 		if ptc.Args.Len() > 0 {
-			// Try to unmarshal, if fails (e.g. partial json), we might have an issue,
-			// but at end of stream it should be complete.
+			// At the end of the stream the JSON should be complete - if it still doesn't parse
+			// the raw arguments are handed to the agent so it can report the error to the model
 			if err := json.Unmarshal([]byte(ptc.Args.String()), &args); err != nil {
-				log.Printf("Error unmarshaling tool args: %v", err)
+				rawArgs = ptc.Args.String()
 			}
 		}
-		toolCalls = append(toolCalls, tools.ToolCall{
+		call := tools.ToolCall{
 			ID:           ptc.ID,
 			FunctionName: ptc.Name,
 			Arguments:    args,
-		})
+			RawArguments: rawArgs,
+		}
+		toolCalls = append(toolCalls, call)
 		if hooks.OnToolCall != nil {
-			hooks.OnToolCall(tools.ToolCall{
-				ID:           ptc.ID,
-				FunctionName: ptc.Name,
-				Arguments:    args,
-			})
+			hooks.OnToolCall(call)
 		}
 	}
 	if hooks.OnStreamComplete != nil {
@@ -151,13 +178,18 @@ func (l OpenRouterLLM) Stream(ctx context.Context, mem []memory.MemoryEntry, age
 
 	return providers.ProviderResponse{
 		Text:      currentResponseText.String(),
+		Thinking:  thinking.String(),
 		ToolCalls: toolCalls,
+		Usage:     usage,
 	}, nil
 }
 
 func (l OpenRouterLLM) Generate(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) error {
 	openRouterTools := makeOpenRouterTools(agentTools)
-	messages := convertMemoryToOpenRouterMessages(mem)
+	messages, err := convertMemoryToOpenRouterMessages(mem)
+	if err != nil {
+		return err
+	}
 
 	req := gopenrouter.ChatCompletionRequest{
 		Model:    l.ModelID,
@@ -165,6 +197,8 @@ func (l OpenRouterLLM) Generate(ctx context.Context, mem []memory.MemoryEntry, a
 		// Tools must be included in every request
 		Tools: openRouterTools,
 	}
+	l.applyConfig(&req)
+	applyOutputSchema(ctx, &req)
 
 	// Apply parallel tool calls configuration if set
 	if l.ParallelToolCalls != nil {
@@ -173,7 +207,7 @@ func (l OpenRouterLLM) Generate(ctx context.Context, mem []memory.MemoryEntry, a
 
 	resp, err := l.Client.CreateChatCompletion(ctx, req)
 	if err != nil {
-		return err
+		return mapError(err)
 	}
 
 	if len(resp.Choices) > 0 {
@@ -184,6 +218,36 @@ func (l OpenRouterLLM) Generate(ctx context.Context, mem []memory.MemoryEntry, a
 	return nil
 }
 
+// applyConfig sets the generation settings of the model on the request
+func (l OpenRouterLLM) applyConfig(req *gopenrouter.ChatCompletionRequest) {
+	if l.Config.Temperature != nil {
+		req.Temperature = *l.Config.Temperature
+	}
+	if l.Config.TopP != nil {
+		req.TopP = *l.Config.TopP
+	}
+	if l.Config.MaxTokens > 0 {
+		req.MaxTokens = l.Config.MaxTokens
+	}
+	if l.Config.ReasoningTokens > 0 {
+		req.Reasoning = &gopenrouter.ReasoningParams{MaxTokens: l.Config.ReasoningTokens}
+	}
+}
+
+// applyOutputSchema asks for JSON matching the schema set with llm.WithOutputSchema.
+// It is sent to every model, the ones that don't support structured outputs ignore it,
+// so Describe doesn't report StructuredOutput and callers put the schema in the prompt too.
+func applyOutputSchema(ctx context.Context, req *gopenrouter.ChatCompletionRequest) {
+	schema, ok := llm.OutputSchemaFrom(ctx)
+	if !ok {
+		return
+	}
+	req.ResponseFormat = &gopenrouter.ResponseFormat{
+		Type:       "json_schema",
+		JSONSchema: &gopenrouter.JSONSchema{Name: schema.Name, Schema: generateJSONSchema(schema.Type)},
+	}
+}
+
 // WithParallelToolCalls sets whether multiple tools can be called simultaneously
 func (l *OpenRouterLLM) WithParallelToolCalls(enabled bool) *OpenRouterLLM {
 	l.ParallelToolCalls = &enabled
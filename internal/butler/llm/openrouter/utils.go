@@ -2,11 +2,13 @@ package openrouter_llm
 
 import (
 	"encoding/json"
+	"fmt"
+	"path/filepath"
 	"reflect"
-	"strings"
 
 	"github.com/iamwavecut/gopenrouter"
 	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
 	"github.com/mightymoud/arlocode/internal/butler/tools"
 )
 
@@ -25,137 +27,62 @@ func makeOpenRouterTools(agentTools []tools.Tool) []gopenrouter.Tool {
 	return openRouterTools
 }
 
+// generateJSONSchema describes tool arguments and output schemas, OpenRouter takes plain JSON Schema
 func generateJSONSchema(t reflect.Type) map[string]any {
-	// Handle pointer types by dereferencing them
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
-	}
-
-	switch t.Kind() {
-	case reflect.String:
-		return map[string]any{"type": "string"}
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
-		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return map[string]any{"type": "integer"}
-	case reflect.Float32, reflect.Float64:
-		return map[string]any{"type": "number"}
-	case reflect.Bool:
-		return map[string]any{"type": "boolean"}
-	case reflect.Slice, reflect.Array:
-		return map[string]any{
-			"type":  "array",
-			"items": generateJSONSchema(t.Elem()),
-		}
-	case reflect.Map:
-		return map[string]any{"type": "object"}
-	case reflect.Struct:
-		properties := make(map[string]any)
-		var required []string
-
-		for i := 0; i < t.NumField(); i++ {
-			field := t.Field(i)
-
-			// Skip unexported fields
-			if field.PkgPath != "" {
-				continue
-			}
-
-			jsonTag := field.Tag.Get("json")
-			if jsonTag == "-" {
-				continue
-			}
-
-			name := field.Name
-			isOmitEmpty := false
-			if jsonTag != "" {
-				parts := strings.Split(jsonTag, ",")
-				name = parts[0]
-				for _, part := range parts[1:] {
-					if part == "omitempty" {
-						isOmitEmpty = true
-						break
-					}
-				}
-			}
-
-			// Add to required if not omitempty
-			if !isOmitEmpty {
-				required = append(required, name)
-			}
-
-			propSchema := generateJSONSchema(field.Type)
-
-			// Add description if available - important for LLM understanding
-			descTag := field.Tag.Get("description")
-			if descTag != "" {
-				propSchema["description"] = descTag
-			}
-
-			// Add enum constraints if available
-			enumTag := field.Tag.Get("enum")
-			if enumTag != "" {
-				enumValues := strings.Split(enumTag, ",")
-				propSchema["enum"] = enumValues
-			}
-
-			// Add default value if available
-			defaultTag := field.Tag.Get("default")
-			if defaultTag != "" {
-				propSchema["default"] = defaultTag
-			}
-
-			properties[name] = propSchema
-		}
-
-		schema := map[string]any{
-			"type":       "object",
-			"properties": properties,
-		}
-
-		// Only add required array if there are required fields
-		if len(required) > 0 {
-			schema["required"] = required
-		}
-
-		return schema
-	default:
-		return map[string]any{"type": "string"}
-	}
+	return tools.JSONSchema(t)
 }
 
 func getRoleFromMemoryEntry(entry memory.MemoryEntry) gopenrouter.ChatCompletionMessageRole {
 	switch entry.Role {
-	case "user":
+	case memory.User:
 		return gopenrouter.RoleUser
-	case "assistant", "model":
+	case memory.Model:
 		return gopenrouter.RoleAssistant
-	case "system":
+	case memory.System:
 		return gopenrouter.RoleSystem
 	default:
 		return gopenrouter.RoleUser
 	}
 }
 
-func convertMemoryToOpenRouterMessages(mem []memory.MemoryEntry) []gopenrouter.ChatCompletionMessage {
+// callArguments is the JSON arguments of a call as the model sent them, an empty object when it sent none
+func callArguments(call tools.ToolCall) (string, error) {
+	if call.RawArguments != "" {
+		return call.RawArguments, nil
+	}
+	if call.Arguments == nil {
+		return "{}", nil
+	}
+	encoded, err := json.Marshal(call.Arguments)
+	if err != nil {
+		return "", fmt.Errorf("encoding the arguments of %s: %w", call.FunctionName, err)
+	}
+	return string(encoded), nil
+}
+
+func convertMemoryToOpenRouterMessages(mem []memory.MemoryEntry) ([]gopenrouter.ChatCompletionMessage, error) {
 	var messages []gopenrouter.ChatCompletionMessage
 	for _, entry := range mem {
-		if entry.Role == "tool" {
+		if result, ok := entry.ToolResult(); ok {
 			messages = append(messages, gopenrouter.ChatCompletionMessage{
 				Role:       gopenrouter.RoleTool,
-				Content:    entry.Message,
-				ToolCallID: entry.ToolCallID,
-				Name:       entry.ToolName,
+				Content:    result.Text,
+				ToolCallID: result.ToolCallID,
+				Name:       result.ToolName,
 			})
 		} else {
 			var toolCalls []gopenrouter.ToolCall
-			for _, tc := range entry.ToolCalls {
-				argsBytes, _ := json.Marshal(tc.Arguments)
+			for _, tc := range entry.ToolCalls() {
+				arguments, err := callArguments(tc)
+				if err != nil {
+					return nil, err
+				}
 				toolCalls = append(toolCalls, gopenrouter.ToolCall{
 					ID:   tc.ID,
 					Type: "function",
 					Function: gopenrouter.Function{
 						Name:      tc.FunctionName,
-						Arguments: string(argsBytes),
+						Arguments: arguments,
 					},
 				})
 			}
@@ -170,15 +97,59 @@ func convertMemoryToOpenRouterMessages(mem []memory.MemoryEntry) []gopenrouter.C
 				msg.ToolCalls = toolCalls
 				// Content is intentionally not set (null) when tool calls are present
 				// unless there's actual content (for interleaved thinking)
-				if entry.Message != "" {
-					msg.Content = entry.Message
+				if text := entry.Text(); text != "" {
+					msg.Content = text
 				}
+			} else if entry.HasMedia() {
+				msg.MultiContent = convertParts(entry.Parts)
 			} else {
-				msg.Content = entry.Message
+				msg.Content = entry.Text()
 			}
 
 			messages = append(messages, msg)
 		}
 	}
-	return messages
+	return messages, nil
+}
+
+// convertParts keeps the order of text and attachments, images go as data URLs and
+// files as OpenRouter file parts, which it parses for models that can't read PDFs
+func convertParts(parts []memory.Part) []gopenrouter.ChatCompletionMessagePart {
+	var content []gopenrouter.ChatCompletionMessagePart
+	for _, part := range parts {
+		part = part.Load()
+		switch {
+		case part.Type == memory.TextPart:
+			content = append(content, gopenrouter.ChatCompletionMessagePart{Type: "text", Text: part.Text})
+		case part.Type == memory.ImagePart:
+			content = append(content, gopenrouter.ChatCompletionMessagePart{
+				Type:     "image_url",
+				ImageURL: &gopenrouter.ImageURL{URL: part.DataURL()},
+			})
+		case part.IsTextFile():
+			content = append(content, gopenrouter.ChatCompletionMessagePart{Type: "text", Text: part.FileText()})
+		case part.Type == memory.FilePart:
+			content = append(content, gopenrouter.ChatCompletionMessagePart{
+				Type: "file",
+				File: &gopenrouter.File{Filename: filepath.Base(part.Path), FileData: part.DataURL()},
+			})
+		}
+	}
+	return content
+}
+
+// convertUsage maps OpenRouter's usage accounting, which already includes the cost
+func convertUsage(u gopenrouter.Usage) providers.Usage {
+	usage := providers.Usage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		Cost:             u.Cost,
+	}
+	if u.PromptTokensDetails != nil {
+		usage.CachedTokens = u.PromptTokensDetails.CachedTokens
+	}
+	if u.CompletionTokensDetails != nil {
+		usage.ReasoningTokens = u.CompletionTokensDetails.ReasoningTokens
+	}
+	return usage
 }
@@ -2,10 +2,12 @@ package openrouter_llm
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/iamwavecut/gopenrouter"
 	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
 	"github.com/mightymoud/arlocode/internal/butler/tools"
 )
 
@@ -121,17 +123,17 @@ func TestGenerateJSONSchema_Struct(t *testing.T) {
 
 func TestGetRoleFromMemoryEntry(t *testing.T) {
 	tests := []struct {
-		input    string
+		input    memory.Role
 		expected gopenrouter.ChatCompletionMessageRole
 	}{
-		{"user", gopenrouter.RoleUser},
-		{"assistant", gopenrouter.RoleAssistant},
-		{"system", gopenrouter.RoleSystem},
+		{memory.User, gopenrouter.RoleUser},
+		{memory.Model, gopenrouter.RoleAssistant},
+		{memory.System, gopenrouter.RoleSystem},
 		{"unknown", gopenrouter.RoleUser}, // default case
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.input, func(t *testing.T) {
+		t.Run(string(tt.input), func(t *testing.T) {
 			entry := memory.MemoryEntry{Role: tt.input}
 			role := getRoleFromMemoryEntry(entry)
 			if role != tt.expected {
@@ -143,14 +145,17 @@ func TestGetRoleFromMemoryEntry(t *testing.T) {
 
 func TestConvertMemoryToOpenRouterMessages(t *testing.T) {
 	mem := []memory.MemoryEntry{
-		{Role: "user", Message: "hello"},
-		{Role: "assistant", Message: "hi", ToolCalls: []tools.ToolCall{
+		memory.Text(memory.User, "hello"),
+		memory.Response("hi", []tools.ToolCall{
 			{ID: "1", FunctionName: "func", Arguments: map[string]any{"a": 1}},
-		}},
-		{Role: "tool", Message: "result", ToolCallID: "1"},
+		}),
+		memory.ToolResult(tools.ToolCall{ID: "1"}, "result", false),
 	}
 
-	messages := convertMemoryToOpenRouterMessages(mem)
+	messages, err := convertMemoryToOpenRouterMessages(mem)
+	if err != nil {
+		t.Fatalf("convertMemoryToOpenRouterMessages failed: %v", err)
+	}
 	if len(messages) != 3 {
 		t.Fatalf("expected 3 messages, got %d", len(messages))
 	}
@@ -188,3 +193,89 @@ func TestConvertMemoryToOpenRouterMessages(t *testing.T) {
 		t.Errorf("expected tool call ID 1, got %s", messages[2].ToolCallID)
 	}
 }
+
+func TestConvertMemoryToOpenRouterMessages_ToolArguments(t *testing.T) {
+	mem := []memory.MemoryEntry{memory.Response("", []tools.ToolCall{
+		{ID: "1", FunctionName: "raw", RawArguments: `{"path": "a.go"`}, // As the model sent it, cut short
+		{ID: "2", FunctionName: "none"},
+		{ID: "3", FunctionName: "decoded", Arguments: map[string]any{"path": "b.go"}},
+	})}
+
+	messages, err := convertMemoryToOpenRouterMessages(mem)
+	if err != nil {
+		t.Fatalf("convertMemoryToOpenRouterMessages failed: %v", err)
+	}
+	want := []string{`{"path": "a.go"`, "{}", `{"path":"b.go"}`}
+	for i, call := range messages[0].ToolCalls {
+		if call.Function.Arguments != want[i] {
+			t.Errorf("expected arguments %s for %s, got %s", want[i], call.Function.Name, call.Function.Arguments)
+		}
+	}
+
+	unencodable := memory.Response("", []tools.ToolCall{{ID: "1", FunctionName: "bad", Arguments: map[string]any{"f": func() {}}}})
+	if _, err := convertMemoryToOpenRouterMessages([]memory.MemoryEntry{unencodable}); err == nil {
+		t.Error("expected arguments that can't be encoded to fail")
+	}
+}
+
+func TestConvertMemoryToOpenRouterMessages_Attachments(t *testing.T) {
+	prompt := memory.Text(memory.User, "what's wrong here?")
+	prompt.Parts = append(prompt.Parts,
+		memory.Image("image/png", []byte("png")),
+		memory.File("docs/spec.pdf", "application/pdf", []byte("pdf")),
+		memory.File("main.go", "text/plain", []byte("package main")),
+	)
+
+	messages, err := convertMemoryToOpenRouterMessages([]memory.MemoryEntry{prompt})
+	if err != nil {
+		t.Fatalf("convertMemoryToOpenRouterMessages failed: %v", err)
+	}
+	if len(messages) != 1 || messages[0].Content != "" {
+		t.Fatalf("expected a single message with parts, got %+v", messages)
+	}
+	parts := messages[0].MultiContent
+	if len(parts) != 4 {
+		t.Fatalf("expected 4 parts, got %+v", parts)
+	}
+	if parts[0].Type != "text" || parts[0].Text != "what's wrong here?" {
+		t.Errorf("expected the prompt first, got %+v", parts[0])
+	}
+	if parts[1].Type != "image_url" || parts[1].ImageURL.URL != "data:image/png;base64,cG5n" {
+		t.Errorf("expected the image as a data URL, got %+v", parts[1])
+	}
+	if parts[2].Type != "file" || parts[2].File.Filename != "spec.pdf" || parts[2].File.FileData != "data:application/pdf;base64,cGRm" {
+		t.Errorf("expected the PDF as a file, got %+v", parts[2])
+	}
+	if parts[3].Type != "text" || !strings.Contains(parts[3].Text, "package main") || !strings.Contains(parts[3].Text, `"main.go"`) {
+		t.Errorf("expected the source file as text, got %+v", parts[3])
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	temperature := 0.2
+	l := OpenRouterLLM{Config: providers.GenerationConfig{Temperature: &temperature, MaxTokens: 4096, ReasoningTokens: 8000}}
+
+	req := gopenrouter.ChatCompletionRequest{Reasoning: &gopenrouter.ReasoningParams{MaxTokens: defaultReasoningTokens}}
+	l.applyConfig(&req)
+
+	if req.Temperature != 0.2 || req.MaxTokens != 4096 || req.Reasoning.MaxTokens != 8000 {
+		t.Errorf("Expected config to be applied, got temperature %v, max tokens %d, reasoning %d", req.Temperature, req.MaxTokens, req.Reasoning.MaxTokens)
+	}
+	if req.TopP != 0 {
+		t.Errorf("Expected unset TopP to keep the provider default, got %v", req.TopP)
+	}
+}
+
+func TestConvertUsage(t *testing.T) {
+	usage := convertUsage(gopenrouter.Usage{
+		PromptTokens:            1200,
+		CompletionTokens:        300,
+		Cost:                    0.0042,
+		PromptTokensDetails:     &gopenrouter.TokensDetails{CachedTokens: 1000},
+		CompletionTokensDetails: &gopenrouter.TokensDetails{ReasoningTokens: 120},
+	})
+	want := providers.Usage{PromptTokens: 1200, CompletionTokens: 300, ReasoningTokens: 120, CachedTokens: 1000, Cost: 0.0042}
+	if usage != want {
+		t.Errorf("Expected %+v, got %+v", want, usage)
+	}
+}
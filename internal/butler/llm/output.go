@@ -0,0 +1,29 @@
+package llm
+
+import (
+	"context"
+	"reflect"
+)
+
+// OutputSchema asks the model to answer with JSON matching a Go type instead of prose
+type OutputSchema struct {
+	Name string       // Providers that name schemas get it, letters, digits, _ and - only
+	Type reflect.Type // Described with the same rules as tool arguments, see tools.JSONSchema
+}
+
+type outputSchemaKey struct{}
+
+// WithOutputSchema asks every model called with the returned context for JSON matching schema.
+// Providers with a native structured output mode, see Info.HoldsToSchema, hold the model
+// to it, the others ignore it and the caller has to ask for the format in the prompt.
+// The context passes through wrappers like retry and the router untouched, so it should
+// only reach the calls meant to answer in the format, not summaries or sub-agents.
+func WithOutputSchema(ctx context.Context, schema OutputSchema) context.Context {
+	return context.WithValue(ctx, outputSchemaKey{}, schema)
+}
+
+// OutputSchemaFrom returns the schema set with WithOutputSchema, providers call it when building a request
+func OutputSchemaFrom(ctx context.Context) (OutputSchema, bool) {
+	schema, ok := ctx.Value(outputSchemaKey{}).(OutputSchema)
+	return schema, ok && schema.Type != nil
+}
@@ -0,0 +1,145 @@
+package retry
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// Config controls how often and how patiently failed calls are retried.
+type Config struct {
+	MaxAttempts int           // Total attempts including the first one
+	BaseDelay   time.Duration // Delay before the first retry, doubled on every attempt
+	MaxDelay    time.Duration // Upper bound for every wait, a longer Retry-After ends the retries
+	Jitter      float64       // Fraction of the delay that is randomised, 0 disables jitter
+
+	// OnRetry is called before sleeping, useful for logging
+	OnRetry func(attempt int, delay time.Duration, err error)
+}
+
+// DefaultConfig suits long unattended runs: a handful of attempts spread over about a minute
+func DefaultConfig() Config {
+	return Config{
+		MaxAttempts: 5,
+		BaseDelay:   time.Second,
+		MaxDelay:    30 * time.Second,
+		Jitter:      0.5,
+	}
+}
+
+// RetryLLM retries rate limited and transient failures of the wrapped LLM.
+// Retry-After from the provider takes precedence over the computed backoff, unless it is
+// longer than MaxDelay: the error is returned then rather than stalling the run.
+type RetryLLM struct {
+	inner  llm.LLM
+	config Config
+	sleep  func(ctx context.Context, d time.Duration) error
+}
+
+func New(inner llm.LLM, config Config) *RetryLLM {
+	return &RetryLLM{
+		inner:  inner,
+		config: config,
+		sleep:  sleepContext,
+	}
+}
+
+// Middleware retries the models it wraps with config, see llm.Chain
+func Middleware(config Config) llm.Middleware {
+	return func(inner llm.LLM) llm.LLM {
+		return New(inner, config)
+	}
+}
+
+func (r *RetryLLM) Describe() llm.Info {
+	return llm.Describe(r.inner)
+}
+
+// Stream retries the wrapped stream.
+// A stream that already emitted chunks is only restarted when the frontend can discard them
+// through OnStreamReset, otherwise the error is returned as is.
+func (r *RetryLLM) Stream(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+	for attempt := 1; ; attempt++ {
+		emitted := false
+		resp, err := r.inner.Stream(ctx, mem, agentTools, llm.TrackEmission(hooks, &emitted))
+		if !r.shouldRetry(ctx, attempt, err) {
+			return resp, err
+		}
+
+		if emitted {
+			if hooks.OnStreamReset == nil {
+				return resp, err
+			}
+			hooks.OnStreamReset()
+		}
+
+		if sleepErr := r.wait(ctx, attempt, err); sleepErr != nil {
+			return resp, sleepErr
+		}
+	}
+}
+
+func (r *RetryLLM) Generate(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) error {
+	for attempt := 1; ; attempt++ {
+		err := r.inner.Generate(ctx, mem, agentTools, hooks)
+		if !r.shouldRetry(ctx, attempt, err) {
+			return err
+		}
+		if sleepErr := r.wait(ctx, attempt, err); sleepErr != nil {
+			return sleepErr
+		}
+	}
+}
+
+func (r *RetryLLM) shouldRetry(ctx context.Context, attempt int, err error) bool {
+	if err == nil || ctx.Err() != nil || attempt >= r.config.MaxAttempts || !butler.IsRetryable(err) {
+		return false
+	}
+	retryAfter, ok := butler.RetryAfter(err)
+	return !ok || r.config.MaxDelay <= 0 || retryAfter <= r.config.MaxDelay
+}
+
+func (r *RetryLLM) wait(ctx context.Context, attempt int, err error) error {
+	delay := r.Backoff(attempt, err)
+	if r.config.OnRetry != nil {
+		r.config.OnRetry(attempt, delay, err)
+	}
+	return r.sleep(ctx, delay)
+}
+
+// Backoff returns the delay before the retry following the given attempt, never more than MaxDelay
+func (r *RetryLLM) Backoff(attempt int, err error) time.Duration {
+	if retryAfter, ok := butler.RetryAfter(err); ok {
+		if r.config.MaxDelay > 0 {
+			return min(retryAfter, r.config.MaxDelay)
+		}
+		return retryAfter
+	}
+
+	delay := r.config.BaseDelay << (attempt - 1)
+	if delay <= 0 || (r.config.MaxDelay > 0 && delay > r.config.MaxDelay) {
+		delay = r.config.MaxDelay
+	}
+	if r.config.Jitter > 0 {
+		spread := time.Duration(float64(delay) * r.config.Jitter)
+		delay = delay - spread + time.Duration(rand.Int64N(int64(spread)+1))
+	}
+	return delay
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
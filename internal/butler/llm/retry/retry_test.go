@@ -0,0 +1,185 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// scriptedLLM returns the scripted errors in order, then succeeds
+type scriptedLLM struct {
+	errs     []error
+	emit     bool
+	attempts int
+}
+
+func (s *scriptedLLM) Stream(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+	s.attempts++
+	if s.emit && hooks.OnTextChunk != nil {
+		hooks.OnTextChunk("partial")
+	}
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return providers.ProviderResponse{Text: "partial"}, err
+	}
+	return providers.ProviderResponse{Text: "done"}, nil
+}
+
+func (s *scriptedLLM) Generate(ctx context.Context, mem []memory.MemoryEntry, t []tools.Tool, hooks butler.EventHooks) error {
+	s.attempts++
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return err
+	}
+	return nil
+}
+
+func transientErr() error {
+	return &butler.ProviderError{Kind: butler.ErrTransient, Provider: "test"}
+}
+
+func newTestRetry(inner *scriptedLLM, config Config) (*RetryLLM, *[]time.Duration) {
+	var slept []time.Duration
+	r := New(inner, config)
+	r.sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+	return r, &slept
+}
+
+func TestStream_RetriesTransientErrors(t *testing.T) {
+	inner := &scriptedLLM{errs: []error{transientErr(), transientErr()}}
+	r, slept := newTestRetry(inner, Config{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute})
+
+	resp, err := r.Stream(context.Background(), nil, nil, butler.EventHooks{})
+	if err != nil {
+		t.Fatalf("Expected success after retries, got %v", err)
+	}
+	if resp.Text != "done" {
+		t.Errorf("Expected final response, got %q", resp.Text)
+	}
+	if inner.attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", inner.attempts)
+	}
+	if len(*slept) != 2 || (*slept)[0] != time.Second || (*slept)[1] != 2*time.Second {
+		t.Errorf("Expected exponential backoff of 1s, 2s, got %v", *slept)
+	}
+}
+
+func TestStream_GivesUpAfterMaxAttempts(t *testing.T) {
+	inner := &scriptedLLM{errs: []error{transientErr(), transientErr(), transientErr()}}
+	r, _ := newTestRetry(inner, Config{MaxAttempts: 2, BaseDelay: time.Millisecond})
+
+	_, err := r.Stream(context.Background(), nil, nil, butler.EventHooks{})
+	if !errors.Is(err, butler.ErrTransient) {
+		t.Errorf("Expected transient error, got %v", err)
+	}
+	if inner.attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", inner.attempts)
+	}
+}
+
+func TestStream_DoesNotRetryPermanentErrors(t *testing.T) {
+	inner := &scriptedLLM{errs: []error{&butler.ProviderError{Kind: butler.ErrAuth, Provider: "test"}}}
+	r, _ := newTestRetry(inner, DefaultConfig())
+
+	_, err := r.Stream(context.Background(), nil, nil, butler.EventHooks{})
+	if !errors.Is(err, butler.ErrAuth) {
+		t.Errorf("Expected auth error, got %v", err)
+	}
+	if inner.attempts != 1 {
+		t.Errorf("Expected a single attempt, got %d", inner.attempts)
+	}
+}
+
+func TestStream_RespectsRetryAfter(t *testing.T) {
+	rateLimited := butler.NewProviderError("test", 429, "", "", nil).WithRetryAfter("9")
+	inner := &scriptedLLM{errs: []error{rateLimited}}
+	r, slept := newTestRetry(inner, Config{MaxAttempts: 2, BaseDelay: time.Second, MaxDelay: time.Minute, Jitter: 0.5})
+
+	if _, err := r.Stream(context.Background(), nil, nil, butler.EventHooks{}); err != nil {
+		t.Fatalf("Expected success after retry, got %v", err)
+	}
+	if len(*slept) != 1 || (*slept)[0] != 9*time.Second {
+		t.Errorf("Expected to wait the Retry-After of 9s, got %v", *slept)
+	}
+}
+
+func TestStream_GivesUpOnLongRetryAfter(t *testing.T) {
+	rateLimited := butler.NewProviderError("test", 429, "", "", nil).WithRetryAfter("3600")
+	inner := &scriptedLLM{errs: []error{rateLimited}}
+	r, slept := newTestRetry(inner, Config{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute})
+
+	_, err := r.Stream(context.Background(), nil, nil, butler.EventHooks{})
+	if !errors.Is(err, butler.ErrRateLimited) {
+		t.Errorf("Expected the rate limit error, got %v", err)
+	}
+	if inner.attempts != 1 || len(*slept) != 0 {
+		t.Errorf("Expected no retry when Retry-After exceeds MaxDelay, got %d attempts and waits %v", inner.attempts, *slept)
+	}
+	if delay := r.Backoff(1, rateLimited); delay != time.Minute {
+		t.Errorf("Expected Backoff to stay within MaxDelay, got %s", delay)
+	}
+}
+
+func TestStream_EmittedChunks(t *testing.T) {
+	// Without a reset hook the partial output can't be taken back, so no retry
+	inner := &scriptedLLM{errs: []error{transientErr()}, emit: true}
+	r, _ := newTestRetry(inner, DefaultConfig())
+
+	hooks := butler.EventHooks{OnTextChunk: func(string) {}}
+	if _, err := r.Stream(context.Background(), nil, nil, hooks); err == nil {
+		t.Error("Expected error when chunks were emitted and no reset hook is set")
+	}
+	if inner.attempts != 1 {
+		t.Errorf("Expected a single attempt, got %d", inner.attempts)
+	}
+
+	// With a reset hook the frontend discards the partial chunk and the stream restarts
+	inner = &scriptedLLM{errs: []error{transientErr()}, emit: true}
+	r, _ = newTestRetry(inner, DefaultConfig())
+	resets := 0
+	hooks.OnStreamReset = func() { resets++ }
+
+	if _, err := r.Stream(context.Background(), nil, nil, hooks); err != nil {
+		t.Fatalf("Expected success after reset, got %v", err)
+	}
+	if resets != 1 {
+		t.Errorf("Expected one reset, got %d", resets)
+	}
+}
+
+func TestBackoff_CappedWithJitter(t *testing.T) {
+	r := New(&scriptedLLM{}, Config{BaseDelay: time.Second, MaxDelay: 10 * time.Second, Jitter: 0.5})
+
+	for attempt := 1; attempt <= 10; attempt++ {
+		delay := r.Backoff(attempt, transientErr())
+		if delay > 10*time.Second {
+			t.Errorf("attempt %d: delay %s exceeds max", attempt, delay)
+		}
+		if delay <= 0 {
+			t.Errorf("attempt %d: expected positive delay, got %s", attempt, delay)
+		}
+	}
+}
+
+func TestGenerate_Retries(t *testing.T) {
+	inner := &scriptedLLM{errs: []error{transientErr()}}
+	r, _ := newTestRetry(inner, DefaultConfig())
+
+	if err := r.Generate(context.Background(), nil, nil, butler.EventHooks{}); err != nil {
+		t.Fatalf("Expected success after retry, got %v", err)
+	}
+	if inner.attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", inner.attempts)
+	}
+}
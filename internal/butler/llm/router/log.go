@@ -0,0 +1,36 @@
+package router
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+)
+
+// logEntry is one line of the log written by Log
+type logEntry struct {
+	Time     time.Time       `json:"time"`
+	Model    string          `json:"model"`
+	Reason   string          `json:"reason"`
+	Fallback bool            `json:"fallback,omitempty"`
+	Usage    providers.Usage `json:"usage,omitzero"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// Log returns an OnDecision that writes every decision to w as a JSON line.
+// Failed writes are ignored, the log must never fail a call.
+func Log(w io.Writer) func(Decision) {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+	return func(d Decision) {
+		entry := logEntry{Time: d.Time, Model: d.Model, Reason: d.Reason, Fallback: d.Fallback, Usage: d.Usage}
+		if d.Err != nil {
+			entry.Error = d.Err.Error()
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		_ = encoder.Encode(entry)
+	}
+}
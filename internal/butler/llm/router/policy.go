@@ -0,0 +1,61 @@
+package router
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+)
+
+// Escalation sends the follow-ups of read-only tool calls to the cheap model and every other call
+// to the strong one: starting a turn, following up on edits or commands, and the rest of a turn
+// in which an edit failed.
+type Escalation struct {
+	Cheap    string
+	Strong   string
+	ReadOnly []string // Tools whose results the cheap model follows up on, e.g. read_file and search_code
+	Edits    []string // Tools whose failure hands the rest of the turn to the strong model
+}
+
+// Route is the Policy of the escalation
+func (e Escalation) Route(req Request) (model, reason string) {
+	turn := currentTurn(req.Messages)
+	if len(turn) == 0 {
+		return e.Strong, "new prompt"
+	}
+	for _, entry := range turn {
+		if result, ok := entry.ToolResult(); ok && result.IsError && slices.Contains(e.Edits, result.ToolName) {
+			return e.Strong, fmt.Sprintf("%s failed in this turn", result.ToolName)
+		}
+	}
+
+	last := turn[len(turn)-1]
+	if last.Role != memory.Tool {
+		return e.Strong, "no tool results to follow up on"
+	}
+	var called []string
+	for i := len(turn) - 1; i >= 0 && turn[i].Role == memory.Tool; i-- {
+		result, _ := turn[i].ToolResult()
+		if !slices.Contains(called, result.ToolName) {
+			called = append(called, result.ToolName)
+		}
+	}
+	slices.Sort(called)
+	for _, name := range called {
+		if !slices.Contains(e.ReadOnly, name) {
+			return e.Strong, "follows up on " + name
+		}
+	}
+	return e.Cheap, "follows up on " + strings.Join(called, ", ")
+}
+
+// currentTurn returns the entries after the last user prompt
+func currentTurn(entries []memory.MemoryEntry) []memory.MemoryEntry {
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Role == memory.User {
+			return entries[i+1:]
+		}
+	}
+	return entries
+}
@@ -0,0 +1,231 @@
+// Package router spreads the calls of an agent over several models: a policy picks the model
+// of every call, e.g. a cheap one for follow-ups on file reads, and the other models take over
+// when it fails. Every decision is reported with its reason, so the savings can be checked.
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// Model is one of the models a Router picks from
+type Model struct {
+	Name string // How policies and decisions refer to it, usually the model ID
+	LLM  llm.LLM
+}
+
+// Request is what a Policy decides on
+type Request struct {
+	Messages []memory.MemoryEntry
+	Tools    []tools.Tool
+}
+
+// Policy picks the model of a call by name and says why.
+// An empty name leaves the call to the first model.
+type Policy func(req Request) (model, reason string)
+
+// Decision is one attempt of a call, reported through Config.OnDecision
+type Decision struct {
+	Time     time.Time
+	Model    string
+	Reason   string          // Why the policy picked the model, or why the router fell back to it
+	Fallback bool            // An earlier model failed this call
+	Usage    providers.Usage // What the attempt cost, zero when it failed
+	Err      error
+}
+
+// Config lists the models and how to pick between them
+type Config struct {
+	// Models in fallback order: when a model fails the others are tried in this order.
+	// The first one is the default.
+	Models []Model
+
+	Policy     Policy           // Picks the first model tried, nil always starts with the first one
+	FallbackOn func(error) bool // Failures another model may not have, DefaultFallbackOn when nil
+	OnDecision func(Decision)   // Called after every attempt, e.g. to log it, see Log
+}
+
+// DefaultFallbackOn falls back on rate limits, outages, prompts too long for the model and
+// rejected credentials, which may belong to one provider only. Content filters and
+// malformed requests would fail the same way elsewhere.
+func DefaultFallbackOn(err error) bool {
+	return butler.IsRetryable(err) || errors.Is(err, butler.ErrContextTooLong) || errors.Is(err, butler.ErrAuth)
+}
+
+// Stats is what the calls routed to one model cost
+type Stats struct {
+	Model    string
+	Calls    int // Attempts, failed ones included
+	Failures int
+	Usage    providers.Usage
+}
+
+// Router is an llm.LLM that routes every call to one of several models.
+// Describe names the first model, the decisions say which one answered.
+type Router struct {
+	config Config
+
+	mu     sync.Mutex
+	pinned string
+	stats  []Stats
+}
+
+func New(config Config) *Router {
+	if config.FallbackOn == nil {
+		config.FallbackOn = DefaultFallbackOn
+	}
+	stats := make([]Stats, len(config.Models))
+	for i, m := range config.Models {
+		stats[i].Model = m.Name
+	}
+	return &Router{config: config, stats: stats}
+}
+
+func (r *Router) Describe() llm.Info {
+	if len(r.config.Models) == 0 {
+		return llm.Info{}
+	}
+	return llm.Describe(r.config.Models[0].LLM)
+}
+
+// Pin sends every call to the named model first, whatever the policy says, e.g. when the user asks
+// for the strong model. Failures still fall back. An empty name hands the calls back to the policy.
+func (r *Router) Pin(name string) error {
+	if name != "" && r.index(name) < 0 {
+		return fmt.Errorf("unknown model %q, expected one of %v", name, r.Models())
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pinned = name
+	return nil
+}
+
+// Pinned is the model set with Pin, empty when the policy decides
+func (r *Router) Pinned() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pinned
+}
+
+// Models lists the names of the models in fallback order
+func (r *Router) Models() []string {
+	names := make([]string, len(r.config.Models))
+	for i, m := range r.config.Models {
+		names[i] = m.Name
+	}
+	return names
+}
+
+// Stats returns what every model was used for so far, in fallback order
+func (r *Router) Stats() []Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.stats)
+}
+
+// Stream runs the call on the picked model and falls back to the others when it fails.
+// Like retries, a model that already streamed chunks is only replaced when the frontend
+// can discard them through OnStreamReset.
+func (r *Router) Stream(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) (providers.ProviderResponse, error) {
+	var resp providers.ProviderResponse
+	err := r.route(ctx, Request{Messages: mem, Tools: agentTools}, func(l llm.LLM, emitted *bool) (providers.Usage, error) {
+		var err error
+		resp, err = l.Stream(ctx, mem, agentTools, llm.TrackEmission(hooks, emitted))
+		return resp.Usage, err
+	}, hooks.OnStreamReset)
+	return resp, err
+}
+
+func (r *Router) Generate(ctx context.Context, mem []memory.MemoryEntry, agentTools []tools.Tool, hooks butler.EventHooks) error {
+	return r.route(ctx, Request{Messages: mem, Tools: agentTools}, func(l llm.LLM, emitted *bool) (providers.Usage, error) {
+		return providers.Usage{}, l.Generate(ctx, mem, agentTools, llm.TrackEmission(hooks, emitted))
+	}, hooks.OnStreamReset)
+}
+
+func (r *Router) route(ctx context.Context, req Request, call func(l llm.LLM, emitted *bool) (providers.Usage, error), onReset func()) error {
+	if len(r.config.Models) == 0 {
+		return errors.New("router has no models")
+	}
+	first, reason := r.pick(req)
+
+	var err error
+	order := r.order(first)
+	for n, i := range order {
+		if n > 0 {
+			reason = fmt.Sprintf("%s failed: %v", r.config.Models[order[n-1]].Name, err)
+		}
+		emitted := false
+		var usage providers.Usage
+		usage, err = call(r.config.Models[i].LLM, &emitted)
+		r.record(Decision{Time: time.Now(), Model: r.config.Models[i].Name, Reason: reason, Fallback: n > 0, Usage: usage, Err: err}, i)
+
+		if err == nil || ctx.Err() != nil || !r.config.FallbackOn(err) {
+			return err
+		}
+		if emitted {
+			if onReset == nil {
+				return err
+			}
+			onReset()
+		}
+	}
+	return err
+}
+
+// pick asks the policy, or the pin, which model to try first
+func (r *Router) pick(req Request) (int, string) {
+	if pinned := r.Pinned(); pinned != "" {
+		return r.index(pinned), "pinned by the user"
+	}
+	if r.config.Policy == nil {
+		return 0, "default model"
+	}
+	name, reason := r.config.Policy(req)
+	if i := r.index(name); i >= 0 {
+		return i, reason
+	}
+	if name != "" {
+		reason = fmt.Sprintf("policy picked unknown model %q", name)
+	}
+	return 0, reason
+}
+
+// order is the picked model followed by the others in fallback order
+func (r *Router) order(first int) []int {
+	order := []int{first}
+	for i := range r.config.Models {
+		if i != first {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+func (r *Router) index(name string) int {
+	return slices.IndexFunc(r.config.Models, func(m Model) bool { return m.Name == name })
+}
+
+func (r *Router) record(d Decision, i int) {
+	r.mu.Lock()
+	stats := &r.stats[i]
+	stats.Calls++
+	if d.Err != nil {
+		stats.Failures++
+	}
+	stats.Usage = stats.Usage.Add(d.Usage)
+	r.mu.Unlock()
+
+	if r.config.OnDecision != nil {
+		r.config.OnDecision(d)
+	}
+}
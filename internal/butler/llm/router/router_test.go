@@ -0,0 +1,149 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/llm/fake"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+func TestRouter_FallsBack(t *testing.T) {
+	strong := fake.New(t).Turn().Fail(fake.ProviderError(butler.ErrRateLimited))
+	backup := fake.New(t).Turn().Text("from backup").Usage(providers.Usage{PromptTokens: 10})
+	var decisions []Decision
+	r := New(Config{
+		Models:     []Model{{Name: "strong", LLM: strong}, {Name: "backup", LLM: backup}},
+		OnDecision: func(d Decision) { decisions = append(decisions, d) },
+	})
+
+	resp, err := r.Stream(context.Background(), nil, nil, butler.EventHooks{})
+	if err != nil || resp.Text != "from backup" {
+		t.Fatalf("Expected the backup to answer, got %q, %v", resp.Text, err)
+	}
+	if len(decisions) != 2 || decisions[0].Model != "strong" || decisions[0].Err == nil ||
+		decisions[1].Model != "backup" || !decisions[1].Fallback || !strings.Contains(decisions[1].Reason, "strong failed") {
+		t.Errorf("Unexpected decisions: %+v", decisions)
+	}
+	stats := r.Stats()
+	if stats[0].Calls != 1 || stats[0].Failures != 1 || stats[1].Calls != 1 || stats[1].Usage.PromptTokens != 10 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestRouter_KeepsErrorsOtherModelsWouldHaveToo(t *testing.T) {
+	backup := fake.New(t)
+	r := New(Config{Models: []Model{
+		{Name: "strong", LLM: fake.New(t).Turn().Fail(fake.ProviderError(butler.ErrContentFiltered))},
+		{Name: "backup", LLM: backup},
+	}})
+
+	if _, err := r.Stream(context.Background(), nil, nil, butler.EventHooks{}); !errors.Is(err, butler.ErrContentFiltered) {
+		t.Errorf("Expected the content filter error, got %v", err)
+	}
+	if len(backup.Requests()) != 0 {
+		t.Error("Expected no fallback for a content filter")
+	}
+}
+
+func TestRouter_FallbackAfterChunksNeedsReset(t *testing.T) {
+	models := func() []Model {
+		return []Model{
+			{Name: "strong", LLM: fake.New(t).Turn().Chunks("half an ans").Fail(fake.ProviderError(butler.ErrTransient))},
+			{Name: "backup", LLM: fake.New(t).Turn().Text("a full answer")},
+		}
+	}
+	onChunk := func(string) {}
+
+	r := New(Config{Models: models()})
+	if _, err := r.Stream(context.Background(), nil, nil, butler.EventHooks{OnTextChunk: onChunk}); !errors.Is(err, butler.ErrTransient) {
+		t.Errorf("Expected the error without OnStreamReset, got %v", err)
+	}
+
+	resets := 0
+	r = New(Config{Models: models()})
+	resp, err := r.Stream(context.Background(), nil, nil, butler.EventHooks{OnTextChunk: onChunk, OnStreamReset: func() { resets++ }})
+	if err != nil || resp.Text != "a full answer" || resets != 1 {
+		t.Errorf("Expected one reset and the backup's answer, got %q, %v after %d resets", resp.Text, err, resets)
+	}
+}
+
+func TestRouter_PolicyAndPin(t *testing.T) {
+	cheap := fake.New(t).Turn().Text("cheap").Turn().Text("cheap again")
+	strong := fake.New(t).Turn().Text("strong")
+	var decisions []Decision
+	r := New(Config{
+		Models:     []Model{{Name: "strong", LLM: strong}, {Name: "cheap", LLM: cheap}},
+		Policy:     func(Request) (string, string) { return "cheap", "always cheap" },
+		OnDecision: func(d Decision) { decisions = append(decisions, d) },
+	})
+
+	if resp, _ := r.Stream(context.Background(), nil, nil, butler.EventHooks{}); resp.Text != "cheap" {
+		t.Errorf("Expected the policy's model, got %q", resp.Text)
+	}
+	if err := r.Pin("strong"); err != nil {
+		t.Fatalf("Pin failed: %v", err)
+	}
+	if resp, _ := r.Stream(context.Background(), nil, nil, butler.EventHooks{}); resp.Text != "strong" {
+		t.Errorf("Expected the pinned model, got %q", resp.Text)
+	}
+	r.Pin("")
+	if resp, _ := r.Stream(context.Background(), nil, nil, butler.EventHooks{}); resp.Text != "cheap again" {
+		t.Errorf("Expected the policy to decide again, got %q", resp.Text)
+	}
+	if decisions[1].Reason != "pinned by the user" {
+		t.Errorf("Expected the pin as reason, got %q", decisions[1].Reason)
+	}
+	if err := r.Pin("huge"); err == nil {
+		t.Error("Expected an error for an unknown model")
+	}
+}
+
+func TestEscalation_Route(t *testing.T) {
+	e := Escalation{
+		Cheap:    "cheap",
+		Strong:   "strong",
+		ReadOnly: []string{"read_file", "search_code"},
+		Edits:    []string{"apply_edit"},
+	}
+	prompt := memory.Text(memory.User, "fix the bug")
+	read := memory.ToolResult(tools.ToolCall{FunctionName: "read_file"}, "package main", false)
+	search := memory.ToolResult(tools.ToolCall{FunctionName: "search_code"}, "main.go:3", false)
+	edit := memory.ToolResult(tools.ToolCall{FunctionName: "apply_edit"}, "edited", false)
+	failedEdit := memory.ToolResult(tools.ToolCall{FunctionName: "apply_edit"}, "error: no match", true)
+	model := memory.Text(memory.Model, "let me look")
+
+	tests := []struct {
+		name     string
+		messages []memory.MemoryEntry
+		want     string
+	}{
+		{"new prompt", []memory.MemoryEntry{prompt}, "strong"},
+		{"after reads", []memory.MemoryEntry{prompt, model, read, search}, "cheap"},
+		{"after an edit", []memory.MemoryEntry{prompt, model, read, model, edit}, "strong"},
+		{"after a failed edit", []memory.MemoryEntry{prompt, model, failedEdit, model, read}, "strong"},
+		{"failed edit of an earlier turn", []memory.MemoryEntry{failedEdit, prompt, model, read}, "cheap"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := e.Route(Request{Messages: tt.messages})
+			if got != tt.want || reason == "" {
+				t.Errorf("Expected %s, got %s (%s)", tt.want, got, reason)
+			}
+		})
+	}
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	Log(&buf)(Decision{Model: "cheap", Reason: "follows up on read_file", Err: errors.New("boom")})
+	if got := buf.String(); !strings.Contains(got, `"model":"cheap"`) || !strings.Contains(got, `"error":"boom"`) {
+		t.Errorf("Unexpected log line: %s", got)
+	}
+}
@@ -0,0 +1,51 @@
+package memory
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaxAttachmentSize is the largest file Attach reads, providers reject bigger inline data anyway
+const MaxAttachmentSize = 20 << 20
+
+// Attach reads the file at path into a part to send with a prompt, an image part for
+// images and a file part for anything else. The MIME type comes from the extension,
+// or from the content when the extension is unknown.
+func Attach(path string) (Part, error) {
+	// File parts are saved as a reference, it must still point at the file from another directory
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Part{}, err
+	}
+	if info.IsDir() {
+		return Part{}, fmt.Errorf("%s is a directory", path)
+	}
+	if info.Size() > MaxAttachmentSize {
+		return Part{}, fmt.Errorf("%s is %d MB, attachments are limited to %d MB", path, info.Size()>>20, MaxAttachmentSize>>20)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Part{}, err
+	}
+
+	mimeType := mime.TypeByExtension(filepath.Ext(path))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	// Drop parameters such as "; charset=utf-8", providers want the bare type
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+
+	if strings.HasPrefix(mimeType, "image/") {
+		part := Image(mimeType, data)
+		part.Path = path
+		return part, nil
+	}
+	return File(path, mimeType, data), nil
+}
@@ -1,11 +1,295 @@
 package memory
 
-import "github.com/mightymoud/arlocode/internal/butler/tools"
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
 
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// Role tells who an entry comes from
+type Role string
+
+const (
+	System Role = "system" // Instructions sent ahead of the conversation
+	User   Role = "user"
+	Model  Role = "model"
+	Tool   Role = "tool" // The result of a tool call the model asked for
+)
+
+// PartType is the kind of content a Part holds
+type PartType string
+
+const (
+	TextPart       PartType = "text"
+	ImagePart      PartType = "image"    // Data holds the image, MIMEType its format
+	FilePart       PartType = "file"     // A file attached to a prompt, saved as a reference to Path, see Load
+	ThinkingPart   PartType = "thinking" // Reasoning the model streamed before answering, never sent back
+	ToolCallPart   PartType = "tool_call"
+	ToolResultPart PartType = "tool_result"
+)
+
+// Part is one piece of the content of an entry, entries keep their parts in order
+// so text and images can alternate the way the user pasted them
+type Part struct {
+	Type       PartType        `json:"type"`
+	Text       string          `json:"text,omitempty"`         // Text and thinking parts, the output of tool results
+	MIMEType   string          `json:"mime_type,omitempty"`    // Image and file parts
+	Data       []byte          `json:"data,omitempty"`         // Image and file parts, base64 in JSON. Only images keep it when saved
+	Path       string          `json:"path,omitempty"`         // File parts and images read from a file, the name the user and the model know it by
+	Digest     string          `json:"digest,omitempty"`       // File parts, "sha256:" and the hex hash of the content when it was attached
+	ToolCall   *tools.ToolCall `json:"tool_call,omitempty"`    // Tool call parts
+	ToolCallID string          `json:"tool_call_id,omitempty"` // Tool result parts, the call they answer
+	ToolName   string          `json:"tool_name,omitempty"`    // Tool result parts
+	IsError    bool            `json:"is_error,omitempty"`     // Tool results whose call failed, the text starts with "error:"
+}
+
+// MemoryEntry is one message of the conversation. Entries form a tree through their parents,
+// a memory is one branch of it: each entry's Parent is the ID of the entry before it.
 type MemoryEntry struct {
-	Message    string
-	Role       string
-	ToolName   string
-	ToolCallID string
-	ToolCalls  []tools.ToolCall
+	ID          string `json:"id,omitempty"`
+	Parent      string `json:"parent,omitempty"` // Empty for the first entry of a branch
+	Role        Role   `json:"role"`
+	Parts       []Part `json:"parts,omitempty"`
+	Interrupted bool   `json:"interrupted,omitempty"` // The run was cancelled while this entry was being produced
+	Tokens      int    `json:"tokens,omitempty"`      // Estimated size of the entry in tokens, 0 when it hasn't been counted yet
+}
+
+// NewID returns a random entry ID
+func NewID() string {
+	id := make([]byte, 6)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Link chains the entries into a branch, each one's parent being the entry before it.
+// An ID names a place in the tree, so entries without one and entries moved under another
+// parent, e.g. the ones compaction keeps, get a new ID. The entries aren't modified.
+func Link(entries []MemoryEntry) []MemoryEntry {
+	linked := slices.Clone(entries)
+	parent := ""
+	for i := range linked {
+		if linked[i].ID == "" || linked[i].Parent != parent {
+			linked[i].ID, linked[i].Parent = NewID(), parent
+		}
+		parent = linked[i].ID
+	}
+	return linked
+}
+
+// Text is an entry holding only text, like a prompt or an answer without tool calls
+func Text(role Role, text string) MemoryEntry {
+	return MemoryEntry{Role: role, Parts: []Part{{Type: TextPart, Text: text}}}
+}
+
+// Response is the answer of the model, the text comes before the tool calls
+func Response(text string, calls []tools.ToolCall) MemoryEntry {
+	entry := MemoryEntry{Role: Model}
+	if text != "" {
+		entry.Parts = append(entry.Parts, Part{Type: TextPart, Text: text})
+	}
+	for _, call := range calls {
+		entry.Parts = append(entry.Parts, Part{Type: ToolCallPart, ToolCall: &call})
+	}
+	return entry
+}
+
+// ToolResult answers a tool call of the model with the output of the tool
+func ToolResult(call tools.ToolCall, output string, isError bool) MemoryEntry {
+	return MemoryEntry{Role: Tool, Parts: []Part{{
+		Type:       ToolResultPart,
+		Text:       output,
+		ToolCallID: call.ID,
+		ToolName:   call.FunctionName,
+		IsError:    isError,
+	}}}
+}
+
+// Image is a part holding an image, e.g. a screenshot pasted into the prompt
+func Image(mimeType string, data []byte) Part {
+	return Part{Type: ImagePart, MIMEType: mimeType, Data: data}
+}
+
+// File is a part holding a file attached to the prompt
+func File(path, mimeType string, data []byte) Part {
+	return Part{Type: FilePart, Path: path, MIMEType: mimeType, Data: data, Digest: digest(data)}
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// MarshalJSON saves file parts that have a path as a reference, sessions would otherwise
+// hold a copy of every attachment in every entry written. Load reads the content back.
+func (p Part) MarshalJSON() ([]byte, error) {
+	type part Part
+	if p.Type == FilePart && p.Path != "" {
+		p.Data = nil
+	}
+	return json.Marshal(part(p))
+}
+
+// Load returns a file part with its content, read from Path when the part was saved as a
+// reference. A file that is gone or changed since it was attached becomes a text part saying
+// so, the model must not get other content than the user sent. Other parts are returned as is.
+func (p Part) Load() Part {
+	if p.Type != FilePart || p.Data != nil || p.Path == "" {
+		return p
+	}
+	data, err := os.ReadFile(p.Path)
+	switch {
+	case err != nil:
+		return Part{Type: TextPart, Text: fmt.Sprintf("<file path=%q>\nThe attached file can't be read anymore: %v\n</file>", p.Path, err)}
+	case p.Digest != "" && digest(data) != p.Digest:
+		return Part{Type: TextPart, Text: fmt.Sprintf("<file path=%q>\nThe attached file changed since, its content isn't available\n</file>", p.Path)}
+	}
+	p.Data = data
+	return p
+}
+
+// DataURL encodes the data of an image or file part the way providers take it inline
+func (p Part) DataURL() string {
+	return "data:" + p.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(p.Data)
+}
+
+// IsTextFile tells whether a file part holds text, providers send those as text with the
+// path around them since only some of them accept attachments other than PDFs
+func (p Part) IsTextFile() bool {
+	return p.Type == FilePart && (strings.HasPrefix(p.MIMEType, "text/") || p.MIMEType == "application/json")
+}
+
+// FileText is the text a text file part is sent as
+func (p Part) FileText() string {
+	return fmt.Sprintf("<file path=%q>\n%s\n</file>", p.Path, p.Data)
+}
+
+// Text joins the text parts of the entry, tool results included
+func (e MemoryEntry) Text() string {
+	var texts []string
+	for _, part := range e.Parts {
+		if part.Type == TextPart || part.Type == ToolResultPart {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// ToolCalls returns the tool calls the model asked for in this entry
+func (e MemoryEntry) ToolCalls() []tools.ToolCall {
+	var calls []tools.ToolCall
+	for _, part := range e.Parts {
+		if part.Type == ToolCallPart && part.ToolCall != nil {
+			calls = append(calls, *part.ToolCall)
+		}
+	}
+	return calls
+}
+
+// ToolResult returns the tool result part of a tool entry
+func (e MemoryEntry) ToolResult() (Part, bool) {
+	for _, part := range e.Parts {
+		if part.Type == ToolResultPart {
+			return part, true
+		}
+	}
+	return Part{}, false
+}
+
+// HasMedia tells whether the entry holds images or files
+func (e MemoryEntry) HasMedia() bool {
+	for _, part := range e.Parts {
+		if part.Type == ImagePart || part.Type == FilePart {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalJSON also reads entries saved before they had parts, with a single message and the tool fields next to it
+func (e *MemoryEntry) UnmarshalJSON(data []byte) error {
+	type entry MemoryEntry
+	var v struct {
+		entry
+		Message    string           `json:"message"`
+		ToolName   string           `json:"tool_name"`
+		ToolCallID string           `json:"tool_call_id"`
+		ToolCalls  []tools.ToolCall `json:"tool_calls"`
+		IsError    bool             `json:"is_error"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*e = MemoryEntry(v.entry)
+	if e.Role == "assistant" {
+		e.Role = Model
+	}
+	if len(e.Parts) > 0 {
+		return nil
+	}
+	switch {
+	case e.Role == Tool:
+		e.Parts = []Part{{Type: ToolResultPart, Text: v.Message, ToolCallID: v.ToolCallID, ToolName: v.ToolName, IsError: v.IsError}}
+	case e.Role == Model:
+		e.Parts = Response(v.Message, v.ToolCalls).Parts
+	case v.Message != "":
+		e.Parts = []Part{{Type: TextPart, Text: v.Message}}
+	}
+	return nil
+}
+
+// entryOverhead covers the role and framing tokens providers add around every message
+const entryOverhead = 4
+
+// imageTokens is about what providers bill for a screenshot, they scale images down to a
+// similar size so the bytes say little about it
+const imageTokens = 1500
+
+// EstimateTokens approximates how many tokens the entry takes in a prompt.
+// It uses the usual ~4 characters per token rule, close enough to decide when to compact
+// without pulling in a tokenizer for every provider.
+func EstimateTokens(entry MemoryEntry) int {
+	chars, tokens := 0, 0
+	for _, part := range entry.Parts {
+		switch part.Type {
+		case ImagePart:
+			tokens += imageTokens
+		case ThinkingPart:
+			// Never sent back to the model
+		case FilePart:
+			chars += len(part.Path) + len(part.Data)
+		case ToolCallPart:
+			if call := part.ToolCall; call != nil {
+				chars += len(call.ID) + len(call.FunctionName) + len(call.RawArguments)
+				if call.Arguments != nil {
+					if args, err := json.Marshal(call.Arguments); err == nil {
+						chars += len(args)
+					}
+				}
+			}
+		default:
+			chars += len(part.Text) + len(part.ToolName) + len(part.ToolCallID)
+		}
+	}
+	return tokens + (chars+3)/4 + entryOverhead
+}
+
+// CountTokens sums the tokens of the entries, estimating the ones that weren't counted yet
+func CountTokens(entries []MemoryEntry) int {
+	total := 0
+	for _, entry := range entries {
+		if entry.Tokens > 0 {
+			total += entry.Tokens
+		} else {
+			total += EstimateTokens(entry)
+		}
+	}
+	return total
 }
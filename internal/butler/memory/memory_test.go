@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAttach(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"shot.png":   {0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'},
+		"spec.pdf":   []byte("%PDF-1.7"),
+		"notes.txt":  []byte("remember the milk"),
+		"screenshot": {0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'},
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		wantType PartType
+		wantMIME string
+	}{
+		{"shot.png", ImagePart, "image/png"},
+		{"spec.pdf", FilePart, "application/pdf"},
+		{"notes.txt", FilePart, "text/plain"},
+		{"screenshot", ImagePart, "image/png"}, // No extension, sniffed from the content
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			part, err := Attach(path)
+			if err != nil {
+				t.Fatalf("Attach failed: %v", err)
+			}
+			if part.Type != tt.wantType || part.MIMEType != tt.wantMIME || part.Path != path || string(part.Data) != string(files[tt.name]) {
+				t.Errorf("Expected a %s part of type %s, got %+v", tt.wantType, tt.wantMIME, part)
+			}
+		})
+	}
+
+	if _, err := Attach(dir); err == nil {
+		t.Error("Expected attaching a directory to fail")
+	}
+}
+
+func TestPart_FileReference(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(path, []byte("remember the milk"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	part, err := Attach(path)
+	if err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+
+	data, err := json.Marshal(part)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var saved Part
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if saved.Data != nil || saved.Path != path || saved.Digest != part.Digest {
+		t.Fatalf("Expected the file saved as a reference, got %+v", saved)
+	}
+	if loaded := saved.Load(); string(loaded.Data) != "remember the milk" || loaded.Type != FilePart {
+		t.Errorf("Expected Load to read the file back, got %+v", loaded)
+	}
+
+	if err := os.WriteFile(path, []byte("forget the milk"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if loaded := saved.Load(); loaded.Type != TextPart || !strings.Contains(loaded.Text, "changed") {
+		t.Errorf("Expected a note that the file changed, got %+v", loaded)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if loaded := saved.Load(); loaded.Type != TextPart || !strings.Contains(loaded.Text, "can't be read") {
+		t.Errorf("Expected a note that the file is gone, got %+v", loaded)
+	}
+
+	image := Image("image/png", []byte("png"))
+	image.Path = path
+	if data, _ := json.Marshal(image); !strings.Contains(string(data), `"data"`) {
+		t.Errorf("Expected images to keep their data, got %s", data)
+	}
+}
@@ -2,10 +2,11 @@ package gemini
 
 import (
 	"context"
-	"log"
 
+	"github.com/mightymoud/arlocode/internal/butler"
 	"github.com/mightymoud/arlocode/internal/butler/llm"
 	gemini_llm "github.com/mightymoud/arlocode/internal/butler/llm/gemini"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
 	"google.golang.org/genai"
 )
 
@@ -14,14 +15,15 @@ type GeminiProvider struct {
 }
 
 // returns a general api client from that provider
-func New(ctx context.Context) *GeminiProvider {
+func New(ctx context.Context) (*GeminiProvider, error) {
 	client, err := genai.NewClient(ctx, nil)
 	if err != nil {
-		log.Fatal(err)
+		// The client only fails to build when no key or project is configured
+		return nil, &butler.ProviderError{Kind: butler.ErrAuth, Provider: "gemini", Err: err}
 	}
 	return &GeminiProvider{
 		client: client,
-	}
+	}, nil
 }
 
 // returns config to get the APIkey from certain place
@@ -33,5 +35,10 @@ func WithApiKey(key string) *genai.ClientConfig {
 
 // returns an llm that can generate and stream
 func (p *GeminiProvider) Model(ctx context.Context, modelID string) llm.LLM {
-	return &gemini_llm.GeminiLLM{ModelID: modelID, Client: p.client}
+	return p.ModelWithConfig(ctx, modelID, providers.GenerationConfig{})
+}
+
+// ModelWithConfig returns a model with its own generation settings
+func (p *GeminiProvider) ModelWithConfig(ctx context.Context, modelID string, config providers.GenerationConfig) llm.LLM {
+	return &gemini_llm.GeminiLLM{ModelID: modelID, Client: p.client, Config: config}
 }
@@ -5,6 +5,7 @@ import (
 
 	"github.com/mightymoud/arlocode/internal/butler/llm"
 	openai_llm "github.com/mightymoud/arlocode/internal/butler/llm/openai"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
 	"github.com/openai/openai-go/v3"
 	"github.com/openai/openai-go/v3/option"
 )
@@ -28,5 +29,10 @@ func WithApiKey(key string) option.RequestOption {
 
 // Model returns an llm that can generate and stream
 func (p *OpenAIProvider) Model(ctx context.Context, modelID string) llm.LLM {
-	return &openai_llm.OpenAILLM{ModelID: modelID, Client: p.client}
+	return p.ModelWithConfig(ctx, modelID, providers.GenerationConfig{})
+}
+
+// ModelWithConfig returns a model with its own generation settings
+func (p *OpenAIProvider) ModelWithConfig(ctx context.Context, modelID string, config providers.GenerationConfig) llm.LLM {
+	return &openai_llm.OpenAILLM{ModelID: modelID, Client: p.client, Config: config}
 }
@@ -2,12 +2,14 @@ package openrouter
 
 import (
 	"context"
-	"log"
+	"errors"
 	"os"
 
 	"github.com/iamwavecut/gopenrouter"
+	"github.com/mightymoud/arlocode/internal/butler"
 	"github.com/mightymoud/arlocode/internal/butler/llm"
 	openrouter_llm "github.com/mightymoud/arlocode/internal/butler/llm/openrouter"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
 )
 
 type OpenRouterProvider struct {
@@ -15,21 +17,31 @@ type OpenRouterProvider struct {
 }
 
 // returns a general api client from that provider
-func New(ctx context.Context) *OpenRouterProvider {
+func New(ctx context.Context) (*OpenRouterProvider, error) {
 	apiKey := os.Getenv("OPENROUTER_API_KEY")
 	if apiKey == "" {
-		log.Fatal("OPENROUTER_API_KEY environment variable is not set")
+		return nil, &butler.ProviderError{
+			Kind:     butler.ErrAuth,
+			Provider: "openrouter",
+			Err:      errors.New("OPENROUTER_API_KEY environment variable is not set"),
+		}
 	}
 	client := gopenrouter.NewClient(apiKey)
 	return &OpenRouterProvider{
 		client: client,
-	}
+	}, nil
 }
 
 func (p *OpenRouterProvider) Model(ctx context.Context, modelID string) llm.LLM {
+	return p.ModelWithConfig(ctx, modelID, providers.GenerationConfig{})
+}
+
+// ModelWithConfig returns a model with its own generation settings
+func (p *OpenRouterProvider) ModelWithConfig(ctx context.Context, modelID string, config providers.GenerationConfig) llm.LLM {
 	return &openrouter_llm.OpenRouterLLM{
 		ModelID:           modelID,
 		Client:            p.client,
 		ParallelToolCalls: nil, // True by default for most models
+		Config:            config,
 	}
 }
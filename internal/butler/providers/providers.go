@@ -6,5 +6,15 @@ import (
 
 type ProviderResponse struct {
 	Text      string
+	Thinking  string // The reasoning the model streamed, empty when the provider doesn't share it
 	ToolCalls []tools.ToolCall
+	Usage     Usage // Zero when the provider didn't report it
+}
+
+// GenerationConfig tunes how a model generates. Zero values keep the provider defaults.
+type GenerationConfig struct {
+	Temperature     *float64 `yaml:"temperature,omitempty"`
+	TopP            *float64 `yaml:"top_p,omitempty"`
+	MaxTokens       int      `yaml:"max_tokens,omitempty"`       // Cap on the tokens of one response
+	ReasoningTokens int      `yaml:"reasoning_tokens,omitempty"` // Thinking budget for models that reason
 }
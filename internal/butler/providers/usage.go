@@ -0,0 +1,67 @@
+package providers
+
+import "strings"
+
+// Usage is what one or more model calls consumed
+type Usage struct {
+	PromptTokens     int     `json:"prompt_tokens,omitempty"`     // Input tokens, cached ones included
+	CompletionTokens int     `json:"completion_tokens,omitempty"` // Output tokens, reasoning ones included
+	ReasoningTokens  int     `json:"reasoning_tokens,omitempty"`
+	CachedTokens     int     `json:"cached_tokens,omitempty"`
+	Cost             float64 `json:"cost,omitempty"` // In USD, 0 when the price of the model is unknown
+}
+
+// Add returns the sum of both usages
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		ReasoningTokens:  u.ReasoningTokens + other.ReasoningTokens,
+		CachedTokens:     u.CachedTokens + other.CachedTokens,
+		Cost:             u.Cost + other.Cost,
+	}
+}
+
+func (u Usage) TotalTokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+func (u Usage) IsZero() bool {
+	return u == Usage{}
+}
+
+// Price is what a model charges in USD per million tokens
+type Price struct {
+	Input       float64
+	CachedInput float64
+	Output      float64
+}
+
+// Prices of the models whose provider doesn't report the cost itself.
+// OpenRouter returns the cost with every response, so its models aren't listed.
+var Prices = map[string]Price{
+	"gpt-4o":                {Input: 2.50, CachedInput: 1.25, Output: 10.00},
+	"gpt-4o-mini":           {Input: 0.15, CachedInput: 0.075, Output: 0.60},
+	"gpt-4.1":               {Input: 2.00, CachedInput: 0.50, Output: 8.00},
+	"gpt-4.1-mini":          {Input: 0.40, CachedInput: 0.10, Output: 1.60},
+	"gpt-5":                 {Input: 1.25, CachedInput: 0.125, Output: 10.00},
+	"gpt-5-mini":            {Input: 0.25, CachedInput: 0.025, Output: 2.00},
+	"gemini-2.5-pro":        {Input: 1.25, CachedInput: 0.31, Output: 10.00},
+	"gemini-2.5-flash":      {Input: 0.30, CachedInput: 0.075, Output: 2.50},
+	"gemini-2.5-flash-lite": {Input: 0.10, CachedInput: 0.025, Output: 0.40},
+	"gemini-2.0-flash":      {Input: 0.10, CachedInput: 0.025, Output: 0.40},
+}
+
+// EstimateCost prices the usage of a model from the Prices table, 0 for unknown models.
+// Provider prefixes like "openai/" are ignored.
+func EstimateCost(modelID string, usage Usage) float64 {
+	if i := strings.LastIndex(modelID, "/"); i >= 0 {
+		modelID = modelID[i+1:]
+	}
+	price, ok := Prices[modelID]
+	if !ok {
+		return 0
+	}
+	uncached := usage.PromptTokens - usage.CachedTokens
+	return (float64(uncached)*price.Input + float64(usage.CachedTokens)*price.CachedInput + float64(usage.CompletionTokens)*price.Output) / 1_000_000
+}
@@ -0,0 +1,29 @@
+package providers
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEstimateCost(t *testing.T) {
+	usage := Usage{PromptTokens: 1_000_000, CachedTokens: 400_000, CompletionTokens: 100_000}
+
+	// 600k uncached at 2.50, 400k cached at 1.25 and 100k output at 10.00 per million
+	if got := EstimateCost("openai/gpt-4o", usage); math.Abs(got-3.0) > 1e-9 {
+		t.Errorf("Expected $3.00, got $%f", got)
+	}
+	if got := EstimateCost("some/unknown-model", usage); got != 0 {
+		t.Errorf("Expected unknown models to cost 0, got %f", got)
+	}
+}
+
+func TestUsageAdd(t *testing.T) {
+	total := Usage{PromptTokens: 10, CompletionTokens: 5, Cost: 0.5}.Add(Usage{PromptTokens: 1, ReasoningTokens: 2, CachedTokens: 3, Cost: 0.25})
+	want := Usage{PromptTokens: 11, CompletionTokens: 5, ReasoningTokens: 2, CachedTokens: 3, Cost: 0.75}
+	if total != want {
+		t.Errorf("Expected %+v, got %+v", want, total)
+	}
+	if total.TotalTokens() != 16 {
+		t.Errorf("Expected 16 total tokens, got %d", total.TotalTokens())
+	}
+}
@@ -0,0 +1,455 @@
+package session
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler/compaction"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+)
+
+// ErrNotFound is returned when no session matches the requested ID
+var ErrNotFound = errors.New("session not found")
+
+const fileExt = ".jsonl"
+
+// Record kinds, one JSON record per line
+const (
+	kindMeta  = "meta"  // First line of every session file
+	kindEntry = "entry" // A memory entry appended by the agent, it becomes the head
+	kindHead  = "head"  // The memory was replaced by another branch, e.g. by a fork or compaction
+	kindState = "state" // A piece of state kept next to the memory, the last value of a key wins
+
+	// Written before sessions had branches, Entries holds the new memory
+	kindReset = "reset"
+)
+
+type record struct {
+	Kind    string               `json:"kind"`
+	Time    time.Time            `json:"time"`
+	Meta    *Meta                `json:"meta,omitempty"`
+	Entry   *memory.MemoryEntry  `json:"entry,omitempty"`
+	Entries []memory.MemoryEntry `json:"entries,omitempty"`
+	Key     string               `json:"key,omitempty"`
+	Value   json.RawMessage      `json:"value,omitempty"`
+	Head    string               `json:"head,omitempty"`
+	Replace bool                 `json:"replace,omitempty"` // The branch that was current is gone, not left behind
+}
+
+// Meta describes a session, it is written once when the session file is created
+type Meta struct {
+	ID      string    `json:"id"`
+	Project string    `json:"project,omitempty"`
+	Model   string    `json:"model,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// Summary is what List knows about a session without keeping its memory around
+type Summary struct {
+	Meta
+	Updated  time.Time
+	Entries  int    // Entries in the memory when the session was last written
+	Title    string // The first user prompt, shortened
+	Branches int
+}
+
+// Store keeps sessions as append-only JSONL files in a directory
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// ProjectDir is the sessions directory of a project inside dataDir.
+// Projects are keyed by their absolute path so two checkouts never share history.
+func ProjectDir(dataDir, project string) string {
+	if abs, err := filepath.Abs(project); err == nil {
+		project = abs
+	}
+	sum := sha256.Sum256([]byte(project))
+	name := unsafeChars.ReplaceAllString(filepath.Base(project), "-")
+	return filepath.Join(dataDir, "projects", name+"-"+hex.EncodeToString(sum[:4]), "sessions")
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Dir is where the session files live
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Create starts a new session. Nothing is written until the first entry is recorded,
+// so sessions that never got a prompt don't show up in List.
+func (s *Store) Create(meta Meta) *Session {
+	if meta.ID == "" {
+		meta.ID = newID()
+	}
+	if meta.Created.IsZero() {
+		meta.Created = time.Now()
+	}
+	return &Session{meta: meta, path: s.path(meta.ID), tree: newTree()}
+}
+
+// Open continues an existing session on the branch it was last on, new entries are appended to its file
+func (s *Store) Open(id string) (*Session, []memory.MemoryEntry, error) {
+	meta, t, state, err := s.load(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Session{meta: meta, path: s.path(meta.ID), written: true, state: state, tree: t}, t.path(t.head), nil
+}
+
+// Load replays a session file and returns the memory as it was last recorded
+func (s *Store) Load(id string) (Meta, []memory.MemoryEntry, error) {
+	meta, t, _, err := s.load(id)
+	return meta, t.path(t.head), err
+}
+
+func (s *Store) load(id string) (Meta, *tree, map[string]json.RawMessage, error) {
+	f, err := os.Open(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return Meta{}, newTree(), nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return Meta{}, newTree(), nil, err
+	}
+	defer f.Close()
+	return replay(f)
+}
+
+// List returns the sessions of the store, most recently updated first
+func (s *Store) List() ([]Summary, error) {
+	files, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var summaries []Summary
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != fileExt {
+			continue
+		}
+		id := strings.TrimSuffix(file.Name(), fileExt)
+		meta, t, _, err := s.load(id)
+		if err != nil {
+			return nil, fmt.Errorf("reading session %s: %w", id, err)
+		}
+		entries := t.path(t.head)
+		summary := Summary{Meta: meta, Updated: meta.Created, Entries: len(entries), Title: title(entries), Branches: len(t.branches())}
+		if info, err := file.Info(); err == nil {
+			summary.Updated = info.ModTime()
+		}
+		summaries = append(summaries, summary)
+	}
+
+	slices.SortFunc(summaries, func(a, b Summary) int {
+		return b.Updated.Compare(a.Updated)
+	})
+	return summaries, nil
+}
+
+// Latest returns the most recently updated session
+func (s *Store) Latest() (Summary, error) {
+	summaries, err := s.List()
+	if err != nil {
+		return Summary{}, err
+	}
+	if len(summaries) == 0 {
+		return Summary{}, fmt.Errorf("%w: no sessions in %s", ErrNotFound, s.dir)
+	}
+	return summaries[0], nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+fileExt)
+}
+
+// replay rebuilds the conversation tree and the state from the records of a session file
+func replay(r io.Reader) (Meta, *tree, map[string]json.RawMessage, error) {
+	var meta Meta
+	t := newTree()
+	state := map[string]json.RawMessage{}
+
+	scanner := bufio.NewScanner(r)
+	// Tool outputs such as read_folder easily exceed the default 64KB line limit
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return meta, t, state, fmt.Errorf("line %d: %w", line, err)
+		}
+		switch rec.Kind {
+		case kindMeta:
+			if rec.Meta != nil {
+				meta = *rec.Meta
+			}
+		case kindState:
+			state[rec.Key] = rec.Value
+		default:
+			t.apply(rec, line)
+		}
+	}
+	return meta, t, state, scanner.Err()
+}
+
+// apply adds the entries of a record to the tree. Entries recorded before sessions had
+// branches have no IDs, they get IDs made from their place in the file so the entries
+// appended after them can refer to them.
+func (t *tree) apply(rec record, line int) {
+	switch rec.Kind {
+	case kindEntry:
+		if rec.Entry == nil {
+			return
+		}
+		entry := *rec.Entry
+		if entry.ID == "" {
+			entry.ID, entry.Parent = fmt.Sprintf("line-%d", line), t.head
+		}
+		t.add(entry, rec.Time)
+	case kindHead:
+		t.checkout(rec.Head, rec.Replace)
+	case kindReset:
+		previous := t.head
+		t.head = ""
+		for i, entry := range rec.Entries {
+			entry.ID, entry.Parent = fmt.Sprintf("line-%d.%d", line, i), t.head
+			t.add(entry, rec.Time)
+		}
+		head := t.head
+		t.head = previous
+		t.checkout(head, true)
+	}
+}
+
+// maxTitleLength caps the prompt shown as session title
+const maxTitleLength = 60
+
+func title(entries []memory.MemoryEntry) string {
+	for _, entry := range entries {
+		if entry.Role == memory.User {
+			return shorten(entry.Text())
+		}
+	}
+	return ""
+}
+
+func shorten(text string) string {
+	t := []rune(strings.Join(strings.Fields(text), " "))
+	if len(t) > maxTitleLength {
+		t = append(t[:maxTitleLength-1], '…')
+	}
+	return string(t)
+}
+
+// isPrompt tells prompts apart from the other user entries, the summaries compaction adds
+func isPrompt(entry memory.MemoryEntry) bool {
+	return entry.Role == memory.User && !strings.HasPrefix(entry.Text(), compaction.SummaryPrefix)
+}
+
+// BeforeTurn returns the entries of a branch before its turn-th prompt, the first turn being 1,
+// and that prompt. Forking there lets the user send a different prompt instead.
+func BeforeTurn(entries []memory.MemoryEntry, turn int) ([]memory.MemoryEntry, string, error) {
+	prompts := 0
+	for i, entry := range entries {
+		if !isPrompt(entry) {
+			continue
+		}
+		prompts++
+		if prompts == turn {
+			return slices.Clone(entries[:i]), entry.Text(), nil
+		}
+	}
+	return nil, "", fmt.Errorf("turn %d doesn't exist, the conversation has %d turns", turn, prompts)
+}
+
+// newID is sortable by creation time and random enough to never collide for one user
+func newID() string {
+	suffix := make([]byte, 3)
+	_, _ = rand.Read(suffix)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// Session records the memory of one conversation as it changes.
+// Writes never fail the agent: the first error is kept, later writes are skipped
+// and the frontend can report it through Err.
+type Session struct {
+	mu      sync.Mutex
+	meta    Meta
+	path    string
+	written bool // The file exists and starts with the meta record
+	state   map[string]json.RawMessage
+	tree    *tree
+	err     error
+}
+
+func (s *Session) ID() string {
+	return s.meta.ID
+}
+
+func (s *Session) Meta() Meta {
+	return s.meta
+}
+
+// Append records a new memory entry after the head, an entry without ID gets one
+func (s *Session) Append(entry memory.MemoryEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry.ID == "" {
+		entry.ID, entry.Parent = memory.NewID(), s.tree.head
+	}
+	s.writeLocked(record{Kind: kindEntry, Time: time.Now(), Entry: &entry})
+}
+
+// Reset records that the memory was replaced as a whole. Only the entries the session
+// doesn't have yet are written. The branch that was current is kept as another branch,
+// unless the new memory is made of new entries from the start, as after a compaction.
+func (s *Session) Reset(entries []memory.MemoryEntry) {
+	entries = memory.Link(entries)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var records []record
+	for _, entry := range entries {
+		if !s.tree.has(entry.ID) {
+			records = append(records, record{Kind: kindEntry, Time: now, Entry: &entry})
+		}
+	}
+	head := record{Kind: kindHead, Time: now}
+	if len(entries) > 0 {
+		head.Head = entries[len(entries)-1].ID
+		head.Replace = len(records) > 0 && !s.tree.has(entries[0].ID)
+	}
+	// The head goes first so it still knows the branch it leaves, the new entries end at it anyway
+	s.writeLocked(append([]record{head}, records...)...)
+}
+
+// Branches lists the branches of the conversation, oldest first
+func (s *Session) Branches() []Branch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.branches()
+}
+
+// Branch returns the entries of the branch ending at head, see Branches.
+// Switch to it by handing them to the agent, which resets the memory.
+func (s *Session) Branch(head string) ([]memory.MemoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if head != "" && !s.tree.has(head) {
+		return nil, fmt.Errorf("no entry %s in session %s", head, s.meta.ID)
+	}
+	return s.tree.path(head), nil
+}
+
+// SetState records a piece of state that belongs to the conversation but not to the memory,
+// e.g. the todo list. Only the last value of a key is kept when the session is opened.
+func (s *Session) SetState(key string, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		s.mu.Lock()
+		if s.err == nil {
+			s.err = err
+		}
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Lock()
+	if s.state == nil {
+		s.state = map[string]json.RawMessage{}
+	}
+	s.state[key] = data
+	s.mu.Unlock()
+	s.write(record{Kind: kindState, Time: time.Now(), Key: key, Value: data})
+}
+
+// State decodes the last value recorded for key into v, ok is false when there is none
+func (s *Session) State(key string, v any) (ok bool, err error) {
+	s.mu.Lock()
+	data, ok := s.state[key]
+	s.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// Err returns the first write error, if any
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Session) write(records ...record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeLocked(records...)
+}
+
+// writeLocked applies the records to the tree and appends them to the file, s.mu must be held
+func (s *Session) writeLocked(records ...record) {
+	for _, rec := range records {
+		s.tree.apply(rec, 0)
+	}
+	if s.err != nil {
+		return
+	}
+	if !s.written {
+		records = append([]record{{Kind: kindMeta, Time: s.meta.Created, Meta: &s.meta}}, records...)
+	}
+
+	var buf []byte
+	for _, rec := range records {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			s.err = err
+			return
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		s.err = err
+		return
+	}
+	// Each write opens the file in append mode, a crash loses at most the record being written
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		s.err = err
+		return
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		s.err = err
+		return
+	}
+	if err := f.Close(); err != nil {
+		s.err = err
+		return
+	}
+	s.written = true
+}
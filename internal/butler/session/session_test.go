@@ -0,0 +1,300 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler/compaction"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+func TestSession_RoundTrip(t *testing.T) {
+	store := NewStore(t.TempDir())
+	sess := store.Create(Meta{Project: "/tmp/project", Model: "test-model"})
+
+	call := tools.ToolCall{
+		ID:               "call_1",
+		FunctionName:     "read_file",
+		Arguments:        map[string]any{"path": "main.go"},
+		ThoughtSignature: []byte{0x00, 0xff, 0x10},
+	}
+	prompt := memory.Text(memory.User, "read main.go")
+	prompt.Parts = append(prompt.Parts, memory.Image("image/png", []byte{0x89, 'P', 'N', 'G'}))
+	prompt.Tokens = 7
+	partial := memory.Text(memory.Model, "partial")
+	partial.Interrupted = true
+	entries := memory.Link([]memory.MemoryEntry{
+		prompt,
+		memory.Response("", []tools.ToolCall{call}),
+		memory.ToolResult(call, "error: no such file", true),
+		partial,
+	})
+	for _, entry := range entries {
+		sess.Append(entry)
+	}
+	if err := sess.Err(); err != nil {
+		t.Fatalf("Unexpected write error: %v", err)
+	}
+
+	meta, loaded, err := store.Load(sess.ID())
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if meta.Model != "test-model" || meta.ID != sess.ID() {
+		t.Errorf("Unexpected meta: %+v", meta)
+	}
+	if !reflect.DeepEqual(loaded, entries) {
+		t.Errorf("Expected entries to round trip\n got: %+v\nwant: %+v", loaded, entries)
+	}
+}
+
+func TestSession_ResetReplacesMemory(t *testing.T) {
+	store := NewStore(t.TempDir())
+	sess := store.Create(Meta{})
+
+	sess.Append(memory.Text(memory.User, "one"))
+	sess.Append(memory.Text(memory.Model, "two"))
+	sess.Reset([]memory.MemoryEntry{memory.Text(memory.User, "summary")})
+	sess.Append(memory.Text(memory.User, "three"))
+
+	_, loaded, err := store.Load(sess.ID())
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(loaded) != 2 || loaded[0].Text() != "summary" || loaded[1].Text() != "three" {
+		t.Errorf("Unexpected memory after reset: %+v", loaded)
+	}
+}
+
+func TestSession_Branches(t *testing.T) {
+	store := NewStore(t.TempDir())
+	sess := store.Create(Meta{})
+	first := memory.Link([]memory.MemoryEntry{
+		memory.Text(memory.User, "first"),
+		memory.Text(memory.Model, "done"),
+		memory.Text(memory.User, "second"),
+		memory.Text(memory.Model, "done too"),
+	})
+	for _, entry := range first {
+		sess.Append(entry)
+	}
+
+	// Forking before the second prompt keeps the first branch next to the new one
+	sess.Reset(first[:2])
+	sess.Append(memory.Text(memory.User, "something else"))
+	titles := func(branches []Branch) []string {
+		var titles []string
+		for _, b := range branches {
+			if b.Current {
+				titles = append(titles, "*"+b.Title)
+			} else {
+				titles = append(titles, b.Title)
+			}
+		}
+		return titles
+	}
+	if got, want := titles(sess.Branches()), []string{"second", "*something else"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected branches %v, got %v", want, got)
+	}
+
+	// The session opens on the branch it was left on
+	opened, entries, err := store.Open(sess.ID())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if len(entries) != 3 || entries[1].ID != first[1].ID || entries[2].Text() != "something else" {
+		t.Errorf("Expected the shared history and the new prompt, got %+v", entries)
+	}
+
+	// Switching back writes no entries, only the head
+	branch, err := opened.Branch(opened.Branches()[0].Head)
+	if err != nil {
+		t.Fatalf("Branch failed: %v", err)
+	}
+	if !reflect.DeepEqual(branch, first) {
+		t.Errorf("Expected the first branch, got %+v", branch)
+	}
+	opened.Reset(branch)
+	if got, want := titles(opened.Branches()), []string{"*second", "something else"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected branches %v, got %v", want, got)
+	}
+	if _, entries, _ := store.Load(sess.ID()); !reflect.DeepEqual(entries, first) {
+		t.Errorf("Expected the session to load on the first branch, got %+v", entries)
+	}
+
+	// Compaction replaces the branch instead of adding one
+	opened.Reset([]memory.MemoryEntry{memory.Text(memory.User, "summary"), memory.Text(memory.User, "third")})
+	if got, want := titles(opened.Branches()), []string{"something else", "*third"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected branches %v, got %v", want, got)
+	}
+}
+
+func TestBeforeTurn(t *testing.T) {
+	entries := []memory.MemoryEntry{
+		memory.Text(memory.User, compaction.SummaryPrefix+"earlier work"),
+		memory.Text(memory.User, "first"),
+		memory.Text(memory.Model, "done"),
+		memory.Text(memory.User, "second"),
+	}
+	before, prompt, err := BeforeTurn(entries, 2)
+	if err != nil || len(before) != 3 || prompt != "second" {
+		t.Errorf("Expected the 3 entries before the second prompt, got %d, %q, %v", len(before), prompt, err)
+	}
+	if _, _, err := BeforeTurn(entries, 3); err == nil {
+		t.Error("Expected an error for a turn that doesn't exist")
+	}
+}
+
+func TestSession_State(t *testing.T) {
+	store := NewStore(t.TempDir())
+	sess := store.Create(Meta{})
+
+	type item struct{ Title string }
+	sess.Append(memory.Text(memory.User, "plan it"))
+	sess.SetState("todos", []item{{Title: "first"}})
+	sess.SetState("todos", []item{{Title: "first"}, {Title: "second"}})
+
+	opened, entries, err := store.Open(sess.ID())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected state records to stay out of the memory, got %+v", entries)
+	}
+	var todos []item
+	if ok, err := opened.State("todos", &todos); !ok || err != nil {
+		t.Fatalf("Expected the state to be loaded, got %v, %v", ok, err)
+	}
+	if len(todos) != 2 || todos[1].Title != "second" {
+		t.Errorf("Expected the last value to win, got %+v", todos)
+	}
+	if ok, _ := opened.State("missing", &todos); ok {
+		t.Error("Expected no value for an unknown key")
+	}
+}
+
+func TestStore_OpenAppends(t *testing.T) {
+	store := NewStore(t.TempDir())
+	sess := store.Create(Meta{})
+	sess.Append(memory.Text(memory.User, "first"))
+
+	resumed, entries, err := store.Open(sess.ID())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+	resumed.Append(memory.Text(memory.User, "second"))
+
+	data, err := os.ReadFile(filepath.Join(store.Dir(), sess.ID()+fileExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), `"kind":"meta"`); n != 1 {
+		t.Errorf("Expected a single meta record, got %d", n)
+	}
+
+	_, entries, _ = store.Load(sess.ID())
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 entries after resuming, got %d", len(entries))
+	}
+}
+
+func TestStore_LoadsEntriesWithoutParts(t *testing.T) {
+	store := NewStore(t.TempDir())
+	// Sessions saved before entries had parts kept the text and the tool fields on the entry
+	legacy := `{"kind":"entry","entry":{"role":"user","message":"read main.go"}}
+{"kind":"entry","entry":{"role":"assistant","message":"reading","tool_calls":[{"id":"call_1","function_name":"read_file"}]}}
+{"kind":"entry","entry":{"role":"tool","message":"error: no such file","tool_name":"read_file","tool_call_id":"call_1","is_error":true}}
+`
+	if err := os.WriteFile(filepath.Join(store.Dir(), "legacy"+fileExt), []byte(legacy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, entries, err := store.Load("legacy")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	call := tools.ToolCall{ID: "call_1", FunctionName: "read_file"}
+	want := []memory.MemoryEntry{
+		memory.Text(memory.User, "read main.go"),
+		memory.Response("reading", []tools.ToolCall{call}),
+		memory.ToolResult(call, "error: no such file", true),
+	}
+	// They get IDs from their line so entries appended later can refer to them
+	for i := range want {
+		want[i].ID = fmt.Sprintf("line-%d", i+1)
+		if i > 0 {
+			want[i].Parent = want[i-1].ID
+		}
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("Expected the entries to be converted\n got: %+v\nwant: %+v", entries, want)
+	}
+}
+
+func TestStore_List(t *testing.T) {
+	store := NewStore(t.TempDir())
+
+	// Sessions without entries are never written
+	store.Create(Meta{})
+
+	older := store.Create(Meta{ID: "older"})
+	older.Append(memory.Text(memory.User, "fix   the\nbuild"))
+	newer := store.Create(Meta{ID: "newer"})
+	newer.Append(memory.Text(memory.User, strings.Repeat("long prompt ", 20)))
+
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(store.Dir(), "older"+fileExt), past, past)
+
+	summaries, err := store.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("Expected 2 sessions, got %d", len(summaries))
+	}
+	if summaries[0].ID != "newer" || summaries[1].ID != "older" {
+		t.Errorf("Expected newest first, got %s then %s", summaries[0].ID, summaries[1].ID)
+	}
+	if summaries[1].Title != "fix the build" {
+		t.Errorf("Expected normalized title, got %q", summaries[1].Title)
+	}
+	if n := len([]rune(summaries[0].Title)); n != maxTitleLength {
+		t.Errorf("Expected title to be shortened to %d characters, got %d", maxTitleLength, n)
+	}
+
+	latest, err := store.Latest()
+	if err != nil || latest.ID != "newer" {
+		t.Errorf("Expected latest to be 'newer', got %q (%v)", latest.ID, err)
+	}
+}
+
+func TestStore_NotFound(t *testing.T) {
+	store := NewStore(t.TempDir())
+	if _, _, err := store.Load("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := store.Latest(); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an empty store, got %v", err)
+	}
+}
+
+func TestProjectDir(t *testing.T) {
+	a := ProjectDir("/data", "/home/me/my project")
+	b := ProjectDir("/data", "/work/my project")
+	if a == b {
+		t.Error("Expected projects with the same name to get different directories")
+	}
+	if !strings.HasPrefix(a, filepath.Join("/data", "projects", "my-project-")) {
+		t.Errorf("Unexpected project dir %s", a)
+	}
+}
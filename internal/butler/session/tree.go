@@ -0,0 +1,107 @@
+package session
+
+import (
+	"slices"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+)
+
+// Branch is one line of the conversation tree, from its first entry to a leaf.
+// Going back to an earlier entry and sending another prompt starts a new branch,
+// the entries before that point are shared.
+type Branch struct {
+	Head    string // ID of the last entry, pass it to Session.Branch to get the entries
+	Entries int
+	Title   string // The last prompt of the branch, shortened, to tell branches apart
+	Updated time.Time
+	Current bool // The branch the session continues on
+}
+
+// tree holds every entry ever recorded in a session, the memory is the path from the head to the root
+type tree struct {
+	nodes    map[string]node
+	order    []string        // IDs in the order they were recorded
+	children map[string]int  // How many entries have the ID as parent
+	replaced map[string]bool // Heads of branches that were replaced as a whole, e.g. by compaction
+	head     string
+}
+
+type node struct {
+	entry memory.MemoryEntry
+	time  time.Time
+}
+
+func newTree() *tree {
+	return &tree{nodes: map[string]node{}, children: map[string]int{}, replaced: map[string]bool{}}
+}
+
+// add records an entry and moves the head to it, entries already in the tree only move the head
+func (t *tree) add(entry memory.MemoryEntry, at time.Time) {
+	if _, ok := t.nodes[entry.ID]; !ok {
+		t.nodes[entry.ID] = node{entry: entry, time: at}
+		t.order = append(t.order, entry.ID)
+		if entry.Parent != "" {
+			t.children[entry.Parent]++
+		}
+	}
+	t.head = entry.ID
+}
+
+// checkout moves the head. With replace the branch that was current is dropped from
+// the branches instead of being kept next to the new one.
+func (t *tree) checkout(head string, replace bool) {
+	if replace && t.head != "" && t.head != head {
+		t.replaced[t.head] = true
+	}
+	t.head = head
+}
+
+func (t *tree) has(id string) bool {
+	_, ok := t.nodes[id]
+	return ok
+}
+
+// path returns the entries from the root to id
+func (t *tree) path(id string) []memory.MemoryEntry {
+	var entries []memory.MemoryEntry
+	for n, ok := t.nodes[id]; ok; n, ok = t.nodes[n.entry.Parent] {
+		entries = append(entries, n.entry)
+	}
+	slices.Reverse(entries)
+	return entries
+}
+
+// branches lists the leaves of the tree, plus the head when it is an inner entry
+// because the session was just forked there, oldest first
+func (t *tree) branches() []Branch {
+	var branches []Branch
+	for _, id := range t.order {
+		if id != t.head && (t.children[id] > 0 || t.replaced[id]) {
+			continue
+		}
+		path := t.path(id)
+		branches = append(branches, Branch{
+			Head:    id,
+			Entries: len(path),
+			Title:   lastPrompt(path),
+			Updated: t.nodes[id].time,
+			Current: id == t.head,
+		})
+	}
+	if t.head == "" && len(t.order) > 0 {
+		// Forked before the first entry, the branch is still empty
+		branches = append(branches, Branch{Current: true})
+	}
+	return branches
+}
+
+// lastPrompt is the title of a branch
+func lastPrompt(entries []memory.MemoryEntry) string {
+	for i := len(entries) - 1; i >= 0; i-- {
+		if isPrompt(entries[i]) {
+			return shorten(entries[i].Text())
+		}
+	}
+	return ""
+}
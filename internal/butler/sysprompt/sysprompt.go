@@ -0,0 +1,222 @@
+package sysprompt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// InstructionFiles are the project instruction files looked up in every directory, in this order
+var InstructionFiles = []string{"AGENTS.md", "ARLO.md"}
+
+// maxInstructionBytes caps a single instruction file so a huge one can't eat the context window
+const maxInstructionBytes = 64 * 1024
+
+// goVersionTimeout bounds the `go env` call, the prompt shouldn't wait on a slow toolchain
+const goVersionTimeout = 2 * time.Second
+
+// Environment holds the facts about the machine the agent runs on.
+// Empty fields are left out of the prompt.
+type Environment struct {
+	WorkingDir string
+	Platform   string
+	Date       string
+	GitBranch  string
+	GoVersion  string
+}
+
+// Instruction is the content of one project instruction file
+type Instruction struct {
+	Path    string
+	Content string
+}
+
+// Assembler builds the system prompt from a base prompt, the environment and the project instruction files
+type Assembler struct {
+	Base string // The agent's own instructions
+	Dir  string // Working directory, instruction files are looked up from here up to the filesystem root
+}
+
+// Assemble builds the system prompt. Facts that can't be found, like the branch outside
+// of a git repository, are skipped. Only unreadable instruction files are errors.
+func (a Assembler) Assemble(ctx context.Context) (string, error) {
+	instructions, err := FindInstructions(a.Dir)
+	if err != nil {
+		return "", err
+	}
+	return Format(a.Base, DetectEnvironment(ctx, a.Dir), instructions), nil
+}
+
+// DetectEnvironment collects the environment facts for dir
+func DetectEnvironment(ctx context.Context, dir string) Environment {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return Environment{
+		WorkingDir: dir,
+		Platform:   runtime.GOOS + "/" + runtime.GOARCH,
+		Date:       time.Now().Format("2006-01-02 (Monday)"),
+		GitBranch:  gitBranch(dir),
+		GoVersion:  goVersion(ctx, dir),
+	}
+}
+
+// FindInstructions returns the instruction files from the filesystem root down to dir.
+// Files closer to dir come last, so their instructions take precedence when they disagree.
+func FindInstructions(dir string) ([]Instruction, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for {
+		dirs = append(dirs, dir)
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	var instructions []Instruction
+	for i := len(dirs) - 1; i >= 0; i-- {
+		for _, name := range InstructionFiles {
+			path := filepath.Join(dirs[i], name)
+			content, err := readInstruction(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("reading %s: %w", path, err)
+			}
+			if strings.TrimSpace(content) != "" {
+				instructions = append(instructions, Instruction{Path: path, Content: content})
+			}
+		}
+	}
+	return instructions, nil
+}
+
+func readInstruction(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fs.ErrNotExist
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxInstructionBytes {
+		return string(data[:maxInstructionBytes]) + "\n[truncated]", nil
+	}
+	return string(data), nil
+}
+
+// Format lays out the system prompt
+func Format(base string, env Environment, instructions []Instruction) string {
+	var b strings.Builder
+	if base = strings.TrimSpace(base); base != "" {
+		b.WriteString(base)
+		b.WriteString("\n\n")
+	}
+
+	b.WriteString("# Environment\n")
+	facts := []struct{ name, value string }{
+		{"Working directory", env.WorkingDir},
+		{"Platform", env.Platform},
+		{"Date", env.Date},
+		{"Git branch", env.GitBranch},
+		{"Go version", env.GoVersion},
+	}
+	for _, fact := range facts {
+		if fact.value != "" {
+			fmt.Fprintf(&b, "- %s: %s\n", fact.name, fact.value)
+		}
+	}
+
+	if len(instructions) > 0 {
+		b.WriteString("\n# Project instructions\n")
+		b.WriteString("Follow these instructions from the project, later files take precedence over earlier ones.\n")
+		for _, instruction := range instructions {
+			fmt.Fprintf(&b, "\n## %s\n\n%s\n", instruction.Path, strings.TrimSpace(instruction.Content))
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// gitBranch reads the current branch from .git/HEAD without shelling out to git.
+// It returns the short commit hash on a detached HEAD and "" outside a repository.
+func gitBranch(dir string) string {
+	gitDir := findGitDir(dir)
+	if gitDir == "" {
+		return ""
+	}
+	head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	ref := strings.TrimSpace(string(head))
+	if branch, ok := strings.CutPrefix(ref, "ref: refs/heads/"); ok {
+		return branch
+	}
+	if len(ref) >= 12 {
+		return "detached at " + ref[:12]
+	}
+	return ""
+}
+
+// findGitDir walks up from dir to the repository's git directory.
+// Worktrees and submodules have a .git file pointing at the real one.
+func findGitDir(dir string) string {
+	for {
+		path := filepath.Join(dir, ".git")
+		info, err := os.Stat(path)
+		if err == nil {
+			if info.IsDir() {
+				return path
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return ""
+			}
+			gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+			if !ok {
+				return ""
+			}
+			if !filepath.IsAbs(gitDir) {
+				gitDir = filepath.Join(dir, gitDir)
+			}
+			return gitDir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// goVersion asks the Go toolchain of the project for its version, "" when Go isn't installed
+func goVersion(ctx context.Context, dir string) string {
+	ctx, cancel := context.WithTimeout(ctx, goVersionTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "go", "env", "GOVERSION")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
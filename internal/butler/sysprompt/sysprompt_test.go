@@ -0,0 +1,104 @@
+package sysprompt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindInstructions_OutermostFirst(t *testing.T) {
+	root := t.TempDir()
+	project := filepath.Join(root, "project")
+	pkg := filepath.Join(project, "pkg")
+	writeFile(t, filepath.Join(root, "AGENTS.md"), "root rules")
+	writeFile(t, filepath.Join(project, "AGENTS.md"), "project rules")
+	writeFile(t, filepath.Join(project, "ARLO.md"), "arlo rules")
+	writeFile(t, filepath.Join(pkg, "ARLO.md"), "  \n")
+	os.MkdirAll(filepath.Join(pkg, "AGENTS.md"), 0o755) // A directory, not an instruction file
+
+	instructions, err := FindInstructions(pkg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, instruction := range instructions {
+		if strings.HasPrefix(instruction.Path, root) {
+			got = append(got, instruction.Content)
+		}
+	}
+	want := []string{"root rules", "project rules", "arlo rules"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestGitBranch(t *testing.T) {
+	repo := t.TempDir()
+	writeFile(t, filepath.Join(repo, ".git", "HEAD"), "ref: refs/heads/feature/prompts\n")
+	if got := gitBranch(filepath.Join(repo, "a", "b")); got != "feature/prompts" {
+		t.Errorf("Expected branch from a subdirectory, got %q", got)
+	}
+
+	worktree := t.TempDir()
+	writeFile(t, filepath.Join(worktree, ".git"), "gitdir: ../gitdir\n")
+	writeFile(t, filepath.Join(worktree, "..", "gitdir", "HEAD"), "0123456789abcdef0123\n")
+	defer os.RemoveAll(filepath.Join(worktree, "..", "gitdir"))
+	if got := gitBranch(worktree); got != "detached at 0123456789ab" {
+		t.Errorf("Expected detached HEAD through the .git file, got %q", got)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	env := Environment{WorkingDir: "/src/app", Platform: "linux/amd64", GitBranch: "main"}
+	instructions := []Instruction{{Path: "/src/app/AGENTS.md", Content: "Run make test.\n"}}
+
+	got := Format("You are arlocode.\n", env, instructions)
+	want := `You are arlocode.
+
+# Environment
+- Working directory: /src/app
+- Platform: linux/amd64
+- Git branch: main
+
+# Project instructions
+Follow these instructions from the project, later files take precedence over earlier ones.
+
+## /src/app/AGENTS.md
+
+Run make test.`
+	if got != want {
+		t.Errorf("Unexpected prompt:\n%s", got)
+	}
+
+	if got := Format("base", Environment{Platform: "linux/amd64"}, nil); strings.Contains(got, "Project instructions") {
+		t.Errorf("Expected no instructions section without files, got:\n%s", got)
+	}
+}
+
+func TestAssembler(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "ARLO.md"), "Use tabs.")
+
+	prompt, err := Assembler{Base: "You are arlocode.", Dir: dir}.Assemble(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, want := range []string{"You are arlocode.", "- Working directory: " + dir, "Use tabs."} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("Expected prompt to contain %q, got:\n%s", want, prompt)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Package telemetry exports the OpenTelemetry spans of the agent, see the agent tracing section of the README
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+)
+
+// Config picks where spans go. With both fields empty tracing stays off,
+// unless OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set.
+type Config struct {
+	Endpoint    string // OTLP/HTTP collector, e.g. http://localhost:4318
+	File        string // Spans are appended to this file as JSON, one per line
+	ServiceName string // Defaults to arlocode
+	Version     string
+}
+
+// Enabled tells whether Setup would install an exporter
+func (c Config) Enabled() bool {
+	return c.Endpoint != "" || c.File != "" ||
+		os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Setup installs the global tracer provider. The returned shutdown flushes the
+// remaining spans and must be called before the program exits.
+func Setup(ctx context.Context, cfg Config) (shutdown func(context.Context) error, err error) {
+	if !cfg.Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var closers []func() error
+	var options []sdktrace.TracerProviderOption
+
+	if cfg.File != "" {
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("opening trace file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("creating file exporter: %w", err)
+		}
+		closers = append(closers, f.Close)
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+
+	if cfg.File == "" || cfg.Endpoint != "" {
+		var otlpOptions []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			endpoint, err := endpointURL(cfg.Endpoint)
+			if err != nil {
+				return nil, err
+			}
+			otlpOptions = append(otlpOptions, otlptracehttp.WithEndpointURL(endpoint))
+		}
+		exporter, err := otlptracehttp.New(ctx, otlpOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+
+	name := cfg.ServiceName
+	if name == "" {
+		name = "arlocode"
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(name),
+		semconv.ServiceVersion(cfg.Version),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("creating trace resource: %w", err)
+	}
+	options = append(options, sdktrace.WithResource(res))
+
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		for _, closeFile := range closers {
+			err = errors.Join(err, closeFile())
+		}
+		return err
+	}, nil
+}
+
+// endpointURL adds the default traces path to a bare collector address
+func endpointURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid trace endpoint %q, expected a URL like http://localhost:4318", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,75 @@
+package telemetry
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestEndpointURL(t *testing.T) {
+	tests := map[string]string{
+		"http://localhost:4318":            "http://localhost:4318/v1/traces",
+		"http://localhost:4318/":           "http://localhost:4318/v1/traces",
+		"https://collector:4318/custom/v1": "https://collector:4318/custom/v1",
+	}
+	for endpoint, want := range tests {
+		got, err := endpointURL(endpoint)
+		if err != nil {
+			t.Errorf("endpointURL(%q) failed: %v", endpoint, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("endpointURL(%q) = %q, want %q", endpoint, got, want)
+		}
+	}
+
+	if _, err := endpointURL("localhost"); err == nil {
+		t.Error("Expected an error for an endpoint without a scheme")
+	}
+}
+
+func TestSetup_Disabled(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+
+	previous := otel.GetTracerProvider()
+	shutdown, err := Setup(context.Background(), Config{})
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if otel.GetTracerProvider() != previous {
+		t.Error("Expected no tracer provider to be installed without a destination")
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown failed: %v", err)
+	}
+}
+
+func TestSetup_File(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	path := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := Setup(context.Background(), Config{File: path})
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	_, span := otel.Tracer("test").Start(context.Background(), "invoke_agent")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Reading trace file failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"Name":"invoke_agent"`) || !strings.Contains(string(data), "arlocode") {
+		t.Errorf("Expected the span and the service name in the trace file, got %s", data)
+	}
+}
@@ -0,0 +1,178 @@
+// Package todo gives the agent a task list it keeps up to date through the todo_write and todo_read tools,
+// so long tasks don't lose track of what is done and what is left.
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+const (
+	WriteToolName = "todo_write"
+	ReadToolName  = "todo_read"
+
+	// StateKey is the key the list is saved under in a session, see session.Session.SetState
+	StateKey = "todos"
+)
+
+type Status string
+
+const (
+	Pending    Status = "pending"
+	InProgress Status = "in_progress"
+	Done       Status = "done"
+	Cancelled  Status = "cancelled"
+)
+
+var statuses = []Status{Pending, InProgress, Done, Cancelled}
+
+// Item is one task of the list
+type Item struct {
+	ID     string `json:"id" description:"Short identifier that stays the same while the task is updated, e.g. 1, 2, 3"`
+	Title  string `json:"title" description:"What has to be done, one line"`
+	Status Status `json:"status" enum:"pending,in_progress,done,cancelled" description:"One of pending, in_progress, done or cancelled"`
+	Notes  string `json:"notes,omitempty" description:"Findings, blockers or what is left, keep it short"`
+}
+
+// List is the task list of one session. It is safe to use from several goroutines.
+type List struct {
+	mu       sync.Mutex
+	items    []Item
+	onChange func(items []Item)
+}
+
+func NewList(items []Item) *List {
+	return &List{items: slices.Clone(items)}
+}
+
+// WithOnChange calls f with the new items whenever the agent writes the list, e.g. to save or show it
+func (l *List) WithOnChange(f func(items []Item)) *List {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.onChange = f
+	return l
+}
+
+func (l *List) Items() []Item {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.items)
+}
+
+// Set replaces the whole list, it fails without changing anything when an item is invalid
+func (l *List) Set(items []Item) error {
+	if err := validate(items); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.items = slices.Clone(items)
+	onChange := l.onChange
+	l.mu.Unlock()
+
+	if onChange != nil {
+		onChange(slices.Clone(items))
+	}
+	return nil
+}
+
+func validate(items []Item) error {
+	seen := map[string]bool{}
+	var errs []error
+	for i, item := range items {
+		switch {
+		case strings.TrimSpace(item.ID) == "":
+			errs = append(errs, fmt.Errorf("item %d has no id", i+1))
+		case seen[item.ID]:
+			errs = append(errs, fmt.Errorf("id %q is used twice", item.ID))
+		}
+		seen[item.ID] = true
+		if strings.TrimSpace(item.Title) == "" {
+			errs = append(errs, fmt.Errorf("item %q has no title", item.ID))
+		}
+		if !slices.Contains(statuses, item.Status) {
+			errs = append(errs, fmt.Errorf("item %q has status %q, expected one of pending, in_progress, done or cancelled", item.ID, item.Status))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Reminder restates the list for the model, e.g. after compaction summarized the calls that wrote it.
+// It is empty when there are no tasks.
+func (l *List) Reminder() string {
+	items := l.Items()
+	if len(items) == 0 {
+		return ""
+	}
+	return "Your todo list, keep it up to date with " + WriteToolName + ":\n" + Format(items)
+}
+
+// Format renders items as a checklist, one per line
+func Format(items []Item) string {
+	if len(items) == 0 {
+		return "The todo list is empty."
+	}
+	var b strings.Builder
+	for _, item := range items {
+		fmt.Fprintf(&b, "%s %s. %s", Mark(item.Status), item.ID, item.Title)
+		if item.Notes != "" {
+			fmt.Fprintf(&b, " (%s)", item.Notes)
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Mark is the checkbox of a status
+func Mark(status Status) string {
+	switch status {
+	case InProgress:
+		return "[~]"
+	case Done:
+		return "[x]"
+	case Cancelled:
+		return "[-]"
+	}
+	return "[ ]"
+}
+
+type writeArgs struct {
+	Todos []Item `json:"todos" description:"The complete list, it replaces the previous one. Include the tasks that didn't change."`
+}
+
+type readArgs struct {
+	Status Status `json:"status,omitempty" enum:"pending,in_progress,done,cancelled" description:"Only list the tasks with this status, all tasks when empty"`
+}
+
+const writeDescription = "Writes your todo list for the current task. Use it for tasks with several steps: plan the steps up front, " +
+	"mark one in_progress when you start it and done as soon as it is finished, and add tasks you discover on the way. " +
+	"The list replaces the previous one, so always send all tasks."
+
+const readDescription = "Reads your current todo list"
+
+// Tools returns todo_write and todo_read working on this list
+func (l *List) Tools() []tools.Tool {
+	return []tools.Tool{
+		tools.NewButlerTool(WriteToolName, writeDescription, l.write),
+		tools.NewButlerTool(ReadToolName, readDescription, l.read),
+	}
+}
+
+func (l *List) write(args writeArgs) (string, error) {
+	if err := l.Set(args.Todos); err != nil {
+		return "", err
+	}
+	return "Todo list updated:\n" + Format(args.Todos), nil
+}
+
+func (l *List) read(args readArgs) (string, error) {
+	items := l.Items()
+	if args.Status != "" {
+		items = slices.DeleteFunc(items, func(item Item) bool { return item.Status != args.Status })
+	}
+	return Format(items), nil
+}
@@ -0,0 +1,83 @@
+package todo
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mightymoud/arlocode/internal/butler/agent"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+func runTool(t *testing.T, l *List, name string, args map[string]any) (string, error) {
+	t.Helper()
+	return agent.NewAgent(nil).WitTools(l.Tools()).HandleToolCall(context.Background(), tools.ToolCall{
+		ID:           "call_1",
+		FunctionName: name,
+		Arguments:    args,
+	})
+}
+
+func TestList_Set_Validates(t *testing.T) {
+	l := NewList([]Item{{ID: "1", Title: "Keep me", Status: Pending}})
+
+	err := l.Set([]Item{
+		{ID: "1", Title: "Read the code", Status: Done},
+		{ID: "1", Title: "", Status: "started"},
+	})
+	if err == nil {
+		t.Fatal("Expected invalid items to be rejected")
+	}
+	for _, want := range []string{`"1" is used twice`, "has no title", `status "started"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to mention %q, got %v", want, err)
+		}
+	}
+	if items := l.Items(); len(items) != 1 || items[0].Title != "Keep me" {
+		t.Errorf("Expected the list to be unchanged, got %+v", items)
+	}
+}
+
+func TestList_Tools(t *testing.T) {
+	var changes [][]Item
+	l := NewList(nil).WithOnChange(func(items []Item) { changes = append(changes, items) })
+
+	out, err := runTool(t, l, WriteToolName, map[string]any{"todos": []any{
+		map[string]any{"id": "1", "title": "Read the code", "status": "done"},
+		map[string]any{"id": "2", "title": "Fix the bug", "status": "in_progress", "notes": "off by one"},
+		map[string]any{"id": "3", "title": "Add a test", "status": "pending"},
+	}})
+	if err != nil {
+		t.Fatalf("todo_write failed: %v", err)
+	}
+	want := "[x] 1. Read the code\n[~] 2. Fix the bug (off by one)\n[ ] 3. Add a test"
+	if !strings.HasSuffix(out, want) {
+		t.Errorf("Expected the written list in the output, got %q", out)
+	}
+	if len(changes) != 1 || len(changes[0]) != 3 {
+		t.Errorf("Expected one change with 3 items, got %+v", changes)
+	}
+
+	out, err = runTool(t, l, ReadToolName, map[string]any{"status": "pending"})
+	if err != nil || out != "[ ] 3. Add a test" {
+		t.Errorf("Expected only the pending task, got %q, %v", out, err)
+	}
+
+	if _, err := runTool(t, l, WriteToolName, map[string]any{"todos": []any{map[string]any{"id": "1"}}}); err == nil {
+		t.Error("Expected todo_write to fail for an invalid list")
+	}
+	if len(changes) != 1 {
+		t.Errorf("Expected no change for an invalid list, got %d", len(changes))
+	}
+}
+
+func TestList_Reminder(t *testing.T) {
+	l := NewList(nil)
+	if got := l.Reminder(); got != "" {
+		t.Errorf("Expected no reminder without tasks, got %q", got)
+	}
+	l.Set([]Item{{ID: "1", Title: "Ship it", Status: InProgress}})
+	if got := l.Reminder(); !strings.Contains(got, "[~] 1. Ship it") {
+		t.Errorf("Expected the tasks in the reminder, got %q", got)
+	}
+}
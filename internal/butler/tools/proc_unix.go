@@ -0,0 +1,21 @@
+//go:build !windows
+
+package tools
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// commandWaitDelay bounds how long runCommand waits for output pipes after the process is killed
+const commandWaitDelay = 2 * time.Second
+
+// setProcessGroup starts the command in its own process group so cancelling
+// kills the shell and everything it spawned, not just /bin/sh
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package tools
+
+import (
+	"os/exec"
+	"time"
+)
+
+// commandWaitDelay bounds how long runCommand waits for output pipes after the process is killed
+const commandWaitDelay = 2 * time.Second
+
+// setProcessGroup is a no-op on Windows, exec.CommandContext kills the process itself
+func setProcessGroup(cmd *exec.Cmd) {}
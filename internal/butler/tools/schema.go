@@ -0,0 +1,123 @@
+package tools
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// EstimateTokens is roughly what declaring the tools costs in every model call,
+// counted like memory entries at four characters per token
+func EstimateTokens(tools []Tool) int {
+	chars := 0
+	for _, tool := range tools {
+		chars += len(tool.Name) + len(tool.Description)
+		if tool.ArgType != nil {
+			if schema, err := json.Marshal(JSONSchema(tool.ArgType)); err == nil {
+				chars += len(schema)
+			}
+		}
+	}
+	return (chars + 3) / 4
+}
+
+// JSONSchema describes the Go type t as a JSON Schema, the way tool arguments are described
+// to the model. Struct fields are named after their json tag and required unless they are
+// omitempty, the description, enum and default tags are added to their schema.
+func JSONSchema(t reflect.Type) map[string]any {
+	// Handle pointer types by dereferencing them
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{
+			"type":  "array",
+			"items": JSONSchema(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]any{"type": "object"}
+	case reflect.Struct:
+		properties := make(map[string]any)
+		var required []string
+
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+
+			// Skip unexported fields
+			if field.PkgPath != "" {
+				continue
+			}
+
+			jsonTag := field.Tag.Get("json")
+			if jsonTag == "-" {
+				continue
+			}
+
+			name := field.Name
+			isOmitEmpty := false
+			if jsonTag != "" {
+				parts := strings.Split(jsonTag, ",")
+				name = parts[0]
+				for _, part := range parts[1:] {
+					if part == "omitempty" {
+						isOmitEmpty = true
+						break
+					}
+				}
+			}
+
+			// Add to required if not omitempty
+			if !isOmitEmpty {
+				required = append(required, name)
+			}
+
+			propSchema := JSONSchema(field.Type)
+
+			// Add description if available - important for LLM understanding
+			descTag := field.Tag.Get("description")
+			if descTag != "" {
+				propSchema["description"] = descTag
+			}
+
+			// Add enum constraints if available
+			enumTag := field.Tag.Get("enum")
+			if enumTag != "" {
+				enumValues := strings.Split(enumTag, ",")
+				propSchema["enum"] = enumValues
+			}
+
+			// Add default value if available
+			defaultTag := field.Tag.Get("default")
+			if defaultTag != "" {
+				propSchema["default"] = defaultTag
+			}
+
+			properties[name] = propSchema
+		}
+
+		schema := map[string]any{
+			"type":       "object",
+			"properties": properties,
+		}
+
+		// Only add required array if there are required fields
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+
+		return schema
+	default:
+		return map[string]any{"type": "string"}
+	}
+}
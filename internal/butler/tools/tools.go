@@ -1,12 +1,18 @@
 package tools
 
-import "reflect"
+import (
+	"context"
+	"reflect"
+)
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
 
 type Tool struct {
-	Name        string
-	Description string
-	Handler     reflect.Value
-	ArgType     reflect.Type
+	Name         string
+	Description  string
+	Handler      reflect.Value
+	ArgType      reflect.Type
+	TakesContext bool // Handler is func(context.Context, Args) and gets the run context so it can be cancelled
 }
 
 func NewButlerTool(name, desc string, fn interface{}) Tool {
@@ -14,20 +20,23 @@ func NewButlerTool(name, desc string, fn interface{}) Tool {
 	fnValue := reflect.ValueOf(fn)
 	fnType := fnValue.Type()
 
-	// Simplify -> tools take one arg as struct
+	// Tools take their args as one struct, optionally preceded by a context
+	takesContext := fnType.NumIn() == 2 && fnType.In(0) == contextType
 	return Tool{
-		Name:        name,
-		Description: desc,
-		Handler:     fnValue,
-		ArgType:     fnType.In(0), // Assumes the tool takes 1 argument (the struct)
+		Name:         name,
+		Description:  desc,
+		Handler:      fnValue,
+		ArgType:      fnType.In(fnType.NumIn() - 1), // The args struct is always the last argument
+		TakesContext: takesContext,
 	}
 }
 
 // ToolCall is a generic representation of an LLM's request to run a tool.
 // It is provider-agnostic.
 type ToolCall struct {
-	ID               string         // Unique ID from the LLM
-	FunctionName     string         // e.g., "read_file"
-	Arguments        map[string]any // The raw arguments (unmarshaled from JSON)
-	ThoughtSignature []byte         // Thought signature for Gemini API
+	ID               string         `json:"id"`                          // Unique ID from the LLM
+	FunctionName     string         `json:"function_name"`               // e.g., "read_file"
+	Arguments        map[string]any `json:"arguments,omitempty"`         // The raw arguments (unmarshaled from JSON)
+	RawArguments     string         `json:"raw_arguments,omitempty"`     // The arguments JSON as sent, kept when it couldn't be unmarshaled
+	ThoughtSignature []byte         `json:"thought_signature,omitempty"` // Thought signature for Gemini API, base64 in JSON
 }
@@ -1,14 +1,32 @@
 package tools
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
+func TestNewButlerTool_WithContext(t *testing.T) {
+	handler := func(ctx context.Context, args struct{ Name string }) (string, error) {
+		return "Hello " + args.Name, nil
+	}
+	tool := NewButlerTool("greet", "Greets a person", handler)
+
+	if !tool.TakesContext {
+		t.Error("Expected tool to take a context")
+	}
+	if tool.ArgType.Kind() != reflect.Struct {
+		t.Errorf("Expected args struct type, got %s", tool.ArgType)
+	}
+}
+
 func TestNewButlerTool(t *testing.T) {
 	handler := func(args struct{ Name string }) (string, error) {
 		return "Hello " + args.Name, nil
@@ -23,6 +41,21 @@ func TestNewButlerTool(t *testing.T) {
 	}
 }
 
+func TestEstimateTokens(t *testing.T) {
+	type args struct {
+		Path string `json:"path" description:"The file to read"`
+	}
+	tool := NewButlerTool("read_file", "Reads a file", func(args) (string, error) { return "", nil })
+
+	// Name, description and schema at four characters per token
+	if got := EstimateTokens([]Tool{tool}); got < 10 || got > 40 {
+		t.Errorf("Expected around 20 tokens for one small tool, got %d", got)
+	}
+	if got := EstimateTokens(nil); got != 0 {
+		t.Errorf("Expected no tokens without tools, got %d", got)
+	}
+}
+
 func TestReadFileFn(t *testing.T) {
 	tmpfile, err := os.CreateTemp("", "example")
 	if err != nil {
@@ -391,14 +424,14 @@ func TestRunCommand(t *testing.T) {
 	args := runCommandArgs{
 		Command: "",
 	}
-	_, err := runCommand(args)
+	_, err := runCommand(context.Background(), args)
 	if err == nil {
 		t.Error("Expected error for empty command")
 	}
 
 	// Test simple echo command
 	args.Command = "echo 'Hello, World!'"
-	result, err := runCommand(args)
+	result, err := runCommand(context.Background(), args)
 	if err != nil {
 		t.Fatalf("runCommand failed for echo: %v", err)
 	}
@@ -408,7 +441,7 @@ func TestRunCommand(t *testing.T) {
 
 	// Test command that outputs to stderr
 	args.Command = "sh -c 'echo \"Error message\" >&2'"
-	result, err = runCommand(args)
+	result, err = runCommand(context.Background(), args)
 	if err != nil {
 		t.Fatalf("runCommand failed for stderr test: %v", err)
 	}
@@ -418,7 +451,7 @@ func TestRunCommand(t *testing.T) {
 
 	// Test command that fails
 	args.Command = "exit 1"
-	result, err = runCommand(args)
+	result, err = runCommand(context.Background(), args)
 	// Note: runCommand doesn't return an error for failed commands, just includes stderr
 	if result == "" {
 		t.Error("Expected some output for failed command")
@@ -426,7 +459,7 @@ func TestRunCommand(t *testing.T) {
 
 	// Test multi-line command
 	args.Command = "echo 'Line 1' && echo 'Line 2'"
-	result, err = runCommand(args)
+	result, err = runCommand(context.Background(), args)
 	if err != nil {
 		t.Fatalf("runCommand failed for multi-line: %v", err)
 	}
@@ -434,3 +467,17 @@ func TestRunCommand(t *testing.T) {
 		t.Errorf("Expected output to contain both lines, got '%s'", result)
 	}
 }
+
+func TestRunCommand_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := runCommand(ctx, runCommandArgs{Command: "echo started && sleep 10"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected command to be killed promptly, took %s", elapsed)
+	}
+}
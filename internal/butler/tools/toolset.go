@@ -2,6 +2,7 @@ package tools
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -259,7 +260,7 @@ type runCommandArgs struct {
 }
 
 // Risky and experimental tool - fix later
-func runCommand(args runCommandArgs) (string, error) {
+func runCommand(ctx context.Context, args runCommandArgs) (string, error) {
 	// Import os/exec is needed, but we need to add it to imports
 	// For now, let's check if the command is empty
 	if args.Command == "" {
@@ -270,8 +271,10 @@ func runCommand(args runCommandArgs) (string, error) {
 	// Using sh -c for shell command support
 	cmdArgs := []string{"/bin/sh", "-c", args.Command}
 
-	// Create the command
-	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+	// Create the command - cancelling the run kills the whole process group
+	cmd := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...)
+	setProcessGroup(cmd)
+	cmd.WaitDelay = commandWaitDelay
 
 	// Create buffers to capture stdout and stderr
 	var stdout, stderr bytes.Buffer
@@ -298,6 +301,11 @@ func runCommand(args runCommandArgs) (string, error) {
 		output = fmt.Sprintf("Command execution failed: %v", err)
 	}
 
+	// Keep whatever the command printed before it was interrupted
+	if ctx.Err() != nil {
+		return output, ctx.Err()
+	}
+
 	return output, nil
 }
 
@@ -0,0 +1,424 @@
+// Package transcript keeps what happened in a conversation the way a person reads it:
+// prompts, streamed answers, reasoning, tool calls with their results, sub-agents and
+// errors. The agent's memory only holds what the model needs, the transcript is what
+// frontends render, it is fed by the agent's events and can be rebuilt from a memory.
+package transcript
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler/agent"
+	"github.com/mightymoud/arlocode/internal/butler/compaction"
+	"github.com/mightymoud/arlocode/internal/butler/delegate"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// Role tells who an entry comes from
+type Role string
+
+const (
+	User     Role = "user"
+	Agent    Role = "agent"    // One entry per model call, with its reasoning, answer and tool calls
+	Tool     Role = "tool"     // One entry per tool call, holding its result
+	SubAgent Role = "subagent" // A task the agent delegated, with the sub-agent's output
+	Summary  Role = "summary"  // What compaction kept of the earlier conversation
+)
+
+// Status tells whether an entry is still being produced and how it ended
+type Status string
+
+const (
+	Running     Status = "running"
+	Done        Status = "done"
+	Interrupted Status = "interrupted" // The run was cancelled while the entry was produced
+	Failed      Status = "failed"      // An Error part says why
+	Denied      Status = "denied"      // Tool calls the approval policy or the user refused
+)
+
+// PartKind is the type of a part of an entry
+type PartKind string
+
+const (
+	Text       PartKind = "text"
+	Thinking   PartKind = "thinking"
+	ToolCall   PartKind = "tool_call"
+	ToolResult PartKind = "tool_result"
+	Error      PartKind = "error"
+	Attachment PartKind = "attachment" // An image or file sent with a prompt, Text names it
+)
+
+// Part is a piece of an entry. Text holds the content of text, thinking and error parts,
+// the output of tool results and the name of attachments, Call is set on tool calls and tool results.
+type Part struct {
+	Kind PartKind
+	Text string
+	Call tools.ToolCall
+}
+
+// Entry is one message of the transcript
+type Entry struct {
+	ID         int       // Unique within the transcript, increasing
+	Time       time.Time // When the entry started, zero for entries rebuilt from a memory
+	Role       Role
+	RunID      string // Run that produced the entry, see agent.EventMeta
+	Turn       int    // Model call within the run
+	Title      string // The task of sub-agent entries
+	SubAgentID string
+	Parts      []Part
+	Status     Status
+}
+
+// Text joins the parts of the given kind
+func (e Entry) Text(kind PartKind) string {
+	var texts []string
+	for _, part := range e.Parts {
+		if part.Kind == kind && part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// Transcript is safe for concurrent use, it is usually written by the goroutine running
+// the agent and read by the frontend
+type Transcript struct {
+	mu       sync.Mutex
+	entries  []Entry
+	nextID   int
+	runID    string // Run in progress, empty between runs
+	onChange func()
+}
+
+func New() *Transcript {
+	return &Transcript{}
+}
+
+// WithOnChange calls f after every change, f must return quickly and must not call back into the transcript while holding locks of its own
+func (t *Transcript) WithOnChange(f func()) *Transcript {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onChange = f
+	return t
+}
+
+// Entries returns a copy of the entries, oldest first
+func (t *Transcript) Entries() []Entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entries := make([]Entry, len(t.entries))
+	for i, entry := range t.entries {
+		entry.Parts = slices.Clone(entry.Parts)
+		entries[i] = entry
+	}
+	return entries
+}
+
+// Len is the number of entries
+func (t *Transcript) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.entries)
+}
+
+// Running tells whether a run of the agent is in progress
+func (t *Transcript) Running() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.runID != ""
+}
+
+// Load replaces the entries with the conversation held in a memory, e.g. of a resumed
+// session or after the memory was truncated
+func (t *Transcript) Load(entries []memory.MemoryEntry) {
+	t.update(func() {
+		t.entries = nil
+		t.runID = ""
+		// Calls waiting for their result, in order. Results of calls without an ID answer the oldest one.
+		var calls []tools.ToolCall
+		for _, entry := range entries {
+			status := Done
+			if entry.Interrupted {
+				status = Interrupted
+			}
+			switch entry.Role {
+			case memory.Tool:
+				result, _ := entry.ToolResult()
+				call := tools.ToolCall{ID: result.ToolCallID, FunctionName: result.ToolName}
+				if i := slices.IndexFunc(calls, func(c tools.ToolCall) bool { return c.ID == result.ToolCallID }); i >= 0 {
+					call = calls[i]
+					calls = slices.Delete(calls, i, i+1)
+				}
+				if result.IsError {
+					status = Failed
+				}
+				t.add(Entry{Role: Tool, Status: status, Parts: []Part{{Kind: ToolResult, Text: result.Text, Call: call}}})
+			case memory.User:
+				if text := entry.Text(); strings.HasPrefix(text, compaction.SummaryPrefix) {
+					t.add(Entry{Role: Summary, Status: Done, Parts: []Part{{Kind: Text, Text: text}}})
+					continue
+				}
+				e := Entry{Role: User, Status: Done}
+				for _, part := range entry.Parts {
+					switch part.Type {
+					case memory.TextPart:
+						e.Parts = append(e.Parts, Part{Kind: Text, Text: part.Text})
+					case memory.ImagePart, memory.FilePart:
+						e.Parts = append(e.Parts, attachment(part))
+					}
+				}
+				t.add(e)
+			case memory.Model:
+				e := Entry{Role: Agent, Status: status}
+				for _, part := range entry.Parts {
+					switch part.Type {
+					case memory.TextPart:
+						if part.Text != "" {
+							e.Parts = append(e.Parts, Part{Kind: Text, Text: part.Text})
+						}
+					case memory.ThinkingPart:
+						e.Parts = append(e.Parts, Part{Kind: Thinking, Text: part.Text})
+					case memory.ToolCallPart:
+						calls = append(calls, *part.ToolCall)
+						e.Parts = append(e.Parts, Part{Kind: ToolCall, Call: *part.ToolCall})
+					}
+				}
+				t.add(e)
+			}
+		}
+	})
+}
+
+// attachment names an image or file part, by its path when it has one
+func attachment(part memory.Part) Part {
+	name := part.Path
+	if name == "" {
+		name = part.MIMEType
+	}
+	return Part{Kind: Attachment, Text: name}
+}
+
+// Handle records an agent event, subscribe it with Agent.Subscribe
+func (t *Transcript) Handle(event agent.Event) {
+	meta := event.Meta()
+	switch e := event.(type) {
+	case agent.RunStarted:
+		t.update(func() {
+			t.runID = meta.RunID
+			var parts []Part
+			if e.Prompt != "" {
+				parts = append(parts, Part{Kind: Text, Text: e.Prompt})
+			}
+			for _, a := range e.Attachments {
+				parts = append(parts, attachment(a))
+			}
+			if len(parts) > 0 {
+				t.add(Entry{Time: meta.Time, Role: User, RunID: meta.RunID, Status: Done, Parts: parts})
+			}
+		})
+	case agent.Steered:
+		t.update(func() {
+			t.finishTurns(meta.RunID, Done)
+			t.add(Entry{Time: meta.Time, Role: User, RunID: meta.RunID, Status: Done, Parts: []Part{{Kind: Text, Text: strings.Join(e.Messages, "\n\n")}}})
+		})
+	case agent.TurnStarted:
+		t.update(func() { t.finishTurns(meta.RunID, Done) })
+	case agent.TextChunk:
+		t.update(func() { t.turn(meta).appendText(Text, e.Text) })
+	case agent.ThinkingChunk:
+		t.update(func() { t.turn(meta).appendText(Thinking, e.Text) })
+	case agent.TextDone:
+		t.update(func() { t.finishTurns(meta.RunID, Done) })
+	case agent.StreamReset:
+		t.update(func() {
+			t.entries = slices.DeleteFunc(t.entries, func(entry Entry) bool {
+				return entry.Role == Agent && entry.RunID == meta.RunID && entry.Turn == meta.Turn
+			})
+		})
+	case agent.ToolCallRequested:
+		t.update(func() { t.turn(meta).setCall(e.Call) })
+	case agent.ToolStarted:
+		t.update(func() {
+			t.finishTurns(meta.RunID, Done)
+			t.add(Entry{Time: meta.Time, Role: Tool, RunID: meta.RunID, Turn: meta.Turn, Status: Running, Parts: []Part{{Kind: ToolResult, Call: e.Call}}})
+		})
+	case agent.ToolFinished:
+		t.update(func() { t.finishTool(meta, e) })
+	case agent.RunFinished:
+		t.update(func() {
+			status := Done
+			switch {
+			case errors.Is(e.Err, context.Canceled):
+				status = Interrupted
+			case e.Err != nil:
+				status = Failed
+			}
+			last := t.running(meta.RunID, Agent)
+			for i := range t.entries {
+				if entry := &t.entries[i]; entry.RunID == meta.RunID && entry.Status == Running {
+					entry.Status = status
+				}
+			}
+			if status == Failed {
+				if last == nil {
+					last = t.add(Entry{Time: meta.Time, Role: Agent, RunID: meta.RunID, Turn: meta.Turn, Status: Failed})
+				}
+				last.Parts = append(last.Parts, Part{Kind: Error, Text: e.Err.Error()})
+			}
+			t.runID = ""
+		})
+	}
+}
+
+// SubAgentHooks records the sub-agents the agent delegates to, pass them to delegate.Config
+func (t *Transcript) SubAgentHooks() delegate.Hooks {
+	return delegate.Hooks{
+		OnStart: func(id, task string) {
+			t.update(func() {
+				t.add(Entry{Time: time.Now(), Role: SubAgent, RunID: t.runID, Title: task, SubAgentID: id, Status: Running})
+			})
+		},
+		OnTextChunk: func(id, chunk string) {
+			t.update(func() {
+				if entry := t.subAgent(id); entry != nil {
+					entry.appendText(Text, chunk)
+				}
+			})
+		},
+		OnToolCall: func(id string, call tools.ToolCall) {
+			t.update(func() {
+				if entry := t.subAgent(id); entry != nil {
+					entry.setCall(call)
+				}
+			})
+		},
+		OnDone: func(id, answer string, err error) {
+			t.update(func() {
+				entry := t.subAgent(id)
+				if entry == nil {
+					return
+				}
+				entry.Status = Done
+				if err != nil {
+					entry.Status = Failed
+					entry.Parts = append(entry.Parts, Part{Kind: Error, Text: err.Error()})
+				}
+			})
+		},
+	}
+}
+
+// update runs f under the lock and reports the change
+func (t *Transcript) update(f func()) {
+	t.mu.Lock()
+	f()
+	onChange := t.onChange
+	t.mu.Unlock()
+	if onChange != nil {
+		onChange()
+	}
+}
+
+// add appends an entry with the next ID and returns it, the pointer is valid until the next add
+func (t *Transcript) add(entry Entry) *Entry {
+	t.nextID++
+	entry.ID = t.nextID
+	t.entries = append(t.entries, entry)
+	return &t.entries[len(t.entries)-1]
+}
+
+// turn returns the entry of the model call the event belongs to, starting it on the first chunk.
+// Tool calls may arrive after the answer was done streaming, they still belong to it.
+func (t *Transcript) turn(meta agent.EventMeta) *Entry {
+	for i := len(t.entries) - 1; i >= 0; i-- {
+		if entry := &t.entries[i]; entry.Role == Agent && entry.RunID == meta.RunID && entry.Turn == meta.Turn {
+			return entry
+		}
+	}
+	return t.add(Entry{Time: meta.Time, Role: Agent, RunID: meta.RunID, Turn: meta.Turn, Status: Running})
+}
+
+// running returns the latest entry of the run with the role that is still being produced
+func (t *Transcript) running(runID string, role Role) *Entry {
+	for i := len(t.entries) - 1; i >= 0; i-- {
+		entry := &t.entries[i]
+		if entry.Role == role && entry.RunID == runID && entry.Status == Running {
+			return entry
+		}
+	}
+	return nil
+}
+
+// finishTurns ends the model calls of the run that are still streaming
+func (t *Transcript) finishTurns(runID string, status Status) {
+	for i := range t.entries {
+		entry := &t.entries[i]
+		if entry.RunID == runID && entry.Status == Running && entry.Role != Tool {
+			entry.Status = status
+		}
+	}
+}
+
+// finishTool ends the entry of the call. Calls without an ID, as Gemini sends them, run in
+// the order they were made, so the oldest running one is the call that finished.
+func (t *Transcript) finishTool(meta agent.EventMeta, e agent.ToolFinished) {
+	var entry *Entry
+	for i := range t.entries {
+		if candidate := &t.entries[i]; candidate.Role == Tool && candidate.RunID == meta.RunID && candidate.Status == Running && candidate.Parts[0].Call.ID == e.Call.ID {
+			entry = candidate
+			break
+		}
+	}
+	if entry == nil {
+		// Calls that never started, like denied ones, get their entry here
+		t.finishTurns(meta.RunID, Done)
+		entry = t.add(Entry{Time: meta.Time, Role: Tool, RunID: meta.RunID, Turn: meta.Turn, Parts: []Part{{Kind: ToolResult, Call: e.Call}}})
+	}
+	entry.Parts[0].Text = e.Output
+	switch {
+	case e.Denied:
+		entry.Status = Denied
+	case e.Interrupted:
+		entry.Status = Interrupted
+	case e.Err != nil:
+		entry.Status = Failed
+		entry.Parts = append(entry.Parts, Part{Kind: Error, Text: e.Err.Error()})
+	default:
+		entry.Status = Done
+	}
+}
+
+func (t *Transcript) subAgent(id string) *Entry {
+	for i := len(t.entries) - 1; i >= 0; i-- {
+		if entry := &t.entries[i]; entry.Role == SubAgent && entry.SubAgentID == id {
+			return entry
+		}
+	}
+	return nil
+}
+
+// appendText adds streamed text to the last part when it has the same kind
+func (e *Entry) appendText(kind PartKind, text string) {
+	if n := len(e.Parts); n > 0 && e.Parts[n-1].Kind == kind {
+		e.Parts[n-1].Text += text
+		return
+	}
+	e.Parts = append(e.Parts, Part{Kind: kind, Text: text})
+}
+
+// setCall adds a tool call, or updates it while its arguments are streamed.
+// Providers that stream the arguments always send an ID, a call without one is a new call.
+func (e *Entry) setCall(call tools.ToolCall) {
+	for i, part := range e.Parts {
+		if call.ID != "" && part.Kind == ToolCall && part.Call.ID == call.ID {
+			e.Parts[i].Call = call
+			return
+		}
+	}
+	e.Parts = append(e.Parts, Part{Kind: ToolCall, Call: call})
+}
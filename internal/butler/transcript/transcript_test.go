@@ -0,0 +1,222 @@
+package transcript
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+	"github.com/mightymoud/arlocode/internal/butler/agent"
+	"github.com/mightymoud/arlocode/internal/butler/llm/fake"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// shape describes an entry by its role, status and the kinds of its parts
+type shape struct {
+	role   Role
+	status Status
+	parts  []PartKind
+}
+
+func checkShapes(t *testing.T, entries []Entry, want []shape) {
+	t.Helper()
+	if len(entries) != len(want) {
+		t.Fatalf("Expected %d entries, got %+v", len(want), entries)
+	}
+	for i, entry := range entries {
+		var kinds []PartKind
+		for _, part := range entry.Parts {
+			kinds = append(kinds, part.Kind)
+		}
+		if entry.Role != want[i].role || entry.Status != want[i].status || len(kinds) != len(want[i].parts) {
+			t.Errorf("Entry %d: expected %+v, got %s %s %v", i, want[i], entry.Role, entry.Status, kinds)
+			continue
+		}
+		for j := range kinds {
+			if kinds[j] != want[i].parts[j] {
+				t.Errorf("Entry %d: expected parts %v, got %v", i, want[i].parts, kinds)
+				break
+			}
+		}
+	}
+}
+
+func TestTranscript_Handle(t *testing.T) {
+	lookup := tools.NewButlerTool("lookup", "Looks something up", func(args struct{}) (string, error) {
+		return "found it", nil
+	})
+	model := fake.New(t).
+		Turn().Thinking("let me look").Text("Looking it up").CallTool("lookup", map[string]any{}).
+		Turn().Text("It's there")
+	a := agent.NewAgent(model).WitTools([]tools.Tool{lookup})
+
+	changes := 0
+	tr := New().WithOnChange(func() { changes++ })
+	a.Subscribe(tr.Handle)
+	if _, err := a.Run(context.Background(), "where is it?"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	entries := tr.Entries()
+	checkShapes(t, entries, []shape{
+		{User, Done, []PartKind{Text}},
+		{Agent, Done, []PartKind{Thinking, Text, ToolCall}},
+		{Tool, Done, []PartKind{ToolResult}},
+		{Agent, Done, []PartKind{Text}},
+	})
+	if got := entries[2].Parts[0]; got.Text != "found it" || got.Call.FunctionName != "lookup" {
+		t.Errorf("Expected the tool result with its call, got %+v", got)
+	}
+	if got := entries[3].Text(Text); got != "It's there" {
+		t.Errorf("Expected the answer, got %q", got)
+	}
+	for i, entry := range entries {
+		if entry.ID != i+1 || entry.Time.IsZero() || entry.RunID == "" {
+			t.Errorf("Expected entry %d to have an ID, a time and a run, got %+v", i, entry)
+		}
+	}
+	if changes == 0 || tr.Running() {
+		t.Errorf("Expected changes to be reported and the run to be over, got %d changes", changes)
+	}
+
+	// The memory holds the same conversation, the reasoning included
+	rebuilt := New()
+	rebuilt.Load(a.GetMemory())
+	checkShapes(t, rebuilt.Entries(), []shape{
+		{User, Done, []PartKind{Text}},
+		{Agent, Done, []PartKind{Thinking, Text, ToolCall}},
+		{Tool, Done, []PartKind{ToolResult}},
+		{Agent, Done, []PartKind{Text}},
+	})
+}
+
+func TestTranscript_CallsWithoutID(t *testing.T) {
+	// Gemini sends tool calls without IDs, two of them in one turn must stay apart
+	first := tools.ToolCall{FunctionName: "read_file", Arguments: map[string]any{"path": "a.go"}}
+	second := tools.ToolCall{FunctionName: "read_file", Arguments: map[string]any{"path": "b.go"}}
+	meta := agent.EventMeta{RunID: "run-1", Turn: 1}
+
+	tr := New()
+	for _, event := range []agent.Event{
+		agent.RunStarted{EventMeta: meta, Prompt: "compare them"},
+		agent.ToolCallRequested{EventMeta: meta, Call: first},
+		agent.ToolCallRequested{EventMeta: meta, Call: second},
+		agent.ToolStarted{EventMeta: meta, Call: first},
+		agent.ToolFinished{EventMeta: meta, Call: first, Output: "package a"},
+		agent.ToolStarted{EventMeta: meta, Call: second},
+		agent.ToolFinished{EventMeta: meta, Call: second, Output: "package b"},
+		agent.RunFinished{EventMeta: meta},
+	} {
+		tr.Handle(event)
+	}
+
+	entries := tr.Entries()
+	checkShapes(t, entries, []shape{
+		{User, Done, []PartKind{Text}},
+		{Agent, Done, []PartKind{ToolCall, ToolCall}},
+		{Tool, Done, []PartKind{ToolResult}},
+		{Tool, Done, []PartKind{ToolResult}},
+	})
+	if entries[2].Parts[0].Text != "package a" || entries[3].Parts[0].Text != "package b" {
+		t.Errorf("Expected each result on its own call, got %+v and %+v", entries[2].Parts[0], entries[3].Parts[0])
+	}
+
+	rebuilt := New()
+	rebuilt.Load([]memory.MemoryEntry{
+		memory.Text(memory.User, "compare them"),
+		memory.Response("", []tools.ToolCall{first, second}),
+		memory.ToolResult(first, "package a", false),
+		memory.ToolResult(second, "package b", false),
+	})
+	entries = rebuilt.Entries()
+	if len(entries) != 4 || entries[2].Parts[0].Call.Arguments["path"] != "a.go" || entries[3].Parts[0].Call.Arguments["path"] != "b.go" {
+		t.Errorf("Expected each rebuilt result on its own call, got %+v", entries)
+	}
+}
+
+func TestTranscript_FailedRun(t *testing.T) {
+	model := fake.New(t).
+		Turn().Fail(fake.ProviderError(butler.ErrAuth))
+	a := agent.NewAgent(model).WithNoTools()
+	tr := New()
+	a.Subscribe(tr.Handle)
+	if _, err := a.Run(context.Background(), "hi"); err == nil {
+		t.Fatal("Expected the run to fail")
+	}
+
+	entries := tr.Entries()
+	checkShapes(t, entries, []shape{
+		{User, Done, []PartKind{Text}},
+		{Agent, Failed, []PartKind{Error}},
+	})
+	if got := entries[1].Text(Error); got == "" {
+		t.Error("Expected the error in the transcript")
+	}
+}
+
+func TestTranscript_Interrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	slow := tools.NewButlerTool("slow", "Takes a while", func(args struct{}) (string, error) {
+		cancel()
+		return "", errors.New("cancelled")
+	})
+	model := fake.New(t).
+		Turn().CallTool("slow", map[string]any{}).CallTool("slow", map[string]any{})
+	a := agent.NewAgent(model).WitTools([]tools.Tool{slow})
+	tr := New()
+	a.Subscribe(tr.Handle)
+	if _, err := a.Run(ctx, "go"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the run to be cancelled, got %v", err)
+	}
+
+	checkShapes(t, tr.Entries(), []shape{
+		{User, Done, []PartKind{Text}},
+		{Agent, Done, []PartKind{ToolCall, ToolCall}},
+		{Tool, Interrupted, []PartKind{ToolResult}},
+		{Tool, Interrupted, []PartKind{ToolResult}},
+	})
+}
+
+func TestTranscript_Attachments(t *testing.T) {
+	a := agent.NewAgent(fake.New(t).Turn().Text("a typo")).WithNoTools()
+	tr := New()
+	a.Subscribe(tr.Handle)
+	screenshot := memory.Image("image/png", []byte("png"))
+	screenshot.Path = "shot.png"
+	if _, err := a.Run(context.Background(), "what's wrong?", screenshot); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	want := []shape{
+		{User, Done, []PartKind{Text, Attachment}},
+		{Agent, Done, []PartKind{Text}},
+	}
+	checkShapes(t, tr.Entries(), want)
+	if got := tr.Entries()[0].Parts[1].Text; got != "shot.png" {
+		t.Errorf("Expected the attachment to be named after its file, got %q", got)
+	}
+
+	rebuilt := New()
+	rebuilt.Load(a.GetMemory())
+	checkShapes(t, rebuilt.Entries(), want)
+}
+
+func TestTranscript_SubAgentHooks(t *testing.T) {
+	tr := New()
+	hooks := tr.SubAgentHooks()
+	hooks.OnStart("sub-1", "find the callers")
+	hooks.OnTextChunk("sub-1", "Looking")
+	hooks.OnToolCall("sub-1", tools.ToolCall{ID: "c1", FunctionName: "grep"})
+	// A retried model call streams the same call again
+	hooks.OnToolCall("sub-1", tools.ToolCall{ID: "c1", FunctionName: "grep"})
+	hooks.OnTextChunk("sub-1", "Found ")
+	hooks.OnTextChunk("sub-1", "two")
+	hooks.OnDone("sub-1", "Found two", errors.New("budget exhausted"))
+
+	entries := tr.Entries()
+	checkShapes(t, entries, []shape{{SubAgent, Failed, []PartKind{Text, ToolCall, Text, Error}}})
+	if entries[0].Title != "find the callers" || entries[0].Text(Text) != "Looking\nFound two" {
+		t.Errorf("Expected the task and the output, got %+v", entries[0])
+	}
+}
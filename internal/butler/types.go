@@ -7,6 +7,7 @@ type OnStreamCompleteFunc func()
 type OnThinkingChunkFunc func(string)
 type OnThinkingCompleteFunc func()
 type OnToolCallFunc func(tools.ToolCall)
+type OnStreamResetFunc func()
 
 type EventHooks struct {
 	OnTextChunk        func(string)
@@ -14,4 +15,5 @@ type EventHooks struct {
 	OnThinkingChunk    func(string)
 	OnThinkingComplete func()
 	OnToolCall         func(tools.ToolCall)
+	OnStreamReset      func() // The stream is being retried, frontends should discard the chunks they got so far
 }
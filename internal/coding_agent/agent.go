@@ -2,13 +2,217 @@ package coding_agent
 
 import (
 	"context"
+	"fmt"
+	"slices"
 
 	"github.com/mightymoud/arlocode/internal/butler/agent"
+	"github.com/mightymoud/arlocode/internal/butler/approval"
+	"github.com/mightymoud/arlocode/internal/butler/compaction"
+	"github.com/mightymoud/arlocode/internal/butler/delegate"
+	"github.com/mightymoud/arlocode/internal/butler/llm"
+	"github.com/mightymoud/arlocode/internal/butler/llm/retry"
+	"github.com/mightymoud/arlocode/internal/butler/llm/router"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
 	"github.com/mightymoud/arlocode/internal/butler/providers/openrouter"
+	"github.com/mightymoud/arlocode/internal/butler/sysprompt"
+	"github.com/mightymoud/arlocode/internal/butler/todo"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+	"github.com/mightymoud/arlocode/internal/coding_agent/hats"
 )
 
-var ctx = context.Background()
-var provider = openrouter.New(ctx)
-var model = provider.Model(ctx, "anthropic/claude-sonnet-4.5")
+// modelID is the model of hats that don't pick their own
+const modelID = "anthropic/claude-sonnet-4.5"
 
-var Agent = agent.NewAgent(model)
+// summaryModelID writes the conversation summaries when the context fills up, it only needs to be cheap and fast
+const summaryModelID = "google/gemini-2.5-flash"
+
+// goFlags are the flags Go commands may run with without asking. None of them write
+// files, read extra configuration or hand the build to another program, unlike
+// -o, -coverprofile, -overlay, -modfile, -C, -ldflags, -toolexec or -mod=mod.
+var goFlags = []string{"-run", "-v", "-count", "-race", "-short", "-timeout", "-failfast", "-cover"}
+
+// ApprovalPolicy lets read-only tools and Go test/build/vet commands run freely
+// and asks the user before anything else touches the system.
+// The Go commands are only allowed as one simple command with literal arguments, so
+// "go test ./... && rm -rf ." or a second line can't slip through, and only with goFlags.
+var ApprovalPolicy = approval.NewRulePolicy(approval.Ask,
+	approval.Rule{Tool: "run_command", Argument: "command", Pattern: "*;*", Decision: approval.Ask},
+	approval.Rule{Tool: "run_command", Argument: "command", Pattern: "*&*", Decision: approval.Ask},
+	approval.Rule{Tool: "run_command", Argument: "command", Pattern: "*|*", Decision: approval.Ask},
+	approval.Rule{Tool: "run_command", Argument: "command", Pattern: "*>*", Decision: approval.Ask},
+	approval.Rule{Tool: "run_command", Argument: "command", Pattern: "*<*", Decision: approval.Ask},
+	approval.Rule{Tool: "run_command", Argument: "command", Pattern: "*`*", Decision: approval.Ask},
+	approval.Rule{Tool: "run_command", Argument: "command", Pattern: "*$(*", Decision: approval.Ask},
+	approval.Rule{Tool: "run_command", Argument: "command", Pattern: "*\n*", Decision: approval.Ask},
+	approval.Rule{Tool: "run_command", Argument: "command", Pattern: "*\r*", Decision: approval.Ask},
+	approval.Rule{Tool: "read_file", Decision: approval.Allow},
+	approval.Rule{Tool: "read_folder", Decision: approval.Allow},
+	approval.Rule{Tool: "list_folder_contents", Decision: approval.Allow},
+	approval.Rule{Tool: "search_code", Decision: approval.Allow},
+	approval.Rule{Tool: "fetch_url_as_markdown", Decision: approval.Allow},
+	approval.Rule{Tool: delegate.ToolName, Decision: approval.Allow},
+	approval.Rule{Tool: todo.WriteToolName, Decision: approval.Allow},
+	approval.Rule{Tool: todo.ReadToolName, Decision: approval.Allow},
+	approval.Rule{Tool: "run_command", Argument: "command", Pattern: "go test *", Command: true, Flags: goFlags, Decision: approval.Allow},
+	approval.Rule{Tool: "run_command", Argument: "command", Pattern: "go build *", Command: true, Flags: goFlags, Decision: approval.Allow},
+	approval.Rule{Tool: "run_command", Argument: "command", Pattern: "go vet *", Command: true, Flags: goFlags, Decision: approval.Allow},
+)
+
+// defaultMaxIterations is used by hats that don't set their own limit
+const defaultMaxIterations = 50
+
+// Options are the parts of the coding agent the frontend provides
+type Options struct {
+	// Hat is the hat to start with, DefaultHat when empty
+	Hat string
+
+	// SubAgentHooks report what delegated sub-agents are doing
+	SubAgentHooks delegate.Hooks
+
+	// Middlewares wrap every model the agent calls, sub-agents and summaries included.
+	// They sit outside the retries, so a retried call goes through them once.
+	Middlewares []llm.Middleware
+}
+
+// CodingAgent is the agent behind arlocode. It wears one hat at a time and can switch mid-session.
+type CodingAgent struct {
+	*agent.Agent
+	provider   *openrouter.OpenRouterProvider
+	summarizer llm.LLM
+	todos      *todo.List
+	opts       Options
+	hats       []hats.Hat
+	hat        hats.Hat
+	modelID    string
+
+	router            *router.Router
+	cheapModelID      string // Empty when the hat has no cheap model
+	pinned            string // Set by PinModel
+	onRoutingDecision func(router.Decision)
+}
+
+// New builds the coding agent, it fails when the provider isn't configured or the hat doesn't exist
+func New(ctx context.Context, opts Options) (*CodingAgent, error) {
+	provider, err := openrouter.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hatList, err := LoadHats()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &CodingAgent{
+		Agent:      agent.NewAgent(nil, opts.Middlewares...).WithApprovalPolicy(ApprovalPolicy),
+		provider:   provider,
+		summarizer: llm.Chain(retry.New(provider.Model(ctx, summaryModelID), retry.DefaultConfig()), opts.Middlewares...),
+		todos:      todo.NewList(nil),
+		opts:       opts,
+		hats:       hatList,
+	}
+
+	name := opts.Hat
+	if name == "" {
+		name = hats.DefaultHat
+	}
+	if err := c.WearHat(ctx, name); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Hat is the hat the agent wears
+func (c *CodingAgent) Hat() hats.Hat {
+	return c.hat
+}
+
+// Hats lists the hats the agent can wear
+func (c *CodingAgent) Hats() []hats.Hat {
+	return c.hats
+}
+
+// Todos is the task list the agent keeps with todo_write, it survives hat switches and compaction
+func (c *CodingAgent) Todos() *todo.List {
+	return c.todos
+}
+
+// ModelID is the model of the current hat
+func (c *CodingAgent) ModelID() string {
+	return c.modelID
+}
+
+// WearHat switches the model, tools, system prompt and budget to the ones of the hat.
+// The memory is kept, so the conversation carries on. It must not be called while Run is in progress.
+func (c *CodingAgent) WearHat(ctx context.Context, name string) error {
+	hat, err := hats.Find(c.hats, name)
+	if err != nil {
+		return err
+	}
+
+	id := hat.Model
+	if id == "" {
+		id = modelID
+	}
+	// A failing model is replaced right away, the retries only start once all the models failed,
+	// so long unattended runs don't die on the first 429 or 5xx
+	modelRouter, cheap := c.modelRouter(ctx, hat, id)
+	model := retry.New(modelRouter, retry.DefaultConfig())
+
+	// Sub-agents only read, their policy denies anything else, so they never need to stop and ask the user
+	subAgents := delegate.DefaultConfig(llm.Chain(model, c.opts.Middlewares...))
+	subAgents.Hooks = c.opts.SubAgentHooks
+	// What sub-agents spend is part of what the session costs
+	onUsage := subAgents.Hooks.OnUsage
+	subAgents.Hooks.OnUsage = func(id string, usage providers.Usage) {
+		c.Agent.AddUsage(usage)
+		if onUsage != nil {
+			onUsage(id, usage)
+		}
+	}
+	available := append(slices.Clone(tools.StdToolset), delegate.NewTool(subAgents))
+	agentTools, err := hatTools(hat, append(available, c.todos.Tools()...))
+	if err != nil {
+		return err
+	}
+
+	maxIterations := hat.MaxIterations
+	if maxIterations == 0 {
+		maxIterations = defaultMaxIterations
+	}
+
+	compactionConfig := compaction.DefaultConfig(compaction.ContextWindow(id), c.summarizer)
+	// The summary may blur which tasks are done, so the list is restated as it is
+	compactionConfig.Pinned = c.todos.Reminder
+
+	c.Agent.WithLLM(model).
+		WitTools(agentTools).
+		WithSystemPromptFunc(sysprompt.Assembler{Base: hat.SystemPrompt, Dir: "."}.Assemble).
+		WithMaxIterations(maxIterations).
+		WithBudget(hat.Budget).
+		WithCompaction(compaction.New(compactionConfig))
+	c.hat = hat
+	c.modelID = id
+	c.cheapModelID = cheap
+	c.router = modelRouter
+	// The user's choice of model outlives the hat, unless the new hat has no such model
+	if err := c.PinModel(c.PinnedModel()); err != nil {
+		c.pinned = AutoModel
+	}
+	return nil
+}
+
+// hatTools keeps the tools the hat allows, a hat naming a tool that doesn't exist is a mistake worth reporting
+func hatTools(hat hats.Hat, available []tools.Tool) ([]tools.Tool, error) {
+	for _, name := range hat.Tools {
+		if !slices.ContainsFunc(available, func(t tools.Tool) bool { return t.Name == name }) {
+			return nil, fmt.Errorf("hat %s allows unknown tool %q", hat.Name, name)
+		}
+	}
+	var allowed []tools.Tool
+	for _, t := range available {
+		if hat.Allows(t.Name) {
+			allowed = append(allowed, t)
+		}
+	}
+	return allowed, nil
+}
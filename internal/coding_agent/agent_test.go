@@ -0,0 +1,116 @@
+package coding_agent
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/mightymoud/arlocode/internal/butler/approval"
+	"github.com/mightymoud/arlocode/internal/butler/delegate"
+	"github.com/mightymoud/arlocode/internal/butler/todo"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+	"github.com/mightymoud/arlocode/internal/coding_agent/hats"
+)
+
+func TestHatTools(t *testing.T) {
+	available := append(slices.Clone(tools.StdToolset), delegate.NewTool(delegate.DefaultConfig(nil)))
+	available = append(available, todo.NewList(nil).Tools()...)
+
+	for _, hat := range hats.Builtin {
+		if _, err := hatTools(hat, available); err != nil {
+			t.Errorf("Built-in hat %s: %v", hat.Name, err)
+		}
+	}
+
+	plan, _ := hats.Find(hats.Builtin, "plan")
+	allowed, _ := hatTools(plan, available)
+	for _, tool := range allowed {
+		if tool.Name == "run_command" || tool.Name == "apply_edit" || tool.Name == "make_file" {
+			t.Errorf("Expected the plan hat to be read-only, got %s", tool.Name)
+		}
+	}
+
+	if _, err := hatTools(hats.Hat{Name: "typo", Tools: []string{"raed_file"}}, available); err == nil {
+		t.Error("Expected an unknown tool to be reported")
+	}
+}
+
+func TestCodingAgent_Routing(t *testing.T) {
+	t.Setenv("OPENROUTER_API_KEY", "test")
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	c, err := New(context.Background(), Options{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	want := []string{modelID, fallbackModelIDs[0], cheapModelID}
+	if got := c.router.Models(); !slices.Equal(got, want) {
+		t.Errorf("Expected the models %v in fallback order, got %v", want, got)
+	}
+
+	if err := c.PinModel(StrongModel); err != nil {
+		t.Fatalf("PinModel failed: %v", err)
+	}
+	if c.router.Pinned() != modelID {
+		t.Errorf("Expected the strong model to be pinned, got %q", c.router.Pinned())
+	}
+	// The pin survives a hat switch
+	if err := c.WearHat(context.Background(), "plan"); err != nil {
+		t.Fatalf("WearHat failed: %v", err)
+	}
+	if c.PinnedModel() != StrongModel || c.router.Pinned() != modelID {
+		t.Errorf("Expected the strong model to stay pinned, got %s (%q)", c.PinnedModel(), c.router.Pinned())
+	}
+
+	c.hats = append(c.hats, hats.Hat{Name: "solo", CheapModel: noCheapModel})
+	if err := c.WearHat(context.Background(), "solo"); err != nil {
+		t.Fatalf("WearHat failed: %v", err)
+	}
+	if err := c.PinModel(CheapModel); err == nil {
+		t.Error("Expected an error pinning the cheap model of a hat without one")
+	}
+	if got := c.router.Models(); slices.Contains(got, cheapModelID) {
+		t.Errorf("Expected no cheap model, got %v", got)
+	}
+}
+
+func TestApprovalPolicy(t *testing.T) {
+	cases := []struct {
+		command string
+		want    approval.Decision
+	}{
+		{"go test ./...", approval.Allow},
+		{"go test -run 'TestA|TestB' ./internal/...", approval.Ask},
+		{"go build ./...", approval.Allow},
+		{"go vet ./...", approval.Allow},
+		{"go test ./... && rm -rf .", approval.Ask},
+		{"go test ./...\nrm -rf ~", approval.Ask},
+		{"go test ./...\rrm -rf ~", approval.Ask},
+		{"go test ./... < /etc/shadow", approval.Ask},
+		{"go test -exec /tmp/evil ./...", approval.Ask},
+		{"go test -exec=/tmp/evil ./...", approval.Ask},
+		{"go test -ex'ec' /tmp/evil ./...", approval.Ask},
+		{"go build -toolexec /tmp/evil ./...", approval.Ask},
+		{"go vet -vettool=/tmp/evil ./...", approval.Ask},
+		{"go build -ldflags=-extld=/tmp/evil ./...", approval.Ask},
+		{"GOFLAGS=-exec=/tmp/evil go test ./...", approval.Ask},
+		{"go test $(cat /etc/passwd)", approval.Ask},
+		{"go test -v -count=1 -race -run TestApprovalPolicy ./internal/...", approval.Allow},
+		{"go test --short ./...", approval.Allow},
+		{"go build -o /home/user/.bashrc ./cmd", approval.Ask},
+		{"go test -coverprofile=/tmp/cover.out ./...", approval.Ask},
+		{"go test -cpuprofile cpu.out ./...", approval.Ask},
+		{"go build -overlay=overlay.json ./...", approval.Ask},
+		{"go build -modfile=other.mod ./...", approval.Ask},
+		{"go build -C /tmp ./...", approval.Ask},
+		{"go build -mod=mod ./...", approval.Ask},
+		{"go test -run -o ./...", approval.Ask},
+	}
+
+	for _, c := range cases {
+		call := tools.ToolCall{FunctionName: "run_command", Arguments: map[string]any{"command": c.command}}
+		if got, _ := ApprovalPolicy.Evaluate(call); got != c.want {
+			t.Errorf("Evaluate(%q) = %s, want %s", c.command, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package coding_agent
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mightymoud/arlocode/internal/coding_agent/hats"
+)
+
+// HatDirs are the directories user-defined hats are loaded from, project hats override user hats
+func HatDirs() []string {
+	var dirs []string
+	if config, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(config, "arlocode", "hats"))
+	}
+	if project, err := os.Getwd(); err == nil {
+		dirs = append(dirs, filepath.Join(project, ".arlocode", "hats"))
+	}
+	return dirs
+}
+
+// LoadHats returns the built-in hats together with the user and project ones
+func LoadHats() ([]hats.Hat, error) {
+	return hats.Load(HatDirs()...)
+}
@@ -0,0 +1,81 @@
+package hats
+
+import (
+	"time"
+
+	"github.com/mightymoud/arlocode/internal/butler/agent"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+)
+
+// DefaultHat is worn when no hat is picked
+const DefaultHat = "write"
+
+// readOnlyTools can't change anything on the machine, the todo list only lives in the session
+var readOnlyTools = []string{"read_file", "read_folder", "list_folder_contents", "search_code", "fetch_url_as_markdown", "delegate_task", "todo_write", "todo_read"}
+
+func float(v float64) *float64 {
+	return &v
+}
+
+// Builtin are the hats that ship with arlocode
+var Builtin = []Hat{
+	{
+		Name:        "write",
+		Description: "Writes and changes code",
+		SystemPrompt: `You are arlocode, a coding agent working in the user's project.
+Read the relevant code before changing it and follow the conventions you find there.
+Keep changes focused on the request, and build or test your work when the project allows it.
+When you are done, summarize what you changed in a few sentences.`,
+		MaxIterations: 30,
+	},
+	{
+		Name:        "plan",
+		Description: "Explores the codebase and writes an implementation plan without changing anything",
+		SystemPrompt: `You are arlocode in planning mode. Don't change any file.
+Explore the codebase until you understand the parts the request touches, then write a step by step plan:
+which files change, what changes in each, in what order, and the risks or open questions.
+Be concrete, name functions and types, and keep the plan short enough to review.`,
+		Tools:         readOnlyTools,
+		Generation:    providers.GenerationConfig{ReasoningTokens: 4000},
+		MaxIterations: 25,
+	},
+	{
+		Name:        "teach",
+		Description: "Explains code and concepts instead of doing the work",
+		SystemPrompt: `You are arlocode in teaching mode. Your goal is that the user understands, not that the task gets done.
+Explain how the relevant code works using the actual code of the project, step by step.
+Point the user at the files and functions to read, suggest small exercises, and don't change any file.`,
+		Tools:         readOnlyTools,
+		Generation:    providers.GenerationConfig{Temperature: float(0.5)},
+		MaxIterations: 15,
+	},
+	{
+		Name:        "test",
+		Description: "Writes and runs tests",
+		SystemPrompt: `You are arlocode in testing mode. Write tests for the code the user points at, in the style and location the project already uses.
+Cover the edge cases and error paths, not just the happy path. Run the tests and fix the tests you wrote until they pass.
+If a test reveals a bug in the code under test, report it instead of changing the code.`,
+		MaxIterations: 30,
+	},
+	{
+		Name:        "design",
+		Description: "Discusses architecture and API design",
+		SystemPrompt: `You are arlocode in design mode. Help the user shape architecture, interfaces and data models.
+Ground every proposal in the existing code, compare two or three options with their trade-offs and recommend one.
+Show signatures and small sketches rather than full implementations, and don't change any file.`,
+		Tools:         readOnlyTools,
+		Generation:    providers.GenerationConfig{ReasoningTokens: 4000},
+		MaxIterations: 15,
+	},
+	{
+		Name:        "long-running",
+		Description: "Works through large tasks unattended",
+		SystemPrompt: `You are arlocode working on a long task without the user watching.
+Break the task into steps and work through them one at a time, verifying each step by building or testing before moving on.
+Delegate research that would fill your context to sub-agents. Don't stop to ask questions: make a reasonable decision,
+note it, and keep going. Finish with a summary of what was done, the decisions you made and anything left open.`,
+		MaxIterations: 200,
+		// Nobody is watching, so the run must stop on its own before it gets expensive
+		Budget: agent.Budget{MaxCost: 5, MaxDuration: 2 * time.Hour},
+	},
+}
@@ -0,0 +1,129 @@
+package hats
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mightymoud/arlocode/internal/butler/agent"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnknownHat is returned when no hat has the requested name
+var ErrUnknownHat = errors.New("unknown hat")
+
+// Hat is a work mode of the coding agent: what it is told, what it may use and how long it may go
+type Hat struct {
+	Name           string                     `yaml:"name"`
+	Description    string                     `yaml:"description"`
+	SystemPrompt   string                     `yaml:"system_prompt"`
+	Tools          []string                   `yaml:"tools,omitempty"`           // Allowed tools, all of them when empty
+	Model          string                     `yaml:"model,omitempty"`           // Model ID, the agent's default when empty
+	FallbackModels []string                   `yaml:"fallback_models,omitempty"` // Tried in order when the model fails, the agent's default when empty
+	CheapModel     string                     `yaml:"cheap_model,omitempty"`     // Follows up on reads and searches, the agent's default when empty, "none" to always use Model
+	Generation     providers.GenerationConfig `yaml:"generation,omitempty"`
+	MaxIterations  int                        `yaml:"max_iterations,omitempty"`
+	Budget         agent.Budget               `yaml:"budget,omitempty"` // Limits of every run, unlimited when empty
+
+	// Source is the file the hat was loaded from, empty for built-in hats
+	Source string `yaml:"-"`
+}
+
+// Allows reports whether the hat may use the tool
+func (h Hat) Allows(tool string) bool {
+	return len(h.Tools) == 0 || slices.Contains(h.Tools, tool)
+}
+
+// Load returns the built-in hats overridden and extended by the YAML files in dirs.
+// Later directories win, so pass the user config dir before the project dir.
+// Directories that don't exist are skipped.
+func Load(dirs ...string) ([]Hat, error) {
+	hats := slices.Clone(Builtin)
+	for _, dir := range dirs {
+		loaded, err := loadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, hat := range loaded {
+			if i := slices.IndexFunc(hats, func(h Hat) bool { return h.Name == hat.Name }); i >= 0 {
+				hats[i] = hat
+			} else {
+				hats = append(hats, hat)
+			}
+		}
+	}
+	return hats, nil
+}
+
+// Find returns the hat with the given name
+func Find(hats []Hat, name string) (Hat, error) {
+	for _, hat := range hats {
+		if hat.Name == name {
+			return hat, nil
+		}
+	}
+	return Hat{}, fmt.Errorf("%w %q, available hats are: %s", ErrUnknownHat, name, strings.Join(Names(hats), ", "))
+}
+
+func Names(hats []Hat) []string {
+	names := make([]string, 0, len(hats))
+	for _, hat := range hats {
+		names = append(names, hat.Name)
+	}
+	return names
+}
+
+func loadDir(dir string) ([]Hat, error) {
+	files, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var hats []Hat
+	for _, file := range files {
+		ext := filepath.Ext(file.Name())
+		if file.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		hat, err := LoadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		hats = append(hats, hat)
+	}
+	return hats, nil
+}
+
+// LoadFile reads a hat from a YAML file, the name defaults to the file name
+func LoadFile(path string) (Hat, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Hat{}, err
+	}
+
+	var hat Hat
+	if err := yaml.Unmarshal(data, &hat); err != nil {
+		return Hat{}, fmt.Errorf("hat %s: %w", path, err)
+	}
+	if hat.Name == "" {
+		hat.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if strings.TrimSpace(hat.SystemPrompt) == "" {
+		return Hat{}, fmt.Errorf("hat %s: system_prompt is required", path)
+	}
+	if hat.MaxIterations < 0 {
+		return Hat{}, fmt.Errorf("hat %s: max_iterations can't be negative", path)
+	}
+	if b := hat.Budget; b.MaxTokens < 0 || b.MaxCost < 0 || b.MaxDuration < 0 || b.MaxToolCalls < 0 {
+		return Hat{}, fmt.Errorf("hat %s: budget limits can't be negative", path)
+	}
+	hat.Source = path
+	return hat, nil
+}
@@ -0,0 +1,119 @@
+package hats
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeHat(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad_OverridesAndExtendsBuiltins(t *testing.T) {
+	userDir := filepath.Join(t.TempDir(), "user")
+	projectDir := filepath.Join(t.TempDir(), "project")
+
+	writeHat(t, userDir, "review.yaml", `
+description: Reviews diffs
+system_prompt: Review the changes.
+tools: [read_file, search_code]
+model: openai/gpt-5
+generation:
+  temperature: 0.1
+  max_tokens: 2000
+max_iterations: 5
+`)
+	writeHat(t, projectDir, "review.yml", `
+system_prompt: Review the changes like this project's maintainers would.
+`)
+	writeHat(t, projectDir, "plan.yaml", `
+system_prompt: Plan in our RFC format.
+`)
+	writeHat(t, projectDir, "notes.txt", "not a hat")
+
+	hats, err := Load(userDir, projectDir, filepath.Join(t.TempDir(), "missing"))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(hats) != len(Builtin)+1 {
+		t.Fatalf("Expected %d hats, got %d", len(Builtin)+1, len(hats))
+	}
+
+	review, err := Find(hats, "review")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if review.SystemPrompt != "Review the changes like this project's maintainers would." || review.Model != "" {
+		t.Errorf("Expected the project hat to replace the user hat, got %+v", review)
+	}
+
+	plan, _ := Find(hats, "plan")
+	if plan.SystemPrompt != "Plan in our RFC format." || plan.Source == "" {
+		t.Errorf("Expected the project hat to override the built-in one, got %+v", plan)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	writeHat(t, dir, "review.yaml", `
+system_prompt: Review the changes.
+tools: [read_file]
+model: openai/gpt-5
+generation:
+  temperature: 0.1
+  reasoning_tokens: 2000
+max_iterations: 5
+budget:
+  max_cost: 0.5
+  max_duration: 10m
+`)
+
+	hat, err := LoadFile(filepath.Join(dir, "review.yaml"))
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	if hat.Name != "review" {
+		t.Errorf("Expected the name to default to the file name, got %q", hat.Name)
+	}
+	if hat.Model != "openai/gpt-5" || hat.MaxIterations != 5 || hat.Generation.ReasoningTokens != 2000 {
+		t.Errorf("Unexpected hat: %+v", hat)
+	}
+	if hat.Budget.MaxCost != 0.5 || hat.Budget.MaxDuration != 10*time.Minute {
+		t.Errorf("Expected the budget to be read, got %+v", hat.Budget)
+	}
+	if hat.Generation.Temperature == nil || *hat.Generation.Temperature != 0.1 {
+		t.Errorf("Expected temperature 0.1, got %v", hat.Generation.Temperature)
+	}
+	if !hat.Allows("read_file") || hat.Allows("run_command") {
+		t.Error("Expected the hat to only allow read_file")
+	}
+}
+
+func TestLoadFile_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	writeHat(t, dir, "empty.yaml", "description: no prompt\n")
+	writeHat(t, dir, "broken.yaml", "system_prompt: [unterminated\n")
+	writeHat(t, dir, "negative.yaml", "system_prompt: Go.\nbudget:\n  max_tool_calls: -1\n")
+
+	for _, name := range []string{"empty.yaml", "broken.yaml", "negative.yaml"} {
+		if _, err := LoadFile(filepath.Join(dir, name)); err == nil {
+			t.Errorf("Expected %s to fail", name)
+		}
+	}
+}
+
+func TestFind_Unknown(t *testing.T) {
+	_, err := Find(Builtin, "juggle")
+	if !errors.Is(err, ErrUnknownHat) {
+		t.Errorf("Expected ErrUnknownHat, got %v", err)
+	}
+}
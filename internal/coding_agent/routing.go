@@ -0,0 +1,140 @@
+package coding_agent
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/mightymoud/arlocode/internal/butler/checkpoint"
+	"github.com/mightymoud/arlocode/internal/butler/delegate"
+	"github.com/mightymoud/arlocode/internal/butler/llm/router"
+	"github.com/mightymoud/arlocode/internal/butler/session"
+	"github.com/mightymoud/arlocode/internal/butler/todo"
+	"github.com/mightymoud/arlocode/internal/coding_agent/hats"
+)
+
+// cheapModelID follows up on reads and searches for hats that don't pick their own cheap model
+const cheapModelID = "anthropic/claude-haiku-4.5"
+
+// noCheapModel in a hat's cheap_model keeps every call on the hat's model
+const noCheapModel = "none"
+
+// fallbackModelIDs take over, in order, when the model of a hat fails.
+// Its large context also fits conversations that grew too long for the others.
+var fallbackModelIDs = []string{"google/gemini-2.5-pro"}
+
+// Roles of the models, see PinModel
+const (
+	StrongModel = "strong"
+	CheapModel  = "cheap"
+	AutoModel   = "auto"
+)
+
+// modelRouter builds the router of a hat: the hat's model, its fallbacks and the cheap model, in fallback order.
+// The cheap model comes last so a failing strong model is replaced by a strong one.
+func (c *CodingAgent) modelRouter(ctx context.Context, hat hats.Hat, id string) (*router.Router, string) {
+	fallbacks := hat.FallbackModels
+	if len(fallbacks) == 0 {
+		fallbacks = fallbackModelIDs
+	}
+	cheap := hat.CheapModel
+	if cheap == "" {
+		cheap = cheapModelID
+	}
+
+	ids := []string{id}
+	for _, fallback := range fallbacks {
+		if !slices.Contains(ids, fallback) {
+			ids = append(ids, fallback)
+		}
+	}
+	if cheap == noCheapModel || cheap == id {
+		cheap = ""
+	} else if !slices.Contains(ids, cheap) {
+		ids = append(ids, cheap)
+	}
+
+	models := make([]router.Model, len(ids))
+	for i, modelID := range ids {
+		models[i] = router.Model{Name: modelID, LLM: c.provider.ModelWithConfig(ctx, modelID, hat.Generation)}
+	}
+	config := router.Config{
+		Models: models,
+		OnDecision: func(d router.Decision) {
+			if c.onRoutingDecision != nil {
+				c.onRoutingDecision(d)
+			}
+		},
+	}
+	if cheap != "" {
+		config.Policy = router.Escalation{
+			Cheap:    cheap,
+			Strong:   id,
+			ReadOnly: routingReadOnlyTools(),
+			Edits:    slices.Sorted(maps.Keys(checkpoint.DefaultTools)),
+		}.Route
+	}
+	return router.New(config), cheap
+}
+
+// routingReadOnlyTools are the tools the cheap model follows up on: reads, searches and the todo list
+func routingReadOnlyTools() []string {
+	var names []string
+	for _, t := range delegate.ReadOnlyTools() {
+		names = append(names, t.Name)
+	}
+	return append(names, todo.WriteToolName, todo.ReadToolName)
+}
+
+// WithOnRoutingDecision reports which model answered every call and why, e.g. to log it with router.Log
+func (c *CodingAgent) WithOnRoutingDecision(f func(router.Decision)) *CodingAgent {
+	c.onRoutingDecision = f
+	return c
+}
+
+// PinModel sends every call to the strong or the cheap model of the hat, AutoModel lets the
+// routing policy pick again. The choice is kept when switching hats.
+func (c *CodingAgent) PinModel(role string) error {
+	var name string
+	switch role {
+	case StrongModel:
+		name = c.modelID
+	case CheapModel:
+		if c.cheapModelID == "" {
+			return fmt.Errorf("the %s hat has no cheap model", c.hat.Name)
+		}
+		name = c.cheapModelID
+	case AutoModel:
+	default:
+		return fmt.Errorf("unknown model %q, expected %s, %s or %s", role, StrongModel, CheapModel, AutoModel)
+	}
+	if err := c.router.Pin(name); err != nil {
+		return err
+	}
+	c.pinned = role
+	return nil
+}
+
+// PinnedModel is the role set with PinModel, AutoModel when the policy picks
+func (c *CodingAgent) PinnedModel() string {
+	if c.pinned == "" {
+		return AutoModel
+	}
+	return c.pinned
+}
+
+// RoutingStats says how much every model of the current hat was used and what it cost
+func (c *CodingAgent) RoutingStats() []router.Stats {
+	return c.router.Stats()
+}
+
+// OpenRoutingLog opens the file the routing decisions of a session are appended to, next to the session file
+func OpenRoutingLog(store *session.Store, sessionID string) (*os.File, error) {
+	if err := os.MkdirAll(store.Dir(), 0o755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(filepath.Join(store.Dir(), filepath.Base(sessionID)+".routing.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+}
@@ -0,0 +1,52 @@
+package coding_agent
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/mightymoud/arlocode/internal/butler/checkpoint"
+	"github.com/mightymoud/arlocode/internal/butler/session"
+)
+
+// DataDir is where arlocode keeps its data: $XDG_DATA_HOME/arlocode, %LOCALAPPDATA%\arlocode
+// on Windows and ~/.local/share/arlocode everywhere else
+func DataDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "arlocode"), nil
+	}
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("LOCALAPPDATA"); dir != "" {
+			return filepath.Join(dir, "arlocode"), nil
+		}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "arlocode"), nil
+}
+
+// SessionStore returns the session store of the project in the working directory
+func SessionStore() (*session.Store, error) {
+	dataDir, err := DataDir()
+	if err != nil {
+		return nil, err
+	}
+	project, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	return session.NewStore(session.ProjectDir(dataDir, project)), nil
+}
+
+// NewSession starts recording a new conversation of the project in the working directory
+func NewSession(store *session.Store, model string) *session.Session {
+	project, _ := os.Getwd()
+	return store.Create(session.Meta{Project: project, Model: model})
+}
+
+// CheckpointStore opens the file checkpoints of a session, they live next to the session file
+func CheckpointStore(store *session.Store, sessionID string) (*checkpoint.Store, error) {
+	return checkpoint.Open(filepath.Join(store.Dir(), filepath.Base(sessionID)+".checkpoints"))
+}
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"context"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mightymoud/arlocode/internal/butler/approval"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+)
+
+// maxApprovalDescriptionLines caps how much of the tool arguments the approval modal shows
+const maxApprovalDescriptionLines = 10
+
+// RequestToolApproval is the approval.AskFunc used when the TUI is attached.
+// It opens the approval modal and blocks the agent until the user answers or the run is cancelled.
+func RequestToolApproval(ctx context.Context, call tools.ToolCall) (bool, error) {
+	reply := make(chan bool, 1)
+	appState.Send(ToolApprovalRequestMsg{Call: call, Reply: reply})
+
+	select {
+	case approved := <-reply:
+		return approved, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// showToolApproval opens the approval modal for the request, or queues it behind the one already shown
+func (m *AppModel) showToolApproval(msg ToolApprovalRequestMsg) tea.Cmd {
+	if m.pendingApproval != nil {
+		m.queuedApprovals = append(m.queuedApprovals, msg)
+		return nil
+	}
+	m.pendingApproval = &msg
+	m.showModal = true
+	m.blurCurrentScreenInput()
+	m.ModalInput.SetValue("")
+	m.ModalInput.Placeholder = "y to approve • n to deny"
+	m.ModalInput.Focus()
+	return m.ModalInput.Cursor.BlinkCmd()
+}
+
+// answerToolApproval replies to the pending approval request and shows the next queued one.
+// It reports whether another request took its place, otherwise the caller closes the modal.
+func (m *AppModel) answerToolApproval(approved bool) bool {
+	if m.pendingApproval == nil {
+		return false
+	}
+	m.pendingApproval.Reply <- approved
+	m.pendingApproval = nil
+	if len(m.queuedApprovals) > 0 {
+		next := m.queuedApprovals[0]
+		m.queuedApprovals = m.queuedApprovals[1:]
+		m.showToolApproval(next)
+		return true
+	}
+	m.ModalInput.SetValue("")
+	m.ModalInput.Placeholder = "Enter input..."
+	return false
+}
+
+// approvalDescription renders the pending call for the modal body
+func (m AppModel) approvalDescription() string {
+	if m.pendingApproval == nil {
+		return ""
+	}
+	lines := strings.Split(approval.Describe(m.pendingApproval.Call), "\n")
+	if len(lines) > maxApprovalDescriptionLines {
+		lines = append(lines[:maxApprovalDescriptionLines], "…")
+	}
+	return strings.Join(lines, "\n")
+}
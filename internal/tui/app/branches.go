@@ -0,0 +1,86 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/session"
+	"github.com/mightymoud/arlocode/internal/tui/app/conversation"
+)
+
+// branchList numbers the branches of the session the way /branch takes them, the current one is marked
+func branchList() string {
+	sess := appState.Session()
+	if sess == nil {
+		return "This conversation isn't saved, it has no branches"
+	}
+	var b strings.Builder
+	for i, branch := range sess.Branches() {
+		marker := "  "
+		if branch.Current {
+			marker = "• "
+		}
+		title := branch.Title
+		if title == "" {
+			title = "(empty)"
+		}
+		fmt.Fprintf(&b, "%s%d. %d messages: %s\n", marker, i+1, branch.Entries, conversation.Shorten(title, 40))
+	}
+	b.WriteString("\nSwitch with /branch <n>, start a new one before a turn with /fork <turn>")
+	return b.String()
+}
+
+// switchBranch continues the conversation on the n-th branch of /branch
+func (m *AppModel) switchBranch(arg string) {
+	sess := appState.Session()
+	if sess == nil {
+		m.Notifications.PushWarning("No branches", "This conversation isn't saved")
+		return
+	}
+	branches := sess.Branches()
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(branches) {
+		m.Notifications.PushWarning("No such branch", fmt.Sprintf("Pick a branch between 1 and %d, see /branch", len(branches)))
+		return
+	}
+	if branches[n-1].Current {
+		m.Notifications.PushInfo("Already there", fmt.Sprintf("The conversation is on branch %d", n))
+		return
+	}
+	entries, err := sess.Branch(branches[n-1].Head)
+	if err != nil {
+		m.Notifications.PushError("Can't switch branches", err.Error())
+		return
+	}
+	m.loadBranch(entries)
+	m.Notifications.PushSuccess(fmt.Sprintf("Switched to branch %d", n), branches[n-1].Title)
+}
+
+// fork goes back to before a turn of the current branch, the next prompt starts a new branch
+func (m *AppModel) fork(arg string) {
+	if appState.Session() == nil {
+		m.Notifications.PushWarning("Can't fork", "This conversation isn't saved, the current branch would be lost")
+		return
+	}
+	turn, err := strconv.Atoi(arg)
+	if err != nil {
+		m.Notifications.PushInfo("Fork", "Type /fork <turn> to go back to before a prompt, the first one being 1")
+		return
+	}
+	entries, prompt, err := session.BeforeTurn(appState.Agent().GetMemory(), turn)
+	if err != nil {
+		m.Notifications.PushWarning("Can't fork", err.Error())
+		return
+	}
+	m.loadBranch(entries)
+	m.Notifications.PushSuccess(fmt.Sprintf("Forked before turn %d", turn), "Send another prompt instead of: "+conversation.Shorten(prompt, 60))
+}
+
+// loadBranch hands the entries to the agent and shows them, files are left alone
+func (m *AppModel) loadBranch(entries []memory.MemoryEntry) {
+	appState.Agent().SwitchBranch(entries)
+	m.ChatScreen.Conversation.Transcript.Load(entries)
+	m.ChatScreen.ShouldScrollToBottom = true
+}
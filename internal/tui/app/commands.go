@@ -0,0 +1,253 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/tui/app/conversation"
+)
+
+// Commands typed in the chat input instead of a prompt
+const (
+	compactCommand     = "/compact"
+	hatCommand         = "/hat"
+	undoCommand        = "/undo"
+	checkpointsCommand = "/checkpoints"
+	routeCommand       = "/route"
+	queueCommand       = "/queue"
+	attachCommand      = "/attach"
+	branchCommand      = "/branch"
+	forkCommand        = "/fork"
+)
+
+// runCommand handles slash commands, ok is false when the input is a prompt for the agent
+func (m *AppModel) runCommand(input string) (cmd tea.Cmd, ok bool) {
+	fields := strings.Fields(input)
+	if len(fields) == 0 {
+		return nil, false
+	}
+
+	switch fields[0] {
+	case compactCommand:
+		if agentBusy() {
+			m.Notifications.PushInfo("Compaction queued", "The conversation is compacted once the agent is done")
+		}
+		return m.startCompaction(), true
+	case queueCommand:
+		prompt := strings.TrimSpace(strings.TrimPrefix(input, queueCommand))
+		if prompt == "" {
+			m.Notifications.PushInfo("Queue", "Type /queue <message> to send it once the agent is done, instead of steering the current run")
+			return tickCmd(), true
+		}
+		appState.Actor().Send(prompt)
+		return tickCmd(), true
+	case attachCommand:
+		m.attach(strings.TrimSpace(strings.TrimPrefix(input, attachCommand)))
+		return tickCmd(), true
+	case hatCommand:
+		if len(fields) == 1 {
+			m.Notifications.PushInfo("Hats", hatList())
+			return tickCmd(), true
+		}
+		if agentBusy() {
+			m.Notifications.PushWarning("Agent busy", "Wait for the agent to finish before switching hats")
+			return tickCmd(), true
+		}
+		m.wearHat(fields[1])
+		return tickCmd(), true
+	case undoCommand:
+		if agentBusy() {
+			m.Notifications.PushWarning("Agent busy", "Wait for the agent to finish before undoing")
+			return tickCmd(), true
+		}
+		m.undo()
+		return tickCmd(), true
+	case branchCommand:
+		if len(fields) == 1 {
+			m.Notifications.PushInfo("Branches", branchList())
+			return tickCmd(), true
+		}
+		if agentBusy() {
+			m.Notifications.PushWarning("Agent busy", "Wait for the agent to finish before switching branches")
+			return tickCmd(), true
+		}
+		m.switchBranch(fields[1])
+		return tickCmd(), true
+	case forkCommand:
+		if agentBusy() {
+			m.Notifications.PushWarning("Agent busy", "Wait for the agent to finish before forking")
+			return tickCmd(), true
+		}
+		m.fork(strings.TrimSpace(strings.TrimPrefix(input, forkCommand)))
+		return tickCmd(), true
+	case checkpointsCommand:
+		m.Notifications.PushInfo("Checkpoints", checkpointList())
+		return tickCmd(), true
+	case routeCommand:
+		if len(fields) == 1 {
+			m.Notifications.PushInfo("Models", routingStats())
+			return tickCmd(), true
+		}
+		if agentBusy() {
+			m.Notifications.PushWarning("Agent busy", "Wait for the agent to finish before changing the model")
+			return tickCmd(), true
+		}
+		m.pinModel(fields[1])
+		return tickCmd(), true
+	}
+	return nil, false
+}
+
+// attach reads a file, e.g. a screenshot, to send with the next prompt
+func (m *AppModel) attach(path string) {
+	if path == "" {
+		m.Notifications.PushInfo("Attach", "Type /attach <path> to send an image or a file with your next message")
+		return
+	}
+	// Files dropped on the terminal come quoted or with escaped spaces
+	path = strings.ReplaceAll(strings.Trim(path, `'"`), `\ `, " ")
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, rest)
+		}
+	}
+	part, err := memory.Attach(path)
+	if err != nil {
+		m.Notifications.PushError("Can't attach", err.Error())
+		return
+	}
+	m.attachments = append(m.attachments, part)
+	m.Notifications.PushSuccess("Attached "+filepath.Base(path), "It is sent with your next message")
+}
+
+// wearHat switches the agent to another hat, the conversation carries on
+func (m *AppModel) wearHat(name string) {
+	wearer := appState.Hats()
+	if wearer == nil {
+		m.Notifications.PushWarning("No hats", "This agent can't switch hats")
+		return
+	}
+	if err := wearer.WearHat(context.Background(), name); err != nil {
+		m.Notifications.PushError("Can't switch hats", err.Error())
+		return
+	}
+	hat := wearer.Hat()
+	m.Notifications.PushSuccess("Wearing the "+hat.Name+" hat", hat.Description)
+}
+
+// hatList describes the available hats, the current one is marked
+func hatList() string {
+	wearer := appState.Hats()
+	if wearer == nil {
+		return "This agent can't switch hats"
+	}
+	var b strings.Builder
+	for _, hat := range wearer.Hats() {
+		marker := "  "
+		if hat.Name == wearer.Hat().Name {
+			marker = "• "
+		}
+		fmt.Fprintf(&b, "%s%s: %s\n", marker, hat.Name, hat.Description)
+	}
+	b.WriteString("\nSwitch with /hat <name>")
+	return b.String()
+}
+
+// currentHat is the name shown in the status bar, empty when the agent has no hats
+func currentHat() string {
+	if wearer := appState.Hats(); wearer != nil {
+		return wearer.Hat().Name
+	}
+	return ""
+}
+
+// undo reverts the files and the conversation of the last turn
+func (m *AppModel) undo() {
+	store := appState.Checkpoints()
+	if store == nil {
+		m.Notifications.PushWarning("No checkpoints", "This session doesn't keep checkpoints")
+		return
+	}
+	last, ok := store.Last()
+	if !ok {
+		m.Notifications.PushInfo("Nothing to undo", "The agent hasn't done anything in this session yet")
+		return
+	}
+
+	// Check the conversation first so a failure doesn't leave the files and the memory out of step
+	entries, err := last.MemoryBefore(appState.Agent().GetMemory(), appState.Session())
+	if err != nil {
+		m.Notifications.PushWarning("Can't undo", fmt.Sprintf("Nothing was changed, %s. 'arlocode rewind %d' restores the files alone", err, last.Turn))
+		return
+	}
+	restored, err := store.Rewind(last.Turn)
+	if err != nil {
+		m.Notifications.PushError("Undo failed", fmt.Sprintf("%d files restored, the conversation was kept: %s", len(restored), err))
+		return
+	}
+	m.loadBranch(entries)
+	m.Notifications.PushSuccess(fmt.Sprintf("Undid turn %d", last.Turn), fmt.Sprintf("%d files restored", len(restored)))
+}
+
+// checkpointList describes the turns that can be undone, latest first
+func checkpointList() string {
+	store := appState.Checkpoints()
+	if store == nil {
+		return "This session doesn't keep checkpoints"
+	}
+	checkpoints := store.List()
+	if len(checkpoints) == 0 {
+		return "No checkpoints yet"
+	}
+	var b strings.Builder
+	for i := len(checkpoints) - 1; i >= 0; i-- {
+		c := checkpoints[i]
+		fmt.Fprintf(&b, "%d. %s, %d files: %s\n", c.Turn, c.Time.Format("15:04"), len(c.Files), conversation.Shorten(c.Prompt, 40))
+	}
+	b.WriteString("\nUndo the last turn with /undo, go further back with 'arlocode rewind <turn>'")
+	return b.String()
+}
+
+// pinModel sends the next calls to the strong or the cheap model, or back to the routing policy
+func (m *AppModel) pinModel(role string) {
+	r := appState.Router()
+	if r == nil {
+		m.Notifications.PushWarning("No routing", "This agent uses a single model")
+		return
+	}
+	if err := r.PinModel(role); err != nil {
+		m.Notifications.PushError("Can't switch models", err.Error())
+		return
+	}
+	if r.PinnedModel() == "auto" {
+		m.Notifications.PushSuccess("Routing calls again", "Reads and searches are followed up by the cheap model")
+		return
+	}
+	m.Notifications.PushSuccess("Using the "+role+" model", "Every call goes to it until /route auto")
+}
+
+// routingStats describes what every model was used for, to check that routing saves money
+func routingStats() string {
+	r := appState.Router()
+	if r == nil {
+		return "This agent uses a single model"
+	}
+	var b strings.Builder
+	for _, s := range r.RoutingStats() {
+		fmt.Fprintf(&b, "%s: %d calls", s.Model, s.Calls)
+		if s.Failures > 0 {
+			fmt.Fprintf(&b, ", %d failed", s.Failures)
+		}
+		if s.Usage.Cost > 0 {
+			b.WriteString(", " + formatCost(s.Usage.Cost))
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "\nUsing %s, switch with /route strong, cheap or auto", r.PinnedModel())
+	return b.String()
+}
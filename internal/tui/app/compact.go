@@ -0,0 +1,39 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mightymoud/arlocode/internal/butler/agent"
+	"github.com/mightymoud/arlocode/internal/butler/compaction"
+)
+
+// startCompaction compacts the agent memory once the queued work is done.
+// It is a job of the actor like runs, so Esc and Ctrl+C stop it the same way.
+func (m *AppModel) startCompaction() tea.Cmd {
+	appState.Actor().Do(compactJob, func(ctx context.Context, a *agent.Agent) error {
+		_, err := a.Compact(ctx)
+		return err
+	})
+	return tickCmd()
+}
+
+// compactionSummary describes a compaction for the notification
+func compactionSummary(r compaction.Result) string {
+	msg := fmt.Sprintf("%s → %s tokens", formatTokens(r.TokensBefore), formatTokens(r.TokensAfter))
+	if r.SummarizedEntries > 0 {
+		msg += fmt.Sprintf(", %d messages summarized", r.SummarizedEntries)
+	}
+	if r.PrunedToolOutputs > 0 {
+		msg += fmt.Sprintf(", %d tool outputs dropped", r.PrunedToolOutputs)
+	}
+	return msg
+}
+
+func formatTokens(n int) string {
+	if n >= 1000 {
+		return fmt.Sprintf("%.1fk", float64(n)/1000)
+	}
+	return fmt.Sprintf("%d", n)
+}
@@ -53,11 +53,42 @@ func (m AppModel) buildConversationContent(mainAreaWidth int, baseLayerStyle lip
 		MarginBottom(1).
 		Width(mainAreaWidth - 4)
 
+	// Sub-agents are indented under the parent's messages
+	subAgentStyle := baseLayerStyle.
+		Border(lipgloss.NormalBorder(), false, false, false, true).
+		BorderForeground(t.Mauve()).
+		Foreground(t.Subtext0()).
+		PaddingLeft(1).
+		MarginLeft(2).
+		MarginBottom(1).
+		Width(mainAreaWidth - 6)
+
+	subAgentTitleStyle := baseLayerStyle.
+		Foreground(t.Mauve()).
+		Bold(true)
+
+	toolStyle := baseLayerStyle.
+		Foreground(t.Overlay1()).
+		PaddingLeft(2).
+		MarginBottom(1)
+
+	interruptedStyle := baseLayerStyle.
+		Foreground(t.Red()).
+		Italic(true)
+
+	errorStyle := baseLayerStyle.
+		Border(lipgloss.ThickBorder(), false, false, false, true).
+		BorderForeground(t.Red()).
+		Foreground(t.Red()).
+		Padding(1, 1).
+		MarginBottom(1).
+		Width(mainAreaWidth - 4)
+
 	var messageBoxes []string
 
-	// Render all completed messages from conversation
-	for _, msg := range m.ChatScreen.Conversation.Conversation {
-		if msg.Content == "" {
+	// Render the transcript, messages still streaming end with a cursor
+	for _, msg := range m.ChatScreen.Conversation.Messages() {
+		if msg.Content == "" && msg.Type != "subagent" && msg.Type != "tool" {
 			continue
 		}
 		var style lipgloss.Style
@@ -79,35 +110,47 @@ func (m AppModel) buildConversationContent(mainAreaWidth int, baseLayerStyle lip
 			} else {
 				content = msg.Content
 			}
+			if msg.Streaming {
+				content += "█"
+			}
+		case "subagent":
+			style = subAgentStyle
+			status := "running…"
+			if msg.Err != "" {
+				status = interruptedStyle.Render("failed: " + msg.Err)
+			} else if msg.Done {
+				status = "done"
+			}
+			content = subAgentTitleStyle.Render("↳ sub-agent · "+msg.Title) + "  " + status
+			if body := subAgentBody(msg.Content); body != "" {
+				content += "\n" + body
+			}
+		case "tool":
+			style = toolStyle
+			content = "→ " + msg.Title
+			if msg.Err != "" {
+				content += "  " + interruptedStyle.Render(msg.Err)
+			} else if !msg.Done {
+				content += "  running…"
+			}
 		case "thinking", "agent_thinking":
 			style = thinkingStyle
 			content = msg.Content
+			if msg.Streaming {
+				style = style.Faint(true)
+				content += "█"
+			}
+		case "error":
+			style = errorStyle
+			content = msg.Content
 		default:
 			style = defaultStyle
 			content = msg.Content
 		}
-		messageBoxes = append(messageBoxes, style.Render(content))
-	}
-
-	// Render active thinking buffer (streaming)
-	if m.ChatScreen.Conversation.AgentThinking && m.ChatScreen.Conversation.ThinkingBuffer != "" {
-		messageBoxes = append(messageBoxes, thinkingStyle.Faint(true).Render(m.ChatScreen.Conversation.ThinkingBuffer+"█"))
-	}
-
-	// Render active text buffer (streaming)
-	if m.ChatScreen.Conversation.TextBuffer != "" {
-		streamContent := m.ChatScreen.Conversation.TextBuffer
-		if glamourRenderer != nil {
-			rendered, err := glamourRenderer.Render(streamContent)
-			if err == nil {
-				streamContent = rendered + "█"
-			} else {
-				streamContent = streamContent + "█"
-			}
-		} else {
-			streamContent = streamContent + "█"
+		if msg.Interrupted {
+			content += "\n" + interruptedStyle.Render("⏹ interrupted")
 		}
-		messageBoxes = append(messageBoxes, agentStyle.Render(streamContent))
+		messageBoxes = append(messageBoxes, style.Render(content))
 	}
 
 	return lipgloss.JoinVertical(lipgloss.Left, messageBoxes...)
@@ -1,52 +1,160 @@
 package conversation
 
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/mightymoud/arlocode/internal/butler/tools"
+	"github.com/mightymoud/arlocode/internal/butler/transcript"
+)
+
 type ConversationMessage struct {
-	Type    string
-	Content string
+	Type        string
+	Content     string
+	Interrupted bool
+	Streaming   bool // Still being produced, rendered with a cursor
+
+	// Sub-agent and tool messages are updated in place while they run
+	ID    string
+	Title string
+	Done  bool
+	Err   string
 }
 
+// ConversationManager is the chat screen's view of the transcript, it turns entries into the messages the screen renders
 type ConversationManager struct {
-	Conversation   []ConversationMessage
-	AgentThinking  bool
-	ThinkingBuffer string
-	TextBuffer     string
+	Transcript *transcript.Transcript
+}
+
+func NewConversationManager(t *transcript.Transcript) *ConversationManager {
+	return &ConversationManager{Transcript: t}
+}
+
+// Messages flattens the transcript into the messages of the chat screen, oldest first
+func (cm *ConversationManager) Messages() []ConversationMessage {
+	var messages []ConversationMessage
+	for _, entry := range cm.Transcript.Entries() {
+		switch entry.Role {
+		case transcript.User:
+			messages = append(messages, ConversationMessage{Type: "user", Content: userContent(entry)})
+		case transcript.Summary:
+			messages = append(messages, ConversationMessage{Type: "summary", Content: entry.Text(transcript.Text)})
+		case transcript.Agent:
+			messages = append(messages, agentMessages(entry)...)
+		case transcript.Tool:
+			call := entry.Parts[0].Call
+			messages = append(messages, ConversationMessage{
+				Type:  "tool",
+				ID:    call.ID,
+				Title: ToolTitle(call),
+				Done:  entry.Status != transcript.Running,
+				Err:   toolStatus(entry),
+			})
+		case transcript.SubAgent:
+			messages = append(messages, ConversationMessage{
+				Type:    "subagent",
+				ID:      entry.SubAgentID,
+				Title:   entry.Title,
+				Content: subAgentOutput(entry),
+				Done:    entry.Status != transcript.Running,
+				Err:     entry.Text(transcript.Error),
+			})
+		}
+	}
+	return messages
 }
 
-func NewConversationManager() *ConversationManager {
-	return &ConversationManager{
-		Conversation:   []ConversationMessage{},
-		AgentThinking:  false,
-		ThinkingBuffer: "",
+// userContent is the prompt followed by the names of its attachments
+func userContent(entry transcript.Entry) string {
+	lines := []string{entry.Text(transcript.Text)}
+	for _, part := range entry.Parts {
+		if part.Kind == transcript.Attachment {
+			lines = append(lines, "📎 "+part.Text)
+		}
 	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
 }
 
-func (cm *ConversationManager) AddThinkingMessage(content string) {
-	conversationTurn := ConversationMessage{
-		Type:    "thinking",
-		Content: content,
+// agentMessages splits a model call into its reasoning, answer and error boxes, tool calls show up with their results
+func agentMessages(entry transcript.Entry) []ConversationMessage {
+	var messages []ConversationMessage
+	for _, part := range entry.Parts {
+		switch part.Kind {
+		case transcript.Thinking:
+			messages = append(messages, ConversationMessage{Type: "thinking", Content: part.Text})
+		case transcript.Text:
+			messages = append(messages, ConversationMessage{Type: "agent", Content: part.Text})
+		case transcript.Error:
+			messages = append(messages, ConversationMessage{Type: "error", Content: part.Text})
+		}
 	}
-	cm.Conversation = append(cm.Conversation, conversationTurn)
-	cm.AgentThinking = false
-	cm.ThinkingBuffer = ""
+	if n := len(messages); n > 0 {
+		messages[n-1].Streaming = entry.Status == transcript.Running
+		messages[n-1].Interrupted = entry.Status == transcript.Interrupted
+	}
+	return messages
+}
+
+// toolStatus is shown next to a tool call that didn't succeed
+func toolStatus(entry transcript.Entry) string {
+	switch entry.Status {
+	case transcript.Denied:
+		return "denied"
+	case transcript.Interrupted:
+		return "interrupted"
+	case transcript.Failed:
+		if err := entry.Text(transcript.Error); err != "" {
+			return "failed: " + err
+		}
+		return "failed"
+	}
+	return ""
 }
 
-func (cm *ConversationManager) AddAgentMessage(content string) {
-	conversationTurn := ConversationMessage{
-		Type:    "agent",
-		Content: content,
+// subAgentOutput is the streamed text of a sub-agent with a line for each of its tool calls
+func subAgentOutput(entry transcript.Entry) string {
+	var b strings.Builder
+	for _, part := range entry.Parts {
+		switch part.Kind {
+		case transcript.Text:
+			b.WriteString(part.Text)
+		case transcript.ToolCall:
+			b.WriteString("\n→ " + part.Call.FunctionName + "\n")
+		}
+	}
+	return b.String()
+}
+
+// maxToolTitleArg caps the argument shown next to the tool name
+const maxToolTitleArg = 60
+
+// ToolTitle is the tool name followed by its first text argument, e.g. "read_file main.go"
+func ToolTitle(call tools.ToolCall) string {
+	keys := slices.Sorted(maps.Keys(call.Arguments))
+	for _, key := range keys {
+		value, ok := call.Arguments[key].(string)
+		if !ok || value == "" {
+			continue
+		}
+		value = strings.Join(strings.Fields(value), " ")
+		if runes := []rune(value); len(runes) > maxToolTitleArg {
+			value = string(runes[:maxToolTitleArg]) + "…"
+		}
+		return call.FunctionName + " " + value
 	}
-	cm.Conversation = append(cm.Conversation, conversationTurn)
-	cm.TextBuffer = ""
+	return call.FunctionName
 }
 
-func (cm *ConversationManager) AddUserMessage(content string) {
-	conversationTurn := ConversationMessage{
-		Type:    "user",
-		Content: content,
+// Shorten puts text on one line and cuts it to max runes
+func Shorten(text string, max int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > max {
+		return string(runes[:max]) + "…"
 	}
-	cm.Conversation = append(cm.Conversation, conversationTurn)
+	return text
 }
 
 func (cm *ConversationManager) IsEmpty() bool {
-	return len(cm.Conversation) == 0
+	return cm.Transcript.Len() == 0
 }
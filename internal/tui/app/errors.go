@@ -0,0 +1,25 @@
+package app
+
+import (
+	"errors"
+
+	"github.com/mightymoud/arlocode/internal/butler"
+)
+
+// agentErrorTitle picks the notification title for an error returned by Agent.Run
+func agentErrorTitle(err error) string {
+	switch {
+	case errors.Is(err, butler.ErrAuth):
+		return "Authentication failed"
+	case errors.Is(err, butler.ErrRateLimited):
+		return "Rate limited"
+	case errors.Is(err, butler.ErrContextTooLong):
+		return "Context too long"
+	case errors.Is(err, butler.ErrContentFiltered):
+		return "Response blocked"
+	case errors.Is(err, butler.ErrTransient):
+		return "Provider unavailable"
+	default:
+		return "Agent error"
+	}
+}
@@ -0,0 +1,19 @@
+package app
+
+import "github.com/mightymoud/arlocode/internal/butler/agent"
+
+// ForwardAgentEvent turns the agent's events into TUI messages, subscribe it with Agent.Subscribe.
+// What the agent says and does reaches the chat through the transcript, see ForwardTranscript.
+func ForwardAgentEvent(event agent.Event) {
+	switch e := event.(type) {
+	case agent.UsageUpdated:
+		appState.Send(AgentUsageMsg{Report: e.Usage})
+	case agent.Compacted:
+		appState.Send(AgentCompactedMsg{Result: e.Result})
+	}
+}
+
+// ForwardTranscript tells the TUI the transcript changed, pass it to Transcript.WithOnChange
+func ForwardTranscript() {
+	appState.Send(TranscriptUpdatedMsg{})
+}
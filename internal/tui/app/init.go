@@ -4,6 +4,7 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mightymoud/arlocode/internal/butler/transcript"
 	"github.com/mightymoud/arlocode/internal/tui/app/conversation"
 	"github.com/mightymoud/arlocode/internal/tui/notifications"
 )
@@ -36,7 +37,7 @@ func NewAppModel() AppModel {
 		},
 		ChatScreen: ChatScreenModel{
 			Input:        chatInput,
-			Conversation: conversation.NewConversationManager(),
+			Conversation: conversation.NewConversationManager(transcript.New()),
 			Viewport:     vp,
 		},
 		ModalInput:    modalInput,
@@ -47,3 +48,17 @@ func NewAppModel() AppModel {
 func (m AppModel) Init() tea.Cmd {
 	return textinput.Blink
 }
+
+// WithTranscript renders the chat from the transcript the agent writes to,
+// a resumed session with a conversation opens on the chat screen
+func (m AppModel) WithTranscript(t *transcript.Transcript) AppModel {
+	m.ChatScreen.Conversation = conversation.NewConversationManager(t)
+	if t.Len() == 0 {
+		return m
+	}
+	m.currentScreen = ScreenChat
+	m.WelcomeScreen.Input.Blur()
+	m.ChatScreen.Input.Focus()
+	m.ChatScreen.ShouldScrollToBottom = true
+	return m
+}
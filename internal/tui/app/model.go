@@ -3,6 +3,10 @@ package app
 import (
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
+	"github.com/mightymoud/arlocode/internal/butler/actor"
+	"github.com/mightymoud/arlocode/internal/butler/memory"
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+	"github.com/mightymoud/arlocode/internal/butler/todo"
 	"github.com/mightymoud/arlocode/internal/tui/app/conversation"
 	"github.com/mightymoud/arlocode/internal/tui/notifications"
 )
@@ -37,6 +41,30 @@ type AppModel struct {
 	ModalInput    textinput.Model
 	Notifications *notifications.NotificationManager
 
+	// Tool call waiting on the user's approval, shown in the modal
+	pendingApproval *ToolApprovalRequestMsg
+
+	// Approval requests that came in while another one was shown, e.g. from parallel sub-agents
+	queuedApprovals []ToolApprovalRequestMsg
+
+	// What the agent is working on and what waits, shown in the sidebar
+	queue actor.State
+
+	// Set once the user was told the session can't be saved, so it isn't repeated after every run
+	sessionErrReported bool
+
+	// Same for file checkpoints, /undo can't restore what they missed
+	checkpointErrReported bool
+
+	// What the session consumed so far, shown in the status bar
+	usage providers.Usage
+
+	// The agent's todo list, shown in the sidebar
+	todos []todo.Item
+
+	// Images and files added with /attach, sent with the next prompt
+	attachments []memory.Part
+
 	// Screen models
 	WelcomeScreen WelcomeScreenModel
 	ChatScreen    ChatScreenModel
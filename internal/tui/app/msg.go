@@ -4,6 +4,11 @@ import (
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mightymoud/arlocode/internal/butler/actor"
+	"github.com/mightymoud/arlocode/internal/butler/agent"
+	"github.com/mightymoud/arlocode/internal/butler/compaction"
+	"github.com/mightymoud/arlocode/internal/butler/todo"
+	"github.com/mightymoud/arlocode/internal/butler/tools"
 )
 
 // tickMsg is sent on each animation frame
@@ -16,7 +21,42 @@ func tickCmd() tea.Cmd {
 	})
 }
 
-type AgentTextChunkMsg string
-type AgentTextCompleteMsg string
-type AgentThinkingChunkMsg string
-type AgentThinkingCompleteMsg string
+// TranscriptUpdatedMsg is sent whenever the transcript changed, the chat screen renders from it
+type TranscriptUpdatedMsg struct{}
+
+// QueueUpdatedMsg is sent whenever prompts are queued, started or picked up as steering
+type QueueUpdatedMsg struct {
+	State actor.State
+}
+
+// AgentRunDoneMsg is sent when Agent.Run returns, Err is context.Canceled for interrupted runs
+type AgentRunDoneMsg struct {
+	Reason agent.StopReason
+	Err    error
+}
+
+// ToolApprovalRequestMsg asks the user to approve a tool call, the answer is sent back on Reply
+type ToolApprovalRequestMsg struct {
+	Call  tools.ToolCall
+	Reply chan bool
+}
+
+// AgentCompactedMsg is sent whenever the agent compacted its memory, automatically or through /compact
+type AgentCompactedMsg struct {
+	Result compaction.Result
+}
+
+// AgentUsageMsg is sent after every model call of the agent or one of its sub-agents
+type AgentUsageMsg struct {
+	Report agent.UsageReport
+}
+
+// CompactionDoneMsg is sent when a /compact started from the chat input finishes
+type CompactionDoneMsg struct {
+	Err error
+}
+
+// TodosUpdatedMsg is sent whenever the agent writes its todo list
+type TodosUpdatedMsg struct {
+	Items []todo.Item
+}
@@ -0,0 +1,67 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mightymoud/arlocode/internal/butler/actor"
+	"github.com/mightymoud/arlocode/internal/tui/app/conversation"
+	"github.com/mightymoud/arlocode/internal/tui/themes"
+)
+
+// compactJob is the name of /compact in the queue
+const compactJob = "/compact"
+
+// ForwardQueue shows what the actor is doing, meant for actor.Actor.WithOnChange
+func ForwardQueue(state actor.State) {
+	appState.Send(QueueUpdatedMsg{State: state})
+}
+
+// ForwardResult reports the end of a job, meant for actor.Actor.WithOnDone
+func ForwardResult(result actor.Result) {
+	if result.Name == compactJob {
+		appState.Send(CompactionDoneMsg{Err: result.Err})
+		return
+	}
+	appState.Send(AgentRunDoneMsg{Reason: result.Reason, Err: result.Err})
+}
+
+// agentBusy reports whether the agent is running or has work waiting
+func agentBusy() bool {
+	a := appState.Actor()
+	return a != nil && a.Busy()
+}
+
+// sendPrompt runs the prompt, or steers the run in progress with it.
+// Prompts with attachments are queued instead, steering messages are text only.
+func (m *AppModel) sendPrompt(prompt string) tea.Cmd {
+	a := appState.Actor()
+	if len(m.attachments) > 0 {
+		a.Send(prompt, m.attachments...)
+		m.attachments = nil
+	} else if a.Busy() {
+		a.Steer(prompt)
+	} else {
+		a.Send(prompt)
+	}
+	return tickCmd()
+}
+
+// renderQueue lists the steering messages and queued prompts under the tasks, empty when nothing waits
+func (m AppModel) renderQueue(width int) string {
+	if len(m.queue.Steering) == 0 && len(m.queue.Queued) == 0 {
+		return ""
+	}
+	t := themes.Current
+	base := lipgloss.NewStyle().Background(t.Base())
+	lines := []string{"", base.Foreground(t.Mauve()).Bold(true).Render(" Queue")}
+	for _, msg := range m.queue.Steering {
+		lines = append(lines, base.Foreground(t.Yellow()).Render(" ↳ "+conversation.Shorten(msg, width-6)))
+	}
+	for i, prompt := range m.queue.Queued {
+		lines = append(lines, base.Foreground(t.Text()).Render(fmt.Sprintf(" %d. %s", i+1, conversation.Shorten(prompt, width-7))))
+	}
+	return strings.Join(lines, "\n")
+}
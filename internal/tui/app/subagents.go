@@ -0,0 +1,15 @@
+package app
+
+import "strings"
+
+// maxSubAgentLines caps how much of a sub-agent's output is shown under the parent
+const maxSubAgentLines = 8
+
+// subAgentBody keeps the last lines of a sub-agent's output
+func subAgentBody(content string) string {
+	lines := strings.Split(strings.TrimSpace(content), "\n")
+	if len(lines) > maxSubAgentLines {
+		lines = append([]string{"…"}, lines[len(lines)-maxSubAgentLines:]...)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mightymoud/arlocode/internal/butler/todo"
+	"github.com/mightymoud/arlocode/internal/tui/app/conversation"
+	"github.com/mightymoud/arlocode/internal/tui/themes"
+)
+
+// ForwardTodos shows the agent's todo list in the sidebar, meant for todo.List.WithOnChange
+func ForwardTodos(items []todo.Item) {
+	appState.Send(TodosUpdatedMsg{Items: items})
+}
+
+// WithTodos shows the todo list of a resumed session
+func (m AppModel) WithTodos(items []todo.Item) AppModel {
+	m.todos = items
+	return m
+}
+
+// renderSidebar shows the agent's tasks and, while it works, the messages waiting for it
+func (m AppModel) renderSidebar(width int) string {
+	if queue := m.renderQueue(width); queue != "" {
+		return m.renderTasks(width) + "\n" + queue
+	}
+	return m.renderTasks(width)
+}
+
+// renderTasks lists the agent's tasks, one line each, cut to the sidebar width
+func (m AppModel) renderTasks(width int) string {
+	t := themes.Current
+	base := lipgloss.NewStyle().Background(t.Base())
+	lines := []string{base.Foreground(t.Mauve()).Bold(true).Render(" Tasks")}
+	if len(m.todos) == 0 {
+		lines = append(lines, base.Foreground(t.Overlay0()).Render(" No tasks yet"))
+		return strings.Join(lines, "\n")
+	}
+
+	done := 0
+	for _, item := range m.todos {
+		style := base.Foreground(t.Text())
+		switch item.Status {
+		case todo.InProgress:
+			style = base.Foreground(t.Yellow())
+		case todo.Done:
+			style = base.Foreground(t.Green())
+			done++
+		case todo.Cancelled:
+			style = base.Foreground(t.Overlay0()).Strikethrough(true)
+		}
+		// Leave room for the mark and the margins
+		lines = append(lines, style.Render(" "+todo.Mark(item.Status)+" "+conversation.Shorten(item.Title, width-7)))
+	}
+	lines = append(lines, base.Foreground(t.Overlay1()).Render(fmt.Sprintf(" %d/%d done", done, len(m.todos))))
+	return strings.Join(lines, "\n")
+}
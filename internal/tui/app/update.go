@@ -2,9 +2,13 @@ package app
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/mightymoud/arlocode/internal/butler/agent"
+	"github.com/mightymoud/arlocode/internal/butler/approval"
 	state "github.com/mightymoud/arlocode/internal/tui"
 )
 
@@ -86,37 +90,87 @@ func (m AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return m, tea.Batch(cmds...)
 
-	case AgentTextChunkMsg:
-		m.ChatScreen.Conversation.TextBuffer += string(msg)
-		// Flag to scroll to bottom when streaming - consumed by View
+	case TranscriptUpdatedMsg:
+		// Flag to scroll to bottom while the agent works - consumed by View
 		m.ChatScreen.ShouldScrollToBottom = true
 		return m, tea.Batch(cmds...)
 
-	case AgentTextCompleteMsg:
-		m.ChatScreen.Conversation.AddAgentMessage(m.ChatScreen.Conversation.TextBuffer)
-		m.ChatScreen.Conversation.TextBuffer = ""
-		m.ChatScreen.ShouldScrollToBottom = true
+	case QueueUpdatedMsg:
+		m.queue = msg.State
+		return m, nil
+
+	case AgentRunDoneMsg:
+		if errors.Is(msg.Err, context.Canceled) {
+			m.Notifications.PushInfo("Interrupted", "The agent run was stopped")
+			cmds = append(cmds, tickCmd())
+		} else if msg.Err != nil {
+			m.Notifications.PushError(agentErrorTitle(msg.Err), msg.Err.Error())
+			cmds = append(cmds, tickCmd())
+		} else if msg.Reason == agent.StopMaxIterations {
+			m.Notifications.PushWarning("Iteration limit reached", "The agent stopped early, send a message to let it continue")
+			cmds = append(cmds, tickCmd())
+		} else if msg.Reason == agent.StopBudgetExhausted {
+			m.Notifications.PushWarning("Budget exhausted", "The agent stopped early, send a message to let it continue")
+			cmds = append(cmds, tickCmd())
+		}
+		if m.reportSessionError() {
+			cmds = append(cmds, tickCmd())
+		}
+		if m.reportCheckpointError() {
+			cmds = append(cmds, tickCmd())
+		}
 		return m, tea.Batch(cmds...)
 
-	case AgentThinkingChunkMsg:
-		m.ChatScreen.Conversation.AgentThinking = true
-		m.ChatScreen.Conversation.ThinkingBuffer += string(msg)
-		// Flag to scroll to bottom when thinking - consumed by View
-		m.ChatScreen.ShouldScrollToBottom = true
+	case AgentCompactedMsg:
+		m.Notifications.PushInfo("Conversation compacted", compactionSummary(msg.Result))
+		cmds = append(cmds, tickCmd())
 		return m, tea.Batch(cmds...)
 
-	case AgentThinkingCompleteMsg:
-		m.ChatScreen.Conversation.AddThinkingMessage(m.ChatScreen.Conversation.ThinkingBuffer)
-		m.ChatScreen.ShouldScrollToBottom = true
+	case AgentUsageMsg:
+		m.usage = msg.Report.Session
+		return m, nil
+
+	case TodosUpdatedMsg:
+		m.todos = msg.Items
+		return m, nil
+
+	case CompactionDoneMsg:
+		if errors.Is(msg.Err, context.Canceled) {
+			m.Notifications.PushInfo("Compaction stopped", "The conversation was left as it was")
+			cmds = append(cmds, tickCmd())
+		} else if msg.Err != nil {
+			m.Notifications.PushError("Compaction failed", msg.Err.Error())
+			cmds = append(cmds, tickCmd())
+		}
+		return m, tea.Batch(cmds...)
+
+	case ToolApprovalRequestMsg:
+		cmds = append(cmds, m.showToolApproval(msg))
 		return m, tea.Batch(cmds...)
 
 	case tea.KeyMsg:
 		// Handle global key bindings first
 		switch msg.String() {
 		case "ctrl+c":
+			// Ctrl+C stops the current run first, and only quits when the agent is idle
+			if agentBusy() {
+				m.interruptRun()
+				cmds = append(cmds, m.getCurrentScreenBlinkCmd())
+				return m, tea.Batch(cmds...)
+			}
 			return m, tea.Quit
 		case "esc":
+			if agentBusy() && (!m.showModal || m.pendingApproval != nil) {
+				m.interruptRun()
+				cmds = append(cmds, m.getCurrentScreenBlinkCmd())
+				return m, tea.Batch(cmds...)
+			}
 			if m.showModal {
+				// Dismissing an approval request denies the tool call, the next waiting request takes its place
+				if m.answerToolApproval(false) {
+					cmds = append(cmds, m.ModalInput.Cursor.BlinkCmd())
+					return m, tea.Batch(cmds...)
+				}
 				m.showModal = false
 				m.ModalInput.Blur()
 				m.focusCurrentScreenInput()
@@ -126,6 +180,10 @@ func (m AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			return m, nil
 		case "ctrl+o":
+			// The modal stays open until a pending approval is answered
+			if m.pendingApproval != nil {
+				return m, nil
+			}
 			// Toggle modal
 			m.showModal = !m.showModal
 			if m.showModal {
@@ -152,6 +210,10 @@ func (m AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// Handle modal input
 		if m.showModal {
 			if msg.String() == "enter" {
+				if m.answerToolApproval(approval.IsAffirmative(m.ModalInput.Value())) {
+					cmds = append(cmds, m.ModalInput.Cursor.BlinkCmd())
+					return m, tea.Batch(cmds...)
+				}
 				// Close modal on enter
 				m.showModal = false
 				m.ModalInput.Blur()
@@ -196,17 +258,19 @@ func (m AppModel) handleWelcomeScreenKeys(msg tea.KeyMsg) (AppModel, tea.Cmd) {
 	switch msg.String() {
 	case "enter":
 		value := m.WelcomeScreen.Input.Value()
+		if cmd, ok := m.runCommand(value); ok {
+			m.WelcomeScreen.Input.SetValue("")
+			return m, cmd
+		}
 		if value != "" {
 			// Clear input and transition to chat screen
 			m.WelcomeScreen.Input.SetValue("")
 			m.currentScreen = ScreenChat
 			m.WelcomeScreen.Input.Blur()
 			m.ChatScreen.Input.Focus()
-			// Add user message to conversation
-			m.ChatScreen.Conversation.AddUserMessage(value)
-			// Start the agent
-			go appState.Agent().Run(context.Background(), value)
-			return m, tickCmd()
+			// The prompt shows up in the conversation once the agent starts on it
+			cmd := m.sendPrompt(value)
+			return m, cmd
 		}
 	}
 	return m, nil
@@ -217,19 +281,62 @@ func (m AppModel) handleChatScreenKeys(msg tea.KeyMsg) (AppModel, tea.Cmd) {
 	switch msg.String() {
 	case "enter":
 		value := m.ChatScreen.Input.Value()
+		if cmd, ok := m.runCommand(value); ok {
+			m.ChatScreen.Input.SetValue("")
+			return m, cmd
+		}
 		if value != "" {
 			// Clear input after submission
 			m.ChatScreen.Input.SetValue("")
-			// Add user message to conversation
-			m.ChatScreen.Conversation.AddUserMessage(value)
-			// Start the agent
-			go appState.Agent().Run(context.Background(), value)
-			return m, tickCmd()
+			// While the agent runs the message steers it, see /queue to wait for the run instead
+			cmd := m.sendPrompt(value)
+			return m, cmd
 		}
 	}
 	return m, nil
 }
 
+// reportSessionError warns once when the conversation couldn't be written to the session file
+func (m *AppModel) reportSessionError() bool {
+	sess := appState.Session()
+	if m.sessionErrReported || sess == nil || sess.Err() == nil {
+		return false
+	}
+	m.sessionErrReported = true
+	m.Notifications.PushWarning("Session not saved", sess.Err().Error())
+	return true
+}
+
+// reportCheckpointError warns once when the files the agent changed couldn't be snapshotted
+func (m *AppModel) reportCheckpointError() bool {
+	store := appState.Checkpoints()
+	if m.checkpointErrReported || store == nil || store.Err() == nil {
+		return false
+	}
+	m.checkpointErrReported = true
+	m.Notifications.PushWarning("Checkpoints not saved", store.Err().Error())
+	return true
+}
+
+// interruptRun cancels the agent run in progress without quitting, queued prompts are dropped.
+// Pending approvals are denied so the modal doesn't outlive the run.
+func (m *AppModel) interruptRun() {
+	a := appState.Actor()
+	if a == nil || !a.Busy() {
+		return
+	}
+	if dropped := a.Cancel(); dropped > 0 {
+		m.Notifications.PushInfo("Queue cleared", fmt.Sprintf("%d waiting messages were dropped", dropped))
+	}
+	if m.pendingApproval != nil {
+		for m.answerToolApproval(false) {
+		}
+		m.showModal = false
+		m.ModalInput.Blur()
+		m.focusCurrentScreenInput()
+	}
+}
+
 // focusCurrentScreenInput focuses the input of the current screen
 func (m *AppModel) focusCurrentScreenInput() {
 	switch m.currentScreen {
@@ -0,0 +1,26 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/mightymoud/arlocode/internal/butler/providers"
+)
+
+// usageSummary is the session usage shown in the status bar, empty before the first model call
+func usageSummary(u providers.Usage) string {
+	if u.IsZero() {
+		return ""
+	}
+	summary := formatTokens(u.TotalTokens()) + " tokens"
+	if u.Cost > 0 {
+		summary += " • " + formatCost(u.Cost)
+	}
+	return summary
+}
+
+func formatCost(usd float64) string {
+	if usd < 0.01 {
+		return fmt.Sprintf("$%.4f", usd)
+	}
+	return fmt.Sprintf("$%.2f", usd)
+}
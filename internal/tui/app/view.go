@@ -1,6 +1,8 @@
 package app
 
 import (
+	"fmt"
+
 	"github.com/charmbracelet/lipgloss"
 	"github.com/kjk/flex"
 	"github.com/mightymoud/arlocode/internal/tui/layers"
@@ -57,6 +59,11 @@ func (m AppModel) RenderModal(canvas *layers.Canvas) {
 		Background(modalBg).
 		Width(modalWidth - 6)
 
+	modalDescriptionStyle := lipgloss.NewStyle().
+		Background(modalBg).
+		Foreground(t.Subtext0()).
+		Width(modalWidth - 6)
+
 	modalHintStyle := lipgloss.NewStyle().
 		Background(modalBg).
 		Foreground(t.Overlay1()).
@@ -68,12 +75,25 @@ func (m AppModel) RenderModal(canvas *layers.Canvas) {
 	m.ModalInput.PlaceholderStyle = lipgloss.NewStyle().Foreground(t.Overlay0()).Background(modalBg)
 	m.ModalInput.Cursor.Style = lipgloss.NewStyle().Foreground(t.Rosewater()).Background(modalBg)
 
+	sections := []string{modalTitleStyle.Render("Modal")}
+	hint := "Enter to close • Esc to cancel"
+	if m.pendingApproval != nil {
+		sections = []string{
+			modalTitleStyle.Render("Approve " + m.pendingApproval.Call.FunctionName + "?"),
+			modalDescriptionStyle.Render(m.approvalDescription()),
+		}
+		hint = "Enter to answer • Esc to deny"
+		if n := len(m.queuedApprovals); n > 0 {
+			hint += fmt.Sprintf(" • %d more waiting", n)
+		}
+	}
+	sections = append(sections,
+		modalInputBoxStyle.Render(m.ModalInput.View()),
+		modalHintStyle.Render(hint),
+	)
+
 	modalContent := modalStyle.Render(
-		lipgloss.JoinVertical(lipgloss.Left,
-			modalTitleStyle.Render("Modal"),
-			modalInputBoxStyle.Render(m.ModalInput.View()),
-			modalHintStyle.Render("Enter to close • Esc to cancel"),
-		),
+		lipgloss.JoinVertical(lipgloss.Left, sections...),
 	)
 
 	// Center modal on screen
@@ -145,13 +165,6 @@ func (m AppModel) RenderWelcomeScreen(canvas *layers.Canvas) {
 	hint := hintStyle.Render("Ctrl+O to open modal • Esc to quit")
 
 	sections := []string{title, input, hint}
-	if m.ChatScreen.Conversation.AgentThinking {
-		thinkingStyle := baseLayerStyle.
-			Foreground(t.Yellow()).
-			PaddingTop(1)
-		thinkingText := thinkingStyle.Render(m.ChatScreen.Conversation.ThinkingBuffer + "█")
-		sections = append(sections, thinkingText)
-	}
 
 	mainContent := lipgloss.JoinVertical(lipgloss.Center,
 		sections...,
@@ -266,13 +279,13 @@ func (m AppModel) RenderChatScreen(canvas *layers.Canvas) {
 		Render(lipgloss.JoinVertical(lipgloss.Left,
 			chatDiv.Render(m.ChatScreen.Viewport.View()),
 			inputDiv.Render(m.ChatScreen.Input.View()),
-			hintDiv.Render("Ctrl+O to open modal • Esc to quit"),
+			hintDiv.Render(m.chatHint()),
 		))
 
 	fullScreen := lipgloss.JoinHorizontal(
 		lipgloss.Bottom,
 		mainContent,
-		sideBarDiv.Render(" Sidebar\n (Placeholder)"),
+		sideBarDiv.Render(m.renderSidebar(sidebarWidth)),
 	)
 
 	// Add base layer (Z=0)
@@ -308,3 +321,21 @@ func (m AppModel) View() string {
 
 	return canvas.Render()
 }
+
+// chatHint is the status bar hint, it changes while the agent is running
+func (m AppModel) chatHint() string {
+	hat := ""
+	if name := currentHat(); name != "" {
+		hat = name + " hat • "
+	}
+	if usage := usageSummary(m.usage); usage != "" {
+		hat += usage + " • "
+	}
+	if n := len(m.attachments); n > 0 {
+		hat += fmt.Sprintf("%d attached • ", n)
+	}
+	if agentBusy() {
+		return hat + "Agent running • Enter to steer, /queue to send after • Esc or Ctrl+C to interrupt"
+	}
+	return hat + "/hat to switch • /compact to free up context • Ctrl+C to quit"
+}
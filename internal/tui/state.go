@@ -1,10 +1,16 @@
 package state
 
 import (
+	"context"
 	"sync"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mightymoud/arlocode/internal/butler/actor"
 	"github.com/mightymoud/arlocode/internal/butler/agent"
+	"github.com/mightymoud/arlocode/internal/butler/checkpoint"
+	"github.com/mightymoud/arlocode/internal/butler/llm/router"
+	"github.com/mightymoud/arlocode/internal/butler/session"
+	"github.com/mightymoud/arlocode/internal/coding_agent/hats"
 )
 
 var (
@@ -12,10 +18,29 @@ var (
 	once     sync.Once
 )
 
+// HatWearer switches the work mode of the agent, see coding_agent.CodingAgent
+type HatWearer interface {
+	Hat() hats.Hat
+	Hats() []hats.Hat
+	WearHat(ctx context.Context, name string) error
+}
+
+// ModelRouter picks between the strong and the cheap model of the agent, see coding_agent.CodingAgent
+type ModelRouter interface {
+	PinModel(role string) error
+	PinnedModel() string
+	RoutingStats() []router.Stats
+}
+
 type AppState struct {
-	mu      sync.RWMutex
-	program *tea.Program
-	agent   *agent.Agent
+	mu          sync.RWMutex
+	program     *tea.Program
+	agent       *agent.Agent
+	session     *session.Session
+	checkpoints *checkpoint.Store
+	hats        HatWearer
+	router      ModelRouter
+	actor       *actor.Actor
 }
 
 func Get() *AppState {
@@ -49,6 +74,71 @@ func (s *AppState) Agent() *agent.Agent {
 	return s.agent
 }
 
+func (s *AppState) SetSession(sess *session.Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.session = sess
+}
+
+// Session is the session the conversation is recorded to, nil when it isn't recorded
+func (s *AppState) Session() *session.Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.session
+}
+
+func (s *AppState) SetCheckpoints(store *checkpoint.Store) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoints = store
+}
+
+// Checkpoints keeps the files the agent changed so turns can be undone, nil when they aren't kept
+func (s *AppState) Checkpoints() *checkpoint.Store {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.checkpoints
+}
+
+func (s *AppState) SetHats(h HatWearer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hats = h
+}
+
+// Hats switches hats of the agent, nil when the agent has no hats
+func (s *AppState) Hats() HatWearer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.hats
+}
+
+func (s *AppState) SetRouter(r ModelRouter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.router = r
+}
+
+// Router picks the model of every call, nil when the agent has a single model
+func (s *AppState) Router() ModelRouter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.router
+}
+
+func (s *AppState) SetActor(a *actor.Actor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.actor = a
+}
+
+// Actor runs the prompts one at a time, everything that runs the agent goes through it
+func (s *AppState) Actor() *actor.Actor {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.actor
+}
+
 // Helper to send messages safely
 func (s *AppState) Send(msg tea.Msg) {
 	if p := s.Program(); p != nil {